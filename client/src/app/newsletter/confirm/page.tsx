@@ -0,0 +1,31 @@
+// Newsletter confirmation page (Server Component)
+import { Suspense } from 'react'
+import { Metadata } from 'next'
+import { createMetadata } from '@/lib/seo/metadata'
+import EmailLinkAction from '@/components/email/EmailLinkAction'
+
+export const generateMetadata = (): Metadata => {
+  return createMetadata({
+    title: 'Confirm your subscription',
+    description: 'Confirm your newsletter subscription.',
+    noIndex: true,
+  })
+}
+
+/**
+ * Landing page of the link in the newsletter confirmation email
+ */
+export default function NewsletterConfirmPage() {
+  return (
+    <Suspense>
+      <EmailLinkAction
+        endpoint="/api/newsletter/confirm"
+        tokenIn="body"
+        title="Confirm your subscription"
+        description="Press the button below to start receiving our newsletter."
+        actionLabel="Confirm subscription"
+        successTitle="Subscription confirmed"
+      />
+    </Suspense>
+  )
+}
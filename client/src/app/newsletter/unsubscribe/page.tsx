@@ -0,0 +1,31 @@
+// Newsletter unsubscribe page (Server Component)
+import { Suspense } from 'react'
+import { Metadata } from 'next'
+import { createMetadata } from '@/lib/seo/metadata'
+import EmailLinkAction from '@/components/email/EmailLinkAction'
+
+export const generateMetadata = (): Metadata => {
+  return createMetadata({
+    title: 'Unsubscribe from the newsletter',
+    description: 'Stop receiving our newsletter.',
+    noIndex: true,
+  })
+}
+
+/**
+ * Landing page of the unsubscribe link in newsletters
+ */
+export default function NewsletterUnsubscribePage() {
+  return (
+    <Suspense>
+      <EmailLinkAction
+        endpoint="/api/newsletter/unsubscribe"
+        tokenIn="query"
+        title="Unsubscribe from the newsletter"
+        description="Press the button below and we won't send you the newsletter anymore."
+        actionLabel="Unsubscribe"
+        successTitle="You're unsubscribed"
+      />
+    </Suspense>
+  )
+}
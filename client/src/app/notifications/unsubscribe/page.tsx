@@ -0,0 +1,31 @@
+// Email notifications unsubscribe page (Server Component)
+import { Suspense } from 'react'
+import { Metadata } from 'next'
+import { createMetadata } from '@/lib/seo/metadata'
+import EmailLinkAction from '@/components/email/EmailLinkAction'
+
+export const generateMetadata = (): Metadata => {
+  return createMetadata({
+    title: 'Unsubscribe from emails',
+    description: 'Stop receiving this kind of email.',
+    noIndex: true,
+  })
+}
+
+/**
+ * Landing page of the unsubscribe link in the footer of account emails
+ */
+export default function NotificationsUnsubscribePage() {
+  return (
+    <Suspense>
+      <EmailLinkAction
+        endpoint="/api/notifications/unsubscribe"
+        tokenIn="query"
+        title="Unsubscribe from these emails"
+        description="Press the button below and we won't email you about this topic anymore. You can change this at any time in your profile."
+        actionLabel="Unsubscribe"
+        successTitle="You're unsubscribed"
+      />
+    </Suspense>
+  )
+}
@@ -25,6 +25,8 @@ export default function robots(): MetadataRoute.Robots {
         '/api/',
         '/auth/reset-password',
         '/checkout',
+        '/newsletter/',
+        '/notifications/',
       ],
     },
     // Add sitemap URL
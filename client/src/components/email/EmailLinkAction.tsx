@@ -0,0 +1,110 @@
+'use client'
+
+import { useState } from 'react'
+import { useSearchParams } from 'next/navigation'
+import Link from 'next/link'
+
+type ActionStatus = 'idle' | 'submitting' | 'success' | 'error'
+
+interface EmailLinkActionProps {
+  // API path the token is posted to, e.g. /api/newsletter/confirm
+  endpoint: string
+  // Whether the token is sent as JSON body ({ token }) or as ?token= query parameter
+  tokenIn: 'body' | 'query'
+  title: string
+  description: string
+  actionLabel: string
+  successTitle: string
+}
+
+/**
+ * EmailLinkAction handles the landing pages of signed links in emails.
+ * It only posts the link's token to the API once the user presses the button, so link
+ * scanners that open the page can't act on anyone's behalf.
+ */
+export default function EmailLinkAction({
+  endpoint,
+  tokenIn,
+  title,
+  description,
+  actionLabel,
+  successTitle,
+}: EmailLinkActionProps) {
+  const searchParams = useSearchParams()
+  const token = searchParams.get('token')
+  const [status, setStatus] = useState<ActionStatus>(token ? 'idle' : 'error')
+  const [message, setMessage] = useState<string>(token ? '' : 'This link is missing its token. Please use the link from your email.')
+
+  const submit = async () => {
+    if (!token) return
+    setStatus('submitting')
+
+    const base = `${process.env.NEXT_PUBLIC_API_URL || ''}${endpoint}`
+    try {
+      const response = await fetch(
+        tokenIn === 'query' ? `${base}?token=${encodeURIComponent(token)}` : base,
+        {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: tokenIn === 'body' ? JSON.stringify({ token }) : undefined,
+        }
+      )
+
+      // Errors are plain text, successes are JSON with a message
+      if (!response.ok) {
+        const text = await response.text().catch(() => '')
+        throw new Error(text.trim() || 'Something went wrong. Please try again later.')
+      }
+      const data = await response.json().catch(() => null)
+      setMessage(data?.message || '')
+      setStatus('success')
+    } catch (error) {
+      console.error(`Error calling ${endpoint}:`, error)
+      setMessage(error instanceof Error ? error.message : 'Something went wrong. Please try again later.')
+      setStatus('error')
+    }
+  }
+
+  return (
+    <div className="min-h-screen bg-light-background dark:bg-dark-background flex items-center justify-center p-4">
+      <div className="max-w-md w-full text-center">
+        {(status === 'idle' || status === 'submitting') && (
+          <>
+            <h1 className="text-2xl font-semibold mb-4 text-light-foreground dark:text-dark-foreground">{title}</h1>
+            <p className="text-light-muted dark:text-dark-muted mb-6">{description}</p>
+            <button
+              type="button"
+              onClick={submit}
+              disabled={status === 'submitting'}
+              className={`rounded-md ${
+                status === 'submitting' ? 'bg-primary-400' : 'bg-primary-600 hover:bg-primary-700'
+              } px-4 py-2.5 text-sm font-semibold text-white shadow-sm focus-visible:outline focus-visible:outline-2 focus-visible:outline-offset-2 focus-visible:outline-primary-600`}
+            >
+              {status === 'submitting' ? 'Please wait...' : actionLabel}
+            </button>
+          </>
+        )}
+
+        {status === 'success' && (
+          <>
+            <h1 className="text-2xl font-semibold mb-4 text-green-600">{successTitle}</h1>
+            {message && <p className="text-light-muted dark:text-dark-muted mb-6">{message}</p>}
+            <Link href="/" className="text-primary-600 hover:text-primary-700">
+              Back to the homepage
+            </Link>
+          </>
+        )}
+
+        {status === 'error' && (
+          <>
+            <h1 className="text-2xl font-semibold mb-4 text-red-600">Something went wrong</h1>
+            <p className="text-light-muted dark:text-dark-muted mb-6">{message}</p>
+            <Link href="/" className="text-primary-600 hover:text-primary-700">
+              Back to the homepage
+            </Link>
+          </>
+        )}
+      </div>
+    </div>
+  )
+}
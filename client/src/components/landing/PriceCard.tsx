@@ -25,7 +25,7 @@ export function PriceCard({
   productId,
   variantId
 }: PriceCardProps) {
-  const { user, isAuthenticated } = useAuth();
+  const { isAuthenticated } = useAuth();
   const router = useRouter();
   const [isLoading, setIsLoading] = useState(false);
 
@@ -47,8 +47,6 @@ export function PriceCard({
       const response = await authService.post('/api/checkout', {
         productId,
         variantId,
-        email: user?.email,
-        userId: user?.id,
       });
 
       const data = response.data;
@@ -102,14 +102,26 @@ func (db *DB) CleanupExpiredBlacklistedTokens() error {
 	return err
 }
 
-// CreatePasswordResetToken creates a new password reset token for a user
-func (db *DB) CreatePasswordResetToken(userID string, token string, expiresAt time.Time) error {
+// CreatePasswordResetToken creates a new password reset token for a user and enqueues
+// the email carrying it in the same transaction
+func (db *DB) CreatePasswordResetToken(userID string, token string, expiresAt time.Time, notification *models.OutboundEmail) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 		INSERT INTO password_reset_tokens (user_id, token, expires_at)
 		VALUES ($1, $2, $3)`
 
-	_, err := db.Exec(query, userID, token, expiresAt)
-	return err
+	if _, err := tx.Exec(query, userID, token, expiresAt); err != nil {
+		return err
+	}
+	if _, err := enqueueEmail(tx, notification); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // GetPasswordResetToken retrieves a valid password reset token
@@ -155,14 +167,26 @@ func (db *DB) MarkPasswordResetTokenUsed(token string) error {
 	return nil
 }
 
-// StoreEmailVerificationToken stores a new email verification token
-func (db *DB) StoreEmailVerificationToken(token, userID, email string, expiresAt time.Time) error {
+// StoreEmailVerificationToken stores a new email verification token and enqueues the
+// email carrying it in the same transaction
+func (db *DB) StoreEmailVerificationToken(token, userID, email string, expiresAt time.Time, notification *models.OutboundEmail) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 		INSERT INTO email_verification_tokens (token, user_id, email, expires_at)
 		VALUES ($1, $2, $3, $4)
 	`
-	_, err := db.Exec(query, token, userID, email, expiresAt)
-	return err
+	if _, err := tx.Exec(query, token, userID, email, expiresAt); err != nil {
+		return err
+	}
+	if _, err := enqueueEmail(tx, notification); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // VerifyEmail verifies the email using the token and updates the user's email_verified status
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"saas-server/models"
+)
+
+// GetBillingProfile retrieves the billing profile of a user
+func (db *DB) GetBillingProfile(userID string) (*models.BillingProfile, error) {
+	query := `
+		SELECT user_id, name, company, address_line1, address_line2, city, state,
+		       postal_code, COALESCE(country, ''), tax_number, created_at, updated_at
+		FROM billing_profiles
+		WHERE user_id = $1`
+
+	var p models.BillingProfile
+	err := db.QueryRow(query, userID).Scan(
+		&p.UserID,
+		&p.Name,
+		&p.Company,
+		&p.AddressLine1,
+		&p.AddressLine2,
+		&p.City,
+		&p.State,
+		&p.PostalCode,
+		&p.Country,
+		&p.TaxNumber,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// UpsertBillingProfile creates or replaces the billing profile of a user
+func (db *DB) UpsertBillingProfile(p *models.BillingProfile) error {
+	query := `
+		INSERT INTO billing_profiles (
+			user_id, name, company, address_line1, address_line2, city, state,
+			postal_code, country, tax_number, created_at, updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id) DO UPDATE SET
+			name = EXCLUDED.name,
+			company = EXCLUDED.company,
+			address_line1 = EXCLUDED.address_line1,
+			address_line2 = EXCLUDED.address_line2,
+			city = EXCLUDED.city,
+			state = EXCLUDED.state,
+			postal_code = EXCLUDED.postal_code,
+			country = EXCLUDED.country,
+			tax_number = EXCLUDED.tax_number,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING created_at, updated_at`
+
+	return db.QueryRow(query,
+		p.UserID,
+		p.Name,
+		p.Company,
+		p.AddressLine1,
+		p.AddressLine2,
+		p.City,
+		p.State,
+		p.PostalCode,
+		p.Country,
+		p.TaxNumber,
+	).Scan(&p.CreatedAt, &p.UpdatedAt)
+}
@@ -0,0 +1,537 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"saas-server/models"
+	"time"
+)
+
+// campaignAudienceQueries select the addresses of each campaign audience
+var campaignAudienceQueries = map[string]string{
+	models.AudienceNewsletter:  `SELECT lower(email) FROM newsletter_subscriptions WHERE subscribed`,
+	models.AudienceUsers:       `SELECT lower(email) FROM users WHERE email_verified`,
+	models.AudienceEarlyAccess: `SELECT lower(email) FROM early_access`,
+}
+
+// campaignColumns lists the columns read by scanCampaign, in order
+const campaignColumns = `
+		id, name, subject, html_body, COALESCE(text_body, ''), audience, segment_id, status,
+		scheduled_at, started_at, completed_at, created_at, updated_at`
+
+// campaignRecipientStatus is the delivery status of a recipient, taken from the outbox
+// once the email is queued. It expects the recipient as r and the outbox email as o.
+const campaignRecipientStatus = `
+		CASE
+			WHEN r.status <> 'queued' THEN r.status
+			WHEN o.status IN ('sent', 'failed', 'suppressed', 'opted_out') THEN o.status
+			ELSE 'queued'
+		END`
+
+// scanCampaign scans a single campaign row
+func scanCampaign(row rowScanner) (*models.Campaign, error) {
+	var c models.Campaign
+	err := row.Scan(
+		&c.ID,
+		&c.Name,
+		&c.Subject,
+		&c.HTMLBody,
+		&c.TextBody,
+		&c.Audience,
+		&c.SegmentID,
+		&c.Status,
+		&c.ScheduledAt,
+		&c.StartedAt,
+		&c.CompletedAt,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// CreateCampaign creates a draft campaign
+func (db *DB) CreateCampaign(c *models.Campaign) error {
+	return db.QueryRow(`
+		INSERT INTO email_campaigns (name, subject, html_body, text_body, audience, segment_id, status)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, 'draft')
+		RETURNING id, status, created_at, updated_at`,
+		c.Name, c.Subject, c.HTMLBody, c.TextBody, c.Audience, c.SegmentID,
+	).Scan(&c.ID, &c.Status, &c.CreatedAt, &c.UpdatedAt)
+}
+
+// UpdateCampaign updates the content and audience of a draft campaign. It returns
+// sql.ErrNoRows if there is no draft campaign with the ID.
+func (db *DB) UpdateCampaign(c *models.Campaign) (*models.Campaign, error) {
+	return scanCampaign(db.QueryRow(`
+		UPDATE email_campaigns
+		SET name = $2, subject = $3, html_body = $4, text_body = NULLIF($5, ''), audience = $6,
+		    segment_id = $7, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'draft'
+		RETURNING `+campaignColumns,
+		c.ID, c.Name, c.Subject, c.HTMLBody, c.TextBody, c.Audience, c.SegmentID))
+}
+
+// GetCampaign returns a campaign by ID
+func (db *DB) GetCampaign(id int) (*models.Campaign, error) {
+	return scanCampaign(db.QueryRow(`SELECT `+campaignColumns+` FROM email_campaigns WHERE id = $1`, id))
+}
+
+// GetCampaigns lists campaigns with their stats, newest first
+func (db *DB) GetCampaigns(page int, limit int) ([]models.CampaignWithStats, int, error) {
+	offset := (page - 1) * limit
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM email_campaigns`).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error counting campaigns: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT `+campaignColumns+`
+		FROM email_campaigns
+		ORDER BY created_at DESC, id DESC
+		LIMIT $1 OFFSET $2`,
+		limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying campaigns: %v", err)
+	}
+	defer rows.Close()
+
+	campaigns := []models.CampaignWithStats{}
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error scanning campaign: %v", err)
+		}
+		campaigns = append(campaigns, models.CampaignWithStats{Campaign: *c})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	for i := range campaigns {
+		stats, err := db.getCampaignStats(campaigns[i].ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		campaigns[i].Stats = *stats
+	}
+	return campaigns, total, nil
+}
+
+// ScheduleCampaign schedules a draft or already scheduled campaign to be sent at sendAt.
+// It returns sql.ErrNoRows if the campaign can't be scheduled.
+func (db *DB) ScheduleCampaign(id int, sendAt time.Time) (*models.Campaign, error) {
+	return scanCampaign(db.QueryRow(`
+		UPDATE email_campaigns
+		SET status = 'scheduled', scheduled_at = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status IN ('draft', 'scheduled')
+		RETURNING `+campaignColumns,
+		id, sendAt))
+}
+
+// CancelCampaign stops a campaign. A scheduled campaign goes back to draft; a campaign that
+// is being sent is cancelled and its recipients that weren't queued yet are not emailed.
+// It returns sql.ErrNoRows if the campaign is neither scheduled nor sending.
+func (db *DB) CancelCampaign(id int) (*models.Campaign, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	c, err := scanCampaign(tx.QueryRow(`
+		UPDATE email_campaigns
+		SET status = CASE WHEN status = 'scheduled' THEN 'draft' ELSE 'cancelled' END,
+		    scheduled_at = CASE WHEN status = 'scheduled' THEN NULL ELSE scheduled_at END,
+		    completed_at = CASE WHEN status = 'sending' THEN CURRENT_TIMESTAMP ELSE completed_at END,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status IN ('scheduled', 'sending')
+		RETURNING `+campaignColumns,
+		id))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE email_campaign_recipients
+		SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP
+		WHERE campaign_id = $1 AND status = 'pending'`,
+		id); err != nil {
+		return nil, fmt.Errorf("error cancelling recipients: %v", err)
+	}
+
+	return c, tx.Commit()
+}
+
+// StartDueCampaigns resolves the recipients of every scheduled campaign that is due and
+// marks it as sending. Addresses that unsubscribed from the newsletter are left out of
+// every audience.
+func (db *DB) StartDueCampaigns(now time.Time) ([]models.Campaign, error) {
+	rows, err := db.Query(`
+		SELECT id FROM email_campaigns
+		WHERE status = 'scheduled' AND scheduled_at <= $1
+		ORDER BY scheduled_at`,
+		now)
+	if err != nil {
+		return nil, fmt.Errorf("error querying due campaigns: %v", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var started []models.Campaign
+	for _, id := range ids {
+		c, err := db.startCampaign(id, now)
+		if err == sql.ErrNoRows {
+			// Started by another instance or cancelled in the meantime
+			continue
+		}
+		if err != nil {
+			return started, fmt.Errorf("error starting campaign %d: %v", id, err)
+		}
+		started = append(started, *c)
+	}
+	return started, nil
+}
+
+// startCampaign resolves the recipients of a due campaign and marks it as sending
+func (db *DB) startCampaign(id int, now time.Time) (*models.Campaign, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var audience string
+	var segmentID *int
+	err = tx.QueryRow(`
+		SELECT audience, segment_id FROM email_campaigns
+		WHERE id = $1 AND status = 'scheduled' AND scheduled_at <= $2
+		FOR UPDATE SKIP LOCKED`,
+		id, now).Scan(&audience, &segmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{id}
+	audienceQuery, ok := campaignAudienceQueries[audience]
+	if audience == models.AudienceSegment && segmentID != nil {
+		// The segment's parameters follow the campaign ID
+		q, err := segmentQuery(tx, *segmentID, now, len(args))
+		if err != nil {
+			return nil, fmt.Errorf("error compiling segment %d: %v", *segmentID, err)
+		}
+		audienceQuery = `SELECT ` + q.Email + ` FROM ` + q.From + ` WHERE ` + q.Where
+		args = append(args, q.Args...)
+	} else if !ok {
+		return nil, fmt.Errorf("unknown audience %q", audience)
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO email_campaign_recipients (campaign_id, email)
+		SELECT DISTINCT $1::INTEGER, a.email
+		FROM (`+audienceQuery+`) AS a(email)
+		WHERE NOT EXISTS (
+			SELECT 1 FROM newsletter_subscriptions ns
+			WHERE lower(ns.email) = a.email AND NOT ns.subscribed AND ns.unsubscribed_at IS NOT NULL
+		)
+		ON CONFLICT (campaign_id, email) DO NOTHING`,
+		args...); err != nil {
+		return nil, fmt.Errorf("error adding recipients: %v", err)
+	}
+
+	c, err := scanCampaign(tx.QueryRow(`
+		UPDATE email_campaigns
+		SET status = 'sending', started_at = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+campaignColumns,
+		id, now))
+	if err != nil {
+		return nil, err
+	}
+	return c, tx.Commit()
+}
+
+// GetSendingCampaigns returns the campaigns that are being sent
+func (db *DB) GetSendingCampaigns() ([]models.Campaign, error) {
+	rows, err := db.Query(`
+		SELECT ` + campaignColumns + `
+		FROM email_campaigns
+		WHERE status = 'sending'
+		ORDER BY started_at`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying sending campaigns: %v", err)
+	}
+	defer rows.Close()
+
+	var campaigns []models.Campaign
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning campaign: %v", err)
+		}
+		campaigns = append(campaigns, *c)
+	}
+	return campaigns, rows.Err()
+}
+
+// GetPendingCampaignRecipients returns up to limit recipients of a campaign that weren't queued yet
+func (db *DB) GetPendingCampaignRecipients(campaignID int, limit int) ([]models.CampaignRecipient, error) {
+	rows, err := db.Query(`
+		SELECT id, campaign_id, email
+		FROM email_campaign_recipients
+		WHERE campaign_id = $1 AND status = 'pending'
+		ORDER BY id
+		LIMIT $2`,
+		campaignID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying pending recipients: %v", err)
+	}
+	defer rows.Close()
+
+	var recipients []models.CampaignRecipient
+	for rows.Next() {
+		r := models.CampaignRecipient{Status: "pending"}
+		if err := rows.Scan(&r.ID, &r.CampaignID, &r.Email); err != nil {
+			return nil, fmt.Errorf("error scanning recipient: %v", err)
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
+
+// QueueCampaignEmail adds a recipient's email to the outbox and marks the recipient as
+// queued. The email's idempotency key keeps a recipient from being emailed twice when
+// several workers race.
+func (db *DB) QueueCampaignEmail(recipientID int64, e *models.OutboundEmail) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := enqueueEmail(tx, e); err != nil {
+		return fmt.Errorf("error queueing email: %v", err)
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE email_campaign_recipients
+		SET status = 'queued',
+		    outbox_id = (SELECT id FROM email_outbox WHERE idempotency_key = $2),
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'pending'`,
+		recipientID, e.IdempotencyKey); err != nil {
+		return fmt.Errorf("error marking recipient queued: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// SkipCampaignRecipient marks a recipient whose email couldn't be built as skipped
+func (db *DB) SkipCampaignRecipient(recipientID int64, reason string) error {
+	_, err := db.Exec(`
+		UPDATE email_campaign_recipients
+		SET status = 'skipped', error = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'pending'`,
+		recipientID, reason)
+	return err
+}
+
+// CompleteCampaign marks a sending campaign as sent once no recipients are pending.
+// It returns false if recipients are still pending.
+func (db *DB) CompleteCampaign(id int, completedAt time.Time) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE email_campaigns
+		SET status = 'sent', completed_at = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'sending'
+		  AND NOT EXISTS (
+			SELECT 1 FROM email_campaign_recipients WHERE campaign_id = $1 AND status = 'pending'
+		  )`,
+		id, completedAt)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// GetCampaignWithStats returns a campaign with its delivery stats and link clicks
+func (db *DB) GetCampaignWithStats(id int) (*models.CampaignWithStats, error) {
+	c, err := db.GetCampaign(id)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := db.getCampaignStats(id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT k.url, COUNT(*), COUNT(DISTINCT k.recipient_id)
+		FROM email_campaign_clicks k
+		JOIN email_campaign_recipients r ON r.id = k.recipient_id
+		WHERE r.campaign_id = $1
+		GROUP BY k.url
+		ORDER BY COUNT(*) DESC, k.url`,
+		id)
+	if err != nil {
+		return nil, fmt.Errorf("error querying link clicks: %v", err)
+	}
+	defer rows.Close()
+
+	result := &models.CampaignWithStats{Campaign: *c, Stats: *stats, Links: []models.CampaignLinkStats{}}
+	for rows.Next() {
+		var link models.CampaignLinkStats
+		if err := rows.Scan(&link.URL, &link.Clicks, &link.Clicked); err != nil {
+			return nil, fmt.Errorf("error scanning link clicks: %v", err)
+		}
+		result.Links = append(result.Links, link)
+	}
+	return result, rows.Err()
+}
+
+// getCampaignStats counts the recipients of a campaign by delivery status and engagement
+func (db *DB) getCampaignStats(id int) (*models.CampaignStats, error) {
+	var s models.CampaignStats
+	err := db.QueryRow(`
+		SELECT
+			COUNT(*),
+			COUNT(*) FILTER (WHERE status = 'pending'),
+			COUNT(*) FILTER (WHERE status = 'queued'),
+			COUNT(*) FILTER (WHERE status = 'sent'),
+			COUNT(*) FILTER (WHERE status IN ('failed', 'skipped')),
+			COUNT(*) FILTER (WHERE status IN ('suppressed', 'opted_out')),
+			COUNT(*) FILTER (WHERE opened_at IS NOT NULL),
+			COUNT(*) FILTER (WHERE clicked_at IS NOT NULL),
+			COALESCE(SUM(click_count), 0)
+		FROM (
+			SELECT `+campaignRecipientStatus+` AS status, r.opened_at, r.clicked_at, r.click_count
+			FROM email_campaign_recipients r
+			LEFT JOIN email_outbox o ON o.id = r.outbox_id
+			WHERE r.campaign_id = $1 AND r.status <> 'cancelled'
+		) recipients`,
+		id).Scan(
+		&s.Recipients,
+		&s.Pending,
+		&s.Queued,
+		&s.Sent,
+		&s.Failed,
+		&s.Suppressed,
+		&s.Opened,
+		&s.Clicked,
+		&s.Clicks,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying campaign stats: %v", err)
+	}
+	return &s, nil
+}
+
+// GetCampaignRecipients lists the recipients of a campaign with their delivery status.
+// An empty status lists all recipients.
+func (db *DB) GetCampaignRecipients(campaignID int, status string, page int, limit int) ([]models.CampaignRecipient, int, error) {
+	offset := (page - 1) * limit
+
+	base := `
+		FROM (
+			SELECT r.id, r.campaign_id, r.email, ` + campaignRecipientStatus + ` AS status,
+				COALESCE(r.error, o.last_error, '') AS error, o.sent_at,
+				r.opened_at, r.open_count, r.clicked_at, r.click_count
+			FROM email_campaign_recipients r
+			LEFT JOIN email_outbox o ON o.id = r.outbox_id
+			WHERE r.campaign_id = $1
+		) recipients
+		WHERE $2 = '' OR status = $2`
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) `+base, campaignID, status).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error counting recipients: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT id, campaign_id, email, status, error, sent_at, opened_at, open_count, clicked_at, click_count
+		`+base+`
+		ORDER BY id
+		LIMIT $3 OFFSET $4`,
+		campaignID, status, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying recipients: %v", err)
+	}
+	defer rows.Close()
+
+	recipients := []models.CampaignRecipient{}
+	for rows.Next() {
+		var r models.CampaignRecipient
+		if err := rows.Scan(
+			&r.ID,
+			&r.CampaignID,
+			&r.Email,
+			&r.Status,
+			&r.Error,
+			&r.SentAt,
+			&r.OpenedAt,
+			&r.OpenCount,
+			&r.ClickedAt,
+			&r.ClickCount,
+		); err != nil {
+			return nil, 0, fmt.Errorf("error scanning recipient: %v", err)
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, total, rows.Err()
+}
+
+// RecordCampaignOpen records that a recipient opened a campaign email
+func (db *DB) RecordCampaignOpen(recipientID int64, openedAt time.Time) error {
+	_, err := db.Exec(`
+		UPDATE email_campaign_recipients
+		SET opened_at = COALESCE(opened_at, $2), open_count = open_count + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		recipientID, openedAt)
+	return err
+}
+
+// RecordCampaignClick records that a recipient clicked a link in a campaign email. A click
+// also counts as an open, since the tracking pixel is often blocked.
+func (db *DB) RecordCampaignClick(recipientID int64, url string, clickedAt time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE email_campaign_recipients
+		SET clicked_at = COALESCE(clicked_at, $2), click_count = click_count + 1,
+		    opened_at = COALESCE(opened_at, $2), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		recipientID, clickedAt)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO email_campaign_clicks (recipient_id, url, clicked_at)
+		VALUES ($1, $2, $3)`,
+		recipientID, url, clickedAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
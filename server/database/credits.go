@@ -0,0 +1,585 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"saas-server/models"
+	"time"
+)
+
+var (
+	// ErrInsufficientCredits is returned when a debit exceeds the user's balance
+	ErrInsufficientCredits = errors.New("insufficient credits")
+	// ErrCreditVersionConflict is returned when the balance changed since the expected version was read
+	ErrCreditVersionConflict = errors.New("credit balance changed")
+)
+
+// creditSystemAccounts maps each transaction type to the system account on the other side of the ledger
+var creditSystemAccounts = map[string]string{
+	models.CreditPurchase:    "purchases",
+	models.CreditGrant:       "grants",
+	models.CreditConsumption: "consumption",
+	models.CreditExpiration:  "expirations",
+	models.CreditReversal:    "purchases",
+}
+
+// creditTransactionColumns lists the columns read by scanCreditTransaction, in order
+const creditTransactionColumns = `id, user_id, type, amount, balance_after, COALESCE(description, ''), order_id, created_at`
+
+// scanCreditTransaction scans a single credit transaction row
+func scanCreditTransaction(row rowScanner) (*models.CreditTransaction, error) {
+	var t models.CreditTransaction
+	var orderID sql.NullInt64
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Type,
+		&t.Amount,
+		&t.BalanceAfter,
+		&t.Description,
+		&orderID,
+		&t.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if orderID.Valid {
+		id := int(orderID.Int64)
+		t.OrderID = &id
+	}
+	return &t, nil
+}
+
+// creditPosting describes a change of a user's balance to be written to the ledger
+type creditPosting struct {
+	userID      string
+	txType      string
+	amount      int    // Change of the user's balance, negative for debits
+	reference   string // Idempotency key, empty for none
+	description string
+	orderID     *int
+	expiresAt   *time.Time // Expiry of the lot created by a credit
+	lotID       int64      // Lot a debit is taken from; zero takes from the soonest-expiring lots
+}
+
+// GetCreditBalance returns a user's spendable balance. Credits that expired but
+// haven't been processed by the expiry job yet are left out.
+func (db *DB) GetCreditBalance(userID string, now time.Time) (*models.CreditBalance, error) {
+	balance := &models.CreditBalance{Expiring: []models.CreditLot{}}
+	err := db.QueryRow(`
+		SELECT a.balance - COALESCE((
+		           SELECT SUM(remaining) FROM credit_lots
+		           WHERE user_id = a.user_id AND remaining > 0 AND expires_at <= $2
+		       ), 0),
+		       a.version
+		FROM credit_accounts a
+		WHERE a.user_id = $1`,
+		userID, now).Scan(&balance.Balance, &balance.Version)
+	if err == sql.ErrNoRows {
+		return balance, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, amount, remaining, expires_at, created_at
+		FROM credit_lots
+		WHERE user_id = $1 AND remaining > 0 AND expires_at > $2
+		ORDER BY expires_at, id`,
+		userID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var lot models.CreditLot
+		if err := rows.Scan(&lot.ID, &lot.Amount, &lot.Remaining, &lot.ExpiresAt, &lot.CreatedAt); err != nil {
+			return nil, err
+		}
+		balance.Expiring = append(balance.Expiring, lot)
+	}
+	return balance, rows.Err()
+}
+
+// GetCreditTransactions returns a page of a user's credit transactions, newest first
+func (db *DB) GetCreditTransactions(userID string, page int, limit int) ([]models.CreditTransaction, int, error) {
+	offset := (page - 1) * limit
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM credit_transactions WHERE user_id = $1`, userID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error counting credit transactions: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT `+creditTransactionColumns+`
+		FROM credit_transactions
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3`,
+		userID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying credit transactions: %v", err)
+	}
+	defer rows.Close()
+
+	transactions := []models.CreditTransaction{}
+	for rows.Next() {
+		t, err := scanCreditTransaction(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error scanning credit transaction: %v", err)
+		}
+		transactions = append(transactions, *t)
+	}
+	return transactions, total, rows.Err()
+}
+
+// AddCredits credits a user's balance with a purchase or grant. Credits with a non-nil
+// expiresAt expire at that time. Replaying a reference returns the original transaction
+// with created=false.
+func (db *DB) AddCredits(userID string, txType string, amount int, reference string, description string, orderID *int, expiresAt *time.Time) (*models.CreditTransaction, bool, error) {
+	if txType != models.CreditPurchase && txType != models.CreditGrant {
+		return nil, false, fmt.Errorf("invalid credit type %q", txType)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	if err := lockCreditAccount(tx, userID); err != nil {
+		return nil, false, err
+	}
+
+	t, err := postCredits(tx, creditPosting{
+		userID:      userID,
+		txType:      txType,
+		amount:      amount,
+		reference:   reference,
+		description: description,
+		orderID:     orderID,
+		expiresAt:   expiresAt,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if t == nil {
+		tx.Rollback()
+		existing, err := db.getCreditTransactionByReference(reference)
+		return existing, false, err
+	}
+	return t, true, tx.Commit()
+}
+
+// DebitCredits spends a user's credits, taking them from the soonest-expiring lots first.
+// When expectedVersion is set the debit fails with ErrCreditVersionConflict if the balance
+// changed since that version was read. Replaying a reference returns the original
+// transaction with created=false.
+func (db *DB) DebitCredits(userID string, amount int, expectedVersion *int, reference string, description string, now time.Time) (*models.CreditTransaction, bool, error) {
+	if reference != "" {
+		existing, err := db.getCreditTransactionByReference(reference)
+		if err == nil {
+			return existing, false, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, false, err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	if err := lockCreditAccount(tx, userID); err != nil {
+		return nil, false, err
+	}
+
+	if expectedVersion != nil {
+		var version int
+		if err := tx.QueryRow(`SELECT version FROM credit_accounts WHERE user_id = $1`, userID).Scan(&version); err != nil {
+			return nil, false, err
+		}
+		if version != *expectedVersion {
+			return nil, false, ErrCreditVersionConflict
+		}
+	}
+
+	// Expire due credits first so they can't be spent
+	if err := expireDueLots(tx, userID, now); err != nil {
+		return nil, false, err
+	}
+
+	t, err := postCredits(tx, creditPosting{
+		userID:      userID,
+		txType:      models.CreditConsumption,
+		amount:      -amount,
+		reference:   reference,
+		description: description,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if t == nil {
+		// A concurrent request with the same reference won
+		tx.Rollback()
+		existing, err := db.getCreditTransactionByReference(reference)
+		return existing, false, err
+	}
+	return t, true, tx.Commit()
+}
+
+// GetOrderCreditPurchase retrieves the top-up credited for an order
+func (db *DB) GetOrderCreditPurchase(orderID int) (*models.CreditTransaction, error) {
+	return scanCreditTransaction(db.QueryRow(`
+		SELECT `+creditTransactionColumns+` FROM credit_transactions WHERE order_id = $1 AND type = $2`,
+		orderID, models.CreditPurchase))
+}
+
+// ReverseOrderCredits takes back the credits bought with an order after a refund, up to
+// reverseTotal credits across all refunds of the order. Credits already spent or expired
+// can't be taken back. It returns nil if the order bought no credits or nothing is left
+// to reverse.
+func (db *DB) ReverseOrderCredits(orderID int, reverseTotal int, reference string) (*models.CreditTransaction, bool, error) {
+	purchase, err := db.GetOrderCreditPurchase(orderID)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	userID := purchase.UserID
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	if err := lockCreditAccount(tx, userID); err != nil {
+		return nil, false, err
+	}
+
+	var lotID int64
+	var remaining int
+	err = tx.QueryRow(`
+		SELECT l.id, l.remaining
+		FROM credit_lots l
+		JOIN credit_transactions t ON t.id = l.transaction_id
+		WHERE t.order_id = $1 AND t.type = $2
+		FOR UPDATE OF l`,
+		orderID, models.CreditPurchase).Scan(&lotID, &remaining)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var reversed int
+	err = tx.QueryRow(`
+		SELECT COALESCE(-SUM(amount), 0) FROM credit_transactions WHERE order_id = $1 AND type = $2`,
+		orderID, models.CreditReversal).Scan(&reversed)
+	if err != nil {
+		return nil, false, err
+	}
+
+	amount := reverseTotal - reversed
+	if amount > remaining {
+		amount = remaining
+	}
+	if amount <= 0 {
+		return nil, false, nil
+	}
+
+	t, err := postCredits(tx, creditPosting{
+		userID:      userID,
+		txType:      models.CreditReversal,
+		amount:      -amount,
+		reference:   reference,
+		description: fmt.Sprintf("Refund of order %d", orderID),
+		orderID:     &orderID,
+		lotID:       lotID,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if t == nil {
+		tx.Rollback()
+		existing, err := db.getCreditTransactionByReference(reference)
+		return existing, false, err
+	}
+	return t, true, tx.Commit()
+}
+
+// ExpireCredits moves the unspent remainder of every lot that expired before now to the
+// expirations account. It returns the number of lots expired.
+func (db *DB) ExpireCredits(now time.Time) (int, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT user_id FROM credit_lots WHERE remaining > 0 AND expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, userID := range userIDs {
+		n, err := db.expireUserCredits(userID, now)
+		if err != nil {
+			return expired, fmt.Errorf("error expiring credits of user %s: %v", userID, err)
+		}
+		expired += n
+	}
+	return expired, nil
+}
+
+// expireUserCredits expires the due lots of a single user in one transaction
+func (db *DB) expireUserCredits(userID string, now time.Time) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := lockCreditAccount(tx, userID); err != nil {
+		return 0, err
+	}
+
+	var due int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM credit_lots WHERE user_id = $1 AND remaining > 0 AND expires_at <= $2`,
+		userID, now).Scan(&due)
+	if err != nil {
+		return 0, err
+	}
+	if err := expireDueLots(tx, userID, now); err != nil {
+		return 0, err
+	}
+	return due, tx.Commit()
+}
+
+// getCreditTransactionByReference retrieves a credit transaction by its idempotency key
+func (db *DB) getCreditTransactionByReference(reference string) (*models.CreditTransaction, error) {
+	return scanCreditTransaction(db.QueryRow(`
+		SELECT `+creditTransactionColumns+` FROM credit_transactions WHERE reference = $1`, reference))
+}
+
+// lockCreditAccount creates the user's credit account if needed and locks it for the rest of
+// the transaction. Every posting locks the user's account before any of their lots, so
+// concurrent postings for the same user are serialised without deadlocking.
+func lockCreditAccount(tx *sql.Tx, userID string) error {
+	_, err := tx.Exec(`
+		INSERT INTO credit_accounts (user_id) VALUES ($1)
+		ON CONFLICT (user_id) DO NOTHING`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`SELECT id FROM credit_accounts WHERE user_id = $1 FOR UPDATE`, userID)
+	return err
+}
+
+// expireDueLots posts an expiration for each of the user's lots that expired before now.
+// The user's account must be locked.
+func expireDueLots(tx *sql.Tx, userID string, now time.Time) error {
+	rows, err := tx.Query(`
+		SELECT id, remaining FROM credit_lots
+		WHERE user_id = $1 AND remaining > 0 AND expires_at <= $2
+		ORDER BY expires_at, id`,
+		userID, now)
+	if err != nil {
+		return err
+	}
+
+	type dueLot struct {
+		id        int64
+		remaining int
+	}
+	var lots []dueLot
+	for rows.Next() {
+		var lot dueLot
+		if err := rows.Scan(&lot.id, &lot.remaining); err != nil {
+			rows.Close()
+			return err
+		}
+		lots = append(lots, lot)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, lot := range lots {
+		_, err := postCredits(tx, creditPosting{
+			userID:      userID,
+			txType:      models.CreditExpiration,
+			amount:      -lot.remaining,
+			reference:   fmt.Sprintf("expire:%d", lot.id),
+			description: "Credits expired",
+			lotID:       lot.id,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postCredits writes a transaction, its balanced ledger entries and the matching lot changes.
+// The user's account must be locked. It returns nil without changing anything if a
+// transaction with the same reference already exists.
+func postCredits(tx *sql.Tx, p creditPosting) (*models.CreditTransaction, error) {
+	account, ok := creditSystemAccounts[p.txType]
+	if !ok {
+		return nil, fmt.Errorf("invalid credit type %q", p.txType)
+	}
+	if p.amount == 0 {
+		return nil, fmt.Errorf("credit amount must not be zero")
+	}
+
+	var reference interface{}
+	if p.reference != "" {
+		reference = p.reference
+	}
+
+	// Insert first so a duplicate reference is detected before any balance changes
+	var id int64
+	err := tx.QueryRow(`
+		INSERT INTO credit_transactions (user_id, type, amount, balance_after, reference, description, order_id, created_at)
+		VALUES ($1, $2, $3, 0, $4, NULLIF($5, ''), $6, CURRENT_TIMESTAMP)
+		ON CONFLICT (reference) DO NOTHING
+		RETURNING id`,
+		p.userID, p.txType, p.amount, reference, p.description, p.orderID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var userAccountID, balanceAfter int
+	err = tx.QueryRow(`
+		UPDATE credit_accounts
+		SET balance = balance + $2, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND balance + $2 >= 0
+		RETURNING id, balance`,
+		p.userID, p.amount).Scan(&userAccountID, &balanceAfter)
+	if err == sql.ErrNoRows {
+		return nil, ErrInsufficientCredits
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var systemAccountID int
+	err = tx.QueryRow(`
+		UPDATE credit_accounts
+		SET balance = balance - $2, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE name = $1
+		RETURNING id`,
+		account, p.amount).Scan(&systemAccountID)
+	if err != nil {
+		return nil, fmt.Errorf("error updating %s account: %v", account, err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO credit_entries (transaction_id, account_id, amount)
+		VALUES ($1, $2, $3), ($1, $4, -$3::BIGINT)`,
+		id, userAccountID, p.amount, systemAccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.amount > 0 {
+		_, err = tx.Exec(`
+			INSERT INTO credit_lots (user_id, transaction_id, amount, remaining, expires_at)
+			VALUES ($1, $2, $3, $3, $4)`,
+			p.userID, id, p.amount, p.expiresAt)
+	} else if p.lotID != 0 {
+		err = takeFromLot(tx, p.lotID, -p.amount)
+	} else {
+		err = takeFromLots(tx, p.userID, -p.amount)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return scanCreditTransaction(tx.QueryRow(`
+		UPDATE credit_transactions SET balance_after = $2 WHERE id = $1
+		RETURNING `+creditTransactionColumns,
+		id, balanceAfter))
+}
+
+// takeFromLot removes amount credits from a single lot
+func takeFromLot(tx *sql.Tx, lotID int64, amount int) error {
+	result, err := tx.Exec(`
+		UPDATE credit_lots SET remaining = remaining - $2 WHERE id = $1 AND remaining >= $2`,
+		lotID, amount)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInsufficientCredits
+	}
+	return nil
+}
+
+// takeFromLots removes amount credits from the user's unexpired lots, soonest-expiring first
+func takeFromLots(tx *sql.Tx, userID string, amount int) error {
+	rows, err := tx.Query(`
+		SELECT id, remaining FROM credit_lots
+		WHERE user_id = $1 AND remaining > 0
+		ORDER BY expires_at NULLS LAST, id
+		FOR UPDATE`,
+		userID)
+	if err != nil {
+		return err
+	}
+
+	type take struct {
+		id     int64
+		amount int
+	}
+	var takes []take
+	left := amount
+	for rows.Next() && left > 0 {
+		var id int64
+		var remaining int
+		if err := rows.Scan(&id, &remaining); err != nil {
+			rows.Close()
+			return err
+		}
+		n := remaining
+		if n > left {
+			n = left
+		}
+		takes = append(takes, take{id, n})
+		left -= n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if left > 0 {
+		return fmt.Errorf("credit lots of user %s don't cover the balance", userID)
+	}
+
+	for _, t := range takes {
+		if err := takeFromLot(tx, t.id, t.amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"database/sql"
+	"saas-server/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// discountColumns lists the columns read by scanDiscount, in order
+const discountColumns = `
+		id, provider_discount_id, name, code, amount, amount_type, duration, duration_in_months,
+		max_redemptions, variant_ids, starts_at, expires_at, status, disabled_at, created_at, updated_at`
+
+// scanDiscount scans a single discount row
+func scanDiscount(row rowScanner) (*models.Discount, error) {
+	var d models.Discount
+	var durationInMonths, maxRedemptions sql.NullInt64
+	var variantIDs pq.Int64Array
+	err := row.Scan(
+		&d.ID,
+		&d.ProviderDiscountID,
+		&d.Name,
+		&d.Code,
+		&d.Amount,
+		&d.AmountType,
+		&d.Duration,
+		&durationInMonths,
+		&maxRedemptions,
+		&variantIDs,
+		&d.StartsAt,
+		&d.ExpiresAt,
+		&d.Status,
+		&d.DisabledAt,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if durationInMonths.Valid {
+		months := int(durationInMonths.Int64)
+		d.DurationInMonths = &months
+	}
+	if maxRedemptions.Valid {
+		max := int(maxRedemptions.Int64)
+		d.MaxRedemptions = &max
+	}
+	d.VariantIDs = make([]int, len(variantIDs))
+	for i, id := range variantIDs {
+		d.VariantIDs[i] = int(id)
+	}
+	return &d, nil
+}
+
+// CreateDiscount stores a discount that was created with the payment provider
+func (db *DB) CreateDiscount(d *models.Discount) (*models.Discount, error) {
+	variantIDs := make(pq.Int64Array, len(d.VariantIDs))
+	for i, id := range d.VariantIDs {
+		variantIDs[i] = int64(id)
+	}
+
+	query := `
+		INSERT INTO discounts (
+			provider_discount_id, name, code, amount, amount_type, duration, duration_in_months,
+			max_redemptions, variant_ids, starts_at, expires_at, status, created_at, updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, 'active', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING ` + discountColumns
+
+	return scanDiscount(db.QueryRow(query,
+		d.ProviderDiscountID,
+		d.Name,
+		d.Code,
+		d.Amount,
+		d.AmountType,
+		d.Duration,
+		d.DurationInMonths,
+		d.MaxRedemptions,
+		variantIDs,
+		d.StartsAt,
+		d.ExpiresAt,
+	))
+}
+
+// GetDiscountByID retrieves a discount by its ID
+func (db *DB) GetDiscountByID(id int) (*models.Discount, error) {
+	query := `
+		SELECT ` + discountColumns + `
+		FROM discounts
+		WHERE id = $1`
+
+	return scanDiscount(db.QueryRow(query, id))
+}
+
+// GetActiveDiscountByCode retrieves the active discount using a code, ignoring case
+func (db *DB) GetActiveDiscountByCode(code string) (*models.Discount, error) {
+	query := `
+		SELECT ` + discountColumns + `
+		FROM discounts
+		WHERE UPPER(code) = UPPER($1) AND status = 'active'`
+
+	return scanDiscount(db.QueryRow(query, code))
+}
+
+// GetDiscounts retrieves every discount with its redemption stats, newest first
+func (db *DB) GetDiscounts() ([]models.DiscountWithStats, error) {
+	rows, err := db.Query(`
+		SELECT ` + discountColumns + `
+		FROM discounts
+		ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var discounts []models.DiscountWithStats
+	index := make(map[int]int)
+	for rows.Next() {
+		d, err := scanDiscount(rows)
+		if err != nil {
+			return nil, err
+		}
+		index[d.ID] = len(discounts)
+		discounts = append(discounts, models.DiscountWithStats{
+			Discount: *d,
+			Stats:    models.DiscountStats{Totals: []models.DiscountCurrencyTotal{}},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	totals, err := db.Query(`
+		SELECT discount_id, currency, COUNT(*), MAX(created_at), SUM(discount_total), SUM(order_total)
+		FROM discount_redemptions
+		GROUP BY discount_id, currency
+		ORDER BY discount_id, currency`)
+	if err != nil {
+		return nil, err
+	}
+	defer totals.Close()
+
+	for totals.Next() {
+		var discountID, redemptions int
+		var lastRedeemedAt time.Time
+		var total models.DiscountCurrencyTotal
+		if err := totals.Scan(&discountID, &total.Currency, &redemptions, &lastRedeemedAt, &total.DiscountTotal, &total.OrderTotal); err != nil {
+			return nil, err
+		}
+
+		i, ok := index[discountID]
+		if !ok {
+			continue
+		}
+		stats := &discounts[i].Stats
+		stats.Redemptions += redemptions
+		if stats.LastRedeemedAt == nil || lastRedeemedAt.After(*stats.LastRedeemedAt) {
+			redeemedAt := lastRedeemedAt
+			stats.LastRedeemedAt = &redeemedAt
+		}
+		stats.Totals = append(stats.Totals, total)
+	}
+
+	return discounts, totals.Err()
+}
+
+// CountDiscountRedemptions returns how many orders used a discount
+func (db *DB) CountDiscountRedemptions(discountID int) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM discount_redemptions WHERE discount_id = $1`, discountID).Scan(&count)
+	return count, err
+}
+
+// DisableDiscount marks a discount as disabled so its code can't be applied anymore
+func (db *DB) DisableDiscount(id int) error {
+	query := `
+		UPDATE discounts
+		SET status = 'disabled',
+		    disabled_at = CURRENT_TIMESTAMP,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'active'`
+
+	result, err := db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RecordDiscountRedemption records an order placed with a discount code.
+// Redelivered webhooks for the same order are ignored.
+func (db *DB) RecordDiscountRedemption(code string, orderID int, userID string, currency string, discountTotal int, orderTotal int) error {
+	var user interface{}
+	if userID != "" {
+		user = userID
+	}
+
+	// Match disabled discounts too, an order can complete after its code was disabled
+	query := `
+		INSERT INTO discount_redemptions (discount_id, order_id, user_id, currency, discount_total, order_total, created_at)
+		SELECT id, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP
+		FROM discounts
+		WHERE UPPER(code) = UPPER($1)
+		ORDER BY (status = 'active') DESC, created_at DESC
+		LIMIT 1
+		ON CONFLICT (order_id) DO NOTHING`
+
+	_, err := db.Exec(query, code, orderID, user, currency, discountTotal, orderTotal)
+	return err
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"database/sql"
+	"saas-server/models"
+	"time"
+)
+
+// dunningCaseColumns lists the columns read by scanDunningCase, in order
+const dunningCaseColumns = `
+		id, subscription_id, user_id, status, failed_at, grace_ends_at,
+		emails_sent, last_email_at, resolved_at, created_at, updated_at`
+
+// scanDunningCase scans a single dunning case row
+func scanDunningCase(row rowScanner) (*models.DunningCase, error) {
+	var c models.DunningCase
+	err := row.Scan(
+		&c.ID,
+		&c.SubscriptionID,
+		&c.UserID,
+		&c.Status,
+		&c.FailedAt,
+		&c.GraceEndsAt,
+		&c.EmailsSent,
+		&c.LastEmailAt,
+		&c.ResolvedAt,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// OpenDunningCase starts a dunning case for a subscription unless one is already unresolved.
+// It returns the unresolved case and whether it was newly created.
+func (db *DB) OpenDunningCase(subscriptionID int, userID string, failedAt time.Time, graceEndsAt time.Time) (*models.DunningCase, bool, error) {
+	query := `
+		INSERT INTO dunning_cases (subscription_id, user_id, status, failed_at, grace_ends_at, created_at, updated_at)
+		VALUES ($1, $2, 'open', $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (subscription_id) WHERE status IN ('open', 'downgraded') DO NOTHING
+		RETURNING ` + dunningCaseColumns
+
+	c, err := scanDunningCase(db.QueryRow(query, subscriptionID, userID, failedAt, graceEndsAt))
+	if err == nil {
+		return c, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, err
+	}
+
+	c, err = db.GetUnresolvedDunningCase(subscriptionID)
+	if err != nil {
+		return nil, false, err
+	}
+	return c, false, nil
+}
+
+// GetUnresolvedDunningCase returns the open or downgraded case of a subscription
+func (db *DB) GetUnresolvedDunningCase(subscriptionID int) (*models.DunningCase, error) {
+	query := `
+		SELECT ` + dunningCaseColumns + `
+		FROM dunning_cases
+		WHERE subscription_id = $1 AND status IN ('open', 'downgraded')`
+
+	return scanDunningCase(db.QueryRow(query, subscriptionID))
+}
+
+// GetOpenDunningCases returns every case that is still within its dunning sequence
+func (db *DB) GetOpenDunningCases() ([]models.DunningCase, error) {
+	rows, err := db.Query(`
+		SELECT ` + dunningCaseColumns + `
+		FROM dunning_cases
+		WHERE status = 'open'
+		ORDER BY failed_at ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cases []models.DunningCase
+	for rows.Next() {
+		c, err := scanDunningCase(rows)
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, *c)
+	}
+
+	return cases, rows.Err()
+}
+
+// MarkDunningEmailSent records that the first emailsSent emails of a case's sequence have gone out
+func (db *DB) MarkDunningEmailSent(caseID int, emailsSent int, sentAt time.Time) error {
+	query := `
+		UPDATE dunning_cases
+		SET emails_sent = $1,
+		    last_email_at = $2,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3`
+
+	_, err := db.Exec(query, emailsSent, sentAt, caseID)
+	return err
+}
+
+// MarkDunningCaseDowngraded records that a case's grace period ran out
+func (db *DB) MarkDunningCaseDowngraded(caseID int) error {
+	query := `
+		UPDATE dunning_cases
+		SET status = 'downgraded',
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'open'`
+
+	_, err := db.Exec(query, caseID)
+	return err
+}
+
+// ResolveDunningCase marks the unresolved case of a subscription as recovered.
+// It returns the case as it was before recovery, or sql.ErrNoRows if there was none.
+func (db *DB) ResolveDunningCase(subscriptionID int, resolvedAt time.Time) (*models.DunningCase, error) {
+	query := `
+		UPDATE dunning_cases d
+		SET status = 'recovered',
+		    resolved_at = $1,
+		    updated_at = CURRENT_TIMESTAMP
+		FROM dunning_cases previous
+		WHERE previous.id = d.id
+		  AND d.subscription_id = $2
+		  AND d.status IN ('open', 'downgraded')
+		RETURNING previous.id, previous.subscription_id, previous.user_id, previous.status,
+		          previous.failed_at, previous.grace_ends_at, previous.emails_sent,
+		          previous.last_email_at, previous.resolved_at, previous.created_at, previous.updated_at`
+
+	return scanDunningCase(db.QueryRow(query, resolvedAt, subscriptionID))
+}
+
+// UpdateSubscriptionPaymentMethodURL stores the latest signed link for updating a subscription's payment method
+func (db *DB) UpdateSubscriptionPaymentMethodURL(subscriptionID int, url string) error {
+	query := `
+		UPDATE subscriptions
+		SET update_payment_method_url = $1
+		WHERE subscription_id = $2`
+
+	_, err := db.Exec(query, url, subscriptionID)
+	return err
+}
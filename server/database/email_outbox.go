@@ -0,0 +1,169 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"saas-server/models"
+	"time"
+)
+
+// queryer is implemented by both *sql.DB and *sql.Tx, so emails can be enqueued
+// inside the transaction of the change that triggered them
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// outboundEmailColumns lists the columns read by scanOutboundEmail, in order
+const outboundEmailColumns = `
+		id, COALESCE(idempotency_key, ''), to_address, subject, html_body, COALESCE(text_body, ''),
+		headers, critical, COALESCE(category, ''), status, attempts, next_attempt_at, COALESCE(last_error, ''),
+		COALESCE(provider_message_id, ''), sent_at, created_at, updated_at`
+
+// scanOutboundEmail scans a single outbox row
+func scanOutboundEmail(row rowScanner) (*models.OutboundEmail, error) {
+	var e models.OutboundEmail
+	var headers []byte
+	err := row.Scan(
+		&e.ID,
+		&e.IdempotencyKey,
+		&e.To,
+		&e.Subject,
+		&e.HTML,
+		&e.Text,
+		&headers,
+		&e.Critical,
+		&e.Category,
+		&e.Status,
+		&e.Attempts,
+		&e.NextAttemptAt,
+		&e.LastError,
+		&e.ProviderMessageID,
+		&e.SentAt,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if headers != nil {
+		if err := json.Unmarshal(headers, &e.Headers); err != nil {
+			return nil, err
+		}
+	}
+	return &e, nil
+}
+
+// enqueueEmail adds an email to the outbox. It returns false without adding it if an
+// email with the same idempotency key was already enqueued.
+func enqueueEmail(q queryer, e *models.OutboundEmail) (bool, error) {
+	var key interface{}
+	if e.IdempotencyKey != "" {
+		key = e.IdempotencyKey
+	}
+	var headers interface{}
+	if len(e.Headers) > 0 {
+		encoded, err := json.Marshal(e.Headers)
+		if err != nil {
+			return false, err
+		}
+		headers = encoded
+	}
+
+	err := q.QueryRow(`
+		INSERT INTO email_outbox (idempotency_key, to_address, subject, html_body, text_body, headers, critical, category, status, next_attempt_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, NULLIF($8, ''), 'pending', CURRENT_TIMESTAMP)
+		ON CONFLICT (idempotency_key) DO NOTHING
+		RETURNING id, status, next_attempt_at, created_at, updated_at`,
+		key, e.To, e.Subject, e.HTML, e.Text, headers, e.Critical, e.Category,
+	).Scan(&e.ID, &e.Status, &e.NextAttemptAt, &e.CreatedAt, &e.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// EnqueueEmail adds an email to the outbox. It returns false without adding it if an
+// email with the same idempotency key was already enqueued.
+func (db *DB) EnqueueEmail(e *models.OutboundEmail) (bool, error) {
+	return enqueueEmail(db, e)
+}
+
+// ClaimDueEmails marks up to limit emails that are due for sending as being sent and returns
+// them. Emails stay claimed for lease, after which another worker may pick them up again,
+// e.g. if the worker that claimed them crashed.
+func (db *DB) ClaimDueEmails(now time.Time, limit int, lease time.Duration) ([]models.OutboundEmail, error) {
+	rows, err := db.Query(`
+		UPDATE email_outbox
+		SET status = 'sending', attempts = attempts + 1, locked_until = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE (status = 'pending' AND next_attempt_at <= $2)
+			   OR (status = 'sending' AND locked_until <= $2)
+			ORDER BY next_attempt_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+outboundEmailColumns,
+		now.Add(lease), now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []models.OutboundEmail
+	for rows.Next() {
+		e, err := scanOutboundEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, *e)
+	}
+	return emails, rows.Err()
+}
+
+// MarkEmailSent records that an email was accepted by the provider
+func (db *DB) MarkEmailSent(id int64, providerMessageID string, sentAt time.Time) error {
+	_, err := db.Exec(`
+		UPDATE email_outbox
+		SET status = 'sent', provider_message_id = NULLIF($2, ''), sent_at = $3,
+		    locked_until = NULL, last_error = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		id, providerMessageID, sentAt)
+	return err
+}
+
+// MarkEmailFailed records a failed delivery attempt. The email is retried at retryAt,
+// or given up on when retryAt is nil.
+func (db *DB) MarkEmailFailed(id int64, errMsg string, retryAt *time.Time) error {
+	_, err := db.Exec(`
+		UPDATE email_outbox
+		SET status = CASE WHEN $3::TIMESTAMPTZ IS NULL THEN 'failed' ELSE 'pending' END,
+		    next_attempt_at = COALESCE($3, next_attempt_at),
+		    last_error = $2, locked_until = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		id, errMsg, retryAt)
+	return err
+}
+
+// MarkEmailSuppressed records that an email was not sent because its recipient is suppressed
+func (db *DB) MarkEmailSuppressed(id int64) error {
+	_, err := db.Exec(`
+		UPDATE email_outbox
+		SET status = 'suppressed', locked_until = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		id)
+	return err
+}
+
+// MarkEmailOptedOut records that an email wasn't sent because the recipient turned off its category
+func (db *DB) MarkEmailOptedOut(id int64) error {
+	_, err := db.Exec(`
+		UPDATE email_outbox
+		SET status = 'opted_out', locked_until = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		id)
+	return err
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"saas-server/models"
+	"strings"
+)
+
+// emailSuppressionColumns lists the columns read by scanEmailSuppression, in order
+const emailSuppressionColumns = `
+		id, email, reason, COALESCE(details, ''), COALESCE(provider_message_id, ''), event_count,
+		lifted_at, created_at, updated_at`
+
+// scanEmailSuppression scans a single suppression row
+func scanEmailSuppression(row rowScanner) (*models.EmailSuppression, error) {
+	var s models.EmailSuppression
+	err := row.Scan(
+		&s.ID,
+		&s.Email,
+		&s.Reason,
+		&s.Details,
+		&s.ProviderMessageID,
+		&s.EventCount,
+		&s.LiftedAt,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// SuppressEmail adds an address to the suppression list. If it is already suppressed, the
+// event is counted on the existing suppression, and a complaint takes precedence over a bounce
+// as the reason. created is false in that case.
+func (db *DB) SuppressEmail(email string, reason string, details string, providerMessageID string) (*models.EmailSuppression, bool, error) {
+	var created bool
+	row := db.QueryRow(`
+		INSERT INTO email_suppressions (email, reason, details, provider_message_id)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''))
+		ON CONFLICT (email) WHERE lifted_at IS NULL DO UPDATE
+		SET reason = CASE WHEN EXCLUDED.reason = 'complaint' THEN EXCLUDED.reason ELSE email_suppressions.reason END,
+		    details = COALESCE(EXCLUDED.details, email_suppressions.details),
+		    provider_message_id = COALESCE(EXCLUDED.provider_message_id, email_suppressions.provider_message_id),
+		    event_count = email_suppressions.event_count + 1,
+		    updated_at = CURRENT_TIMESTAMP
+		RETURNING `+emailSuppressionColumns+`, (xmax = 0)`,
+		normalizeEmail(email), reason, details, providerMessageID)
+
+	var s models.EmailSuppression
+	err := row.Scan(
+		&s.ID,
+		&s.Email,
+		&s.Reason,
+		&s.Details,
+		&s.ProviderMessageID,
+		&s.EventCount,
+		&s.LiftedAt,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+		&created,
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("error suppressing email: %v", err)
+	}
+	return &s, created, nil
+}
+
+// IsEmailSuppressed reports whether an address has an active suppression
+func (db *DB) IsEmailSuppressed(email string) (bool, error) {
+	var suppressed bool
+	err := db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM email_suppressions WHERE email = $1 AND lifted_at IS NULL
+		)`,
+		normalizeEmail(email)).Scan(&suppressed)
+	return suppressed, err
+}
+
+// GetEmailSuppressions lists suppressions, newest first. Lifted suppressions are only
+// included when includeLifted is set.
+func (db *DB) GetEmailSuppressions(page int, limit int, includeLifted bool) ([]models.EmailSuppression, int, error) {
+	offset := (page - 1) * limit
+
+	var total int
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FROM email_suppressions WHERE $1 OR lifted_at IS NULL`,
+		includeLifted).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error counting email suppressions: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT `+emailSuppressionColumns+`
+		FROM email_suppressions
+		WHERE $1 OR lifted_at IS NULL
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3`,
+		includeLifted, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying email suppressions: %v", err)
+	}
+	defer rows.Close()
+
+	suppressions := []models.EmailSuppression{}
+	for rows.Next() {
+		s, err := scanEmailSuppression(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error scanning email suppression: %v", err)
+		}
+		suppressions = append(suppressions, *s)
+	}
+	return suppressions, total, rows.Err()
+}
+
+// LiftEmailSuppression lifts the active suppression of an address so it is emailed again.
+// It returns sql.ErrNoRows if the address is not suppressed.
+func (db *DB) LiftEmailSuppression(email string) (*models.EmailSuppression, error) {
+	s, err := scanEmailSuppression(db.QueryRow(`
+		UPDATE email_suppressions
+		SET lifted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE email = $1 AND lifted_at IS NULL
+		RETURNING `+emailSuppressionColumns,
+		normalizeEmail(email)))
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error lifting email suppression: %v", err)
+	}
+	return s, nil
+}
+
+// normalizeEmail lowercases an address so suppressions match regardless of case
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
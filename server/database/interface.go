@@ -12,6 +12,7 @@ type DBInterface interface {
 	GetUserByID(id string) (*models.User, error)
 	CreateUser(email, password, name string, emailVerified bool) (*models.User, error)
 	UpdateUser(id, name, email string) error
+	UpdateUserLanguage(id, language string) error
 	UpdatePassword(id, hashedPassword string) error
 	UserExists(email string) (bool, error)
 	GetUserSubscriptionStatus(id string) (*models.UserSubscriptionStatus, error)
@@ -31,22 +32,48 @@ type DBInterface interface {
 	CleanupExpiredBlacklistedTokens() error //TODO: Implement this
 
 	// Password reset operations
-	CreatePasswordResetToken(userID string, token string, expiresAt time.Time) error
+	CreatePasswordResetToken(userID string, token string, expiresAt time.Time, notification *models.OutboundEmail) error
 	GetPasswordResetToken(token string) (string, error)
 	MarkPasswordResetTokenUsed(token string) error
 
 	// Order operations
 	GetUserOrders(userID string) ([]models.Orders, error)
 
+	// Billing profile operations
+	GetBillingProfile(userID string) (*models.BillingProfile, error)
+	UpsertBillingProfile(p *models.BillingProfile) error
+
+	// Invoice operations
+	UpsertInvoice(invoice *models.Invoice) error
+	GetUserBillingHistory(userID string, page, limit int) ([]models.BillingDocument, int, error)
+
 	// Subscription operations
 	GetSubscriptionByUserID(userID string) (*models.Subscription, error)
+	GetSubscriptionsByUserID(userID string) ([]models.Subscription, error)
+	GetActiveSubscriptionsByUserID(userID string) ([]models.Subscription, error)
+	SyncUserPrimarySubscription(userID string) error
+	UpdateSubscriptionPaymentMethodURL(subscriptionID int, url string) error
+	GetSubscriptionBySubscriptionID(subscriptionID int) (*models.Subscription, error)
+	GetSubscriptionEvents(userID string, subscriptionID int, page int, limit int) ([]models.SubscriptionEvent, int, error)
+
+	// Usage operations
+	RecordUsageEvent(userID string, subscriptionID int, quantity int, idempotencyKey string, occurredAt time.Time, periodStart time.Time, hardLimit *int) (*models.UsageEvent, bool, error)
+	GetSubscriptionUsageSince(subscriptionID int, since time.Time) (int, error)
+	GetPlanUsageLimit(variantID int) (*models.PlanUsageLimit, error)
+	GetAllPlanUsageLimits() ([]models.PlanUsageLimit, error)
+	UpsertPlanUsageLimit(variantID int, includedUnits int, hardLimit *int, unitLabel string) error
 
 	// Additional operations
-	CreateOrder(userID string, orderID int, customerID int, productID int, variantID int, status string, subtotalFormatted string, taxFormatted string, totalFormatted string, taxInclusive bool) error
-	UpdateOrderRefund(orderID int, refundedAt *time.Time, refundedAmountFormatted string) error
-	CreateSubscription(userID string, subscriptionID int, orderID int, customerID int, productID int, variantID int, status string, renewsAt *time.Time, endsAt *time.Time, trialEndsAt *time.Time) error
-	UpdateSubscription(subscriptionID int, status string, cancelled bool, productID int, variantID int, renewsAt *time.Time, endsAt *time.Time, trialEndsAt *time.Time) error
+	CreateOrder(userID string, orderID int, customerID int, productID int, variantID int, status string, currency string, subtotal int, tax int, total int, taxInclusive bool, receiptURL string, taxDetails models.OrderTaxDetails) error
+	UpdateOrderRefund(orderID int, refundedAt *time.Time, refundedAmount int) error
+	CreateSubscription(userID string, subscriptionID int, orderID int, customerID int, productID int, variantID int, status string, renewsAt *time.Time, endsAt *time.Time, trialEndsAt *time.Time, source string) error
+	UpdateSubscription(subscriptionID int, status string, cancelled bool, productID int, variantID int, renewsAt *time.Time, endsAt *time.Time, trialEndsAt *time.Time, source string) error
 	UpdateUserSubscription(userID string, subscriptionID int, status string, productID int, variantID int, renewalDate *time.Time, endDate *time.Time) error
-	StoreEmailVerificationToken(token, userID, email string, expiresAt time.Time) error
+	StoreEmailVerificationToken(token, userID, email string, expiresAt time.Time, notification *models.OutboundEmail) error
 	VerifyEmail(token string) error
+
+	// Email suppression operations
+	SuppressEmail(email string, reason string, details string, providerMessageID string) (*models.EmailSuppression, bool, error)
+	GetEmailSuppressions(page int, limit int, includeLifted bool) ([]models.EmailSuppression, int, error)
+	LiftEmailSuppression(email string) (*models.EmailSuppression, error)
 }
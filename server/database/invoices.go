@@ -0,0 +1,108 @@
+package database
+
+import (
+	"fmt"
+	"saas-server/models"
+	"saas-server/pkg/money"
+	"time"
+)
+
+// UpsertInvoice creates a subscription invoice or updates its status, amounts and refund details
+func (db *DB) UpsertInvoice(invoice *models.Invoice) error {
+	var userID interface{}
+	if invoice.UserID != "" {
+		userID = invoice.UserID
+	}
+
+	query := `
+		INSERT INTO invoices (
+			invoice_id, subscription_id, user_id, customer_id, billing_reason, status,
+			currency, subtotal, discount_total, tax, total,
+			refunded, refunded_amount, refunded_at,
+			card_brand, card_last_four, invoice_url,
+			created_at, updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, CURRENT_TIMESTAMP)
+		ON CONFLICT (invoice_id) DO UPDATE
+		SET status = EXCLUDED.status,
+		    user_id = COALESCE(EXCLUDED.user_id, invoices.user_id),
+		    subtotal = EXCLUDED.subtotal,
+		    discount_total = EXCLUDED.discount_total,
+		    tax = EXCLUDED.tax,
+		    total = EXCLUDED.total,
+		    refunded = EXCLUDED.refunded,
+		    refunded_amount = EXCLUDED.refunded_amount,
+		    refunded_at = EXCLUDED.refunded_at,
+		    invoice_url = COALESCE(NULLIF(EXCLUDED.invoice_url, ''), invoices.invoice_url),
+		    updated_at = CURRENT_TIMESTAMP`
+
+	createdAt := invoice.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	_, err := db.Exec(query,
+		invoice.InvoiceID, invoice.SubscriptionID, userID, invoice.CustomerID, invoice.BillingReason, invoice.Status,
+		invoice.Currency, invoice.Subtotal, invoice.DiscountTotal, invoice.Tax, invoice.Total,
+		invoice.Refunded, invoice.RefundedAmount, invoice.RefundedAt,
+		invoice.CardBrand, invoice.CardLastFour, invoice.InvoiceURL,
+		createdAt,
+	)
+	return err
+}
+
+// GetUserBillingHistory returns a page of the user's orders and renewal invoices, newest first.
+// Initial subscription invoices are skipped because the order for the same payment already carries its receipt.
+func (db *DB) GetUserBillingHistory(userID string, page int, limit int) ([]models.BillingDocument, int, error) {
+	offset := (page - 1) * limit
+
+	historyQuery := `
+		SELECT 'order' AS type, order_id AS id, status, '' AS billing_reason,
+		       currency, total,
+		       refunded_at IS NOT NULL AS refunded, COALESCE(receipt_url, '') AS download_url, created_at
+		FROM orders
+		WHERE user_id = $1
+		UNION ALL
+		SELECT 'subscription_invoice' AS type, invoice_id AS id, status, billing_reason,
+		       currency, total,
+		       refunded, COALESCE(invoice_url, '') AS download_url, created_at
+		FROM invoices
+		WHERE user_id = $1 AND billing_reason <> 'initial'`
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM (`+historyQuery+`) AS history`, userID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error counting billing history: %v", err)
+	}
+
+	rows, err := db.Query(historyQuery+` ORDER BY created_at DESC LIMIT $2 OFFSET $3`, userID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying billing history: %v", err)
+	}
+	defer rows.Close()
+
+	documents := []models.BillingDocument{}
+	for rows.Next() {
+		var doc models.BillingDocument
+		if err := rows.Scan(
+			&doc.Type,
+			&doc.ID,
+			&doc.Status,
+			&doc.BillingReason,
+			&doc.Currency,
+			&doc.Total,
+			&doc.Refunded,
+			&doc.DownloadURL,
+			&doc.CreatedAt,
+		); err != nil {
+			return nil, 0, fmt.Errorf("error scanning billing history: %v", err)
+		}
+		doc.TotalFormatted = money.Format(doc.Total, doc.Currency)
+		documents = append(documents, doc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating billing history: %v", err)
+	}
+
+	return documents, total, nil
+}
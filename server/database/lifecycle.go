@@ -0,0 +1,201 @@
+package database
+
+import (
+	"fmt"
+	"saas-server/models"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// lifecycleEventQueries select the user and time of each occurrence of a lifecycle event
+var lifecycleEventQueries = map[string]string{
+	models.LifecycleSignup: `
+		SELECT u.id AS user_id, u.created_at AS event_at FROM users u`,
+	models.LifecycleUnverified: `
+		SELECT u.id AS user_id, u.created_at AS event_at FROM users u
+		WHERE NOT COALESCE(u.email_verified, false)`,
+	models.LifecycleTrialEnding: `
+		SELECT t.user_id, t.ends_at AS event_at FROM trials t WHERE t.status = 'active'`,
+	models.LifecycleInactive: `
+		SELECT u.id AS user_id, GREATEST(
+			u.created_at,
+			(SELECT MAX(pv.created_at) FROM page_views pv WHERE pv.user_id = u.id),
+			(SELECT MAX(COALESCE(rt.last_used_at, rt.created_at)) FROM refresh_tokens rt WHERE rt.user_id = u.id)) AS event_at
+		FROM users u`,
+	models.LifecycleCancelled: `
+		SELECT se.user_id, se.created_at AS event_at FROM subscription_events se
+		WHERE se.new_cancelled AND NOT COALESCE(se.old_cancelled, false)`,
+}
+
+// lifecycleExitConditions are the conditions that stop a lifecycle email, with the user as u
+var lifecycleExitConditions = map[string]string{
+	models.LifecycleExitVerified: `COALESCE(u.email_verified, false)`,
+	models.LifecycleExitSubscribed: `EXISTS (
+		SELECT 1 FROM subscriptions s
+		WHERE s.user_id = u.id AND s.status IN ('active', 'on_trial') AND NOT s.cancelled)`,
+}
+
+// lifecycleRuleColumns lists the columns read by scanLifecycleRule, in order
+const lifecycleRuleColumns = `
+		id, name, event, delay_minutes, template, exit_conditions, enabled, enabled_at,
+		(SELECT COUNT(*) FROM lifecycle_sends ls WHERE ls.rule_id = lifecycle_rules.id AND ls.status = 'sent'),
+		(SELECT COUNT(*) FROM lifecycle_sends ls WHERE ls.rule_id = lifecycle_rules.id AND ls.status = 'exited'),
+		created_at, updated_at`
+
+// scanLifecycleRule scans a single lifecycle rule row
+func scanLifecycleRule(row rowScanner) (*models.LifecycleRule, error) {
+	var r models.LifecycleRule
+	err := row.Scan(
+		&r.ID,
+		&r.Name,
+		&r.Event,
+		&r.DelayMinutes,
+		&r.Template,
+		pq.Array(&r.ExitConditions),
+		&r.Enabled,
+		&r.EnabledAt,
+		&r.Sent,
+		&r.Exited,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if r.ExitConditions == nil {
+		r.ExitConditions = []string{}
+	}
+	return &r, nil
+}
+
+// CreateLifecycleRule creates a lifecycle rule. Enabled rules apply to emails due from at.
+func (db *DB) CreateLifecycleRule(r *models.LifecycleRule, at time.Time) error {
+	created, err := scanLifecycleRule(db.QueryRow(`
+		INSERT INTO lifecycle_rules (name, event, delay_minutes, template, exit_conditions, enabled, enabled_at)
+		VALUES ($1, $2, $3, $4, COALESCE($5::text[], '{}'), $6, CASE WHEN $6 THEN $7::timestamptz END)
+		RETURNING `+lifecycleRuleColumns,
+		r.Name, r.Event, r.DelayMinutes, r.Template, pq.Array(r.ExitConditions), r.Enabled, at))
+	if err != nil {
+		return err
+	}
+	*r = *created
+	return nil
+}
+
+// UpdateLifecycleRule updates a lifecycle rule. A rule that is turned on applies to emails
+// due from at, so users it missed while it was off don't all get it at once. It returns
+// sql.ErrNoRows if there is no rule with the ID.
+func (db *DB) UpdateLifecycleRule(r *models.LifecycleRule, at time.Time) (*models.LifecycleRule, error) {
+	return scanLifecycleRule(db.QueryRow(`
+		UPDATE lifecycle_rules
+		SET name = $2, event = $3, delay_minutes = $4, template = $5, exit_conditions = COALESCE($6::text[], '{}'),
+		    enabled_at = CASE WHEN NOT $7 THEN NULL WHEN enabled THEN enabled_at ELSE $8::timestamptz END,
+		    enabled = $7, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+lifecycleRuleColumns,
+		r.ID, r.Name, r.Event, r.DelayMinutes, r.Template, pq.Array(r.ExitConditions), r.Enabled, at))
+}
+
+// GetLifecycleRule returns a lifecycle rule by ID
+func (db *DB) GetLifecycleRule(id int) (*models.LifecycleRule, error) {
+	return scanLifecycleRule(db.QueryRow(`SELECT `+lifecycleRuleColumns+` FROM lifecycle_rules WHERE id = $1`, id))
+}
+
+// GetLifecycleRules returns every lifecycle rule, or only the enabled ones
+func (db *DB) GetLifecycleRules(enabledOnly bool) ([]models.LifecycleRule, error) {
+	rows, err := db.Query(`
+		SELECT `+lifecycleRuleColumns+`
+		FROM lifecycle_rules
+		WHERE enabled OR NOT $1
+		ORDER BY id`,
+		enabledOnly)
+	if err != nil {
+		return nil, fmt.Errorf("error querying lifecycle rules: %v", err)
+	}
+	defer rows.Close()
+
+	rules := []models.LifecycleRule{}
+	for rows.Next() {
+		r, err := scanLifecycleRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning lifecycle rule: %v", err)
+		}
+		rules = append(rules, *r)
+	}
+	return rules, rows.Err()
+}
+
+// DeleteLifecycleRule deletes a lifecycle rule and its send history. It returns false if
+// there is no rule with the ID.
+func (db *DB) DeleteLifecycleRule(id int) (bool, error) {
+	result, err := db.Exec(`DELETE FROM lifecycle_rules WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// GetLifecycleCandidates returns up to limit users a rule is due for: their event plus the
+// rule's delay is between notBefore and now, and the rule wasn't applied to that event yet.
+// Each candidate carries the first of the rule's exit conditions they meet.
+func (db *DB) GetLifecycleCandidates(r *models.LifecycleRule, notBefore time.Time, now time.Time, limit int) ([]models.LifecycleCandidate, error) {
+	events, ok := lifecycleEventQueries[r.Event]
+	if !ok {
+		return nil, fmt.Errorf("unknown lifecycle event %q", r.Event)
+	}
+
+	exit := "''"
+	if len(r.ExitConditions) > 0 {
+		var cases strings.Builder
+		cases.WriteString("CASE")
+		for _, condition := range r.ExitConditions {
+			expr, ok := lifecycleExitConditions[condition]
+			if !ok {
+				return nil, fmt.Errorf("unknown lifecycle exit condition %q", condition)
+			}
+			fmt.Fprintf(&cases, " WHEN %s THEN '%s'", expr, condition)
+		}
+		cases.WriteString(" ELSE '' END")
+		exit = cases.String()
+	}
+
+	rows, err := db.Query(`
+		SELECT u.id::text, u.email, u.name, COALESCE(u.language, ''), e.event_at, `+exit+`
+		FROM (`+events+`) e
+		JOIN users u ON u.id = e.user_id
+		WHERE e.event_at + make_interval(mins => $2) BETWEEN $3 AND $4
+		  AND NOT EXISTS (
+		      SELECT 1 FROM lifecycle_sends ls
+		      WHERE ls.rule_id = $1 AND ls.user_id = u.id AND ls.event_at = e.event_at)
+		ORDER BY e.event_at
+		LIMIT $5`,
+		r.ID, r.DelayMinutes, notBefore, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying lifecycle candidates: %v", err)
+	}
+	defer rows.Close()
+
+	var candidates []models.LifecycleCandidate
+	for rows.Next() {
+		var c models.LifecycleCandidate
+		if err := rows.Scan(&c.UserID, &c.Email, &c.Name, &c.Language, &c.EventAt, &c.ExitCondition); err != nil {
+			return nil, fmt.Errorf("error scanning lifecycle candidate: %v", err)
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// RecordLifecycleSend records that a rule was applied to an occurrence of its event for a
+// user, either by sending the email or because the user met an exit condition
+func (db *DB) RecordLifecycleSend(ruleID int, userID string, eventAt time.Time, status string, exitCondition string) error {
+	_, err := db.Exec(`
+		INSERT INTO lifecycle_sends (rule_id, user_id, event_at, status, exit_condition)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+		ON CONFLICT (rule_id, user_id, event_at) DO NOTHING`,
+		ruleID, userID, eventAt, status, exitCondition)
+	return err
+}
@@ -0,0 +1,14 @@
+-- Drop indexes first
+DROP INDEX IF EXISTS idx_usage_reports_status;
+DROP INDEX IF EXISTS idx_usage_events_unreported;
+DROP INDEX IF EXISTS idx_usage_events_user_id;
+DROP INDEX IF EXISTS idx_usage_events_subscription_occurred;
+
+-- Drop the tables
+DROP TABLE IF EXISTS plan_usage_limits;
+DROP TABLE IF EXISTS usage_events;
+DROP TABLE IF EXISTS usage_reports;
+
+-- Drop the subscription item columns
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS is_usage_based;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS subscription_item_id;
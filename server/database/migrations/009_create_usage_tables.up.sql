@@ -0,0 +1,49 @@
+-- Track the subscription item that usage is reported against
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS subscription_item_id INTEGER;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS is_usage_based BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Create usage_reports table for aggregated usage sent to the payment provider
+CREATE TABLE IF NOT EXISTS usage_reports (
+    id SERIAL PRIMARY KEY,
+    subscription_id INTEGER NOT NULL,
+    subscription_item_id INTEGER NOT NULL,
+    quantity INTEGER NOT NULL,
+    period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    period_end TIMESTAMP WITH TIME ZONE NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, reported, failed
+    provider_usage_record_id VARCHAR(255),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    reported_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create usage_events table for individual metered events
+CREATE TABLE IF NOT EXISTS usage_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL,
+    subscription_id INTEGER NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    idempotency_key VARCHAR(255) NOT NULL UNIQUE,
+    usage_report_id INTEGER REFERENCES usage_reports(id),
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Create plan_usage_limits table for the units included in each plan
+CREATE TABLE IF NOT EXISTS plan_usage_limits (
+    variant_id INTEGER PRIMARY KEY,
+    included_units INTEGER NOT NULL DEFAULT 0,
+    hard_limit INTEGER, -- NULL means unlimited
+    unit_label VARCHAR(50) NOT NULL DEFAULT 'calls',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes for frequently accessed columns
+CREATE INDEX IF NOT EXISTS idx_usage_events_subscription_occurred ON usage_events(subscription_id, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_usage_events_user_id ON usage_events(user_id);
+CREATE INDEX IF NOT EXISTS idx_usage_events_unreported ON usage_events(subscription_id) WHERE usage_report_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_usage_reports_status ON usage_reports(status);
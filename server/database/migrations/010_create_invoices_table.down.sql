@@ -0,0 +1,10 @@
+-- Drop indexes first
+DROP INDEX IF EXISTS idx_invoices_created_at;
+DROP INDEX IF EXISTS idx_invoices_subscription_id;
+DROP INDEX IF EXISTS idx_invoices_user_id;
+
+-- Drop the table
+DROP TABLE IF EXISTS invoices;
+
+-- Drop the receipt column
+ALTER TABLE orders DROP COLUMN IF EXISTS receipt_url;
@@ -0,0 +1,31 @@
+-- Keep the receipt link Lemon Squeezy sends with every order
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS receipt_url TEXT;
+
+-- Create invoices table for subscription invoices
+CREATE TABLE IF NOT EXISTS invoices (
+    id SERIAL PRIMARY KEY,
+    invoice_id INTEGER NOT NULL UNIQUE,
+    subscription_id INTEGER NOT NULL,
+    user_id UUID,
+    customer_id INTEGER NOT NULL,
+    billing_reason VARCHAR(50) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    subtotal INTEGER NOT NULL DEFAULT 0, -- All amounts are in the currency's minor unit (e.g. cents)
+    discount_total INTEGER NOT NULL DEFAULT 0,
+    tax INTEGER NOT NULL DEFAULT 0,
+    total INTEGER NOT NULL DEFAULT 0,
+    refunded BOOLEAN NOT NULL DEFAULT FALSE,
+    refunded_amount INTEGER NOT NULL DEFAULT 0,
+    refunded_at TIMESTAMP WITH TIME ZONE,
+    card_brand VARCHAR(50),
+    card_last_four VARCHAR(4),
+    invoice_url TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes for frequently accessed columns
+CREATE INDEX IF NOT EXISTS idx_invoices_user_id ON invoices(user_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_subscription_id ON invoices(subscription_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_created_at ON invoices(created_at);
@@ -0,0 +1,32 @@
+-- Restore the formatted amount columns
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS subtotal_formatted VARCHAR(50);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_formatted VARCHAR(50);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS total_formatted VARCHAR(50);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS refunded_amount_formatted VARCHAR(50);
+
+-- Rebuild them from the minor unit amounts, e.g. "12.00 USD"
+UPDATE orders
+SET subtotal_formatted = to_char(subtotal / scale, 'FM999999999990' || decimals) || ' ' || currency,
+    tax_formatted = to_char(tax / scale, 'FM999999999990' || decimals) || ' ' || currency,
+    total_formatted = to_char(total / scale, 'FM999999999990' || decimals) || ' ' || currency,
+    refunded_amount_formatted = CASE
+        WHEN refunded_amount > 0 THEN to_char(refunded_amount / scale, 'FM999999999990' || decimals) || ' ' || currency
+    END
+FROM (
+    SELECT id AS order_pk,
+           CASE WHEN currency IN ('JPY', 'KRW', 'VND', 'CLP', 'ISK') THEN 1.0 ELSE 100.0 END AS scale,
+           CASE WHEN currency IN ('JPY', 'KRW', 'VND', 'CLP', 'ISK') THEN '' ELSE '.00' END AS decimals
+    FROM orders
+) AS scales
+WHERE orders.id = scales.order_pk;
+
+ALTER TABLE orders ALTER COLUMN subtotal_formatted SET NOT NULL;
+ALTER TABLE orders ALTER COLUMN tax_formatted SET NOT NULL;
+ALTER TABLE orders ALTER COLUMN total_formatted SET NOT NULL;
+
+-- Drop the minor unit columns
+ALTER TABLE orders DROP COLUMN IF EXISTS refunded_amount;
+ALTER TABLE orders DROP COLUMN IF EXISTS total;
+ALTER TABLE orders DROP COLUMN IF EXISTS tax;
+ALTER TABLE orders DROP COLUMN IF EXISTS subtotal;
+ALTER TABLE orders DROP COLUMN IF EXISTS currency;
@@ -0,0 +1,101 @@
+-- Store order amounts as integer minor units (e.g. cents) with their ISO 4217 currency
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS currency CHAR(3);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS subtotal INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS total INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS refunded_amount INTEGER NOT NULL DEFAULT 0;
+
+-- Backfill the currency from the formatted total, e.g. "$1,299.00", "1.234,56 €" or "1,299.00 PLN".
+-- A bare "$" is taken as USD, like money.Format prints it; anything else is left unknown.
+UPDATE orders
+SET currency = CASE
+    WHEN total_formatted ~ '[A-Z]{3}' THEN substring(total_formatted FROM '([A-Z]{3})')
+    WHEN total_formatted ~ '^-?CA\$' THEN 'CAD'
+    WHEN total_formatted ~ '^-?NZ\$' THEN 'NZD'
+    WHEN total_formatted ~ '^-?A\$' THEN 'AUD'
+    WHEN total_formatted ~ '^-?R\$' THEN 'BRL'
+    WHEN total_formatted LIKE '%$%' THEN 'USD'
+    WHEN total_formatted LIKE '%€%' THEN 'EUR'
+    WHEN total_formatted LIKE '%£%' THEN 'GBP'
+    WHEN total_formatted LIKE '%¥%' THEN 'JPY'
+    WHEN total_formatted LIKE '%₹%' THEN 'INR'
+    WHEN total_formatted LIKE '%₩%' THEN 'KRW'
+END
+WHERE currency IS NULL;
+
+-- Refuse to guess the currency of the remaining orders. The migration is rolled back, so
+-- their formatted total can be given an ISO 4217 code before it runs again.
+DO $$
+DECLARE
+    unknown TEXT;
+BEGIN
+    SELECT string_agg(format('%s (%s)', order_id, total_formatted), ', ' ORDER BY order_id)
+    INTO unknown
+    FROM orders
+    WHERE currency IS NULL;
+
+    IF unknown IS NOT NULL THEN
+        RAISE EXCEPTION 'unknown currency of orders %; append the ISO 4217 code to their total_formatted, e.g. "12.00 USD", and restart', unknown;
+    END IF;
+END $$;
+
+-- minor_units parses a formatted amount into minor units with the rules of money.Parse:
+-- a "." or "," followed by exactly three digits groups thousands, any other separates the
+-- decimals, and a minus sign or parentheses make it negative. Amounts it can't parse abort
+-- the migration.
+CREATE FUNCTION pg_temp.minor_units(formatted TEXT, digits INTEGER) RETURNS INTEGER AS $$
+DECLARE
+    number TEXT;
+    whole TEXT;
+    fraction TEXT := '';
+    amount NUMERIC;
+BEGIN
+    IF formatted IS NULL OR btrim(formatted) = '' THEN
+        RETURN 0;
+    END IF;
+
+    number := regexp_replace(formatted, '[^0-9.,]', '', 'g');
+    IF number !~ '^[0-9]+([.,][0-9]+)*$' THEN
+        RAISE EXCEPTION 'cannot parse amount "%"', formatted;
+    END IF;
+
+    whole := number;
+    IF number ~ '[.,]' THEN
+        fraction := substring(number FROM '[.,]([0-9]+)$');
+        IF length(fraction) = 3 THEN
+            fraction := '';
+        ELSE
+            whole := left(number, length(number) - length(fraction) - 1);
+        END IF;
+    END IF;
+
+    amount := round((regexp_replace(whole, '[.,]', '', 'g') || '.' || fraction || '0')::NUMERIC * power(10::NUMERIC, digits));
+    IF formatted ~ '[-−]' OR formatted ~ '^\s*\(' THEN
+        amount := -amount;
+    END IF;
+    RETURN amount;
+END
+$$ LANGUAGE plpgsql;
+
+-- Backfill the amounts in the minor unit of their currency
+UPDATE orders
+SET subtotal = pg_temp.minor_units(subtotal_formatted, digits),
+    tax = pg_temp.minor_units(tax_formatted, digits),
+    total = pg_temp.minor_units(total_formatted, digits),
+    refunded_amount = pg_temp.minor_units(refunded_amount_formatted, digits)
+FROM (
+    SELECT id AS order_pk,
+           CASE WHEN currency IN ('JPY', 'KRW', 'VND', 'CLP', 'ISK') THEN 0 ELSE 2 END AS digits
+    FROM orders
+) AS currencies
+WHERE orders.id = currencies.order_pk;
+
+DROP FUNCTION pg_temp.minor_units(TEXT, INTEGER);
+
+ALTER TABLE orders ALTER COLUMN currency SET NOT NULL;
+
+-- Formatted amounts are now generated when orders are read
+ALTER TABLE orders DROP COLUMN IF EXISTS subtotal_formatted;
+ALTER TABLE orders DROP COLUMN IF EXISTS tax_formatted;
+ALTER TABLE orders DROP COLUMN IF EXISTS total_formatted;
+ALTER TABLE orders DROP COLUMN IF EXISTS refunded_amount_formatted;
@@ -0,0 +1,6 @@
+-- Drop indexes first
+DROP INDEX IF EXISTS idx_subscription_mrr_snapshots_subscription_id;
+
+-- Drop the tables
+DROP TABLE IF EXISTS revenue_snapshots;
+DROP TABLE IF EXISTS subscription_mrr_snapshots;
@@ -0,0 +1,31 @@
+-- Daily MRR of every subscription, used to derive MRR movements between days
+CREATE TABLE IF NOT EXISTS subscription_mrr_snapshots (
+    snapshot_date DATE NOT NULL,
+    subscription_id INTEGER NOT NULL,
+    user_id UUID NOT NULL,
+    variant_id INTEGER NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    mrr INTEGER NOT NULL DEFAULT 0, -- In the reporting currency's minor unit
+    PRIMARY KEY (snapshot_date, subscription_id)
+);
+
+-- Daily revenue metrics materialised from subscription_mrr_snapshots
+CREATE TABLE IF NOT EXISTS revenue_snapshots (
+    snapshot_date DATE PRIMARY KEY,
+    currency CHAR(3) NOT NULL,
+    mrr INTEGER NOT NULL DEFAULT 0,
+    arr BIGINT NOT NULL DEFAULT 0,
+    active_customers INTEGER NOT NULL DEFAULT 0,
+    trialing_customers INTEGER NOT NULL DEFAULT 0,
+    new_mrr INTEGER NOT NULL DEFAULT 0,
+    expansion_mrr INTEGER NOT NULL DEFAULT 0,
+    contraction_mrr INTEGER NOT NULL DEFAULT 0,
+    churned_mrr INTEGER NOT NULL DEFAULT 0,
+    new_customers INTEGER NOT NULL DEFAULT 0,
+    churned_customers INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes for frequently accessed columns
+CREATE INDEX IF NOT EXISTS idx_subscription_mrr_snapshots_subscription_id ON subscription_mrr_snapshots(subscription_id);
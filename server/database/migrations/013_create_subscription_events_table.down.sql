@@ -0,0 +1,10 @@
+-- Drop the append-only trigger first
+DROP TRIGGER IF EXISTS subscription_events_append_only ON subscription_events;
+DROP FUNCTION IF EXISTS prevent_subscription_event_changes();
+
+-- Drop indexes
+DROP INDEX IF EXISTS idx_subscription_events_user_id;
+DROP INDEX IF EXISTS idx_subscription_events_subscription_id;
+
+-- Drop the table
+DROP TABLE IF EXISTS subscription_events;
@@ -0,0 +1,34 @@
+-- Append-only history of subscription state transitions
+CREATE TABLE IF NOT EXISTS subscription_events (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id INTEGER NOT NULL,
+    user_id UUID NOT NULL,
+    source VARCHAR(100) NOT NULL, -- Webhook event name or the API action that caused the change
+    old_status VARCHAR(50),
+    new_status VARCHAR(50) NOT NULL,
+    old_variant_id INTEGER,
+    new_variant_id INTEGER NOT NULL,
+    old_cancelled BOOLEAN,
+    new_cancelled BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes for frequently accessed columns
+CREATE INDEX IF NOT EXISTS idx_subscription_events_subscription_id ON subscription_events(subscription_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_subscription_events_user_id ON subscription_events(user_id, created_at);
+
+-- Seed the history with the current state of existing subscriptions
+INSERT INTO subscription_events (subscription_id, user_id, source, new_status, new_variant_id, new_cancelled, created_at)
+SELECT subscription_id, user_id, 'backfill', status, variant_id, cancelled, updated_at
+FROM subscriptions;
+
+-- Reject updates and deletes so the history stays append-only
+CREATE OR REPLACE FUNCTION prevent_subscription_event_changes() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'subscription_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER subscription_events_append_only
+    BEFORE UPDATE OR DELETE ON subscription_events
+    FOR EACH ROW EXECUTE FUNCTION prevent_subscription_event_changes();
@@ -0,0 +1,9 @@
+-- Drop indexes first
+DROP INDEX IF EXISTS idx_dunning_cases_status;
+DROP INDEX IF EXISTS idx_dunning_cases_unresolved;
+
+-- Drop the table
+DROP TABLE IF EXISTS dunning_cases;
+
+-- Drop the payment method link
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS update_payment_method_url;
@@ -0,0 +1,21 @@
+-- Keep the latest signed link for updating the payment method of a subscription
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS update_payment_method_url TEXT;
+
+-- Create dunning_cases table to track failed subscription payments
+CREATE TABLE IF NOT EXISTS dunning_cases (
+    id SERIAL PRIMARY KEY,
+    subscription_id INTEGER NOT NULL,
+    user_id UUID NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'open', -- open, recovered, downgraded
+    failed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    grace_ends_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    emails_sent INTEGER NOT NULL DEFAULT 0,
+    last_email_at TIMESTAMP WITH TIME ZONE,
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- A subscription has at most one unresolved case
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dunning_cases_unresolved ON dunning_cases(subscription_id) WHERE status IN ('open', 'downgraded');
+CREATE INDEX IF NOT EXISTS idx_dunning_cases_status ON dunning_cases(status);
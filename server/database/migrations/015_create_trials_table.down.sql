@@ -0,0 +1,5 @@
+-- Drop indexes first
+DROP INDEX IF EXISTS idx_trials_status_ends_at;
+
+-- Drop the table
+DROP TABLE IF EXISTS trials;
@@ -0,0 +1,18 @@
+-- Create trials table for app-managed free trials that don't need a card
+CREATE TABLE IF NOT EXISTS trials (
+    id SERIAL PRIMARY KEY,
+    user_id UUID NOT NULL UNIQUE, -- One trial per user
+    product_id INTEGER NOT NULL,
+    variant_id INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active', -- active, converted, expired
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ends_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    reminder_sent_at TIMESTAMP WITH TIME ZONE,
+    converted_at TIMESTAMP WITH TIME ZONE,
+    subscription_id INTEGER,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes for frequently accessed columns
+CREATE INDEX IF NOT EXISTS idx_trials_status_ends_at ON trials(status, ends_at);
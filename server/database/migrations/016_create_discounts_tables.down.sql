@@ -0,0 +1,7 @@
+-- Drop indexes first
+DROP INDEX IF EXISTS idx_discount_redemptions_discount_id;
+DROP INDEX IF EXISTS idx_discounts_active_code;
+
+-- Drop the tables
+DROP TABLE IF EXISTS discount_redemptions;
+DROP TABLE IF EXISTS discounts;
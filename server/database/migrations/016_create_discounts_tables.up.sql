@@ -0,0 +1,36 @@
+-- Create discounts table mirroring the discount codes created through the payment provider
+CREATE TABLE IF NOT EXISTS discounts (
+    id SERIAL PRIMARY KEY,
+    provider_discount_id INTEGER NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    code VARCHAR(64) NOT NULL,
+    amount INTEGER NOT NULL, -- Percentage or minor currency units depending on amount_type
+    amount_type VARCHAR(20) NOT NULL, -- percent, fixed
+    duration VARCHAR(20) NOT NULL DEFAULT 'once', -- once, repeating, forever
+    duration_in_months INTEGER,
+    max_redemptions INTEGER, -- NULL means unlimited
+    variant_ids INTEGER[] NOT NULL DEFAULT '{}', -- Empty means every variant
+    starts_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    status VARCHAR(20) NOT NULL DEFAULT 'active', -- active, disabled
+    disabled_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Codes are case insensitive and only one active discount may use a code
+CREATE UNIQUE INDEX IF NOT EXISTS idx_discounts_active_code ON discounts(UPPER(code)) WHERE status = 'active';
+
+-- Create discount_redemptions table recording orders placed with a discount code
+CREATE TABLE IF NOT EXISTS discount_redemptions (
+    id SERIAL PRIMARY KEY,
+    discount_id INTEGER NOT NULL REFERENCES discounts(id) ON DELETE CASCADE,
+    order_id INTEGER NOT NULL UNIQUE,
+    user_id UUID,
+    currency VARCHAR(3) NOT NULL,
+    discount_total INTEGER NOT NULL DEFAULT 0, -- Amount taken off the order in minor currency units
+    order_total INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_discount_redemptions_discount_id ON discount_redemptions(discount_id);
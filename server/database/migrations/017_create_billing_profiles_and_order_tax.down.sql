@@ -0,0 +1,12 @@
+-- Drop indexes first
+DROP INDEX IF EXISTS idx_orders_billing_country;
+
+-- Remove the tax breakdown columns
+ALTER TABLE orders DROP COLUMN IF EXISTS tax_number;
+ALTER TABLE orders DROP COLUMN IF EXISTS billing_country;
+ALTER TABLE orders DROP COLUMN IF EXISTS tax_rate;
+ALTER TABLE orders DROP COLUMN IF EXISTS tax_name;
+ALTER TABLE orders DROP COLUMN IF EXISTS discount_total;
+
+-- Drop the table
+DROP TABLE IF EXISTS billing_profiles;
@@ -0,0 +1,24 @@
+-- Create billing_profiles table holding each user's billing address and tax ID
+CREATE TABLE IF NOT EXISTS billing_profiles (
+    user_id UUID PRIMARY KEY,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    company VARCHAR(255) NOT NULL DEFAULT '',
+    address_line1 VARCHAR(255) NOT NULL DEFAULT '',
+    address_line2 VARCHAR(255) NOT NULL DEFAULT '',
+    city VARCHAR(255) NOT NULL DEFAULT '',
+    state VARCHAR(255) NOT NULL DEFAULT '',
+    postal_code VARCHAR(32) NOT NULL DEFAULT '',
+    country CHAR(2), -- ISO 3166-1 alpha-2
+    tax_number VARCHAR(32) NOT NULL DEFAULT '', -- Normalised, e.g. DE123456789 for EU VAT numbers
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Store the tax breakdown of each order for reporting
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount_total INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_name VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_rate NUMERIC(7,4) NOT NULL DEFAULT 0; -- Percent, e.g. 20.0000
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS billing_country CHAR(2);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_number VARCHAR(32) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_orders_billing_country ON orders(billing_country);
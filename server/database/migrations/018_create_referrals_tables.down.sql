@@ -0,0 +1,7 @@
+-- Drop indexes first
+DROP INDEX IF EXISTS idx_referrals_status;
+DROP INDEX IF EXISTS idx_referrals_referrer_id;
+
+-- Drop the tables
+DROP TABLE IF EXISTS referrals;
+DROP TABLE IF EXISTS referral_codes;
@@ -0,0 +1,29 @@
+-- Create referral_codes table holding each user's shareable referral code
+CREATE TABLE IF NOT EXISTS referral_codes (
+    user_id UUID PRIMARY KEY,
+    code VARCHAR(32) NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create referrals table linking referred users to the user who referred them
+CREATE TABLE IF NOT EXISTS referrals (
+    id SERIAL PRIMARY KEY,
+    referrer_id UUID NOT NULL,
+    referred_user_id UUID NOT NULL UNIQUE, -- A user can only be referred once
+    code VARCHAR(32) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, qualified, rewarded
+    first_touch_at TIMESTAMP WITH TIME ZONE NOT NULL, -- When the referred user first opened the link
+    signed_up_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    qualifying_order_id INTEGER, -- First paid order of the referred user
+    qualified_at TIMESTAMP WITH TIME ZONE,
+    reward_type VARCHAR(20), -- discount, credit
+    reward_amount INTEGER,
+    reward_code VARCHAR(64), -- Discount code issued to the referrer
+    rewarded_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes for frequently accessed columns
+CREATE INDEX IF NOT EXISTS idx_referrals_referrer_id ON referrals(referrer_id);
+CREATE INDEX IF NOT EXISTS idx_referrals_status ON referrals(status);
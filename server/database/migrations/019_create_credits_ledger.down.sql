@@ -0,0 +1,16 @@
+-- Drop indexes first
+DROP INDEX IF EXISTS idx_credit_lots_expires_at;
+DROP INDEX IF EXISTS idx_credit_lots_user_id;
+DROP INDEX IF EXISTS idx_credit_entries_transaction_id;
+DROP INDEX IF EXISTS idx_credit_transactions_order_id;
+DROP INDEX IF EXISTS idx_credit_transactions_user_id;
+
+-- Drop the balance check
+DROP TRIGGER IF EXISTS credit_entries_balanced ON credit_entries;
+DROP FUNCTION IF EXISTS check_credit_transaction_balanced();
+
+-- Drop the tables
+DROP TABLE IF EXISTS credit_lots;
+DROP TABLE IF EXISTS credit_entries;
+DROP TABLE IF EXISTS credit_transactions;
+DROP TABLE IF EXISTS credit_accounts;
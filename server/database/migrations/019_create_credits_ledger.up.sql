@@ -0,0 +1,70 @@
+-- Create credit_accounts table holding a balance per user plus the system accounts credits move between
+CREATE TABLE IF NOT EXISTS credit_accounts (
+    id SERIAL PRIMARY KEY,
+    user_id UUID UNIQUE, -- NULL for system accounts
+    name VARCHAR(32) UNIQUE, -- System account name: purchases, grants, consumption, expirations
+    balance BIGINT NOT NULL DEFAULT 0,
+    version INTEGER NOT NULL DEFAULT 0, -- Bumped on every balance change for optimistic concurrency
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((user_id IS NULL) <> (name IS NULL)),
+    CHECK (user_id IS NULL OR balance >= 0) -- Users can't overdraw, system accounts go negative
+);
+
+INSERT INTO credit_accounts (name) VALUES ('purchases'), ('grants'), ('consumption'), ('expirations')
+ON CONFLICT DO NOTHING;
+
+-- Create credit_transactions table with one row per balance change of a user
+CREATE TABLE IF NOT EXISTS credit_transactions (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL,
+    type VARCHAR(20) NOT NULL, -- purchase, grant, consumption, expiration, reversal
+    amount BIGINT NOT NULL, -- Change of the user's balance, negative for debits
+    balance_after BIGINT NOT NULL,
+    reference VARCHAR(255) UNIQUE, -- Idempotency key, e.g. order:123 or the caller's key for debits
+    description TEXT,
+    order_id INTEGER,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create credit_entries table with the double-entry postings of each transaction
+CREATE TABLE IF NOT EXISTS credit_entries (
+    id BIGSERIAL PRIMARY KEY,
+    transaction_id BIGINT NOT NULL REFERENCES credit_transactions(id),
+    account_id INTEGER NOT NULL REFERENCES credit_accounts(id),
+    amount BIGINT NOT NULL, -- Positive credits the account, negative debits it
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create credit_lots table tracking what is left of each purchase or grant so credits can expire
+CREATE TABLE IF NOT EXISTS credit_lots (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL,
+    transaction_id BIGINT NOT NULL REFERENCES credit_transactions(id),
+    amount BIGINT NOT NULL,
+    remaining BIGINT NOT NULL CHECK (remaining >= 0),
+    expires_at TIMESTAMP WITH TIME ZONE, -- NULL for credits that never expire
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Reject transactions whose entries don't sum to zero, checked at commit
+CREATE OR REPLACE FUNCTION check_credit_transaction_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM credit_entries WHERE transaction_id = NEW.transaction_id) <> 0 THEN
+        RAISE EXCEPTION 'credit transaction % is unbalanced', NEW.transaction_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER credit_entries_balanced
+    AFTER INSERT ON credit_entries
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION check_credit_transaction_balanced();
+
+-- Create indexes for frequently accessed columns
+CREATE INDEX IF NOT EXISTS idx_credit_transactions_user_id ON credit_transactions(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_credit_transactions_order_id ON credit_transactions(order_id);
+CREATE INDEX IF NOT EXISTS idx_credit_entries_transaction_id ON credit_entries(transaction_id);
+CREATE INDEX IF NOT EXISTS idx_credit_lots_user_id ON credit_lots(user_id, expires_at) WHERE remaining > 0;
+CREATE INDEX IF NOT EXISTS idx_credit_lots_expires_at ON credit_lots(expires_at) WHERE remaining > 0;
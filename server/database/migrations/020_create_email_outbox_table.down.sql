@@ -0,0 +1,6 @@
+-- Drop indexes first
+DROP INDEX IF EXISTS idx_email_outbox_locked;
+DROP INDEX IF EXISTS idx_email_outbox_due;
+
+-- Drop the table
+DROP TABLE IF EXISTS email_outbox;
@@ -0,0 +1,5 @@
+-- Remember each user's preferred language so emails can be sent in it
+ALTER TABLE users ADD COLUMN IF NOT EXISTS language VARCHAR(16); -- e.g. en, de; NULL uses the default locale
+
+-- Store the plain-text alternative of queued emails
+ALTER TABLE email_outbox ADD COLUMN IF NOT EXISTS text_body TEXT;
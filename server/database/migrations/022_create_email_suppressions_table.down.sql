@@ -0,0 +1,7 @@
+-- Drop indexes first
+DROP INDEX IF EXISTS idx_email_suppressions_created_at;
+DROP INDEX IF EXISTS idx_email_suppressions_active;
+
+-- Drop the added column and the table
+ALTER TABLE email_outbox DROP COLUMN IF EXISTS critical;
+DROP TABLE IF EXISTS email_suppressions;
@@ -0,0 +1,20 @@
+-- Create email_suppressions table listing addresses that bounced or complained, which are no longer emailed
+CREATE TABLE IF NOT EXISTS email_suppressions (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL, -- Lowercased
+    reason VARCHAR(20) NOT NULL, -- bounce, complaint
+    details TEXT, -- Diagnostic message reported by the provider
+    provider_message_id VARCHAR(255), -- Email the latest event was reported for
+    event_count INTEGER NOT NULL DEFAULT 1, -- Events received while the suppression was active
+    lifted_at TIMESTAMP WITH TIME ZONE, -- Set when an admin lifts the suppression
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Security-critical emails may still be delivered to suppressed addresses.
+-- Outbox emails skipped because of a suppression get the status 'suppressed'.
+ALTER TABLE email_outbox ADD COLUMN IF NOT EXISTS critical BOOLEAN NOT NULL DEFAULT false;
+
+-- Only one active suppression per address; lifted ones are kept for history
+CREATE UNIQUE INDEX IF NOT EXISTS idx_email_suppressions_active ON email_suppressions(email) WHERE lifted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_email_suppressions_created_at ON email_suppressions(created_at);
@@ -0,0 +1,9 @@
+-- Drop the added columns
+ALTER TABLE email_outbox DROP COLUMN IF EXISTS headers;
+
+ALTER TABLE newsletter_subscriptions ALTER COLUMN subscribed SET DEFAULT TRUE;
+ALTER TABLE newsletter_subscriptions DROP COLUMN IF EXISTS unsubscribed_at;
+ALTER TABLE newsletter_subscriptions DROP COLUMN IF EXISTS confirm_ip;
+ALTER TABLE newsletter_subscriptions DROP COLUMN IF EXISTS confirmed_at;
+ALTER TABLE newsletter_subscriptions DROP COLUMN IF EXISTS request_ip;
+ALTER TABLE newsletter_subscriptions DROP COLUMN IF EXISTS requested_at;
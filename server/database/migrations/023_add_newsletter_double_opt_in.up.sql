@@ -0,0 +1,11 @@
+-- Record newsletter consent for double opt-in. Subscribers are only marked subscribed once
+-- they confirm through the link in the confirmation email.
+ALTER TABLE newsletter_subscriptions ADD COLUMN IF NOT EXISTS requested_at TIMESTAMP WITH TIME ZONE; -- Latest confirmation email, NULL once confirmed
+ALTER TABLE newsletter_subscriptions ADD COLUMN IF NOT EXISTS request_ip VARCHAR(255);
+ALTER TABLE newsletter_subscriptions ADD COLUMN IF NOT EXISTS confirmed_at TIMESTAMP WITH TIME ZONE; -- Consent timestamp, NULL for subscribers from before double opt-in
+ALTER TABLE newsletter_subscriptions ADD COLUMN IF NOT EXISTS confirm_ip VARCHAR(255); -- IP the consent was given from
+ALTER TABLE newsletter_subscriptions ADD COLUMN IF NOT EXISTS unsubscribed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE newsletter_subscriptions ALTER COLUMN subscribed SET DEFAULT FALSE;
+
+-- Extra headers of queued emails, e.g. List-Unsubscribe for newsletters
+ALTER TABLE email_outbox ADD COLUMN IF NOT EXISTS headers JSONB;
@@ -0,0 +1,10 @@
+-- Drop indexes first
+DROP INDEX IF EXISTS idx_email_campaign_clicks_recipient;
+DROP INDEX IF EXISTS idx_email_campaign_recipients_outbox;
+DROP INDEX IF EXISTS idx_email_campaign_recipients_pending;
+DROP INDEX IF EXISTS idx_email_campaigns_status;
+
+-- Drop the tables
+DROP TABLE IF EXISTS email_campaign_clicks;
+DROP TABLE IF EXISTS email_campaign_recipients;
+DROP TABLE IF EXISTS email_campaigns;
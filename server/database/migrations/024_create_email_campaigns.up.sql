@@ -0,0 +1,46 @@
+-- Create email_campaigns table storing newsletter campaigns composed by admins
+CREATE TABLE IF NOT EXISTS email_campaigns (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL, -- Internal name shown to admins
+    subject VARCHAR(255) NOT NULL,
+    html_body TEXT NOT NULL, -- Sanitized HTML content, wrapped in the email layout when sent
+    text_body TEXT, -- Plain-text content; derived from the HTML when empty
+    audience VARCHAR(20) NOT NULL, -- newsletter, users, early_access
+    status VARCHAR(20) NOT NULL DEFAULT 'draft', -- draft, scheduled, sending, sent, cancelled
+    scheduled_at TIMESTAMP WITH TIME ZONE,
+    started_at TIMESTAMP WITH TIME ZONE, -- When the recipients were resolved
+    completed_at TIMESTAMP WITH TIME ZONE, -- When the last recipient was queued
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create email_campaign_recipients table tracking delivery and engagement per recipient
+CREATE TABLE IF NOT EXISTS email_campaign_recipients (
+    id BIGSERIAL PRIMARY KEY,
+    campaign_id INTEGER NOT NULL REFERENCES email_campaigns(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, queued, skipped, cancelled; delivery is tracked by the outbox email
+    outbox_id BIGINT REFERENCES email_outbox(id) ON DELETE SET NULL,
+    error TEXT, -- Why the recipient was skipped
+    opened_at TIMESTAMP WITH TIME ZONE, -- First open
+    open_count INTEGER NOT NULL DEFAULT 0,
+    clicked_at TIMESTAMP WITH TIME ZONE, -- First click
+    click_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(campaign_id, email)
+);
+
+-- Create email_campaign_clicks table recording each tracked link click
+CREATE TABLE IF NOT EXISTS email_campaign_clicks (
+    id BIGSERIAL PRIMARY KEY,
+    recipient_id BIGINT NOT NULL REFERENCES email_campaign_recipients(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    clicked_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Add indexes for better query performance
+CREATE INDEX IF NOT EXISTS idx_email_campaigns_status ON email_campaigns(status, scheduled_at);
+CREATE INDEX IF NOT EXISTS idx_email_campaign_recipients_pending ON email_campaign_recipients(campaign_id, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_email_campaign_recipients_outbox ON email_campaign_recipients(outbox_id);
+CREATE INDEX IF NOT EXISTS idx_email_campaign_clicks_recipient ON email_campaign_clicks(recipient_id);
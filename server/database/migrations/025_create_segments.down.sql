@@ -0,0 +1,6 @@
+-- Drop indexes first
+DROP INDEX IF EXISTS idx_email_campaigns_segment_id;
+
+-- Drop the columns and tables
+ALTER TABLE email_campaigns DROP COLUMN IF EXISTS segment_id;
+DROP TABLE IF EXISTS segments;
@@ -0,0 +1,16 @@
+-- Create segments table storing saved audience definitions
+CREATE TABLE IF NOT EXISTS segments (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    source VARCHAR(20) NOT NULL, -- users, newsletter, early_access
+    rules JSONB NOT NULL, -- Rule tree compiled to SQL when the segment is used
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Let campaigns target a saved segment
+ALTER TABLE email_campaigns ADD COLUMN IF NOT EXISTS segment_id INTEGER REFERENCES segments(id) ON DELETE SET NULL; -- Set for the segment audience
+
+-- Add indexes for better query performance
+CREATE INDEX IF NOT EXISTS idx_email_campaigns_segment_id ON email_campaigns(segment_id);
@@ -0,0 +1,3 @@
+-- Drop the columns and tables
+ALTER TABLE email_outbox DROP COLUMN IF EXISTS category;
+DROP TABLE IF EXISTS notification_preferences;
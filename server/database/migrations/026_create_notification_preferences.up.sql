@@ -0,0 +1,14 @@
+-- Create notification_preferences table storing which notifications users opted out of.
+-- Categories without a row use the defaults, which enable every channel.
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    category VARCHAR(20) NOT NULL, -- billing, product, newsletter; security can't be turned off
+    email BOOLEAN NOT NULL DEFAULT TRUE,
+    in_app BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, category)
+);
+
+-- Record the notification category of queued emails, which decides whether recipients can
+-- opt out of them. Outbox status 'opted_out' marks emails skipped because of a preference.
+ALTER TABLE email_outbox ADD COLUMN IF NOT EXISTS category VARCHAR(20); -- NULL for transactional emails nobody can opt out of
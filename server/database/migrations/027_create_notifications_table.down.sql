@@ -0,0 +1,6 @@
+-- Drop indexes first
+DROP INDEX IF EXISTS idx_notifications_unread;
+DROP INDEX IF EXISTS idx_notifications_user_id;
+
+-- Drop the table
+DROP TABLE IF EXISTS notifications;
@@ -0,0 +1,16 @@
+-- Create notifications table storing the in-app notifications of each user
+CREATE TABLE IF NOT EXISTS notifications (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    category VARCHAR(20) NOT NULL, -- security, billing, product, newsletter
+    type VARCHAR(50) NOT NULL, -- What happened, e.g. payment_failed, team_invite, export_ready
+    title VARCHAR(255) NOT NULL,
+    body TEXT,
+    link TEXT, -- Where the notification leads in the app
+    read_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes for frequently accessed columns
+CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications(user_id) WHERE read_at IS NULL;
@@ -0,0 +1,7 @@
+-- Drop indexes first
+DROP INDEX IF EXISTS idx_lifecycle_sends_user_id;
+DROP INDEX IF EXISTS idx_lifecycle_rules_enabled;
+
+-- Drop the tables
+DROP TABLE IF EXISTS lifecycle_sends;
+DROP TABLE IF EXISTS lifecycle_rules;
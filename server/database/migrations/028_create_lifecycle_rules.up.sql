@@ -0,0 +1,37 @@
+-- Create lifecycle_rules table storing the automated emails sent after product events
+CREATE TABLE IF NOT EXISTS lifecycle_rules (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL, -- Internal name shown to admins
+    event VARCHAR(30) NOT NULL, -- signup, unverified, trial_ending, inactive, cancelled
+    delay_minutes INTEGER NOT NULL DEFAULT 0, -- Time after the event; negative sends before events known in advance, like the end of a trial
+    template VARCHAR(100) NOT NULL, -- Email template that is sent
+    exit_conditions TEXT[] NOT NULL DEFAULT '{}', -- verified, subscribed; users meeting one when the email is due don't get it
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    enabled_at TIMESTAMP WITH TIME ZONE, -- Emails that were due before the rule was enabled aren't sent
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create lifecycle_sends table recording each user a rule was applied to, so nobody gets an email twice
+CREATE TABLE IF NOT EXISTS lifecycle_sends (
+    id BIGSERIAL PRIMARY KEY,
+    rule_id INTEGER NOT NULL REFERENCES lifecycle_rules(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event_at TIMESTAMP WITH TIME ZONE NOT NULL, -- When the event happened, so events that recur are sent again
+    status VARCHAR(20) NOT NULL, -- sent, exited
+    exit_condition VARCHAR(30), -- The exit condition the user met
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(rule_id, user_id, event_at)
+);
+
+-- Seed the standard rules, disabled until an admin turns them on
+INSERT INTO lifecycle_rules (name, event, delay_minutes, template, exit_conditions) VALUES
+    ('Welcome', 'signup', 0, 'lifecycle_welcome', '{}'),
+    ('Verify email reminder', 'unverified', 1440, 'lifecycle_verify_reminder', '{}'),
+    ('Trial ending', 'trial_ending', -4320, 'lifecycle_trial_ending', '{subscribed}'),
+    ('Inactive for 14 days', 'inactive', 20160, 'lifecycle_inactive', '{}'),
+    ('Subscription cancelled', 'cancelled', 60, 'lifecycle_cancelled', '{subscribed}');
+
+-- Add indexes for better query performance
+CREATE INDEX IF NOT EXISTS idx_lifecycle_rules_enabled ON lifecycle_rules(enabled);
+CREATE INDEX IF NOT EXISTS idx_lifecycle_sends_user_id ON lifecycle_sends(user_id);
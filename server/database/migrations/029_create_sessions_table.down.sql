@@ -0,0 +1,9 @@
+-- Drop indexes first
+DROP INDEX IF EXISTS idx_page_views_session_id;
+DROP INDEX IF EXISTS idx_sessions_started_at;
+DROP INDEX IF EXISTS idx_sessions_user_id;
+DROP INDEX IF EXISTS idx_sessions_visitor_id;
+
+-- Drop the columns and tables
+ALTER TABLE page_views DROP COLUMN IF EXISTS session_id;
+DROP TABLE IF EXISTS sessions;
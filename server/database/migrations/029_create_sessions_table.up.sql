@@ -0,0 +1,23 @@
+-- Create sessions table grouping a visitor's page views that are at most 30 minutes apart
+CREATE TABLE IF NOT EXISTS sessions (
+    id BIGSERIAL PRIMARY KEY,
+    visitor_id VARCHAR(255) NOT NULL,
+    user_id UUID, -- Set once the visitor is signed in, including retroactively on login or signup
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    page_views INTEGER NOT NULL DEFAULT 1,
+    entry_path VARCHAR(255) NOT NULL,
+    exit_path VARCHAR(255) NOT NULL, -- Path of the latest page view
+    referrer VARCHAR(255), -- Referrer of the entry page view
+    user_agent VARCHAR(512),
+    ip_address VARCHAR(45)
+);
+
+-- Link page views to their session
+ALTER TABLE page_views ADD COLUMN IF NOT EXISTS session_id BIGINT REFERENCES sessions(id) ON DELETE SET NULL;
+
+-- Create indexes for frequently accessed columns
+CREATE INDEX IF NOT EXISTS idx_sessions_visitor_id ON sessions(visitor_id, last_seen_at DESC);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_started_at ON sessions(started_at);
+CREATE INDEX IF NOT EXISTS idx_page_views_session_id ON page_views(session_id);
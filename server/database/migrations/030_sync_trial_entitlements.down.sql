@@ -0,0 +1,10 @@
+-- Clear the trials copied into users.latest_*
+UPDATE users u
+SET latest_status = NULL,
+    latest_product_id = NULL,
+    latest_variant_id = NULL,
+    latest_end_date = NULL,
+    updated_at = CURRENT_TIMESTAMP
+WHERE u.latest_status = 'on_trial'
+  AND u.latest_subscription_id IS NULL
+  AND NOT EXISTS (SELECT 1 FROM subscriptions s WHERE s.user_id = u.id);
@@ -0,0 +1,16 @@
+-- Copy active app-managed trials into users.latest_*, which now mirrors them like any other
+-- primary subscription. Users with a subscription keep it, as a paid subscription takes precedence.
+UPDATE users u
+SET latest_subscription_id = NULL,
+    latest_status = 'on_trial',
+    latest_product_id = t.product_id,
+    latest_variant_id = t.variant_id,
+    latest_renewal_date = NULL,
+    latest_end_date = t.ends_at,
+    updated_at = CURRENT_TIMESTAMP
+FROM trials t
+WHERE t.user_id = u.id
+  AND t.status = 'active'
+  AND t.ends_at > CURRENT_TIMESTAMP
+  AND u.latest_status IS NULL
+  AND NOT EXISTS (SELECT 1 FROM subscriptions s WHERE s.user_id = u.id);
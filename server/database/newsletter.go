@@ -1,40 +1,108 @@
 package database
 
 import (
+	"database/sql"
 	"saas-server/models"
 	"time"
 )
 
-// CreateNewsletterSubscription creates a new newsletter subscription in the database
-func (db *DB) CreateNewsletterSubscription(email string) error {
-	_, err := db.Exec(
-		"INSERT INTO newsletter_subscriptions (email, subscribed, created_at, updated_at) VALUES ($1, $2, $3, $3)",
-		email, true, time.Now(),
+// newsletterSubscriptionColumns lists the columns read by scanNewsletterSubscription, in order
+const newsletterSubscriptionColumns = `
+		id, email, subscribed, requested_at, COALESCE(request_ip, ''), confirmed_at,
+		COALESCE(confirm_ip, ''), unsubscribed_at, created_at, updated_at`
+
+// scanNewsletterSubscription scans a single newsletter subscription row
+func scanNewsletterSubscription(row rowScanner) (*models.NewsletterSubscription, error) {
+	var s models.NewsletterSubscription
+	err := row.Scan(
+		&s.ID,
+		&s.Email,
+		&s.Subscribed,
+		&s.RequestedAt,
+		&s.RequestIP,
+		&s.ConfirmedAt,
+		&s.ConfirmIP,
+		&s.UnsubscribedAt,
+		&s.CreatedAt,
+		&s.UpdatedAt,
 	)
-	return err
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
 }
 
-// NewsletterEmailExists checks if an email already exists in the newsletter_subscriptions table
-func (db *DB) NewsletterEmailExists(email string) (bool, error) {
-	var exists bool
-	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM newsletter_subscriptions WHERE email = $1)", email).Scan(&exists)
-	return exists, err
+// RequestNewsletterSubscription records a subscription request awaiting confirmation. It
+// returns nil without changes if the address is already subscribed or a confirmation was
+// requested after resendAfter, so the same address isn't flooded with confirmation emails.
+func (db *DB) RequestNewsletterSubscription(email string, ip string, requestedAt time.Time, resendAfter time.Time) (*models.NewsletterSubscription, error) {
+	s, err := scanNewsletterSubscription(db.QueryRow(`
+		INSERT INTO newsletter_subscriptions (email, subscribed, requested_at, request_ip, created_at, updated_at)
+		VALUES ($1, false, $2, NULLIF($3, ''), $2, $2)
+		ON CONFLICT (email) DO UPDATE
+		SET requested_at = EXCLUDED.requested_at, request_ip = EXCLUDED.request_ip, updated_at = EXCLUDED.updated_at
+		WHERE NOT newsletter_subscriptions.subscribed
+		  AND (newsletter_subscriptions.requested_at IS NULL OR newsletter_subscriptions.requested_at <= $4)
+		RETURNING `+newsletterSubscriptionColumns,
+		email, requestedAt, ip, resendAfter))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return s, err
 }
 
-// UpdateNewsletterSubscription updates the subscription status for an existing newsletter subscription
-func (db *DB) UpdateNewsletterSubscription(email string, subscribed bool) error {
-	_, err := db.Exec(
-		"UPDATE newsletter_subscriptions SET subscribed = $1, updated_at = $2 WHERE email = $3",
-		subscribed, time.Now(), email,
-	)
-	return err
+// ConfirmNewsletterSubscription subscribes an address and records the consent. Only the
+// confirmation requested at requestedAt is accepted, so each confirmation link works once
+// and links from before an unsubscribe don't subscribe again. It returns false if the
+// request is unknown.
+func (db *DB) ConfirmNewsletterSubscription(email string, requestedAt time.Time, ip string, confirmedAt time.Time) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE newsletter_subscriptions
+		SET subscribed = true, confirmed_at = $3, confirm_ip = NULLIF($4, ''),
+		    requested_at = NULL, unsubscribed_at = NULL, updated_at = $3
+		WHERE email = $1 AND requested_at = $2`,
+		email, requestedAt, confirmedAt, ip)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// UnsubscribeNewsletter unsubscribes an address and invalidates pending confirmation links.
+// Addresses that never subscribed, e.g. users unsubscribing from a campaign, are recorded
+// as opted out so campaigns leave them out. It returns false if the address was neither
+// subscribed nor pending.
+func (db *DB) UnsubscribeNewsletter(email string, unsubscribedAt time.Time) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE newsletter_subscriptions
+		SET subscribed = false, requested_at = NULL, unsubscribed_at = $2, updated_at = $2
+		WHERE email = $1 AND (subscribed OR requested_at IS NOT NULL)`,
+		email, unsubscribedAt)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil || rows > 0 {
+		return rows > 0, err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO newsletter_subscriptions (email, subscribed, unsubscribed_at, created_at, updated_at)
+		VALUES ($1, false, $2, $2, $2)
+		ON CONFLICT (email) DO UPDATE
+		SET unsubscribed_at = COALESCE(newsletter_subscriptions.unsubscribed_at, EXCLUDED.unsubscribed_at),
+		    updated_at = EXCLUDED.updated_at`,
+		email, unsubscribedAt)
+	return false, err
 }
 
 // GetAllNewsletterSubscriptions returns all newsletter subscriptions from the database
 func (db *DB) GetAllNewsletterSubscriptions() ([]models.NewsletterSubscription, error) {
-	rows, err := db.Query(
-		"SELECT id, email, subscribed, created_at, updated_at FROM newsletter_subscriptions ORDER BY created_at DESC",
-	)
+	rows, err := db.Query(`
+		SELECT ` + newsletterSubscriptionColumns + `
+		FROM newsletter_subscriptions
+		ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -42,17 +110,11 @@ func (db *DB) GetAllNewsletterSubscriptions() ([]models.NewsletterSubscription,
 
 	var subscriptions []models.NewsletterSubscription
 	for rows.Next() {
-		var subscription models.NewsletterSubscription
-		if err := rows.Scan(
-			&subscription.ID,
-			&subscription.Email,
-			&subscription.Subscribed,
-			&subscription.CreatedAt,
-			&subscription.UpdatedAt,
-		); err != nil {
+		subscription, err := scanNewsletterSubscription(rows)
+		if err != nil {
 			return nil, err
 		}
-		subscriptions = append(subscriptions, subscription)
+		subscriptions = append(subscriptions, *subscription)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"fmt"
+	"saas-server/models"
+)
+
+// GetNotificationPreferences returns the preferences a user saved. Categories the user
+// never changed are missing and use the defaults.
+func (db *DB) GetNotificationPreferences(userID string) ([]models.NotificationPreference, error) {
+	rows, err := db.Query(`
+		SELECT category, email, in_app
+		FROM notification_preferences
+		WHERE user_id = $1`,
+		userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying notification preferences: %v", err)
+	}
+	defer rows.Close()
+
+	var preferences []models.NotificationPreference
+	for rows.Next() {
+		var p models.NotificationPreference
+		if err := rows.Scan(&p.Category, &p.Email, &p.InApp); err != nil {
+			return nil, fmt.Errorf("error scanning notification preference: %v", err)
+		}
+		preferences = append(preferences, p)
+	}
+	return preferences, rows.Err()
+}
+
+// SaveNotificationPreferences stores a user's preferences for the given categories
+func (db *DB) SaveNotificationPreferences(userID string, preferences []models.NotificationPreference) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, p := range preferences {
+		if _, err := tx.Exec(`
+			INSERT INTO notification_preferences (user_id, category, email, in_app)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, category) DO UPDATE
+			SET email = EXCLUDED.email, in_app = EXCLUDED.in_app, updated_at = CURRENT_TIMESTAMP`,
+			userID, p.Category, p.Email, p.InApp); err != nil {
+			return fmt.Errorf("error saving %s preference: %v", p.Category, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableEmailNotifications turns off emails of a category for the user with the given
+// address. It returns false if no user has the address.
+func (db *DB) DisableEmailNotifications(email string, category string) (bool, error) {
+	result, err := db.Exec(`
+		INSERT INTO notification_preferences (user_id, category, email)
+		SELECT id, $2, FALSE FROM users WHERE lower(email) = lower($1)
+		ON CONFLICT (user_id, category) DO UPDATE
+		SET email = FALSE, updated_at = CURRENT_TIMESTAMP`,
+		email, category)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// IsEmailNotificationEnabled reports whether emails of a category may go to an address.
+// Addresses without an account only have their newsletter and suppression status.
+func (db *DB) IsEmailNotificationEnabled(email string, category string) (bool, error) {
+	var enabled bool
+	err := db.QueryRow(`
+		SELECT COALESCE((
+			SELECT np.email
+			FROM notification_preferences np
+			JOIN users u ON u.id = np.user_id
+			WHERE lower(u.email) = lower($1) AND np.category = $2
+		), TRUE)`,
+		email, category).Scan(&enabled)
+	return enabled, err
+}
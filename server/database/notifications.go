@@ -0,0 +1,129 @@
+package database
+
+import (
+	"fmt"
+	"saas-server/models"
+	"time"
+)
+
+// notificationColumns lists the columns read by scanNotification, in order
+const notificationColumns = `
+		id, user_id, category, type, title, COALESCE(body, ''), COALESCE(link, ''), read_at, created_at`
+
+// scanNotification scans a single notification row
+func scanNotification(row rowScanner) (*models.Notification, error) {
+	var n models.Notification
+	err := row.Scan(
+		&n.ID,
+		&n.UserID,
+		&n.Category,
+		&n.Type,
+		&n.Title,
+		&n.Body,
+		&n.Link,
+		&n.ReadAt,
+		&n.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// CreateNotification stores an in-app notification
+func (db *DB) CreateNotification(n *models.Notification) error {
+	return db.QueryRow(`
+		INSERT INTO notifications (user_id, category, type, title, body, link)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''))
+		RETURNING id, created_at`,
+		n.UserID, n.Category, n.Type, n.Title, n.Body, n.Link,
+	).Scan(&n.ID, &n.CreatedAt)
+}
+
+// GetNotification returns a notification by ID
+func (db *DB) GetNotification(id int64) (*models.Notification, error) {
+	return scanNotification(db.QueryRow(`SELECT `+notificationColumns+` FROM notifications WHERE id = $1`, id))
+}
+
+// GetNotifications returns a page of a user's notifications, newest first, and the total
+// number of matching notifications
+func (db *DB) GetNotifications(userID string, unreadOnly bool, page int, limit int) ([]models.Notification, int, error) {
+	offset := (page - 1) * limit
+
+	var total int
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FROM notifications
+		WHERE user_id = $1 AND (NOT $2 OR read_at IS NULL)`,
+		userID, unreadOnly).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error counting notifications: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT `+notificationColumns+`
+		FROM notifications
+		WHERE user_id = $1 AND (NOT $2 OR read_at IS NULL)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4`,
+		userID, unreadOnly, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying notifications: %v", err)
+	}
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error scanning notification: %v", err)
+		}
+		notifications = append(notifications, *n)
+	}
+	return notifications, total, rows.Err()
+}
+
+// CountUnreadNotifications counts a user's unread notifications
+func (db *DB) CountUnreadNotifications(userID string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
+
+// MarkNotificationRead marks one of a user's notifications as read. It returns false if
+// the user has no notification with the ID; reading a notification again succeeds.
+func (db *DB) MarkNotificationRead(userID string, id int64, readAt time.Time) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE notifications
+		SET read_at = COALESCE(read_at, $3)
+		WHERE id = $1 AND user_id = $2`,
+		id, userID, readAt)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// MarkAllNotificationsRead marks every unread notification of a user as read and returns
+// how many there were
+func (db *DB) MarkAllNotificationsRead(userID string, readAt time.Time) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE notifications
+		SET read_at = $2
+		WHERE user_id = $1 AND read_at IS NULL`,
+		userID, readAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// IsInAppNotificationEnabled reports whether a user gets in-app notifications of a category
+func (db *DB) IsInAppNotificationEnabled(userID string, category string) (bool, error) {
+	var enabled bool
+	err := db.QueryRow(`
+		SELECT COALESCE((
+			SELECT in_app FROM notification_preferences WHERE user_id = $1 AND category = $2
+		), TRUE)`,
+		userID, category).Scan(&enabled)
+	return enabled, err
+}
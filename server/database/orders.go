@@ -2,21 +2,31 @@ package database
 
 import (
 	"saas-server/models"
+	"strings"
 	"time"
 )
 
-// CreateOrder creates a new order record in the database
-func (db *DB) CreateOrder(userID string, orderID int, customerID int, productID int, variantID int, status string, subtotalFormatted string, taxFormatted string, totalFormatted string, taxInclusive bool) error {
+// CreateOrder creates a new order record in the database.
+// Amounts are in the minor unit of the given ISO 4217 currency.
+func (db *DB) CreateOrder(userID string, orderID int, customerID int, productID int, variantID int, status string, currency string, subtotal int, tax int, total int, taxInclusive bool, receiptURL string, taxDetails models.OrderTaxDetails) error {
 	query := `
 		INSERT INTO orders (
-			user_id, order_id, customer_id, product_id, variant_id, 
-			status, subtotal_formatted, tax_formatted, total_formatted,
-			tax_inclusive, created_at, updated_at
+			user_id, order_id, customer_id, product_id, variant_id,
+			status, currency, subtotal, tax, total,
+			tax_inclusive, receipt_url, discount_total, tax_name, tax_rate,
+			billing_country, tax_number, created_at, updated_at
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, NULLIF($16, ''), $17, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`
+
+	taxRate := taxDetails.TaxRate
+	if taxRate == "" {
+		taxRate = "0"
+	}
 
 	_, err := db.Exec(query, userID, orderID, customerID, productID, variantID,
-		status, subtotalFormatted, taxFormatted, totalFormatted, taxInclusive)
+		status, strings.ToUpper(currency), subtotal, tax, total, taxInclusive, receiptURL,
+		taxDetails.DiscountTotal, taxDetails.TaxName, taxRate,
+		strings.ToUpper(taxDetails.BillingCountry), taxDetails.TaxNumber)
 	return err
 }
 
@@ -32,16 +42,16 @@ func (db *DB) UpdateOrderStatus(orderID int, status string, refunded bool, refun
 }
 
 // UpdateOrderRefund updates the order's refund status and related information
-func (db *DB) UpdateOrderRefund(orderID int, refundedAt *time.Time, refundedAmountFormatted string) error {
+func (db *DB) UpdateOrderRefund(orderID int, refundedAt *time.Time, refundedAmount int) error {
 	query := `
 		UPDATE orders
 		SET status = 'refunded', 
 		    refunded_at = $1,
-		    refunded_amount_formatted = $2,
+		    refunded_amount = $2,
 		    updated_at = CURRENT_TIMESTAMP
 		WHERE order_id = $3`
 
-	_, err := db.Exec(query, refundedAt, refundedAmountFormatted, orderID)
+	_, err := db.Exec(query, refundedAt, refundedAmount, orderID)
 	return err
 }
 
@@ -49,9 +59,10 @@ func (db *DB) UpdateOrderRefund(orderID int, refundedAt *time.Time, refundedAmou
 func (db *DB) GetUserOrders(userID string) ([]models.Orders, error) {
 	query := `
 		SELECT id, order_id, user_id, customer_id, status,
-		       refunded_at, product_id, variant_id, subtotal_formatted,
-		       tax_formatted, total_formatted, tax_inclusive, refunded_amount_formatted,
-		       created_at, updated_at
+		       refunded_at, product_id, variant_id, currency, subtotal,
+		       tax, total, tax_inclusive, refunded_amount,
+		       COALESCE(receipt_url, ''), discount_total, tax_name, tax_rate::text,
+		       COALESCE(billing_country, ''), tax_number, created_at, updated_at
 		FROM orders
 		WHERE user_id = $1
 		ORDER BY created_at DESC`
@@ -74,17 +85,25 @@ func (db *DB) GetUserOrders(userID string) ([]models.Orders, error) {
 			&order.RefundedAt,
 			&order.ProductID,
 			&order.VariantID,
-			&order.SubtotalFormatted,
-			&order.TaxFormatted,
-			&order.TotalFormatted,
+			&order.Currency,
+			&order.Subtotal,
+			&order.Tax,
+			&order.Total,
 			&order.TaxInclusive,
-			&order.RefundedAmountFormatted,
+			&order.RefundedAmount,
+			&order.ReceiptURL,
+			&order.DiscountTotal,
+			&order.TaxName,
+			&order.TaxRate,
+			&order.BillingCountry,
+			&order.TaxNumber,
 			&order.CreatedAt,
 			&order.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
+		order.FormatAmounts()
 		orders = append(orders, order)
 	}
 
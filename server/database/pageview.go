@@ -1,6 +1,7 @@
 package database
 
 import (
+	"database/sql"
 	"time"
 
 	"saas-server/pkg/analytics"
@@ -8,24 +9,88 @@ import (
 	"github.com/google/uuid"
 )
 
-// TrackPageView stores a page view event in the database
+// TrackPageView stores a page view event in the database. Page views with a visitor ID
+// continue the visitor's session if their previous page view was within the session
+// timeout, and start a new session otherwise.
 func (db *DB) TrackPageView(view *analytics.PageView) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if view.VisitorID != "" {
+		// Serialize the visitor's page views, so parallel ones don't start two sessions
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, view.VisitorID); err != nil {
+			return err
+		}
+
+		var sessionID int64
+		err := tx.QueryRow(`
+			UPDATE sessions
+			SET last_seen_at = $2, page_views = page_views + 1, exit_path = $3, user_id = COALESCE($4, user_id)
+			WHERE id = (
+				SELECT id FROM sessions
+				WHERE visitor_id = $1 AND last_seen_at >= $5
+				ORDER BY last_seen_at DESC
+				LIMIT 1)
+			RETURNING id`,
+			view.VisitorID, view.CreatedAt, view.Path, view.UserID, view.CreatedAt.Add(-analytics.SessionTimeout),
+		).Scan(&sessionID)
+		if err == sql.ErrNoRows {
+			err = tx.QueryRow(`
+				INSERT INTO sessions (visitor_id, user_id, started_at, last_seen_at, entry_path, exit_path, referrer, user_agent, ip_address)
+				VALUES ($1, $2, $3, $3, $4, $4, $5, $6, $7)
+				RETURNING id`,
+				view.VisitorID, view.UserID, view.CreatedAt, view.Path, view.Referrer, view.UserAgent, view.IPAddress,
+			).Scan(&sessionID)
+		}
+		if err != nil {
+			return err
+		}
+		view.SessionID = &sessionID
+	}
+
 	query := `
-		INSERT INTO page_views (user_id, visitor_id, path, referrer, user_agent, ip_address, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO page_views (user_id, visitor_id, session_id, path, referrer, user_agent, ip_address, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
 	`
-	_, err := db.Exec(query,
-		view.UserID, view.VisitorID, view.Path,
+	err = tx.QueryRow(query,
+		view.UserID, view.VisitorID, view.SessionID, view.Path,
 		view.Referrer, view.UserAgent, view.IPAddress,
 		view.CreatedAt,
-	)
-	return err
+	).Scan(&view.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// LinkVisitor attributes a visitor's anonymous page views and sessions to the user they
+// signed up or logged in as
+func (db *DB) LinkVisitor(visitorID string, userID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE page_views SET user_id = $2 WHERE visitor_id = $1 AND user_id IS NULL`, visitorID, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE sessions SET user_id = $2 WHERE visitor_id = $1 AND user_id IS NULL`, visitorID, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // GetUserJourney retrieves the page view history for a specific user within a time range
 func (db *DB) GetUserJourney(userID uuid.UUID, startTime, endTime time.Time) ([]analytics.PageView, error) {
 	query := `
-		SELECT id, user_id, visitor_id, path, referrer, user_agent, ip_address, created_at
+		SELECT id, user_id, visitor_id, session_id, path, referrer, user_agent, ip_address, created_at
 		FROM page_views
 		WHERE user_id = $1 AND created_at BETWEEN $2 AND $3
 		ORDER BY created_at ASC
@@ -41,7 +106,7 @@ func (db *DB) GetUserJourney(userID uuid.UUID, startTime, endTime time.Time) ([]
 	for rows.Next() {
 		var view analytics.PageView
 		err := rows.Scan(
-			&view.ID, &view.UserID, &view.VisitorID,
+			&view.ID, &view.UserID, &view.VisitorID, &view.SessionID,
 			&view.Path, &view.Referrer, &view.UserAgent,
 			&view.IPAddress, &view.CreatedAt,
 		)
@@ -57,10 +122,10 @@ func (db *DB) GetUserJourney(userID uuid.UUID, startTime, endTime time.Time) ([]
 // GetVisitorJourneys retrieves all visitor page views within a time range
 func (db *DB) GetVisitorJourneys(startTime, endTime time.Time) ([]analytics.PageView, error) {
 	query := `
-		SELECT id, user_id, visitor_id, path, referrer, user_agent, ip_address, created_at
+		SELECT id, user_id, visitor_id, session_id, path, referrer, user_agent, ip_address, created_at
 		FROM page_views
 		WHERE created_at BETWEEN $1 AND $2
-		ORDER BY visitor_id, created_at ASC
+		ORDER BY visitor_id, session_id, created_at ASC
 	`
 
 	rows, err := db.Query(query, startTime, endTime)
@@ -73,7 +138,7 @@ func (db *DB) GetVisitorJourneys(startTime, endTime time.Time) ([]analytics.Page
 	for rows.Next() {
 		var view analytics.PageView
 		err := rows.Scan(
-			&view.ID, &view.UserID, &view.VisitorID,
+			&view.ID, &view.UserID, &view.VisitorID, &view.SessionID,
 			&view.Path, &view.Referrer, &view.UserAgent,
 			&view.IPAddress, &view.CreatedAt,
 		)
@@ -165,26 +230,30 @@ func (db *DB) GetPageViewStats(startTime, endTime time.Time) (*analytics.PageVie
 		referrerStats = append(referrerStats, stat)
 	}
 
-	// Get total views and unique paths
+	// Get total views, unique paths, unique visitors and sessions
 	totalsQuery := `
 		SELECT 
 			COUNT(*) as total_views,
-			COUNT(DISTINCT path) as unique_paths
+			COUNT(DISTINCT path) as unique_paths,
+			COUNT(DISTINCT COALESCE(user_id::text, visitor_id)) as unique_visitors,
+			COUNT(DISTINCT session_id) as sessions
 		FROM page_views
 		WHERE created_at BETWEEN $1 AND $2
 	`
 
-	var totalViews, uniquePaths int
-	err = db.QueryRow(totalsQuery, startTime, endTime).Scan(&totalViews, &uniquePaths)
+	var totalViews, uniquePaths, uniqueVisitors, sessions int
+	err = db.QueryRow(totalsQuery, startTime, endTime).Scan(&totalViews, &uniquePaths, &uniqueVisitors, &sessions)
 	if err != nil {
 		return nil, err
 	}
 
 	return &analytics.PageViewResponse{
-		PageStats:     pageStats,
-		DailyStats:    dailyStats,
-		ReferrerStats: referrerStats,
-		TotalViews:    totalViews,
-		UniquePaths:   uniquePaths,
+		PageStats:      pageStats,
+		DailyStats:     dailyStats,
+		ReferrerStats:  referrerStats,
+		TotalViews:     totalViews,
+		UniquePaths:    uniquePaths,
+		UniqueVisitors: uniqueVisitors,
+		Sessions:       sessions,
 	}, nil
 }
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"database/sql"
+	"saas-server/models"
+	"time"
+)
+
+// referralColumns lists the columns read by scanReferral, in order
+const referralColumns = `
+		r.id, r.referrer_id, r.referred_user_id, COALESCE(u.email, ''), r.code, r.status,
+		r.first_touch_at, r.signed_up_at, r.qualifying_order_id, r.qualified_at,
+		COALESCE(r.reward_type, ''), r.reward_amount, COALESCE(r.reward_code, ''),
+		r.rewarded_at, r.created_at, r.updated_at`
+
+// scanReferral scans a single referral row
+func scanReferral(row rowScanner) (*models.Referral, error) {
+	var ref models.Referral
+	var orderID, rewardAmount sql.NullInt64
+	err := row.Scan(
+		&ref.ID,
+		&ref.ReferrerID,
+		&ref.ReferredUserID,
+		&ref.ReferredEmail,
+		&ref.Code,
+		&ref.Status,
+		&ref.FirstTouchAt,
+		&ref.SignedUpAt,
+		&orderID,
+		&ref.QualifiedAt,
+		&ref.RewardType,
+		&rewardAmount,
+		&ref.RewardCode,
+		&ref.RewardedAt,
+		&ref.CreatedAt,
+		&ref.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if orderID.Valid {
+		id := int(orderID.Int64)
+		ref.QualifyingOrderID = &id
+	}
+	if rewardAmount.Valid {
+		amount := int(rewardAmount.Int64)
+		ref.RewardAmount = &amount
+	}
+	return &ref, nil
+}
+
+// GetReferralCode retrieves the referral code of a user
+func (db *DB) GetReferralCode(userID string) (string, error) {
+	var code string
+	err := db.QueryRow(`SELECT code FROM referral_codes WHERE user_id = $1`, userID).Scan(&code)
+	return code, err
+}
+
+// CreateReferralCode assigns a referral code to a user. If the user already has one it is returned
+// instead, and sql.ErrNoRows is returned if another user holds the code.
+func (db *DB) CreateReferralCode(userID string, code string) (string, error) {
+	query := `
+		INSERT INTO referral_codes (user_id, code, created_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP)
+		ON CONFLICT DO NOTHING
+		RETURNING code`
+
+	var stored string
+	err := db.QueryRow(query, userID, code).Scan(&stored)
+	if err == sql.ErrNoRows {
+		// Either the user got a code concurrently or the code belongs to someone else
+		return db.GetReferralCode(userID)
+	}
+	return stored, err
+}
+
+// GetReferrerByCode returns the user ID owning a referral code, ignoring case
+func (db *DB) GetReferrerByCode(code string) (string, error) {
+	var userID string
+	err := db.QueryRow(`SELECT user_id FROM referral_codes WHERE UPPER(code) = UPPER($1)`, code).Scan(&userID)
+	return userID, err
+}
+
+// CreateReferral records that a new user signed up through a referral code.
+// It does nothing if the user was already attributed to a referrer.
+func (db *DB) CreateReferral(referrerID string, referredUserID string, code string, firstTouchAt time.Time, signedUpAt time.Time) error {
+	query := `
+		INSERT INTO referrals (referrer_id, referred_user_id, code, status, first_touch_at, signed_up_at, created_at, updated_at)
+		VALUES ($1, $2, $3, 'pending', $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (referred_user_id) DO NOTHING`
+
+	_, err := db.Exec(query, referrerID, referredUserID, code, firstTouchAt, signedUpAt)
+	return err
+}
+
+// QualifyReferral marks the pending referral of a user as qualified by their first paid order.
+// It returns sql.ErrNoRows if the user wasn't referred or the referral already qualified, so
+// each referral is rewarded once even when webhooks are redelivered.
+func (db *DB) QualifyReferral(referredUserID string, orderID int, qualifiedAt time.Time) (*models.Referral, error) {
+	query := `
+		WITH updated AS (
+			UPDATE referrals
+			SET status = 'qualified',
+			    qualifying_order_id = $2,
+			    qualified_at = $3,
+			    updated_at = CURRENT_TIMESTAMP
+			WHERE referred_user_id = $1 AND status = 'pending'
+			RETURNING *
+		)
+		SELECT ` + referralColumns + `
+		FROM updated r
+		LEFT JOIN users u ON u.id = r.referred_user_id`
+
+	return scanReferral(db.QueryRow(query, referredUserID, orderID, qualifiedAt))
+}
+
+// GetQualifiedReferrals retrieves referrals that qualified before the given time but weren't rewarded
+func (db *DB) GetQualifiedReferrals(qualifiedBefore time.Time) ([]models.Referral, error) {
+	return db.queryReferrals(`
+		SELECT `+referralColumns+`
+		FROM referrals r
+		LEFT JOIN users u ON u.id = r.referred_user_id
+		WHERE r.status = 'qualified' AND r.qualified_at < $1
+		ORDER BY r.qualified_at ASC`, qualifiedBefore)
+}
+
+// MarkReferralRewarded records the reward given to the referrer
+func (db *DB) MarkReferralRewarded(referralID int, rewardType string, rewardAmount int, rewardCode string, rewardedAt time.Time) error {
+	query := `
+		UPDATE referrals
+		SET status = 'rewarded',
+		    reward_type = $2,
+		    reward_amount = $3,
+		    reward_code = NULLIF($4, ''),
+		    rewarded_at = $5,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'qualified'`
+
+	_, err := db.Exec(query, referralID, rewardType, rewardAmount, rewardCode, rewardedAt)
+	return err
+}
+
+// GetReferralsByReferrer retrieves the referrals made by a user, newest first
+func (db *DB) GetReferralsByReferrer(referrerID string) ([]models.Referral, error) {
+	return db.queryReferrals(`
+		SELECT `+referralColumns+`
+		FROM referrals r
+		LEFT JOIN users u ON u.id = r.referred_user_id
+		WHERE r.referrer_id = $1
+		ORDER BY r.signed_up_at DESC`, referrerID)
+}
+
+// queryReferrals runs a query returning referralColumns
+func (db *DB) queryReferrals(query string, args ...interface{}) ([]models.Referral, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	referrals := []models.Referral{}
+	for rows.Next() {
+		ref, err := scanReferral(rows)
+		if err != nil {
+			return nil, err
+		}
+		referrals = append(referrals, *ref)
+	}
+
+	return referrals, rows.Err()
+}
@@ -0,0 +1,343 @@
+package database
+
+import (
+	"fmt"
+	"saas-server/models"
+	"time"
+)
+
+// GetAllSubscriptions returns every stored subscription
+func (db *DB) GetAllSubscriptions() ([]models.Subscription, error) {
+	rows, err := db.Query(`SELECT ` + subscriptionColumns + ` FROM subscriptions ORDER BY subscription_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []models.Subscription
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, *subscription)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+// GetLatestInvoiceSubtotals returns the subtotal of the most recent paid invoice of each subscription
+// in the given currency, keyed by subscription ID
+func (db *DB) GetLatestInvoiceSubtotals(currency string) (map[int]int, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT ON (subscription_id) subscription_id, subtotal
+		FROM invoices
+		WHERE status = 'paid' AND refunded = FALSE AND currency = $1
+		ORDER BY subscription_id, created_at DESC`, currency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subtotals := make(map[int]int)
+	for rows.Next() {
+		var subscriptionID, subtotal int
+		if err := rows.Scan(&subscriptionID, &subtotal); err != nil {
+			return nil, err
+		}
+		subtotals[subscriptionID] = subtotal
+	}
+
+	return subtotals, rows.Err()
+}
+
+// SaveRevenueSnapshot stores the MRR of every subscription for a day and materialises that
+// day's revenue snapshot. Re-running it for the same day replaces the earlier snapshot.
+func (db *DB) SaveRevenueSnapshot(date time.Time, currency string, subscriptions []models.SubscriptionMRR) (*models.RevenueSnapshot, error) {
+	day := date.Format("2006-01-02")
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM subscription_mrr_snapshots WHERE snapshot_date = $1`, day); err != nil {
+		return nil, fmt.Errorf("error clearing subscription snapshots: %v", err)
+	}
+
+	for _, s := range subscriptions {
+		_, err := tx.Exec(`
+			INSERT INTO subscription_mrr_snapshots (snapshot_date, subscription_id, user_id, variant_id, status, mrr)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			day, s.SubscriptionID, s.UserID, s.VariantID, s.Status, s.MRR,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error storing subscription snapshot %d: %v", s.SubscriptionID, err)
+		}
+	}
+
+	// Compare every customer's MRR with the most recent earlier snapshot
+	query := `
+		WITH previous_day AS (
+			SELECT MAX(snapshot_date) AS snapshot_date
+			FROM subscription_mrr_snapshots
+			WHERE snapshot_date < $1
+		),
+		current_mrr AS (
+			SELECT user_id, SUM(mrr) AS mrr, BOOL_OR(status = 'on_trial') AS trialing
+			FROM subscription_mrr_snapshots
+			WHERE snapshot_date = $1
+			GROUP BY user_id
+		),
+		previous_mrr AS (
+			SELECT user_id, SUM(mrr) AS mrr
+			FROM subscription_mrr_snapshots
+			WHERE snapshot_date = (SELECT snapshot_date FROM previous_day)
+			GROUP BY user_id
+		),
+		movements AS (
+			SELECT COALESCE(c.mrr, 0) AS cur, COALESCE(p.mrr, 0) AS prev, COALESCE(c.trialing, FALSE) AS trialing
+			FROM current_mrr c
+			FULL OUTER JOIN previous_mrr p ON p.user_id = c.user_id
+		)
+		INSERT INTO revenue_snapshots (
+			snapshot_date, currency, mrr, arr, active_customers, trialing_customers,
+			new_mrr, expansion_mrr, contraction_mrr, churned_mrr,
+			new_customers, churned_customers, created_at, updated_at
+		)
+		SELECT $1, $2,
+		       COALESCE(SUM(cur), 0),
+		       COALESCE(SUM(cur), 0) * 12,
+		       COUNT(*) FILTER (WHERE cur > 0),
+		       COUNT(*) FILTER (WHERE trialing AND cur = 0),
+		       COALESCE(SUM(cur) FILTER (WHERE prev = 0 AND cur > 0), 0),
+		       COALESCE(SUM(cur - prev) FILTER (WHERE prev > 0 AND cur > prev), 0),
+		       COALESCE(SUM(prev - cur) FILTER (WHERE cur > 0 AND cur < prev), 0),
+		       COALESCE(SUM(prev) FILTER (WHERE prev > 0 AND cur = 0), 0),
+		       COUNT(*) FILTER (WHERE prev = 0 AND cur > 0),
+		       COUNT(*) FILTER (WHERE prev > 0 AND cur = 0),
+		       CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+		FROM movements
+		ON CONFLICT (snapshot_date) DO UPDATE
+		SET currency = EXCLUDED.currency,
+		    mrr = EXCLUDED.mrr,
+		    arr = EXCLUDED.arr,
+		    active_customers = EXCLUDED.active_customers,
+		    trialing_customers = EXCLUDED.trialing_customers,
+		    new_mrr = EXCLUDED.new_mrr,
+		    expansion_mrr = EXCLUDED.expansion_mrr,
+		    contraction_mrr = EXCLUDED.contraction_mrr,
+		    churned_mrr = EXCLUDED.churned_mrr,
+		    new_customers = EXCLUDED.new_customers,
+		    churned_customers = EXCLUDED.churned_customers,
+		    updated_at = CURRENT_TIMESTAMP
+		RETURNING ` + revenueSnapshotColumns
+
+	snapshot, err := scanRevenueSnapshot(tx.QueryRow(query, day, currency))
+	if err != nil {
+		return nil, fmt.Errorf("error materialising revenue snapshot: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// revenueSnapshotColumns lists the columns read by scanRevenueSnapshot, in order
+const revenueSnapshotColumns = `
+		snapshot_date, currency, mrr, arr, active_customers, trialing_customers,
+		new_mrr, expansion_mrr, contraction_mrr, churned_mrr,
+		new_customers, churned_customers`
+
+// scanRevenueSnapshot scans a single revenue snapshot row
+func scanRevenueSnapshot(row rowScanner) (*models.RevenueSnapshot, error) {
+	var s models.RevenueSnapshot
+	err := row.Scan(
+		&s.Date,
+		&s.Currency,
+		&s.MRR,
+		&s.ARR,
+		&s.ActiveCustomers,
+		&s.TrialingCustomers,
+		&s.NewMRR,
+		&s.ExpansionMRR,
+		&s.ContractionMRR,
+		&s.ChurnedMRR,
+		&s.NewCustomers,
+		&s.ChurnedCustomers,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetRevenueSnapshots returns the daily revenue snapshots between from and to (inclusive), oldest first
+func (db *DB) GetRevenueSnapshots(from, to time.Time) ([]models.RevenueSnapshot, error) {
+	rows, err := db.Query(`
+		SELECT `+revenueSnapshotColumns+`
+		FROM revenue_snapshots
+		WHERE snapshot_date BETWEEN $1 AND $2
+		ORDER BY snapshot_date ASC`,
+		from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := []models.RevenueSnapshot{}
+	for rows.Next() {
+		snapshot, err := scanRevenueSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, *snapshot)
+	}
+
+	return snapshots, rows.Err()
+}
+
+// GetTrialConversion counts the trials that ended between from and to and how many of them
+// went on to pay, based on the subscription's MRR snapshots after the trial ended. Both
+// provider trials and app-managed trials are counted; an app trial ends when it expires or
+// when the user subscribes, and counts as converted if that subscription paid.
+func (db *DB) GetTrialConversion(from, to time.Time) (int, int, error) {
+	var ended, converted int
+	query := `
+		WITH ended_trials AS (
+			SELECT s.subscription_id, s.trial_ends_at AS ended_at
+			FROM subscriptions s
+			WHERE s.trial_ends_at IS NOT NULL
+			  AND NOT EXISTS (SELECT 1 FROM trials t WHERE t.subscription_id = s.subscription_id)
+			UNION ALL
+			SELECT t.subscription_id, LEAST(t.ends_at, COALESCE(t.converted_at, t.ends_at)) AS ended_at
+			FROM trials t
+		)
+		SELECT COUNT(*),
+		       COUNT(*) FILTER (WHERE EXISTS (
+		           SELECT 1 FROM subscription_mrr_snapshots m
+		           WHERE m.subscription_id = e.subscription_id
+		             AND m.snapshot_date >= e.ended_at::date
+		             AND m.mrr > 0
+		       ))
+		FROM ended_trials e
+		WHERE e.ended_at >= $1
+		  AND e.ended_at < $2
+		  AND e.ended_at <= CURRENT_TIMESTAMP`
+
+	err := db.QueryRow(query, from, to).Scan(&ended, &converted)
+	return ended, converted, err
+}
+
+// GetCohortRevenue returns the net revenue (after refunds) of customers grouped by the month of
+// their first paid order, for cohorts starting between from and to. MonthlyRevenue[i] is the
+// revenue the cohort earned in month i after it started.
+func (db *DB) GetCohortRevenue(from, to time.Time, currency string) ([]models.CohortLTV, error) {
+	query := `
+		WITH payments AS (
+			SELECT user_id, created_at, total - refunded_amount AS amount
+			FROM orders
+			WHERE status IN ('paid', 'refunded') AND currency = $3
+			UNION ALL
+			SELECT user_id::text, created_at, total - refunded_amount AS amount
+			FROM invoices
+			WHERE status IN ('paid', 'refunded') AND billing_reason <> 'initial'
+			  AND currency = $3 AND user_id IS NOT NULL
+		),
+		cohorts AS (
+			SELECT user_id, date_trunc('month', MIN(created_at)) AS cohort
+			FROM orders
+			WHERE status IN ('paid', 'refunded') AND currency = $3
+			GROUP BY user_id
+		),
+		cohort_sizes AS (
+			SELECT cohort, COUNT(*) AS customers
+			FROM cohorts
+			GROUP BY cohort
+		)
+		SELECT to_char(c.cohort, 'YYYY-MM'),
+		       (EXTRACT(YEAR FROM age(date_trunc('month', p.created_at), c.cohort)) * 12
+		        + EXTRACT(MONTH FROM age(date_trunc('month', p.created_at), c.cohort)))::INTEGER AS month_offset,
+		       cs.customers,
+		       SUM(p.amount)
+		FROM cohorts c
+		JOIN cohort_sizes cs ON cs.cohort = c.cohort
+		JOIN payments p ON p.user_id = c.user_id
+		WHERE c.cohort >= date_trunc('month', $1::timestamptz) AND c.cohort <= $2
+		GROUP BY c.cohort, month_offset, cs.customers
+		ORDER BY c.cohort, month_offset`
+
+	rows, err := db.Query(query, from, to, currency)
+	if err != nil {
+		return nil, fmt.Errorf("error querying cohort revenue: %v", err)
+	}
+	defer rows.Close()
+
+	cohorts := []models.CohortLTV{}
+	for rows.Next() {
+		var cohort string
+		var offset, customers, revenue int
+		if err := rows.Scan(&cohort, &offset, &customers, &revenue); err != nil {
+			return nil, fmt.Errorf("error scanning cohort revenue: %v", err)
+		}
+
+		if len(cohorts) == 0 || cohorts[len(cohorts)-1].Cohort != cohort {
+			cohorts = append(cohorts, models.CohortLTV{Cohort: cohort, Customers: customers})
+		}
+		c := &cohorts[len(cohorts)-1]
+		for len(c.MonthlyRevenue) <= offset {
+			c.MonthlyRevenue = append(c.MonthlyRevenue, 0)
+		}
+		c.MonthlyRevenue[offset] += revenue
+		c.Revenue += revenue
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating cohort revenue: %v", err)
+	}
+
+	return cohorts, nil
+}
+
+// GetTaxReport returns the tax collected on orders created between from and to, grouped by billing
+// country, tax name, rate and currency. Refunded amounts are reported separately rather than netted.
+func (db *DB) GetTaxReport(from, to time.Time) ([]models.TaxReportRow, error) {
+	query := `
+		SELECT COALESCE(billing_country, ''), tax_name, tax_rate::text, currency,
+		       COUNT(*), SUM(subtotal), SUM(tax), SUM(total), SUM(refunded_amount)
+		FROM orders
+		WHERE status IN ('paid', 'refunded')
+		  AND created_at >= $1
+		  AND created_at < $2
+		GROUP BY billing_country, tax_name, tax_rate, currency
+		ORDER BY billing_country NULLS LAST, tax_name, tax_rate, currency`
+
+	rows, err := db.Query(query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := []models.TaxReportRow{}
+	for rows.Next() {
+		var row models.TaxReportRow
+		if err := rows.Scan(
+			&row.Country,
+			&row.TaxName,
+			&row.TaxRate,
+			&row.Currency,
+			&row.Orders,
+			&row.Subtotal,
+			&row.Tax,
+			&row.Total,
+			&row.Refunded,
+		); err != nil {
+			return nil, err
+		}
+		report = append(report, row)
+	}
+
+	return report, rows.Err()
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"fmt"
+	"saas-server/models"
+	"saas-server/pkg/segments"
+	"time"
+)
+
+// segmentColumns lists the columns read by scanSegment, in order
+const segmentColumns = `id, name, COALESCE(description, ''), source, rules, created_at, updated_at`
+
+// scanSegment scans a single segment row
+func scanSegment(row rowScanner) (*models.Segment, error) {
+	var s models.Segment
+	var definition []byte
+	if err := row.Scan(&s.ID, &s.Name, &s.Description, &s.Source, &definition, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	s.Definition = definition
+	return &s, nil
+}
+
+// CreateSegment saves a segment
+func (db *DB) CreateSegment(s *models.Segment) error {
+	return db.QueryRow(`
+		INSERT INTO segments (name, description, source, rules)
+		VALUES ($1, NULLIF($2, ''), $3, $4)
+		RETURNING id, created_at, updated_at`,
+		s.Name, s.Description, s.Source, []byte(s.Definition),
+	).Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt)
+}
+
+// UpdateSegment replaces the name and rules of a segment. It returns sql.ErrNoRows if the
+// segment doesn't exist.
+func (db *DB) UpdateSegment(s *models.Segment) (*models.Segment, error) {
+	return scanSegment(db.QueryRow(`
+		UPDATE segments
+		SET name = $2, description = NULLIF($3, ''), source = $4, rules = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+segmentColumns,
+		s.ID, s.Name, s.Description, s.Source, []byte(s.Definition)))
+}
+
+// GetSegment returns a segment by ID
+func (db *DB) GetSegment(id int) (*models.Segment, error) {
+	return scanSegment(db.QueryRow(`SELECT `+segmentColumns+` FROM segments WHERE id = $1`, id))
+}
+
+// GetSegments returns every saved segment ordered by name
+func (db *DB) GetSegments() ([]models.Segment, error) {
+	rows, err := db.Query(`SELECT ` + segmentColumns + ` FROM segments ORDER BY name, id`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying segments: %v", err)
+	}
+	defer rows.Close()
+
+	list := []models.Segment{}
+	for rows.Next() {
+		s, err := scanSegment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning segment: %v", err)
+		}
+		list = append(list, *s)
+	}
+	return list, rows.Err()
+}
+
+// DeleteSegment deletes a segment unless a campaign that wasn't sent yet targets it.
+// It returns false if the segment wasn't deleted.
+func (db *DB) DeleteSegment(id int) (bool, error) {
+	result, err := db.Exec(`
+		DELETE FROM segments
+		WHERE id = $1
+		  AND NOT EXISTS (
+			SELECT 1 FROM email_campaigns
+			WHERE segment_id = $1 AND status IN ('draft', 'scheduled', 'sending')
+		  )`,
+		id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// CountSegment counts the members of a compiled segment
+func (db *DB) CountSegment(q *segments.Query) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM `+q.From+` WHERE `+q.Where, q.Args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting segment: %v", err)
+	}
+	return count, nil
+}
+
+// GetSegmentMembers returns a page of the members of a compiled segment, newest first.
+// A limit of 0 returns every member, e.g. for exports.
+func (db *DB) GetSegmentMembers(q *segments.Query, page int, limit int) ([]models.SegmentMember, error) {
+	query := `SELECT ` + q.Columns + ` FROM ` + q.From + ` WHERE ` + q.Where + ` ORDER BY 4 DESC, 2`
+	args := q.Args
+	if limit > 0 {
+		query += fmt.Sprintf(` LIMIT $%d OFFSET $%d`, len(args)+1, len(args)+2)
+		args = append(append([]interface{}{}, args...), limit, (page-1)*limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying segment members: %v", err)
+	}
+	defer rows.Close()
+
+	members := []models.SegmentMember{}
+	for rows.Next() {
+		var m models.SegmentMember
+		if err := rows.Scan(&m.UserID, &m.Email, &m.Name, &m.SignedUpAt); err != nil {
+			return nil, fmt.Errorf("error scanning segment member: %v", err)
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// segmentQuery loads a saved segment and compiles it, numbering its parameters after
+// argOffset parameters of the surrounding query
+func segmentQuery(q queryer, id int, now time.Time, argOffset int) (*segments.Query, error) {
+	s, err := scanSegment(q.QueryRow(`SELECT `+segmentColumns+` FROM segments WHERE id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+	def, err := segments.Parse(s.Definition)
+	if err != nil {
+		return nil, err
+	}
+	return segments.Compile(def, now, argOffset)
+}
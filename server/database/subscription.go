@@ -17,8 +17,15 @@ var (
 	cacheMutex        sync.RWMutex
 )
 
-// CreateSubscription creates a new subscription record in the database
-func (db *DB) CreateSubscription(userID string, subscriptionID int, orderID int, customerID int, productID int, variantID int, status string, renewsAt *time.Time, endsAt *time.Time, trialEndsAt *time.Time) error {
+// CreateSubscription creates a new subscription record and its first history event in one transaction.
+// source names what caused the change, e.g. the webhook event name.
+func (db *DB) CreateSubscription(userID string, subscriptionID int, orderID int, customerID int, productID int, variantID int, status string, renewsAt *time.Time, endsAt *time.Time, trialEndsAt *time.Time, source string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 		INSERT INTO subscriptions (
 			subscription_id, user_id, order_id, customer_id, product_id, variant_id,
@@ -26,15 +33,47 @@ func (db *DB) CreateSubscription(userID string, subscriptionID int, orderID int,
 			created_at, updated_at
 		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 	`
-	_, err := db.Exec(query,
+	_, err = tx.Exec(query,
 		subscriptionID, userID, orderID, customerID, productID, variantID,
 		status, renewsAt, endsAt, trialEndsAt,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	if err := insertSubscriptionEvent(tx, subscriptionID, userID, source, nil, status, nil, variantID, nil, false); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// UpdateSubscription updates an existing subscription record
-func (db *DB) UpdateSubscription(subscriptionID int, status string, cancelled bool, productID int, variantID int, renewsAt *time.Time, endsAt *time.Time, trialEndsAt *time.Time) error {
+// UpdateSubscription updates an existing subscription record. When the status, variant or
+// cancellation changes, a history event is appended in the same transaction.
+func (db *DB) UpdateSubscription(subscriptionID int, status string, cancelled bool, productID int, variantID int, renewsAt *time.Time, endsAt *time.Time, trialEndsAt *time.Time, source string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID, oldStatus string
+	var oldVariantID int
+	var oldCancelled bool
+	err = tx.QueryRow(`
+		SELECT user_id, status, variant_id, cancelled
+		FROM subscriptions
+		WHERE subscription_id = $1
+		FOR UPDATE`, subscriptionID,
+	).Scan(&userID, &oldStatus, &oldVariantID, &oldCancelled)
+	if err == sql.ErrNoRows {
+		// Nothing to update, matching the behaviour of a plain UPDATE
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE subscriptions 
 		SET status = $1,
@@ -47,35 +86,52 @@ func (db *DB) UpdateSubscription(subscriptionID int, status string, cancelled bo
 		    updated_at = CURRENT_TIMESTAMP
 		WHERE subscription_id = $8
 	`
-	_, err := db.Exec(query, status, cancelled, productID, variantID, renewsAt, endsAt, trialEndsAt, subscriptionID)
-	return err
+	_, err = tx.Exec(query, status, cancelled, productID, variantID, renewsAt, endsAt, trialEndsAt, subscriptionID)
+	if err != nil {
+		return err
+	}
+
+	if oldStatus != status || oldVariantID != variantID || oldCancelled != cancelled {
+		if err := insertSubscriptionEvent(tx, subscriptionID, userID, source, &oldStatus, status, &oldVariantID, variantID, &oldCancelled, cancelled); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-// GetSubscriptionByUserID retrieves a subscription by user ID
-func (db *DB) GetSubscriptionByUserID(userID string) (*models.Subscription, error) {
+// subscriptionColumns lists the columns read by scanSubscription, in order
+const subscriptionColumns = `
+		id, subscription_id, user_id, order_id, customer_id, product_id, variant_id,
+		COALESCE(subscription_item_id, 0), is_usage_based,
+		status, cancelled, renews_at, ends_at, trial_ends_at,
+		COALESCE(update_payment_method_url, ''), created_at, updated_at`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSubscription scans a row selected with subscriptionColumns into a Subscription
+func scanSubscription(row rowScanner) (*models.Subscription, error) {
 	var subscription models.Subscription
 	var subscriptionIDInt int
-	query := `
-		SELECT id, subscription_id, user_id, customer_id, product_id, variant_id,
-		       status, cancelled, renews_at, ends_at, trial_ends_at,
-		       created_at, updated_at
-		FROM subscriptions
-		WHERE user_id = $1
-		ORDER BY created_at DESC
-		LIMIT 1
-	`
-	err := db.QueryRow(query, userID).Scan(
+	err := row.Scan(
 		&subscription.ID,
 		&subscriptionIDInt,
 		&subscription.UserID,
+		&subscription.OrderID,
 		&subscription.CustomerID,
 		&subscription.ProductID,
 		&subscription.VariantID,
+		&subscription.SubscriptionItemID,
+		&subscription.IsUsageBased,
 		&subscription.Status,
 		&subscription.Cancelled,
 		&subscription.RenewsAt,
 		&subscription.EndsAt,
 		&subscription.TrialEndsAt,
+		&subscription.UpdatePaymentMethodURL,
 		&subscription.CreatedAt,
 		&subscription.UpdatedAt,
 	)
@@ -87,6 +143,173 @@ func (db *DB) GetSubscriptionByUserID(userID string) (*models.Subscription, erro
 	return &subscription, nil
 }
 
+// entitledSubscriptionCondition matches subscriptions that currently grant access to their plan.
+// Cancelled subscriptions keep access until the end of the period that was paid for, and past due
+// subscriptions until their dunning grace period has run out.
+const entitledSubscriptionCondition = `
+		((status IN ('active', 'on_trial', 'past_due')
+		  OR (status = 'cancelled' AND ends_at > CURRENT_TIMESTAMP))
+		 AND NOT EXISTS (
+		     SELECT 1 FROM dunning_cases d
+		     WHERE d.subscription_id = subscriptions.subscription_id AND d.status = 'downgraded'))`
+
+// primarySubscriptionOrder sorts a user's subscriptions so the primary one comes first:
+// entitled subscriptions before the rest, then by status, then the oldest entitled
+// subscription (usually the base plan rather than an add-on), otherwise the newest.
+const primarySubscriptionOrder = `
+		ORDER BY CASE WHEN ` + entitledSubscriptionCondition + ` THEN 0 ELSE 1 END,
+		         CASE status WHEN 'active' THEN 0 WHEN 'on_trial' THEN 1 WHEN 'past_due' THEN 2 ELSE 3 END,
+		         CASE WHEN ` + entitledSubscriptionCondition + ` THEN created_at END ASC,
+		         created_at DESC`
+
+// GetSubscriptionByUserID retrieves the user's primary subscription, which decides what the
+// user is entitled to. It is the user's main entitled subscription, otherwise their active
+// app-managed trial (with IsTrial set), otherwise their newest subscription.
+func (db *DB) GetSubscriptionByUserID(userID string) (*models.Subscription, error) {
+	active, err := db.GetActiveSubscriptionsByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(active) > 0 {
+		return &active[0], nil
+	}
+
+	// An app-managed trial grants access like a provider trial, but a paid subscription takes precedence
+	trial, err := db.GetActiveTrialByUserID(userID)
+	if err == nil {
+		return trialSubscription(trial), nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + subscriptionColumns + `
+		FROM subscriptions
+		WHERE user_id = $1
+		` + primarySubscriptionOrder + `
+		LIMIT 1
+	`
+	subscription, err := scanSubscription(db.QueryRow(query, userID))
+	if err != nil {
+		return nil, err
+	}
+	subscription.IsPrimary = true
+	return subscription, nil
+}
+
+// trialSubscription represents an active app-managed trial as the user's primary subscription
+func trialSubscription(trial *models.Trial) *models.Subscription {
+	return &models.Subscription{
+		UserID:      trial.UserID,
+		ProductID:   trial.ProductID,
+		VariantID:   trial.VariantID,
+		Status:      "on_trial",
+		EndsAt:      &trial.EndsAt,
+		TrialEndsAt: &trial.EndsAt,
+		IsPrimary:   true,
+		IsTrial:     true,
+		CreatedAt:   trial.CreatedAt,
+		UpdatedAt:   trial.UpdatedAt,
+	}
+}
+
+// GetSubscriptionsByUserID retrieves all of a user's subscriptions with the primary one first
+func (db *DB) GetSubscriptionsByUserID(userID string) ([]models.Subscription, error) {
+	return db.querySubscriptions(`
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions
+		WHERE user_id = $1
+		`+primarySubscriptionOrder, userID)
+}
+
+// GetActiveSubscriptionsByUserID retrieves the user's entitled subscriptions with the primary one first
+func (db *DB) GetActiveSubscriptionsByUserID(userID string) ([]models.Subscription, error) {
+	return db.querySubscriptions(`
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions
+		WHERE user_id = $1 AND `+entitledSubscriptionCondition+`
+		`+primarySubscriptionOrder, userID)
+}
+
+// querySubscriptions runs a query selecting subscriptionColumns in primary order
+// and marks the first row as the primary subscription
+func (db *DB) querySubscriptions(query string, args ...interface{}) ([]models.Subscription, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []models.Subscription{}
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscription.IsPrimary = len(subscriptions) == 0
+		subscriptions = append(subscriptions, *subscription)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+// SyncUserPrimarySubscription copies the user's primary subscription, including an app-managed
+// trial, into users.latest_*, which older clients and segments read. The fields are cleared when
+// the user has neither.
+func (db *DB) SyncUserPrimarySubscription(userID string) error {
+	subscription, err := db.GetSubscriptionByUserID(userID)
+	if err == sql.ErrNoRows {
+		_, err = db.Exec(`
+			UPDATE users
+			SET latest_subscription_id = NULL,
+			    latest_status = NULL,
+			    latest_product_id = NULL,
+			    latest_variant_id = NULL,
+			    latest_renewal_date = NULL,
+			    latest_end_date = NULL,
+			    updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND latest_status IS NOT NULL`, userID)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	// Trials have no provider subscription, which is stored as NULL
+	subscriptionID := 0
+	if !subscription.IsTrial {
+		subscriptionID, err = strconv.Atoi(subscription.SubscriptionID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return db.UpdateUserSubscription(userID, subscriptionID, subscription.Status, subscription.ProductID, subscription.VariantID, subscription.RenewsAt, subscription.EndsAt)
+}
+
+// GetSubscriptionBySubscriptionID retrieves a subscription by its Lemon Squeezy subscription ID
+func (db *DB) GetSubscriptionBySubscriptionID(subscriptionID int) (*models.Subscription, error) {
+	query := `
+		SELECT ` + subscriptionColumns + `
+		FROM subscriptions
+		WHERE subscription_id = $1
+	`
+	return scanSubscription(db.QueryRow(query, subscriptionID))
+}
+
+// UpdateSubscriptionItem records the subscription item that usage is reported against
+func (db *DB) UpdateSubscriptionItem(subscriptionID int, subscriptionItemID int, isUsageBased bool) error {
+	query := `
+		UPDATE subscriptions
+		SET subscription_item_id = $1,
+		    is_usage_based = $2,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE subscription_id = $3
+	`
+	_, err := db.Exec(query, subscriptionItemID, isUsageBased, subscriptionID)
+	return err
+}
 
 // UpdateUserSubscription updates a user's subscription in the database
 func (db *DB) UpdateUserSubscription(userID string, subscriptionID int, status string, productID int, variantID int, renewalDate *time.Time, endDate *time.Time) error {
@@ -98,7 +321,7 @@ func (db *DB) UpdateUserSubscription(userID string, subscriptionID int, status s
 
 	query := `
 		UPDATE users
-		SET latest_subscription_id = $2,
+		SET latest_subscription_id = NULLIF($2, 0),
 			latest_status = $3,
 			latest_product_id = $4,
 			latest_variant_id = $5,
@@ -152,12 +375,45 @@ func (db *DB) GetUserSubscriptionStatus(id string) (*models.UserSubscriptionStat
 		return nil, err
 	}
 
+	active, err := db.GetActiveSubscriptionsByUserID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	primary, err := db.GetSubscriptionByUserID(id)
+	if err == sql.ErrNoRows {
+		primary = nil
+	} else if err != nil {
+		return nil, err
+	}
+
 	// Only create the status object if at least one field is not null
-	if !nullStatus.Valid && !nullProductID.Valid && !nullVariantID.Valid {
+	if !nullStatus.Valid && !nullProductID.Valid && !nullVariantID.Valid && primary == nil {
 		return nil, nil
 	}
 
-	status := &models.UserSubscriptionStatus{}
+	status := &models.UserSubscriptionStatus{
+		Subscriptions: make([]models.SubscriptionEntitlement, 0, len(active)+1),
+	}
+	for _, sub := range active {
+		status.Subscriptions = append(status.Subscriptions, models.SubscriptionEntitlement{
+			SubscriptionID: sub.SubscriptionID,
+			Status:         sub.Status,
+			ProductID:      sub.ProductID,
+			VariantID:      sub.VariantID,
+			IsPrimary:      sub.IsPrimary,
+		})
+	}
+	if primary != nil && primary.IsTrial {
+		status.Subscriptions = append(status.Subscriptions, models.SubscriptionEntitlement{
+			Status:    primary.Status,
+			ProductID: primary.ProductID,
+			VariantID: primary.VariantID,
+			IsPrimary: true,
+			IsTrial:   true,
+			EndsAt:    primary.EndsAt,
+		})
+	}
 
 	if nullStatus.Valid {
 		status.Status = &nullStatus.String
@@ -171,6 +427,14 @@ func (db *DB) GetUserSubscriptionStatus(id string) (*models.UserSubscriptionStat
 		status.VariantID = &variantID
 	}
 
+	// The primary subscription is current even if users.latest_* wasn't synced yet, e.g. when a
+	// trial ended before the trial job expired it
+	if primary != nil {
+		status.Status = &primary.Status
+		status.ProductID = &primary.ProductID
+		status.VariantID = &primary.VariantID
+	}
+
 	return status, nil
 }
 
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"saas-server/models"
+)
+
+// insertSubscriptionEvent appends a subscription state transition to the history
+func insertSubscriptionEvent(tx *sql.Tx, subscriptionID int, userID string, source string, oldStatus *string, newStatus string, oldVariantID *int, newVariantID int, oldCancelled *bool, newCancelled bool) error {
+	query := `
+		INSERT INTO subscription_events (
+			subscription_id, user_id, source,
+			old_status, new_status, old_variant_id, new_variant_id,
+			old_cancelled, new_cancelled, created_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, CURRENT_TIMESTAMP)`
+
+	_, err := tx.Exec(query, subscriptionID, userID, source,
+		oldStatus, newStatus, oldVariantID, newVariantID, oldCancelled, newCancelled)
+	if err != nil {
+		return fmt.Errorf("error recording subscription event: %v", err)
+	}
+	return nil
+}
+
+// GetSubscriptionEvents returns a page of subscription history, newest first.
+// Empty userID or zero subscriptionID values are not used as filters.
+func (db *DB) GetSubscriptionEvents(userID string, subscriptionID int, page int, limit int) ([]models.SubscriptionEvent, int, error) {
+	offset := (page - 1) * limit
+
+	where := `WHERE ($1 = '' OR user_id::text = $1) AND ($2 = 0 OR subscription_id = $2)`
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM subscription_events `+where, userID, subscriptionID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error counting subscription events: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT id, subscription_id, user_id, source,
+		       old_status, new_status, old_variant_id, new_variant_id,
+		       old_cancelled, new_cancelled, created_at
+		FROM subscription_events
+		`+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4`,
+		userID, subscriptionID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying subscription events: %v", err)
+	}
+	defer rows.Close()
+
+	events := []models.SubscriptionEvent{}
+	for rows.Next() {
+		var event models.SubscriptionEvent
+		var oldStatus sql.NullString
+		var oldVariantID sql.NullInt64
+		var oldCancelled sql.NullBool
+		if err := rows.Scan(
+			&event.ID,
+			&event.SubscriptionID,
+			&event.UserID,
+			&event.Source,
+			&oldStatus,
+			&event.NewStatus,
+			&oldVariantID,
+			&event.NewVariantID,
+			&oldCancelled,
+			&event.NewCancelled,
+			&event.CreatedAt,
+		); err != nil {
+			return nil, 0, fmt.Errorf("error scanning subscription event: %v", err)
+		}
+		if oldStatus.Valid {
+			event.OldStatus = &oldStatus.String
+		}
+		if oldVariantID.Valid {
+			v := int(oldVariantID.Int64)
+			event.OldVariantID = &v
+		}
+		if oldCancelled.Valid {
+			event.OldCancelled = &oldCancelled.Bool
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating subscription events: %v", err)
+	}
+
+	return events, total, nil
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"saas-server/models"
+	"time"
+)
+
+// ErrTrialAlreadyUsed is returned when a user who already had a trial starts another one
+var ErrTrialAlreadyUsed = errors.New("trial already used")
+
+// trialColumns lists the columns read by scanTrial, in order
+const trialColumns = `
+		id, user_id, product_id, variant_id, status, started_at, ends_at,
+		reminder_sent_at, converted_at, subscription_id, created_at, updated_at`
+
+// scanTrial scans a single trial row
+func scanTrial(row rowScanner) (*models.Trial, error) {
+	var t models.Trial
+	var subscriptionID sql.NullInt64
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.ProductID,
+		&t.VariantID,
+		&t.Status,
+		&t.StartedAt,
+		&t.EndsAt,
+		&t.ReminderSentAt,
+		&t.ConvertedAt,
+		&subscriptionID,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if subscriptionID.Valid {
+		id := int(subscriptionID.Int64)
+		t.SubscriptionID = &id
+	}
+	return &t, nil
+}
+
+// CreateTrial starts a trial for a user. It returns ErrTrialAlreadyUsed if the user ever had one.
+func (db *DB) CreateTrial(userID string, productID int, variantID int, startedAt time.Time, endsAt time.Time) (*models.Trial, error) {
+	query := `
+		INSERT INTO trials (user_id, product_id, variant_id, status, started_at, ends_at, created_at, updated_at)
+		VALUES ($1, $2, $3, 'active', $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id) DO NOTHING
+		RETURNING ` + trialColumns
+
+	trial, err := scanTrial(db.QueryRow(query, userID, productID, variantID, startedAt, endsAt))
+	if err == sql.ErrNoRows {
+		return nil, ErrTrialAlreadyUsed
+	}
+	return trial, err
+}
+
+// GetTrialByUserID retrieves the trial of a user
+func (db *DB) GetTrialByUserID(userID string) (*models.Trial, error) {
+	query := `
+		SELECT ` + trialColumns + `
+		FROM trials
+		WHERE user_id = $1`
+
+	return scanTrial(db.QueryRow(query, userID))
+}
+
+// GetActiveTrialByUserID retrieves the user's trial if it currently grants access
+func (db *DB) GetActiveTrialByUserID(userID string) (*models.Trial, error) {
+	query := `
+		SELECT ` + trialColumns + `
+		FROM trials
+		WHERE user_id = $1 AND status = 'active' AND ends_at > CURRENT_TIMESTAMP`
+
+	return scanTrial(db.QueryRow(query, userID))
+}
+
+// GetTrialsNeedingReminder returns active trials ending before the given time that haven't been reminded yet
+func (db *DB) GetTrialsNeedingReminder(endingBefore time.Time) ([]models.Trial, error) {
+	return db.queryTrials(`
+		SELECT `+trialColumns+`
+		FROM trials
+		WHERE status = 'active' AND reminder_sent_at IS NULL AND ends_at <= $1
+		ORDER BY ends_at ASC`, endingBefore)
+}
+
+// MarkTrialReminderSent records that the trial ending reminder went out
+func (db *DB) MarkTrialReminderSent(trialID int, sentAt time.Time) error {
+	query := `
+		UPDATE trials
+		SET reminder_sent_at = $1,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`
+
+	_, err := db.Exec(query, sentAt, trialID)
+	return err
+}
+
+// ExpireTrials marks every active trial that ended before now as expired and returns them
+func (db *DB) ExpireTrials(now time.Time) ([]models.Trial, error) {
+	return db.queryTrials(`
+		UPDATE trials
+		SET status = 'expired',
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE status = 'active' AND ends_at <= $1
+		RETURNING `+trialColumns, now)
+}
+
+// MarkTrialConverted links a user's trial to the subscription they bought.
+// Trials that already expired are converted too so conversion stats stay accurate.
+func (db *DB) MarkTrialConverted(userID string, subscriptionID int, convertedAt time.Time) error {
+	query := `
+		UPDATE trials
+		SET status = 'converted',
+		    converted_at = $1,
+		    subscription_id = $2,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = $3 AND status IN ('active', 'expired')`
+
+	_, err := db.Exec(query, convertedAt, subscriptionID, userID)
+	return err
+}
+
+// queryTrials runs a query returning trialColumns
+func (db *DB) queryTrials(query string, args ...interface{}) ([]models.Trial, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trials []models.Trial
+	for rows.Next() {
+		trial, err := scanTrial(rows)
+		if err != nil {
+			return nil, err
+		}
+		trials = append(trials, *trial)
+	}
+
+	return trials, rows.Err()
+}
@@ -0,0 +1,464 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"saas-server/models"
+	"time"
+)
+
+// ErrUsageLimitExceeded is returned when a usage event would take a subscription over its plan's hard limit
+var ErrUsageLimitExceeded = errors.New("usage limit exceeded")
+
+// RecordUsageEvent stores a usage event unless one with the same idempotency key already exists.
+// It returns the stored event and whether it was newly created. With a hard limit, a new event
+// that would take the usage since periodStart over it is refused with ErrUsageLimitExceeded.
+// Events of a subscription are recorded one at a time, so concurrent ones can't exceed the limit.
+func (db *DB) RecordUsageEvent(userID string, subscriptionID int, quantity int, idempotencyKey string, occurredAt time.Time, periodStart time.Time, hardLimit *int) (*models.UsageEvent, bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('usage_events'), $1)`, subscriptionID); err != nil {
+		return nil, false, err
+	}
+
+	// A replayed key returns the original event, even if the limit has been reached since
+	event, err := scanUsageEvent(tx.QueryRow(`
+		SELECT id, user_id, subscription_id, quantity, idempotency_key, usage_report_id, occurred_at, created_at
+		FROM usage_events
+		WHERE idempotency_key = $1`, idempotencyKey))
+	if err == nil {
+		return event, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, err
+	}
+
+	if hardLimit != nil {
+		var used int
+		err := tx.QueryRow(`
+			SELECT COALESCE(SUM(quantity), 0)
+			FROM usage_events
+			WHERE subscription_id = $1 AND occurred_at >= $2`,
+			subscriptionID, periodStart,
+		).Scan(&used)
+		if err != nil {
+			return nil, false, err
+		}
+		if used+quantity > *hardLimit {
+			return nil, false, ErrUsageLimitExceeded
+		}
+	}
+
+	query := `
+		INSERT INTO usage_events (user_id, subscription_id, quantity, idempotency_key, occurred_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (idempotency_key) DO NOTHING
+		RETURNING id, user_id, subscription_id, quantity, idempotency_key, usage_report_id, occurred_at, created_at`
+
+	event, err = scanUsageEvent(tx.QueryRow(query, userID, subscriptionID, quantity, idempotencyKey, occurredAt))
+	created := err == nil
+	if err == sql.ErrNoRows {
+		// The key was just used for another subscription's event, return that one
+		event, err = scanUsageEvent(tx.QueryRow(`
+			SELECT id, user_id, subscription_id, quantity, idempotency_key, usage_report_id, occurred_at, created_at
+			FROM usage_events
+			WHERE idempotency_key = $1`, idempotencyKey))
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return event, created, nil
+}
+
+// scanUsageEvent scans a single usage event row
+func scanUsageEvent(row rowScanner) (*models.UsageEvent, error) {
+	var event models.UsageEvent
+	var reportID sql.NullInt64
+	err := row.Scan(
+		&event.ID,
+		&event.UserID,
+		&event.SubscriptionID,
+		&event.Quantity,
+		&event.IdempotencyKey,
+		&reportID,
+		&event.OccurredAt,
+		&event.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if reportID.Valid {
+		id := int(reportID.Int64)
+		event.UsageReportID = &id
+	}
+	return &event, nil
+}
+
+// GetSubscriptionUsageSince returns the total quantity recorded for a subscription since the given time
+func (db *DB) GetSubscriptionUsageSince(subscriptionID int, since time.Time) (int, error) {
+	var total int
+	query := `
+		SELECT COALESCE(SUM(quantity), 0)
+		FROM usage_events
+		WHERE subscription_id = $1 AND occurred_at >= $2`
+
+	err := db.QueryRow(query, subscriptionID, since).Scan(&total)
+	return total, err
+}
+
+// GetUnreportedUsageSubscriptions returns the usage-based subscriptions that have usage events
+// which aren't part of a usage report yet
+func (db *DB) GetUnreportedUsageSubscriptions() ([]models.Subscription, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT s.subscription_id, s.user_id, s.subscription_item_id
+		FROM usage_events e
+		JOIN subscriptions s ON s.subscription_id = e.subscription_id
+		WHERE e.usage_report_id IS NULL
+		  AND s.is_usage_based = TRUE
+		  AND s.subscription_item_id IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []models.Subscription
+	for rows.Next() {
+		var subscription models.Subscription
+		if err := rows.Scan(&subscription.SubscriptionID, &subscription.UserID, &subscription.SubscriptionItemID); err != nil {
+			return nil, err
+		}
+		subscription.IsUsageBased = true
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+// CreatePendingUsageReport aggregates a subscription's unreported usage events into a pending
+// usage report for its current billing period. Usage records only add to the current period, so
+// events from before it, e.g. recorded between the last run and the renewal, are carried over
+// and billed in this period instead of being dropped. It returns the report, nil if there were
+// no events, and the quantity carried over. Events are linked to their report in the same
+// transaction so each event is reported at most once.
+func (db *DB) CreatePendingUsageReport(subscriptionID int, subscriptionItemID int, periodStart time.Time, periodEnd time.Time) (*models.UsageReport, int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	// Serialise aggregation so concurrent runs don't report the same events twice
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('usage_reports'))`); err != nil {
+		return nil, 0, err
+	}
+
+	var quantity, carriedOver int
+	var maxEventID sql.NullInt64
+	err = tx.QueryRow(`
+		SELECT COALESCE(SUM(quantity), 0),
+		       COALESCE(SUM(quantity) FILTER (WHERE occurred_at < $2), 0),
+		       MAX(id)
+		FROM usage_events
+		WHERE subscription_id = $1 AND usage_report_id IS NULL AND occurred_at < $3`,
+		subscriptionID, periodStart, periodEnd,
+	).Scan(&quantity, &carriedOver, &maxEventID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !maxEventID.Valid {
+		return nil, 0, nil
+	}
+
+	report := models.UsageReport{
+		SubscriptionID:     subscriptionID,
+		SubscriptionItemID: subscriptionItemID,
+		Quantity:           quantity,
+		PeriodStart:        periodStart,
+		PeriodEnd:          periodEnd,
+	}
+	err = tx.QueryRow(`
+		INSERT INTO usage_reports (subscription_id, subscription_item_id, quantity, period_start, period_end, status)
+		VALUES ($1, $2, $3, $4, $5, 'pending')
+		RETURNING id, status, attempts, created_at, updated_at`,
+		report.SubscriptionID, report.SubscriptionItemID, report.Quantity, report.PeriodStart, report.PeriodEnd,
+	).Scan(&report.ID, &report.Status, &report.Attempts, &report.CreatedAt, &report.UpdatedAt)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE usage_events
+		SET usage_report_id = $1
+		WHERE subscription_id = $2 AND id <= $3 AND usage_report_id IS NULL AND occurred_at < $4`,
+		report.ID, subscriptionID, maxEventID.Int64, periodEnd,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, 0, err
+	}
+	return &report, carriedOver, nil
+}
+
+// GetUnsentUsageReports returns pending and failed usage reports that have been attempted fewer than maxAttempts times
+func (db *DB) GetUnsentUsageReports(maxAttempts int) ([]models.UsageReport, error) {
+	query := `
+		SELECT id, subscription_id, subscription_item_id, quantity, period_start, period_end,
+		       status, COALESCE(provider_usage_record_id, ''), attempts, COALESCE(last_error, ''),
+		       reported_at, created_at, updated_at
+		FROM usage_reports
+		WHERE status IN ('pending', 'failed') AND attempts < $1
+		ORDER BY created_at ASC`
+
+	rows, err := db.Query(query, maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanUsageReports(rows)
+}
+
+// scanUsageReports scans usage report rows
+func scanUsageReports(rows *sql.Rows) ([]models.UsageReport, error) {
+	var reports []models.UsageReport
+	for rows.Next() {
+		var report models.UsageReport
+		if err := rows.Scan(
+			&report.ID,
+			&report.SubscriptionID,
+			&report.SubscriptionItemID,
+			&report.Quantity,
+			&report.PeriodStart,
+			&report.PeriodEnd,
+			&report.Status,
+			&report.ProviderUsageRecordID,
+			&report.Attempts,
+			&report.LastError,
+			&report.ReportedAt,
+			&report.CreatedAt,
+			&report.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, rows.Err()
+}
+
+// ClaimUsageReport marks a pending or failed usage report as being sent and counts the attempt.
+// It returns false if the report was already claimed, e.g. by a concurrent run. A claimed report
+// is never sent again until it is marked sent or failed, so a crash after the provider accepted
+// it can't bill the usage twice.
+func (db *DB) ClaimUsageReport(reportID int) (bool, error) {
+	query := `
+		UPDATE usage_reports
+		SET status = 'sending',
+		    attempts = attempts + 1,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status IN ('pending', 'failed')`
+
+	result, err := db.Exec(query, reportID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// MarkUsageReportSent marks a claimed usage report as accepted by the payment provider
+func (db *DB) MarkUsageReportSent(reportID int, providerUsageRecordID string) error {
+	query := `
+		UPDATE usage_reports
+		SET status = 'reported',
+		    provider_usage_record_id = $1,
+		    last_error = NULL,
+		    reported_at = CURRENT_TIMESTAMP,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND status = 'sending'`
+
+	_, err := db.Exec(query, providerUsageRecordID, reportID)
+	return err
+}
+
+// MarkUsageReportFailed releases a claimed usage report the payment provider didn't record,
+// so it is retried
+func (db *DB) MarkUsageReportFailed(reportID int, reportErr string) error {
+	query := `
+		UPDATE usage_reports
+		SET status = 'failed',
+		    last_error = $1,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND status = 'sending'`
+
+	_, err := db.Exec(query, reportErr, reportID)
+	return err
+}
+
+// MoveUsageReportToPeriod moves an unsent usage report into the current billing period when
+// its own period ended before it could be sent, so the usage is billed late instead of never
+func (db *DB) MoveUsageReportToPeriod(reportID int, periodStart time.Time, periodEnd time.Time) error {
+	query := `
+		UPDATE usage_reports
+		SET period_start = $1,
+		    period_end = $2,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND status IN ('pending', 'failed')`
+
+	_, err := db.Exec(query, periodStart, periodEnd, reportID)
+	return err
+}
+
+// MarkUsageReportUnconfirmed records why the outcome of sending a claimed usage report is
+// unknown. The report stays claimed until an admin checks it against the provider.
+func (db *DB) MarkUsageReportUnconfirmed(reportID int, reportErr string) error {
+	query := `
+		UPDATE usage_reports
+		SET last_error = $1,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND status = 'sending'`
+
+	_, err := db.Exec(query, reportErr, reportID)
+	return err
+}
+
+// GetUsageReportsNeedingReview returns the usage reports an admin has to check against the
+// payment provider: ones claimed before claimedBefore whose outcome was never confirmed, and
+// ones that failed maxAttempts times
+func (db *DB) GetUsageReportsNeedingReview(claimedBefore time.Time, maxAttempts int) ([]models.UsageReport, error) {
+	query := `
+		SELECT id, subscription_id, subscription_item_id, quantity, period_start, period_end,
+		       status, COALESCE(provider_usage_record_id, ''), attempts, COALESCE(last_error, ''),
+		       reported_at, created_at, updated_at
+		FROM usage_reports
+		WHERE (status = 'sending' AND updated_at < $1)
+		   OR (status = 'failed' AND attempts >= $2)
+		ORDER BY created_at ASC`
+
+	rows, err := db.Query(query, claimedBefore, maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanUsageReports(rows)
+}
+
+// ResolveUsageReport records the outcome an admin found for a usage report needing review.
+// A report the provider recorded is marked reported; any other is released with its attempts
+// reset, so it is sent again. It returns false if the report doesn't need review.
+func (db *DB) ResolveUsageReport(reportID int, recorded bool, providerUsageRecordID string, claimedBefore time.Time, maxAttempts int) (bool, error) {
+	query := `
+		UPDATE usage_reports
+		SET status = 'failed',
+		    attempts = 0,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		  AND ((status = 'sending' AND updated_at < $2) OR (status = 'failed' AND attempts >= $3))`
+	args := []interface{}{reportID, claimedBefore, maxAttempts}
+	if recorded {
+		query = `
+			UPDATE usage_reports
+			SET status = 'reported',
+			    provider_usage_record_id = NULLIF($4, ''),
+			    last_error = NULL,
+			    reported_at = CURRENT_TIMESTAMP,
+			    updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+			  AND ((status = 'sending' AND updated_at < $2) OR (status = 'failed' AND attempts >= $3))`
+		args = append(args, providerUsageRecordID)
+	}
+
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// GetPlanUsageLimit retrieves the usage limits for a plan variant
+func (db *DB) GetPlanUsageLimit(variantID int) (*models.PlanUsageLimit, error) {
+	var limit models.PlanUsageLimit
+	var hardLimit sql.NullInt64
+	query := `
+		SELECT variant_id, included_units, hard_limit, unit_label, created_at, updated_at
+		FROM plan_usage_limits
+		WHERE variant_id = $1`
+
+	err := db.QueryRow(query, variantID).Scan(
+		&limit.VariantID,
+		&limit.IncludedUnits,
+		&hardLimit,
+		&limit.UnitLabel,
+		&limit.CreatedAt,
+		&limit.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if hardLimit.Valid {
+		v := int(hardLimit.Int64)
+		limit.HardLimit = &v
+	}
+	return &limit, nil
+}
+
+// GetAllPlanUsageLimits returns the usage limits of every plan variant
+func (db *DB) GetAllPlanUsageLimits() ([]models.PlanUsageLimit, error) {
+	rows, err := db.Query(`
+		SELECT variant_id, included_units, hard_limit, unit_label, created_at, updated_at
+		FROM plan_usage_limits
+		ORDER BY variant_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var limits []models.PlanUsageLimit
+	for rows.Next() {
+		var limit models.PlanUsageLimit
+		var hardLimit sql.NullInt64
+		if err := rows.Scan(
+			&limit.VariantID,
+			&limit.IncludedUnits,
+			&hardLimit,
+			&limit.UnitLabel,
+			&limit.CreatedAt,
+			&limit.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if hardLimit.Valid {
+			v := int(hardLimit.Int64)
+			limit.HardLimit = &v
+		}
+		limits = append(limits, limit)
+	}
+
+	return limits, rows.Err()
+}
+
+// UpsertPlanUsageLimit creates or updates the usage limits for a plan variant
+func (db *DB) UpsertPlanUsageLimit(variantID int, includedUnits int, hardLimit *int, unitLabel string) error {
+	query := `
+		INSERT INTO plan_usage_limits (variant_id, included_units, hard_limit, unit_label, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (variant_id) DO UPDATE
+		SET included_units = EXCLUDED.included_units,
+		    hard_limit = EXCLUDED.hard_limit,
+		    unit_label = EXCLUDED.unit_label,
+		    updated_at = CURRENT_TIMESTAMP`
+
+	_, err := db.Exec(query, variantID, includedUnits, hardLimit, unitLabel)
+	return err
+}
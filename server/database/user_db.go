@@ -26,7 +26,7 @@ func (db *DB) CreateUser(email, password, name string, emailVerified bool) (*mod
 	query := `
 		INSERT INTO users (id, email, password, name, email_verified, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, email, password, name, email_verified, created_at, updated_at`
+		RETURNING id, email, password, name, email_verified, COALESCE(language, ''), created_at, updated_at`
 
 	var user models.User
 	err = db.QueryRow(
@@ -44,6 +44,7 @@ func (db *DB) CreateUser(email, password, name string, emailVerified bool) (*mod
 		&user.Password,
 		&user.Name,
 		&user.EmailVerified,
+		&user.Language,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -57,7 +58,7 @@ func (db *DB) CreateUser(email, password, name string, emailVerified bool) (*mod
 func (db *DB) GetUserByEmail(email string) (*models.User, error) {
 	var user models.User
 	query := `
-		SELECT id, email, password, name, email_verified, created_at, updated_at
+		SELECT id, email, password, name, email_verified, COALESCE(language, ''), created_at, updated_at
 		FROM users
 		WHERE email = $1`
 
@@ -67,6 +68,7 @@ func (db *DB) GetUserByEmail(email string) (*models.User, error) {
 		&user.Password,
 		&user.Name,
 		&user.EmailVerified,
+		&user.Language,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -86,7 +88,7 @@ func (db *DB) GetUserByID(id string) (*models.User, error) {
 	var latestEndDate sql.NullTime
 
 	query := `
-		SELECT id, email, password, name, email_verified,
+		SELECT id, email, password, name, email_verified, COALESCE(language, ''),
 			latest_status, latest_product_id, latest_variant_id,
 			latest_renewal_date, latest_end_date,
 			created_at, updated_at
@@ -99,6 +101,7 @@ func (db *DB) GetUserByID(id string) (*models.User, error) {
 		&user.Password,
 		&user.Name,
 		&user.EmailVerified,
+		&user.Language,
 		&latestStatus,
 		&latestProductID,
 		&latestVariantID,
@@ -178,6 +181,34 @@ func (db *DB) UpdateUser(id, name, email string) error {
 	return nil
 }
 
+// UpdateUserLanguage sets the language the user's emails are sent in
+func (db *DB) UpdateUserLanguage(id, language string) error {
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(`
+		UPDATE users
+		SET language = NULLIF($2, ''), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		parsedID, language)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
 // UpdatePassword updates a user's password in the database
 func (db *DB) UpdatePassword(id, hashedPassword string) error {
 	parsedID, err := uuid.Parse(id)
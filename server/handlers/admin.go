@@ -108,3 +108,38 @@ func (h *AdminHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
 		Limit: limit,
 	})
 }
+
+// GetSubscriptionHistory handles GET /admin/subscriptions/history
+// Results can be filtered with the user_id and subscription_id query parameters.
+func (h *AdminHandler) GetSubscriptionHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	subscriptionID := 0
+	if v := r.URL.Query().Get("subscription_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid subscription_id", http.StatusBadRequest)
+			return
+		}
+		subscriptionID = id
+	}
+
+	page, limit := parsePagination(r)
+	events, total, err := h.db.GetSubscriptionEvents(userID, subscriptionID, page, limit)
+	if err != nil {
+		http.Error(w, "Error retrieving subscription history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SubscriptionHistoryResponse{
+		Events: events,
+		Total:  total,
+		Page:   page,
+		Limit:  limit,
+	})
+}
@@ -18,8 +18,9 @@ type AnalyticsHandler struct {
 }
 
 type PageViewRequest struct {
-	Path     string `json:"path"`
-	Referrer string `json:"referrer,omitempty"`
+	Path      string `json:"path"`
+	Referrer  string `json:"referrer,omitempty"`
+	VisitorID string `json:"visitor_id,omitempty"` // Client-provided ID, e.g. from local storage
 }
 
 type JourneyRequest struct {
@@ -63,12 +64,16 @@ func (h *AnalyticsHandler) TrackPageView(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	// Use visitor ID from header only if user is not authenticated
-	var visitorID string
-	if userIDPtr == nil {
-		// Generate time-based visitor ID if not provided
-		visitorID = fmt.Sprintf("v_%d", time.Now().UnixNano())
+	// Identify the browser across visits, signed in or not, so its sessions can be
+	// stitched to the user once they log in
+	visitorID := req.VisitorID
+	if !analytics.ValidVisitorID(visitorID) {
+		visitorID = readVisitorID(r)
 	}
+	if visitorID == "" {
+		visitorID = analytics.NewVisitorID()
+	}
+	setVisitorCookie(w, visitorID)
 
 	// Create page view
 	pageView := analytics.NewPageView(
@@ -164,3 +169,28 @@ func (h *AnalyticsHandler) GetPageViewStats(w http.ResponseWriter, r *http.Reque
 		return
 	}
 }
+
+// readVisitorID returns the visitor ID of a request from the X-Visitor-ID header or the
+// visitor cookie, or an empty string if it has none
+func readVisitorID(r *http.Request) string {
+	if id := r.Header.Get("X-Visitor-ID"); analytics.ValidVisitorID(id) {
+		return id
+	}
+	if cookie, err := r.Cookie(analytics.VisitorCookieName); err == nil && analytics.ValidVisitorID(cookie.Value) {
+		return cookie.Value
+	}
+	return ""
+}
+
+// setVisitorCookie stores the visitor ID in a first-party cookie, renewing it on each visit
+func setVisitorCookie(w http.ResponseWriter, visitorID string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     analytics.VisitorCookieName,
+		Value:    visitorID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Now().Add(analytics.VisitorCookieLifetime),
+	})
+}
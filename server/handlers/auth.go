@@ -40,6 +40,33 @@ type AuthHandler struct {
 	githubClientID     string
 	githubClientSecret string
 	githubRedirectURL  string
+	mailer             email.Mailer
+
+	// Trials starts a free trial for new users when enabled. It may be nil.
+	Trials SignupTrialStarter
+	// Referrals attributes new users to the referral link they opened. It may be nil.
+	Referrals SignupReferrals
+	// Visitors links the anonymous analytics history of a browser to the user who signs
+	// in with it. It may be nil.
+	Visitors VisitorLinker
+}
+
+// SignupTrialStarter starts the free trial of a newly registered user.
+// Implemented by trials.Service
+type SignupTrialStarter interface {
+	StartSignupTrial(userID string)
+}
+
+// SignupReferrals attributes a newly registered user to a referral code.
+// Implemented by referrals.Service
+type SignupReferrals interface {
+	AttributeSignup(userID string, code string, firstTouchAt time.Time)
+}
+
+// VisitorLinker attributes a visitor's anonymous page views and sessions to a user.
+// Implemented by database.DB
+type VisitorLinker interface {
+	LinkVisitor(visitorID string, userID string) error
 }
 
 // AuthResponse represents the response body for successful authentication operations
@@ -99,7 +126,7 @@ type GithubAuthRequest struct {
 }
 
 // NewAuthHandler creates a new AuthHandler instance with the given database connection and JWT secret
-func NewAuthHandler(db database.DBInterface, jwtSecret string) *AuthHandler {
+func NewAuthHandler(db database.DBInterface, jwtSecret string, mailer email.Mailer) *AuthHandler {
 	// Create rate limiter for auth endpoints - 5 attempts per minute
 	authLimiter := middleware.NewRateLimiter(time.Minute, 5)
 
@@ -114,6 +141,45 @@ func NewAuthHandler(db database.DBInterface, jwtSecret string) *AuthHandler {
 		githubClientID:     os.Getenv("GITHUB_CLIENT_ID"),
 		githubClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
 		githubRedirectURL:  os.Getenv("GITHUB_REDIRECT_URL"),
+		mailer:             mailer,
+	}
+}
+
+// onSignup runs the follow-up work for a newly registered user: attributing them to the
+// referral link they opened, starting their free trial if trials are configured and
+// remembering the language of their browser for emails
+func (h *AuthHandler) onSignup(w http.ResponseWriter, r *http.Request, userID string) {
+	if code, firstTouchAt, ok := readReferralCookie(r); ok {
+		if h.Referrals != nil {
+			h.Referrals.AttributeSignup(userID, code, firstTouchAt)
+		}
+		clearReferralCookie(w)
+	}
+
+	if h.Trials != nil {
+		h.Trials.StartSignupTrial(userID)
+	}
+
+	// Send emails in the browser's language until the user picks one
+	if locale := email.LocaleFromAcceptLanguage(r.Header.Get("Accept-Language")); locale != "" {
+		if err := h.db.UpdateUserLanguage(userID, locale); err != nil {
+			log.Printf("[Auth] Error storing language of user %s: %v", userID, err)
+		}
+	}
+}
+
+// linkVisitor attributes the anonymous analytics history of the request's browser to the
+// user, so their journey includes the visits before they signed up or logged in
+func (h *AuthHandler) linkVisitor(r *http.Request, userID string) {
+	if h.Visitors == nil {
+		return
+	}
+	visitorID := readVisitorID(r)
+	if visitorID == "" {
+		return
+	}
+	if err := h.Visitors.LinkVisitor(visitorID, userID); err != nil {
+		log.Printf("[Auth] Error linking visitor %s to user %s: %v", visitorID, userID, err)
 	}
 }
 
@@ -173,11 +239,12 @@ func (h *AuthHandler) GoogleAuth(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			// Track user signup with Plunk for new users
-			if err := trackUserSignup(user.Email, user.Name); err != nil {
+			// Track user signup with the email provider for new users
+			if err := email.TrackUserSignup(h.mailer, user.Email, user.Name); err != nil {
 				log.Printf("[Auth] Error tracking user signup: %v", err)
 				// Continue even if tracking fails
 			}
+			h.onSignup(w, r, user.ID)
 		} else {
 			log.Printf("[Auth] Database error while checking user: %v", err)
 			sendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
@@ -252,11 +319,13 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Track user signup with Plunk
-	if err := trackUserSignup(user.Email, user.Name); err != nil {
+	// Track user signup with the email provider
+	if err := email.TrackUserSignup(h.mailer, user.Email, user.Name); err != nil {
 		log.Printf("[Auth] Error tracking user signup: %v", err)
 		// Continue even if tracking fails
 	}
+	h.onSignup(w, r, user.ID)
+	h.linkVisitor(r, user.ID)
 
 	// Send success response
 	w.WriteHeader(http.StatusCreated)
@@ -432,22 +501,28 @@ func (h *AuthHandler) RequestPasswordReset(w http.ResponseWriter, r *http.Reques
 	token := uuid.New().String()
 	expiresAt := time.Now().Add(1 * time.Hour)
 
-	// Save reset token
-	if err := h.db.CreatePasswordResetToken(user.ID, token, expiresAt); err != nil {
-		log.Printf("[Auth] Error creating password reset token: %v", err)
-		http.Error(w, "Error creating password reset token", http.StatusInternalServerError)
-		return
-	}
-
 	// Generate reset URL
 	resetURL := fmt.Sprintf("%s/auth/reset-password?token=%s", os.Getenv("FRONTEND_URL"), token)
 
-	// Send password reset email using our email utility
-	if err := email.SendPasswordResetEmail(user.Email, resetURL); err != nil {
-		log.Printf("[Auth] Error sending password reset email: %v", err)
+	msg, err := email.PasswordResetEmail(user.Email, user.Language, resetURL)
+	if err != nil {
+		log.Printf("[Auth] Error rendering password reset email: %v", err)
 		http.Error(w, "Error sending password reset email", http.StatusInternalServerError)
 		return
 	}
+	notification, err := email.NewOutbound(msg, "password-reset:"+token)
+	if err != nil {
+		log.Printf("[Auth] Error preparing password reset email: %v", err)
+		http.Error(w, "Error sending password reset email", http.StatusInternalServerError)
+		return
+	}
+
+	// Save reset token and queue the email with it
+	if err := h.db.CreatePasswordResetToken(user.ID, token, expiresAt, notification); err != nil {
+		log.Printf("[Auth] Error creating password reset token: %v", err)
+		http.Error(w, "Error creating password reset token", http.StatusInternalServerError)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
@@ -786,11 +861,12 @@ func (h *AuthHandler) GithubAuth(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			// Track user signup with Plunk for new users
-			if err := trackUserSignup(user.Email, user.Name); err != nil {
+			// Track user signup with the email provider for new users
+			if err := email.TrackUserSignup(h.mailer, user.Email, user.Name); err != nil {
 				log.Printf("[Auth] Error tracking user signup: %v", err)
 				// Continue even if tracking fails
 			}
+			h.onSignup(w, r, user.ID)
 		} else {
 			log.Printf("[Auth] Database error while checking user: %v", err)
 			sendErrorResponse(w, http.StatusInternalServerError, "Internal server error")
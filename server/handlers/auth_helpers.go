@@ -2,12 +2,10 @@
 package handlers
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"regexp"
 	"time"
 
@@ -16,6 +14,7 @@ import (
 
 	"saas-server/middleware"
 	"saas-server/models"
+	"saas-server/pkg/email"
 )
 
 // Common response types
@@ -38,8 +37,9 @@ type TokenPair struct {
 
 // UpdateProfileRequest represents the request body for profile update endpoint
 type UpdateProfileRequest struct {
-	Name  string `json:"name"`  // New display name
-	Email string `json:"email"` // New email address
+	Name     string  `json:"name"`               // New display name
+	Email    string  `json:"email"`              // New email address
+	Language *string `json:"language,omitempty"` // Language emails are sent in; empty for the default, omitted to keep it
 }
 
 // UpdateProfile handles user profile update endpoint (PUT /user/profile)
@@ -65,12 +65,28 @@ func (h *AuthHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Language != nil && *req.Language != "" {
+		locale, ok := email.SupportedLocale(*req.Language)
+		if !ok {
+			http.Error(w, "Unsupported language", http.StatusBadRequest)
+			return
+		}
+		req.Language = &locale
+	}
+
 	log.Printf("[Auth] Updating profile for user: %s", userID)
 	if err := h.db.UpdateUser(userID, req.Name, req.Email); err != nil {
 		log.Printf("[Auth] Failed to update profile: %v", err)
 		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
 		return
 	}
+	if req.Language != nil {
+		if err := h.db.UpdateUserLanguage(userID, *req.Language); err != nil {
+			log.Printf("[Auth] Failed to update language: %v", err)
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	user, err := h.db.GetUserByID(userID)
 	if err != nil {
@@ -82,9 +98,10 @@ func (h *AuthHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[Auth] Profile updated successfully for user: %s", userID)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":    user.ID,
-		"name":  user.Name,
-		"email": user.Email,
+		"id":       user.ID,
+		"name":     user.Name,
+		"email":    user.Email,
+		"language": user.Language,
 	})
 }
 
@@ -399,61 +416,10 @@ func (h *AuthHandler) GenerateAuthResponse(w http.ResponseWriter, r *http.Reques
 	// Set cookies
 	h.setAuthCookies(w, tokens)
 
+	// Attribute the browser's anonymous visits to the user
+	h.linkVisitor(r, user.ID)
+
 	// Send response
 	h.sendAuthResponse(w, user)
 	return nil
 }
-
-// Track user signup with Plunk
-func trackUserSignup(email string, name string) error {
-	plunkAPIKey := os.Getenv("PLUNK_SECRET_API_KEY")
-	if plunkAPIKey == "" {
-		return fmt.Errorf("PLUNK_SECRET_API_KEY not set")
-	}
-
-	type UserData struct {
-		Name string `json:"name"`
-	}
-
-	type TrackRequest struct {
-		Event     string   `json:"event"`
-		Email     string   `json:"email"`
-		Subscribed bool     `json:"subscribed"` // Added subscribed field
-		Data      UserData `json:"data"`
-	}
-
-	trackReq := TrackRequest{
-		Event:     "user-signup",
-		Email:     email,
-		Subscribed: true, // Set subscribed to true
-		Data: UserData{
-			Name: name,
-		},
-	}
-
-	jsonData, err := json.Marshal(trackReq)
-	if err != nil {
-		return fmt.Errorf("error marshaling track request: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", "https://api.useplunk.com/v1/track", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("error creating request: %v", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+plunkAPIKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("error tracking signup: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error response from Plunk API: %d", resp.StatusCode)
-	}
-
-	return nil
-}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"saas-server/database"
+	"saas-server/middleware"
+	"saas-server/models"
+	"saas-server/pkg/vat"
+)
+
+// countryCodePattern matches ISO 3166-1 alpha-2 country codes
+var countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// BillingProfileHandler manages the authenticated user's billing address and tax ID
+type BillingProfileHandler struct {
+	db database.DBInterface
+}
+
+// NewBillingProfileHandler creates a new BillingProfileHandler
+func NewBillingProfileHandler(db database.DBInterface) *BillingProfileHandler {
+	return &BillingProfileHandler{db: db}
+}
+
+// BillingProfileRequest represents the request body for updating a billing profile
+type BillingProfileRequest struct {
+	Name         string `json:"name"`
+	Company      string `json:"company"`
+	AddressLine1 string `json:"address_line1"`
+	AddressLine2 string `json:"address_line2"`
+	City         string `json:"city"`
+	State        string `json:"state"`
+	PostalCode   string `json:"postal_code"`
+	Country      string `json:"country"`    // ISO 3166-1 alpha-2
+	TaxNumber    string `json:"tax_number"` // EU VAT numbers must include the country prefix
+}
+
+// BillingProfile handles GET /api/user/billing-profile and PUT /api/user/billing-profile
+// EU VAT numbers are checked offline for format and check digits and must match the billing country.
+func (h *BillingProfileHandler) BillingProfile(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		profile, err := h.db.GetBillingProfile(userID)
+		if err == sql.ErrNoRows {
+			profile = &models.BillingProfile{UserID: userID}
+		} else if err != nil {
+			log.Printf("[Billing] Error getting billing profile for user %s: %v", userID, err)
+			http.Error(w, "Failed to fetch billing profile", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(profile)
+
+	case http.MethodPut:
+		var req BillingProfileRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		profile, msg := buildBillingProfile(userID, req)
+		if msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		if err := h.db.UpsertBillingProfile(profile); err != nil {
+			log.Printf("[Billing] Error saving billing profile for user %s: %v", userID, err)
+			http.Error(w, "Failed to save billing profile", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(profile)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// buildBillingProfile trims and validates a billing profile request.
+// It returns a message for the user when the request is invalid.
+func buildBillingProfile(userID string, req BillingProfileRequest) (*models.BillingProfile, string) {
+	profile := &models.BillingProfile{
+		UserID:       userID,
+		Name:         strings.TrimSpace(req.Name),
+		Company:      strings.TrimSpace(req.Company),
+		AddressLine1: strings.TrimSpace(req.AddressLine1),
+		AddressLine2: strings.TrimSpace(req.AddressLine2),
+		City:         strings.TrimSpace(req.City),
+		State:        strings.TrimSpace(req.State),
+		PostalCode:   strings.TrimSpace(req.PostalCode),
+		Country:      strings.ToUpper(strings.TrimSpace(req.Country)),
+		TaxNumber:    strings.TrimSpace(req.TaxNumber),
+	}
+
+	for _, field := range []string{profile.Name, profile.Company, profile.AddressLine1, profile.AddressLine2, profile.City, profile.State} {
+		if len(field) > 255 {
+			return nil, "Billing fields must be at most 255 characters"
+		}
+	}
+	if len(profile.PostalCode) > 32 {
+		return nil, "Postal code must be at most 32 characters"
+	}
+	if profile.Country != "" && !countryCodePattern.MatchString(profile.Country) {
+		return nil, "Country must be a two-letter ISO country code"
+	}
+
+	if profile.TaxNumber == "" {
+		return profile, ""
+	}
+	if profile.Country == "" {
+		return nil, "A country is required with a tax number"
+	}
+
+	if vat.IsEU(profile.Country) {
+		number, err := vat.Validate(profile.TaxNumber)
+		if err != nil {
+			return nil, err.Error()
+		}
+		if number.Prefix != vat.Prefix(profile.Country) {
+			return nil, "VAT number does not match the billing country"
+		}
+		profile.TaxNumber = number.String()
+	} else if len(profile.TaxNumber) > 32 {
+		return nil, "Tax number must be at most 32 characters"
+	}
+
+	return profile, ""
+}
@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/campaigns"
+	"saas-server/pkg/signedlink"
+)
+
+// trackingPixel is a transparent 1x1 GIF returned by the open tracking endpoint
+var trackingPixel, _ = base64.StdEncoding.DecodeString("R0lGODlhAQABAIAAAAAAAP///yH5BAEAAAAALAAAAAABAAEAAAIBRAA7")
+
+// CampaignHandler serves the admin endpoints for newsletter campaigns and the public
+// open and click tracking endpoints
+type CampaignHandler struct {
+	campaigns *campaigns.Service
+}
+
+// NewCampaignHandler creates a new CampaignHandler
+func NewCampaignHandler(campaignService *campaigns.Service) *CampaignHandler {
+	return &CampaignHandler{campaigns: campaignService}
+}
+
+// CampaignRequest represents the request body for creating or updating a campaign
+type CampaignRequest struct {
+	Name      string `json:"name"`
+	Subject   string `json:"subject"`
+	HTMLBody  string `json:"html_body"`
+	TextBody  string `json:"text_body,omitempty"`  // Derived from the HTML if empty
+	Audience  string `json:"audience"`             // newsletter, users, early_access or segment
+	SegmentID *int   `json:"segment_id,omitempty"` // Saved segment for the segment audience
+}
+
+// CampaignTestRequest represents the request body for sending a test email
+type CampaignTestRequest struct {
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+}
+
+// ScheduleCampaignRequest represents the request body for scheduling a campaign.
+// Without send_at the campaign is sent right away.
+type ScheduleCampaignRequest struct {
+	ID     int        `json:"id"`
+	SendAt *time.Time `json:"send_at,omitempty"`
+}
+
+// CancelCampaignRequest represents the request body for cancelling a campaign
+type CancelCampaignRequest struct {
+	ID int `json:"id"`
+}
+
+// CampaignsResponse represents a page of campaigns
+type CampaignsResponse struct {
+	Campaigns []models.CampaignWithStats `json:"campaigns"`
+	Total     int                        `json:"total"`
+	Page      int                        `json:"page"`
+	Limit     int                        `json:"limit"`
+}
+
+// CampaignRecipientsResponse represents a page of a campaign's recipients
+type CampaignRecipientsResponse struct {
+	Recipients []models.CampaignRecipient `json:"recipients"`
+	Total      int                        `json:"total"`
+	Page       int                        `json:"page"`
+	Limit      int                        `json:"limit"`
+}
+
+// Campaigns handles GET /admin/campaigns to list campaigns and POST /admin/campaigns to
+// create a draft
+func (h *CampaignHandler) Campaigns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		page, limit := parsePagination(r)
+		list, total, err := h.campaigns.List(page, limit)
+		if err != nil {
+			log.Printf("[Campaigns] Error listing campaigns: %v", err)
+			http.Error(w, "Failed to fetch campaigns", http.StatusInternalServerError)
+			return
+		}
+		sendJSONResponse(w, http.StatusOK, CampaignsResponse{
+			Campaigns: list,
+			Total:     total,
+			Page:      page,
+			Limit:     limit,
+		})
+
+	case http.MethodPost:
+		var req CampaignRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		campaign := req.campaign(0)
+		if err := h.campaigns.Create(campaign); err != nil {
+			writeCampaignError(w, "creating", 0, err)
+			return
+		}
+
+		log.Printf("[Campaigns] Created campaign %d", campaign.ID)
+		sendJSONResponse(w, http.StatusCreated, campaign)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Campaign handles GET /admin/campaigns/detail?id= to fetch a campaign with its stats and
+// PUT /admin/campaigns/detail?id= to update a draft
+func (h *CampaignHandler) Campaign(w http.ResponseWriter, r *http.Request) {
+	id, ok := campaignID(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		campaign, err := h.campaigns.Get(id)
+		if err != nil {
+			writeCampaignError(w, "fetching", id, err)
+			return
+		}
+		sendJSONResponse(w, http.StatusOK, campaign)
+
+	case http.MethodPut:
+		var req CampaignRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		campaign, err := h.campaigns.Update(req.campaign(id))
+		if err != nil {
+			writeCampaignError(w, "updating", id, err)
+			return
+		}
+		sendJSONResponse(w, http.StatusOK, campaign)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Preview handles GET /admin/campaigns/preview?id=&format=html|text
+// Without a format it returns the subject and both parts as JSON.
+func (h *CampaignHandler) Preview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := campaignID(w, r)
+	if !ok {
+		return
+	}
+
+	msg, err := h.campaigns.Preview(id)
+	if err != nil {
+		writeCampaignError(w, "previewing", id, err)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(msg.HTML))
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(msg.Text))
+	case "":
+		sendJSONResponse(w, http.StatusOK, map[string]string{
+			"subject": msg.Subject,
+			"html":    msg.HTML,
+			"text":    msg.Text,
+		})
+	default:
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+	}
+}
+
+// SendTest handles POST /admin/campaigns/test
+// The email is sent to the given address only, without tracking.
+func (h *CampaignHandler) SendTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CampaignTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.campaigns.SendTest(req.ID, req.Email); err != nil {
+		writeCampaignError(w, "test-sending", req.ID, err)
+		return
+	}
+	sendSuccessResponse(w, "Test email queued")
+}
+
+// Schedule handles POST /admin/campaigns/schedule
+func (h *CampaignHandler) Schedule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ScheduleCampaignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sendAt := time.Now()
+	if req.SendAt != nil {
+		sendAt = *req.SendAt
+	}
+
+	campaign, err := h.campaigns.Schedule(req.ID, sendAt)
+	if err != nil {
+		writeCampaignError(w, "scheduling", req.ID, err)
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, campaign)
+}
+
+// Cancel handles POST /admin/campaigns/cancel
+// A scheduled campaign goes back to draft; a campaign that is being sent stops.
+func (h *CampaignHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CancelCampaignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	campaign, err := h.campaigns.Cancel(req.ID)
+	if err != nil {
+		writeCampaignError(w, "cancelling", req.ID, err)
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, campaign)
+}
+
+// Recipients handles GET /admin/campaigns/recipients?id=&status=
+// status filters by delivery status, e.g. failed or suppressed.
+func (h *CampaignHandler) Recipients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := campaignID(w, r)
+	if !ok {
+		return
+	}
+
+	page, limit := parsePagination(r)
+	recipients, total, err := h.campaigns.Recipients(id, r.URL.Query().Get("status"), page, limit)
+	if err != nil {
+		writeCampaignError(w, "listing recipients of", id, err)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, CampaignRecipientsResponse{
+		Recipients: recipients,
+		Total:      total,
+		Page:       page,
+		Limit:      limit,
+	})
+}
+
+// TrackOpen handles GET /api/email/open?t=
+// It always returns the tracking pixel, so broken tokens don't show up in the email.
+func (h *CampaignHandler) TrackOpen(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.campaigns.RecordOpen(r.URL.Query().Get("t")); err != nil && !isLinkError(err) {
+		log.Printf("[Campaigns] Error recording open: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	w.Header().Set("Pragma", "no-cache")
+	w.Write(trackingPixel)
+}
+
+// TrackClick handles GET /api/email/click?t=
+// It redirects to the link's target; only targets signed into the token are followed.
+func (h *CampaignHandler) TrackClick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target, err := h.campaigns.RecordClick(r.URL.Query().Get("t"))
+	if target == "" {
+		http.Error(w, "Invalid link", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[Campaigns] Error recording click: %v", err)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// campaign converts the request to a campaign with the given ID
+func (req CampaignRequest) campaign(id int) *models.Campaign {
+	return &models.Campaign{
+		ID:        id,
+		Name:      req.Name,
+		Subject:   req.Subject,
+		HTMLBody:  req.HTMLBody,
+		TextBody:  req.TextBody,
+		Audience:  req.Audience,
+		SegmentID: req.SegmentID,
+	}
+}
+
+// campaignID reads the campaign ID from the query and rejects the request if it is missing
+func campaignID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid campaign ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// isLinkError reports whether err is about an invalid or expired signed link
+func isLinkError(err error) bool {
+	return errors.Is(err, signedlink.ErrInvalid) || errors.Is(err, signedlink.ErrExpired)
+}
+
+// writeCampaignError maps campaign service errors to responses
+func writeCampaignError(w http.ResponseWriter, action string, id int, err error) {
+	switch {
+	case errors.Is(err, campaigns.ErrNotFound):
+		http.Error(w, "Campaign not found", http.StatusNotFound)
+	case errors.Is(err, campaigns.ErrNotEditable):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, campaigns.ErrInvalidCampaign):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("[Campaigns] Error %s campaign %d: %v", action, id, err)
+		http.Error(w, "Failed to process campaign", http.StatusInternalServerError)
+	}
+}
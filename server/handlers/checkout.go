@@ -1,28 +1,70 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"saas-server/database"
+	"saas-server/middleware"
+	"saas-server/models"
+	"saas-server/pkg/discounts"
 	"saas-server/pkg/lemonsqueezy"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type CheckoutHandler struct {
-	client *lemonsqueezy.Client
-	db     database.DBInterface
+	client    *lemonsqueezy.Client
+	db        database.DBInterface
+	discounts DiscountValidator
+	expiry    time.Duration
 }
 
+// CheckoutRequest represents the request body for creating a checkout.
+// The buyer is always the authenticated user; their ID and email come from the session.
 type CheckoutRequest struct {
-	ProductID string `json:"productId"`
-	VariantID string `json:"variantId"`
-	Email     string `json:"email"`
-	UserID    string `json:"userId"`
+	ProductID    string `json:"productId,omitempty"` // Optional, checked against the variant's product
+	VariantID    string `json:"variantId"`
+	DiscountCode string `json:"discountCode,omitempty"`
+	SuccessURL   string `json:"successUrl,omitempty"` // Where the provider sends the user after paying
+	CancelURL    string `json:"cancelUrl,omitempty"`  // Returned to the client, the provider has no cancel redirect
 }
 
-func NewCheckoutHandler(db database.DBInterface) *CheckoutHandler {
-	return &CheckoutHandler{client: lemonsqueezy.NewClient(), db: db}
+// CheckoutResponse represents a created checkout
+type CheckoutResponse struct {
+	CheckoutURL string    `json:"checkoutURL"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	CancelURL   string    `json:"cancelURL,omitempty"`
+}
+
+// DiscountValidator checks a discount code before it is applied to a checkout.
+// Implemented by discounts.Service
+type DiscountValidator interface {
+	Validate(code string, variantID int) (*models.Discount, error)
+}
+
+// BillingProfileSource looks up the billing profile used to pre-fill checkouts
+type BillingProfileSource interface {
+	GetBillingProfile(userID string) (*models.BillingProfile, error)
+}
+
+// NewCheckoutHandler creates a new CheckoutHandler. Checkouts expire after
+// CHECKOUT_EXPIRY_MINUTES (default 60) so stale links can't be used later.
+func NewCheckoutHandler(db database.DBInterface, discountValidator DiscountValidator) *CheckoutHandler {
+	expiry := 60 * time.Minute
+	if v := os.Getenv("CHECKOUT_EXPIRY_MINUTES"); v != "" {
+		if minutes, err := strconv.Atoi(v); err == nil && minutes > 0 {
+			expiry = time.Duration(minutes) * time.Minute
+		} else {
+			log.Printf("[Checkout] Ignoring invalid CHECKOUT_EXPIRY_MINUTES %q", v)
+		}
+	}
+
+	return &CheckoutHandler{client: lemonsqueezy.NewClient(), db: db, discounts: discountValidator, expiry: expiry}
 }
 
 // CreateCheckout handles POST /api/checkout
@@ -32,26 +74,30 @@ func (h *CheckoutHandler) CreateCheckout(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	userID := middleware.GetUserID(r.Context())
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	var req CheckoutRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// Check if user already has a subscription
-	subscription, err := h.db.GetSubscriptionByUserID(req.UserID)
-	if err == nil && subscription != nil {
-		// User has an active subscription, get their customer portal URL
-		customer, err := h.client.GetCustomer(strconv.Itoa(subscription.CustomerID))
-		if err != nil {
-			http.Error(w, "Failed to fetch customer portal", http.StatusInternalServerError)
-			return
-		}
+	variantID, err := strconv.Atoi(req.VariantID)
+	if err != nil || variantID <= 0 {
+		http.Error(w, "Invalid variant ID", http.StatusBadRequest)
+		return
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{
-			"portalURL": customer.Data.Attributes.CustomerPortal.CustomerPortal,
-		})
+	successURL, ok := checkoutRedirectURL(w, req.SuccessURL, "/profile")
+	if !ok {
+		return
+	}
+	cancelURL, ok := checkoutRedirectURL(w, req.CancelURL, "")
+	if !ok {
 		return
 	}
 
@@ -64,29 +110,179 @@ func (h *CheckoutHandler) CreateCheckout(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	checkout, err := h.client.CreateCheckout(
-		storeIDStr,
-		req.VariantID,
-		map[string]interface{}{
-			"email": req.Email,
-			"checkout_data": lemonsqueezy.CheckoutData{
-				Custom: map[string]interface{}{
-					"user_id": req.UserID,
-				},
-			},
+	variant, ok := catalogVariant(w, h.client, storeIDStr, req.VariantID, req.ProductID)
+	if !ok {
+		return
+	}
+
+	user, err := h.db.GetUserByID(userID)
+	if err != nil {
+		log.Printf("[Checkout] Error getting user %s: %v", userID, err)
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return
+	}
+
+	// Users can hold several subscriptions to different products (e.g. a base plan and an add-on),
+	// but not two to the same product. Switching tiers goes through change-plan instead, so a user
+	// already subscribed to the product is sent to the customer portal.
+	if variant.IsSubscription {
+		active, err := h.db.GetActiveSubscriptionsByUserID(userID)
+		if err != nil {
+			log.Printf("[Checkout] Error getting subscriptions for user %s: %v", userID, err)
+			http.Error(w, "Failed to check existing subscriptions", http.StatusInternalServerError)
+			return
+		}
+		for _, subscription := range active {
+			if subscription.ProductID != variant.ProductID {
+				continue
+			}
+
+			customer, err := h.client.GetCustomer(strconv.Itoa(subscription.CustomerID))
+			if err != nil {
+				http.Error(w, "Failed to fetch customer portal", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{
+				"portalURL": customer.Data.Attributes.CustomerPortal.CustomerPortal,
+			})
+			return
+		}
+	}
+
+	checkoutData := lemonsqueezy.CheckoutData{
+		Custom: map[string]interface{}{
+			"user_id": userID,
 		},
-	)
+	}
+	prefillBillingDetails(h.db, userID, &checkoutData)
+
+	// Validate the discount code here so users get a clear error instead of a checkout without the discount
+	if code := strings.TrimSpace(req.DiscountCode); code != "" {
+		discount, err := h.discounts.Validate(code, variantID)
+		if discounts.IsValidationError(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("[Checkout] Error validating discount code: %v", err)
+			http.Error(w, "Failed to validate discount code", http.StatusInternalServerError)
+			return
+		}
+
+		checkoutData.DiscountCode = discount.Code
+		// Echoed back in the order webhook so the redemption can be attributed
+		checkoutData.Custom["discount_code"] = discount.Code
+	}
 
+	expiresAt := time.Now().UTC().Add(h.expiry).Truncate(time.Second)
+	options := map[string]interface{}{
+		"email":         user.Email,
+		"checkout_data": checkoutData,
+		"expires_at":    expiresAt.Format(time.RFC3339),
+	}
+	if successURL != "" {
+		options["product_options"] = lemonsqueezy.ProductOptions{RedirectURL: successURL}
+	}
+
+	checkout, err := h.client.CreateCheckout(storeIDStr, req.VariantID, options)
 	if err != nil {
+		log.Printf("[Checkout] Error creating checkout for user %s: %v", userID, err)
 		http.Error(w, "Failed to create checkout", http.StatusInternalServerError)
 		return
 	}
 
-	// Extract checkout URL from the response
-	checkoutURL := checkout.Data.Attributes.URL
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"checkoutURL": checkoutURL,
+	json.NewEncoder(w).Encode(CheckoutResponse{
+		CheckoutURL: checkout.Data.Attributes.URL,
+		ExpiresAt:   expiresAt,
+		CancelURL:   cancelURL,
 	})
 }
+
+// catalogVariant looks up a variant and checks that it can be bought from the store, for
+// checkouts and plan changes. It writes the error response and returns false if it can't.
+func catalogVariant(w http.ResponseWriter, client *lemonsqueezy.Client, storeID string, variantID string, productID string) (*lemonsqueezy.VariantAttributes, bool) {
+	variant, err := client.GetVariant(variantID)
+	if err != nil {
+		log.Printf("[Catalog] Error fetching variant %s: %v", variantID, err)
+		http.Error(w, "Unknown variant", http.StatusBadRequest)
+		return nil, false
+	}
+
+	attrs := variant.Data.Attributes
+	// Single-variant products keep their variant "pending", it is still purchasable
+	if attrs.Status != "published" && attrs.Status != "pending" {
+		http.Error(w, "This plan is not available for purchase", http.StatusBadRequest)
+		return nil, false
+	}
+	if productID != "" && productID != strconv.Itoa(attrs.ProductID) {
+		http.Error(w, "Variant does not belong to the product", http.StatusBadRequest)
+		return nil, false
+	}
+
+	product, err := client.GetProductByID(strconv.Itoa(attrs.ProductID))
+	if err != nil {
+		log.Printf("[Catalog] Error fetching product %d: %v", attrs.ProductID, err)
+		http.Error(w, "Failed to fetch product", http.StatusInternalServerError)
+		return nil, false
+	}
+	if strconv.Itoa(product.Data.Attributes.StoreID) != storeID || product.Data.Attributes.Status != "published" {
+		http.Error(w, "This plan is not available for purchase", http.StatusBadRequest)
+		return nil, false
+	}
+
+	return &attrs, true
+}
+
+// prefillBillingDetails fills the checkout's name, billing address and tax number from the
+// user's billing profile. The country and tax number are also passed as custom data so the
+// order webhook can store them with the order's tax breakdown.
+func prefillBillingDetails(db BillingProfileSource, userID string, checkoutData *lemonsqueezy.CheckoutData) {
+	profile, err := db.GetBillingProfile(userID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("[Checkout] Error getting billing profile for user %s: %v", userID, err)
+		}
+		return
+	}
+
+	checkoutData.Name = profile.Name
+	if profile.Country != "" || profile.PostalCode != "" {
+		checkoutData.BillingAddress = &lemonsqueezy.BillingAddress{
+			Country: profile.Country,
+			Zip:     profile.PostalCode,
+		}
+	}
+	checkoutData.TaxNumber = profile.TaxNumber
+
+	if profile.Country != "" {
+		checkoutData.Custom["billing_country"] = profile.Country
+	}
+	if profile.TaxNumber != "" {
+		checkoutData.Custom["tax_number"] = profile.TaxNumber
+	}
+}
+
+// checkoutRedirectURL validates a redirect URL from the client. Only URLs on the frontend's
+// origin are accepted so checkouts can't be used as an open redirect. An empty value falls back
+// to defaultPath on the frontend, or to no URL when defaultPath is empty.
+func checkoutRedirectURL(w http.ResponseWriter, raw string, defaultPath string) (string, bool) {
+	frontendURL := strings.TrimRight(os.Getenv("FRONTEND_URL"), "/")
+	if raw == "" {
+		if defaultPath == "" || frontendURL == "" {
+			return "", true
+		}
+		return frontendURL + defaultPath, true
+	}
+
+	frontend, err := url.Parse(frontendURL)
+	target, err2 := url.Parse(raw)
+	if err != nil || err2 != nil || frontendURL == "" ||
+		target.Scheme != frontend.Scheme || target.Host != frontend.Host {
+		http.Error(w, "Redirect URLs must point to the application", http.StatusBadRequest)
+		return "", false
+	}
+	return target.String(), true
+}
@@ -2,17 +2,19 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
 	"saas-server/pkg/email"
 	"saas-server/pkg/validation"
 )
 
 // ContactHandler handles requests related to contact form submissions
-type ContactHandler struct{}
+type ContactHandler struct {
+	outbox *email.Outbox
+}
 
 // ContactFormRequest represents the data submitted from the contact form
 type ContactFormRequest struct {
@@ -23,8 +25,8 @@ type ContactFormRequest struct {
 }
 
 // NewContactHandler creates a new instance of ContactHandler
-func NewContactHandler() *ContactHandler {
-	return &ContactHandler{}
+func NewContactHandler(outbox *email.Outbox) *ContactHandler {
+	return &ContactHandler{outbox: outbox}
 }
 
 // SendContactEmail handles the contact form submission and sends an email to the admin
@@ -65,8 +67,8 @@ func (h *ContactHandler) SendContactEmail(w http.ResponseWriter, r *http.Request
 		req.Subject = "Contact Form Submission"
 	}
 
-	// Sanitize message for XSS protection
-	req.Message = validation.SanitizeHTML(req.Message)
+	// The message is escaped by the email template, so only surrounding whitespace is removed
+	req.Message = strings.TrimSpace(req.Message)
 	if req.Message == "" {
 		http.Error(w, "Message is required", http.StatusBadRequest)
 		return
@@ -81,18 +83,20 @@ func (h *ContactHandler) SendContactEmail(w http.ResponseWriter, r *http.Request
 	}
 
 	// Prepare email content
-	subject := fmt.Sprintf("Contact Form: %s", req.Subject)
-
-	emailContent := fmt.Sprintf(`
-<h1>New Contact Form Submission</h1>
-<p><strong>From:</strong> %s (%s)</p>
-<p><strong>Subject:</strong> %s</p>
-<p><strong>Message:</strong></p>
-<p>%s</p>
-`, req.Name, req.Email, req.Subject, req.Message)
+	msg, err := email.ContactFormEmail(adminEmail, email.ContactFormData{
+		Name:    req.Name,
+		Email:   req.Email,
+		Subject: req.Subject,
+		Message: req.Message,
+	})
+	if err != nil {
+		log.Printf("[ContactHandler] Error rendering contact email: %v", err)
+		http.Error(w, "Error sending email", http.StatusInternalServerError)
+		return
+	}
 
-	// Send email using our email utility
-	if err := email.SendEmail(adminEmail, subject, emailContent); err != nil {
+	// Queue the email for delivery
+	if err := h.outbox.Enqueue(msg, ""); err != nil {
 		log.Printf("[ContactHandler] Error sending contact email: %v", err)
 		http.Error(w, "Error sending email", http.StatusInternalServerError)
 		return
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"saas-server/database"
+	"saas-server/middleware"
+	"saas-server/models"
+	"saas-server/pkg/credits"
+	"saas-server/pkg/validation"
+
+	"github.com/google/uuid"
+)
+
+// CreditsHandler serves the credit balance, its history and the debit API
+type CreditsHandler struct {
+	credits *credits.Service
+}
+
+// NewCreditsHandler creates a new CreditsHandler
+func NewCreditsHandler(creditService *credits.Service) *CreditsHandler {
+	return &CreditsHandler{credits: creditService}
+}
+
+// CreditsResponse represents the user's credit balance and the top-ups they can buy
+type CreditsResponse struct {
+	models.CreditBalance
+	TopUps []credits.TopUp `json:"top_ups"`
+}
+
+// CreditTransactionsResponse represents a page of the user's credit history
+type CreditTransactionsResponse struct {
+	Transactions []models.CreditTransaction `json:"transactions"`
+	Total        int                        `json:"total"`
+	Page         int                        `json:"page"`
+	Limit        int                        `json:"limit"`
+}
+
+// Balance handles GET /api/user/credits
+// Top-ups are bought by checking out one of the listed variants.
+func (h *CreditsHandler) Balance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+	if userID == "" {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return
+	}
+
+	balance, err := h.credits.Balance(userID)
+	if err != nil {
+		log.Printf("[Credits] Error loading balance of user %s: %v", userID, err)
+		http.Error(w, "Failed to fetch credits", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, CreditsResponse{
+		CreditBalance: *balance,
+		TopUps:        h.credits.TopUps(),
+	})
+}
+
+// Transactions handles GET /api/user/credits/transactions
+func (h *CreditsHandler) Transactions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+	if userID == "" {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return
+	}
+
+	page, limit := parsePagination(r)
+	transactions, total, err := h.credits.Transactions(userID, page, limit)
+	if err != nil {
+		log.Printf("[Credits] Error loading transactions of user %s: %v", userID, err)
+		http.Error(w, "Failed to fetch credit history", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, CreditTransactionsResponse{
+		Transactions: transactions,
+		Total:        total,
+		Page:         page,
+		Limit:        limit,
+	})
+}
+
+// Debit handles POST /internal/credits/debit
+// Debits are de-duplicated by idempotency key; replaying a key returns the original transaction.
+// It responds 402 when the balance is too low and 409 when expected_version is stale.
+func (h *CreditsHandler) Debit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.CreditDebitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.IdempotencyKey = validation.SanitizeInput(req.IdempotencyKey, 200)
+	if req.IdempotencyKey == "" {
+		http.Error(w, "Idempotency key is required", http.StatusBadRequest)
+		return
+	}
+	if _, err := uuid.Parse(req.UserID); err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+	req.Description = validation.SanitizeInput(req.Description, 255)
+
+	transaction, created, err := h.credits.Debit(req)
+	switch {
+	case errors.Is(err, credits.ErrInvalidAmount):
+		http.Error(w, "Amount must be positive", http.StatusBadRequest)
+		return
+	case errors.Is(err, database.ErrInsufficientCredits):
+		http.Error(w, "Insufficient credits", http.StatusPaymentRequired)
+		return
+	case errors.Is(err, database.ErrCreditVersionConflict):
+		http.Error(w, "Credit balance changed", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("[Credits] Error debiting user %s: %v", req.UserID, err)
+		http.Error(w, "Failed to debit credits", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusCreated
+	if !created {
+		status = http.StatusOK
+	}
+	sendJSONResponse(w, status, transaction)
+}
+
+// Grant handles POST /admin/credits/grant
+func (h *CreditsHandler) Grant(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.CreditGrantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := uuid.Parse(req.UserID); err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "Expiry must be in the future", http.StatusBadRequest)
+		return
+	}
+	req.Description = validation.SanitizeInput(req.Description, 255)
+	if req.Description == "" {
+		req.Description = "Granted by an admin"
+	}
+
+	transaction, err := h.credits.Grant(req.UserID, req.Amount, req.Description, req.ExpiresAt, "")
+	if errors.Is(err, credits.ErrInvalidAmount) {
+		http.Error(w, "Amount must be positive", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[Credits] Error granting credits to user %s: %v", req.UserID, err)
+		http.Error(w, "Failed to grant credits", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("[Credits] Granted %d credits to user %s", req.Amount, req.UserID)
+	sendJSONResponse(w, http.StatusCreated, transaction)
+}
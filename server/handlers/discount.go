@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/discounts"
+	"saas-server/pkg/lemonsqueezy"
+)
+
+// DiscountHandler serves the admin endpoints for managing discount codes
+type DiscountHandler struct {
+	discounts *discounts.Service
+}
+
+// NewDiscountHandler creates a new DiscountHandler
+func NewDiscountHandler(discountService *discounts.Service) *DiscountHandler {
+	return &DiscountHandler{discounts: discountService}
+}
+
+// CreateDiscountRequest represents the request body for creating a discount.
+// Amount is a percentage for "percent" discounts and minor currency units for "fixed" ones.
+type CreateDiscountRequest struct {
+	Name             string     `json:"name"`
+	Code             string     `json:"code"`
+	Amount           int        `json:"amount"`
+	AmountType       string     `json:"amount_type"`
+	Duration         string     `json:"duration,omitempty"`           // once (default), repeating or forever
+	DurationInMonths int        `json:"duration_in_months,omitempty"` // Required for repeating discounts
+	MaxRedemptions   int        `json:"max_redemptions,omitempty"`    // 0 means unlimited
+	StartsAt         *time.Time `json:"starts_at,omitempty"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	VariantIDs       []int      `json:"variant_ids,omitempty"` // Empty applies to every plan
+}
+
+// DisableDiscountRequest represents the request body for disabling a discount
+type DisableDiscountRequest struct {
+	ID int `json:"id"`
+}
+
+// DiscountsResponse represents the list of discounts with their redemption stats
+type DiscountsResponse struct {
+	Discounts []models.DiscountWithStats `json:"discounts"`
+}
+
+// Discounts handles GET /admin/discounts to list discounts and POST /admin/discounts to create one
+func (h *DiscountHandler) Discounts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list, err := h.discounts.List()
+		if err != nil {
+			log.Printf("[Discounts] Error listing discounts: %v", err)
+			http.Error(w, "Failed to fetch discounts", http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []models.DiscountWithStats{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(DiscountsResponse{Discounts: list})
+
+	case http.MethodPost:
+		var req CreateDiscountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		discount, err := h.discounts.Create(lemonsqueezy.DiscountInput{
+			Name:             strings.TrimSpace(req.Name),
+			Code:             req.Code,
+			Amount:           req.Amount,
+			AmountType:       req.AmountType,
+			Duration:         req.Duration,
+			DurationInMonths: req.DurationInMonths,
+			MaxRedemptions:   req.MaxRedemptions,
+			StartsAt:         req.StartsAt,
+			ExpiresAt:        req.ExpiresAt,
+			VariantIDs:       req.VariantIDs,
+		})
+		if errors.Is(err, discounts.ErrInvalidDiscount) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("[Discounts] Error creating discount: %v", err)
+			http.Error(w, "Failed to create discount", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("[Discounts] Created discount %d with code %s", discount.ID, discount.Code)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(discount)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Disable handles POST /admin/discounts/disable
+// The code stops working at checkout; past redemptions stay in the stats.
+func (h *DiscountHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req DisableDiscountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := h.discounts.Disable(req.ID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Active discount not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[Discounts] Error disabling discount %d: %v", req.ID, err)
+		http.Error(w, "Failed to disable discount", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("[Discounts] Disabled discount %d", req.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Discount disabled",
+	})
+}
@@ -4,18 +4,12 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"sort"
 
 	"saas-server/pkg/email"
 	"saas-server/pkg/validation"
 )
 
-// PlunkEmailRequest represents the request format for Plunk API
-type PlunkEmailRequest struct {
-	To      string `json:"to"`
-	Subject string `json:"subject"`
-	HTML    string `json:"html"`
-}
-
 // AdminEmailRequest represents the request structure for admin to send an email
 type AdminEmailRequest struct {
 	To      string `json:"to"`
@@ -57,8 +51,8 @@ func (h *Handler) AdminSendEmailHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Send the email using our centralized email utility
-	if err := email.SendEmail(req.To, req.Subject, req.Body); err != nil {
+	// Queue the email for delivery
+	if err := h.Outbox.Enqueue(email.Message{To: req.To, Subject: req.Subject, HTML: req.Body}, ""); err != nil {
 		log.Printf("[AdminEmail] Failed to send email: %v", err)
 		http.Error(w, "Failed to send email: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -69,3 +63,68 @@ func (h *Handler) AdminSendEmailHandler(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "Email sent successfully"})
 }
+
+// EmailTemplate describes an email template and the locales it is available in
+type EmailTemplate struct {
+	Name    string   `json:"name"`
+	Locales []string `json:"locales"`
+}
+
+// ListEmailTemplates lists the email templates for the admin preview (GET /admin/emails/templates)
+func (h *Handler) ListEmailTemplates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	templates := []EmailTemplate{}
+	for name, locales := range email.TemplateLocales() {
+		templates = append(templates, EmailTemplate{Name: name, Locales: locales})
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"templates":      templates,
+		"default_locale": email.DefaultLocale,
+	})
+}
+
+// PreviewEmailTemplate renders an email template with sample data
+// (GET /admin/emails/preview?name=...&locale=...&format=html|text).
+// Without a format it returns the subject and both parts as JSON.
+func (h *Handler) PreviewEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	locale := query.Get("locale")
+	if locale == "" {
+		locale = email.DefaultLocale
+	}
+
+	msg, err := email.Preview(query.Get("name"), locale)
+	if err != nil {
+		log.Printf("[AdminEmail] Failed to render preview: %v", err)
+		http.Error(w, "Email template not found", http.StatusNotFound)
+		return
+	}
+
+	switch query.Get("format") {
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(msg.HTML))
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(msg.Text))
+	case "":
+		sendJSONResponse(w, http.StatusOK, map[string]string{
+			"subject": msg.Subject,
+			"html":    msg.HTML,
+			"text":    msg.Text,
+		})
+	default:
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+	}
+}
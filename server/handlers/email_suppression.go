@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"saas-server/database"
+	"saas-server/models"
+	"saas-server/pkg/ses"
+	"saas-server/pkg/validation"
+)
+
+// EmailSuppressionHandler receives bounce and complaint notifications from Amazon SES and
+// lets admins manage the resulting suppression list
+type EmailSuppressionHandler struct {
+	db       database.DBInterface
+	receiver *ses.Receiver
+}
+
+// NewEmailSuppressionHandler creates a new EmailSuppressionHandler. Only notifications of the
+// SNS topics the receiver accepts are processed.
+func NewEmailSuppressionHandler(db database.DBInterface, receiver *ses.Receiver) *EmailSuppressionHandler {
+	return &EmailSuppressionHandler{db: db, receiver: receiver}
+}
+
+// LiftSuppressionRequest represents the request body for lifting a suppression
+type LiftSuppressionRequest struct {
+	Email string `json:"email"`
+}
+
+// EmailSuppressionsResponse represents a page of the suppression list
+type EmailSuppressionsResponse struct {
+	Suppressions []models.EmailSuppression `json:"suppressions"`
+	Total        int                       `json:"total"`
+	Page         int                       `json:"page"`
+	Limit        int                       `json:"limit"`
+}
+
+// HandleEvents handles POST /webhooks/email
+// It is the HTTPS endpoint of an SNS subscription to the topics SES publishes bounce and
+// complaint notifications of the sending domain to. Messages are verified against the
+// certificate AWS signed them with, and the subscription is confirmed automatically.
+// Permanent bounces and complaints add the recipient to the suppression list.
+func (h *EmailSuppressionHandler) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.receiver.Configured() {
+		log.Printf("[EmailEvents] EMAIL_EVENTS_TOPIC_ARNS not configured")
+		http.Error(w, "Email events are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		log.Printf("[EmailEvents] Error reading request body: %v", err)
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	events, err := h.receiver.Receive(body)
+	switch {
+	case errors.Is(err, ses.ErrInvalidSignature), errors.Is(err, ses.ErrUnknownTopic):
+		log.Printf("[EmailEvents] Rejected notification: %v", err)
+		http.Error(w, "Invalid signature", http.StatusForbidden)
+		return
+	case errors.Is(err, ses.ErrInvalidMessage):
+		log.Printf("[EmailEvents] Failed to parse notification: %v", err)
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	case err != nil:
+		// e.g. the signing certificate couldn't be fetched; SNS retries the delivery
+		log.Printf("[EmailEvents] Error processing notification: %v", err)
+		http.Error(w, "Failed to process events", http.StatusInternalServerError)
+		return
+	}
+
+	suppressed := 0
+	for _, event := range events {
+		reason := suppressionReason(event)
+		if reason == "" {
+			continue
+		}
+		if !validation.ValidateEmail(event.Email) {
+			log.Printf("[EmailEvents] Ignoring %s event with invalid address %q", event.Type, event.Email)
+			continue
+		}
+
+		_, created, err := h.db.SuppressEmail(
+			event.Email,
+			reason,
+			validation.SanitizeInput(event.Description, 1000),
+			validation.SanitizeInput(event.MessageID, 255),
+		)
+		if err != nil {
+			// Fail the request so SNS retries the notification; suppressing is idempotent
+			log.Printf("[EmailEvents] Error suppressing %s: %v", event.Email, err)
+			http.Error(w, "Failed to process events", http.StatusInternalServerError)
+			return
+		}
+		if created {
+			log.Printf("[EmailEvents] Suppressed %s after %s", event.Email, reason)
+		}
+		suppressed++
+	}
+
+	sendJSONResponse(w, http.StatusOK, map[string]int{"suppressed": suppressed})
+}
+
+// suppressionReason returns the suppression reason for an event, or an empty string if
+// the event doesn't suppress the address
+func suppressionReason(event ses.Event) string {
+	switch event.Type {
+	case "complaint":
+		return models.SuppressionComplaint
+	case "bounce":
+		// Transient bounces (full mailbox, greylisting) and undetermined ones may not recur,
+		// so the address stays usable
+		if event.BounceType != "Permanent" {
+			return ""
+		}
+		return models.SuppressionBounce
+	default:
+		return ""
+	}
+}
+
+// Suppressions handles GET /admin/emails/suppressions
+// Lifted suppressions are included with ?include_lifted=true.
+func (h *EmailSuppressionHandler) Suppressions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	page, limit := parsePagination(r)
+	includeLifted, _ := strconv.ParseBool(r.URL.Query().Get("include_lifted"))
+
+	suppressions, total, err := h.db.GetEmailSuppressions(page, limit, includeLifted)
+	if err != nil {
+		log.Printf("[EmailSuppressions] Error listing suppressions: %v", err)
+		http.Error(w, "Failed to fetch suppressions", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, EmailSuppressionsResponse{
+		Suppressions: suppressions,
+		Total:        total,
+		Page:         page,
+		Limit:        limit,
+	})
+}
+
+// LiftSuppression handles POST /admin/emails/suppressions/lift
+// The address is emailed again, e.g. after the user fixed their mailbox.
+func (h *EmailSuppressionHandler) LiftSuppression(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LiftSuppressionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	suppression, err := h.db.LiftEmailSuppression(req.Email)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Address is not suppressed", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[EmailSuppressions] Error lifting suppression of %s: %v", req.Email, err)
+		http.Error(w, "Failed to lift suppression", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("[EmailSuppressions] Lifted suppression of %s", suppression.Email)
+	sendJSONResponse(w, http.StatusOK, suppression)
+}
@@ -62,14 +62,6 @@ func (h *AuthHandler) SendVerificationEmail(w http.ResponseWriter, r *http.Reque
 	token := uuid.New().String()
 	expiresAt := time.Now().Add(24 * time.Hour)
 
-	// Store verification token in database
-	err = h.db.StoreEmailVerificationToken(token, userID, user.Email, expiresAt)
-	if err != nil {
-		log.Printf("Error storing verification token: %v", err)
-		http.Error(w, "Error generating verification token", http.StatusInternalServerError)
-		return
-	}
-
 	// Generate verification link
 	clientURL := os.Getenv("FRONTEND_URL")
 	if clientURL == "" {
@@ -77,14 +69,27 @@ func (h *AuthHandler) SendVerificationEmail(w http.ResponseWriter, r *http.Reque
 	}
 	verificationLink := fmt.Sprintf("%s/auth/verify-email?token=%s", clientURL, token)
 
-	// Send verification email using our email utility
-	err = email.SendVerificationEmail(user.Email, verificationLink)
+	msg, err := email.VerificationEmail(user.Email, user.Language, verificationLink)
+	if err != nil {
+		log.Printf("Error rendering verification email: %v", err)
+		http.Error(w, "Error sending verification email", http.StatusInternalServerError)
+		return
+	}
+	notification, err := email.NewOutbound(msg, "verify-email:"+token)
 	if err != nil {
-		log.Printf("Error sending verification email: %v", err)
+		log.Printf("Error preparing verification email: %v", err)
 		http.Error(w, "Error sending verification email", http.StatusInternalServerError)
 		return
 	}
 
+	// Store verification token in database and queue the email with it
+	err = h.db.StoreEmailVerificationToken(token, userID, user.Email, expiresAt, notification)
+	if err != nil {
+		log.Printf("Error storing verification token: %v", err)
+		http.Error(w, "Error generating verification token", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "Verification email sent successfully",
@@ -1,12 +1,37 @@
 // Package handlers provides HTTP request handlers for the SaaS platform's API endpoints.
 package handlers
 
-import "saas-server/database"
+import (
+	"net/http"
+	"saas-server/database"
+	"saas-server/pkg/email"
+	"strconv"
+)
 
 // Handler is a base handler struct that contains common dependencies
 // for all handler types. It provides access to the database connection
 // and other shared resources that may be needed across different handlers.
 type Handler struct {
 	*WebhookHandler
-	DB database.DBInterface
+	DB     database.DBInterface
+	Outbox *email.Outbox
+}
+
+// parsePagination reads the page and limit query parameters.
+// page defaults to 1 and limit to 20, capped at 100.
+func parsePagination(r *http.Request) (int, int) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 {
+		limit = 20 // Default limit
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	return page, limit
 }
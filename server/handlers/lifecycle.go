@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"saas-server/models"
+	"saas-server/pkg/email"
+	"saas-server/pkg/lifecycle"
+)
+
+// LifecycleHandler serves the admin endpoints for managing lifecycle email rules
+type LifecycleHandler struct {
+	lifecycle *lifecycle.Service
+}
+
+// NewLifecycleHandler creates a new LifecycleHandler
+func NewLifecycleHandler(lifecycleService *lifecycle.Service) *LifecycleHandler {
+	return &LifecycleHandler{lifecycle: lifecycleService}
+}
+
+// LifecycleRuleRequest represents the request body for creating or updating a rule
+type LifecycleRuleRequest struct {
+	Name           string   `json:"name"`
+	Event          string   `json:"event"`
+	DelayMinutes   int      `json:"delay_minutes"`
+	Template       string   `json:"template"`
+	ExitConditions []string `json:"exit_conditions"`
+	Enabled        bool     `json:"enabled"`
+}
+
+// LifecycleRulesResponse represents the list of lifecycle rules
+type LifecycleRulesResponse struct {
+	Rules []models.LifecycleRule `json:"rules"`
+}
+
+// LifecycleOptionsResponse lists the values rules can be built from
+type LifecycleOptionsResponse struct {
+	Events         []string `json:"events"`
+	ExitConditions []string `json:"exit_conditions"`
+	Templates      []string `json:"templates"`
+}
+
+// Rules handles GET /admin/lifecycle to list the rules and POST /admin/lifecycle to
+// create one
+func (h *LifecycleHandler) Rules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := h.lifecycle.List()
+		if err != nil {
+			log.Printf("[Lifecycle] Error listing rules: %v", err)
+			http.Error(w, "Failed to fetch lifecycle rules", http.StatusInternalServerError)
+			return
+		}
+		sendJSONResponse(w, http.StatusOK, LifecycleRulesResponse{Rules: rules})
+
+	case http.MethodPost:
+		var req LifecycleRuleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		rule := req.rule(0)
+		if err := h.lifecycle.Create(rule); err != nil {
+			writeLifecycleError(w, "creating", 0, err)
+			return
+		}
+
+		log.Printf("[Lifecycle] Created rule %d", rule.ID)
+		sendJSONResponse(w, http.StatusCreated, rule)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Rule handles GET, PUT and DELETE /admin/lifecycle/detail?id=
+func (h *LifecycleHandler) Rule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rule, err := h.lifecycle.Get(id)
+		if err != nil {
+			writeLifecycleError(w, "fetching", id, err)
+			return
+		}
+		sendJSONResponse(w, http.StatusOK, rule)
+
+	case http.MethodPut:
+		var req LifecycleRuleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		rule, err := h.lifecycle.Update(req.rule(id))
+		if err != nil {
+			writeLifecycleError(w, "updating", id, err)
+			return
+		}
+		sendJSONResponse(w, http.StatusOK, rule)
+
+	case http.MethodDelete:
+		if err := h.lifecycle.Delete(id); err != nil {
+			writeLifecycleError(w, "deleting", id, err)
+			return
+		}
+		log.Printf("[Lifecycle] Deleted rule %d", id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Options handles GET /admin/lifecycle/options
+// It lists the events, exit conditions and templates rules can use.
+func (h *LifecycleHandler) Options(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, LifecycleOptionsResponse{
+		Events:         lifecycle.Events,
+		ExitConditions: lifecycle.ExitConditions,
+		Templates:      email.LifecycleTemplates(),
+	})
+}
+
+// rule converts the request to a rule with the given ID
+func (req LifecycleRuleRequest) rule(id int) *models.LifecycleRule {
+	return &models.LifecycleRule{
+		ID:             id,
+		Name:           req.Name,
+		Event:          req.Event,
+		DelayMinutes:   req.DelayMinutes,
+		Template:       req.Template,
+		ExitConditions: req.ExitConditions,
+		Enabled:        req.Enabled,
+	}
+}
+
+// writeLifecycleError maps lifecycle service errors to responses
+func writeLifecycleError(w http.ResponseWriter, action string, id int, err error) {
+	switch {
+	case errors.Is(err, lifecycle.ErrNotFound):
+		http.Error(w, "Lifecycle rule not found", http.StatusNotFound)
+	case errors.Is(err, lifecycle.ErrInvalidRule):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("[Lifecycle] Error %s rule %d: %v", action, id, err)
+		http.Error(w, "Failed to process lifecycle rule", http.StatusInternalServerError)
+	}
+}
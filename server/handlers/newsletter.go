@@ -1,30 +1,40 @@
 package handlers
 
 import (
-	"bytes"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 
 	"saas-server/database"
 	"saas-server/models"
+	"saas-server/pkg/email"
+	"saas-server/pkg/newsletter"
+	"saas-server/pkg/signedlink"
 	"saas-server/pkg/validation"
 )
 
 // NewsletterHandler handles newsletter subscription requests
 type NewsletterHandler struct {
-	DB *database.DB
+	DB         *database.DB
+	newsletter *newsletter.Service
 }
 
 // NewNewsletterHandler creates a new newsletter handler
-func NewNewsletterHandler(db *database.DB) *NewsletterHandler {
-	return &NewsletterHandler{DB: db}
+func NewNewsletterHandler(db *database.DB, newsletterService *newsletter.Service) *NewsletterHandler {
+	return &NewsletterHandler{DB: db, newsletter: newsletterService}
 }
 
-// Subscribe handles newsletter subscription requests
+// NewsletterConfirmRequest represents the request body for confirming a subscription
+type NewsletterConfirmRequest struct {
+	Token string `json:"token"`
+}
+
+// Subscribe handles newsletter subscription requests (POST /api/newsletter/subscribe)
+// The address is only subscribed once it is confirmed through the emailed link.
 func (h *NewsletterHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
 	// Only allow POST requests
 	if r.Method != http.MethodPost {
@@ -49,54 +59,91 @@ func (h *NewsletterHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Trim and lowercase email (not needed as sanitization already does this, but keeping for explicitness)
-	email := strings.ToLower(req.Email)
+	subscriberEmail := strings.ToLower(req.Email)
 
-	// Check if email already exists
-	exists, err := h.DB.NewsletterEmailExists(email)
-	if err != nil {
-		log.Printf("[NewsletterHandler] Error checking for existing email: %v", err)
-		http.Error(w, "Failed to process request", http.StatusInternalServerError)
+	_, ip := getDeviceInfo(r)
+	locale := email.LocaleFromAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err := h.newsletter.Subscribe(subscriberEmail, ip, locale); err != nil {
+		log.Printf("[NewsletterHandler] Error subscribing %s: %v", subscriberEmail, err)
+		http.Error(w, "Failed to subscribe to newsletter", http.StatusInternalServerError)
 		return
 	}
 
-	if exists {
-		// If email exists, update the subscription status to true
-		err := h.DB.UpdateNewsletterSubscription(email, true)
-		if err != nil {
-			log.Printf("[NewsletterHandler] Error updating subscription: %v", err)
-			// Don't return an error to the client, just log it
-		}
+	// Same response whether or not the address is already subscribed, so it doesn't leak subscribers
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Thanks! Please check your inbox to confirm your subscription.",
+	})
+}
 
-		// Return success (don't let users know they've already subscribed)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{
-			"message": "Thank you for subscribing to our newsletter!",
-		})
+// Confirm handles POST /api/newsletter/confirm
+// It is called by the frontend page the confirmation email links to and records the consent.
+func (h *NewsletterHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Insert new newsletter subscription
-	err = h.DB.CreateNewsletterSubscription(email)
-	if err != nil {
-		log.Printf("[NewsletterHandler] Error creating subscription: %v", err)
-		http.Error(w, "Failed to subscribe to newsletter", http.StatusInternalServerError)
+	var req NewsletterConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// Track subscription with Plunk
-	if err := trackNewsletterSubscription(email); err != nil {
-		log.Printf("[NewsletterHandler] Error tracking subscription: %v", err)
-		// Continue even if tracking fails
+	_, ip := getDeviceInfo(r)
+	subscriberEmail, err := h.newsletter.Confirm(req.Token, ip)
+	if err != nil {
+		h.writeLinkError(w, err)
+		return
 	}
 
-	// Return success
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Thank you for subscribing to our newsletter!",
+	sendJSONResponse(w, http.StatusOK, map[string]string{
+		"message": "Your subscription is confirmed.",
+		"email":   subscriberEmail,
 	})
 }
 
+// Unsubscribe handles /api/newsletter/unsubscribe?token=...
+// POST unsubscribes right away, which is what mail clients send for RFC 8058 one-click
+// unsubscribes and what the frontend unsubscribe page calls. GET, e.g. from the link in a
+// newsletter, redirects to that page, so link scanners can't unsubscribe anyone.
+func (h *NewsletterHandler) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	switch r.Method {
+	case http.MethodGet:
+		target := strings.TrimRight(os.Getenv("FRONTEND_URL"), "/") + "/newsletter/unsubscribe?token=" + url.QueryEscape(token)
+		http.Redirect(w, r, target, http.StatusFound)
+
+	case http.MethodPost:
+		subscriberEmail, err := h.newsletter.Unsubscribe(token)
+		if err != nil {
+			h.writeLinkError(w, err)
+			return
+		}
+		sendJSONResponse(w, http.StatusOK, map[string]string{
+			"message": "You have been unsubscribed.",
+			"email":   subscriberEmail,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeLinkError responds to a failed confirmation or unsubscribe link
+func (h *NewsletterHandler) writeLinkError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, signedlink.ErrExpired):
+		http.Error(w, "This link has expired", http.StatusGone)
+	case errors.Is(err, signedlink.ErrInvalid), errors.Is(err, newsletter.ErrConfirmationUsed):
+		http.Error(w, "This link is invalid or was already used", http.StatusBadRequest)
+	default:
+		log.Printf("[NewsletterHandler] Error processing link: %v", err)
+		http.Error(w, "Failed to process request", http.StatusInternalServerError)
+	}
+}
+
 // GetAllNewsletterSubscriptions returns all newsletter subscriptions
 // This is an admin-only function
 func (h *NewsletterHandler) GetAllNewsletterSubscriptions(w http.ResponseWriter, r *http.Request) {
@@ -115,49 +162,3 @@ func (h *NewsletterHandler) GetAllNewsletterSubscriptions(w http.ResponseWriter,
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(subscriptions)
 }
-
-// trackNewsletterSubscription tracks a newsletter subscription with analytics
-func trackNewsletterSubscription(email string) error {
-	plunkAPIKey := os.Getenv("PLUNK_SECRET_API_KEY")
-	if plunkAPIKey == "" {
-		return fmt.Errorf("PLUNK_SECRET_API_KEY not set")
-	}
-
-	type TrackRequest struct {
-		Event      string `json:"event"`
-		Email      string `json:"email"`
-		Subscribed bool   `json:"subscribed"`
-	}
-
-	trackReq := TrackRequest{
-		Event:      "newsletter-subscription",
-		Email:      email,
-		Subscribed: true,
-	}
-
-	jsonData, err := json.Marshal(trackReq)
-	if err != nil {
-		return fmt.Errorf("error marshaling track request: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", "https://api.useplunk.com/v1/track", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("error creating request: %v", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+plunkAPIKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("error tracking subscription: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error response from Plunk API: %d", resp.StatusCode)
-	}
-
-	return nil
-}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"saas-server/middleware"
+	"saas-server/models"
+	"saas-server/pkg/notifications"
+	"saas-server/pkg/signedlink"
+)
+
+// streamHeartbeat is how often an idle notification stream sends a comment, so proxies
+// keep the connection open
+const streamHeartbeat = 25 * time.Second
+
+// NotificationHandler serves the notification preferences and in-app notifications of the
+// current user and the unsubscribe links in emails
+type NotificationHandler struct {
+	notifications *notifications.Service
+}
+
+// NewNotificationHandler creates a new NotificationHandler
+func NewNotificationHandler(notificationService *notifications.Service) *NotificationHandler {
+	return &NotificationHandler{notifications: notificationService}
+}
+
+// NotificationPreferencesRequest represents the request body for updating preferences.
+// Categories that are left out keep their current preferences.
+type NotificationPreferencesRequest struct {
+	Preferences []models.NotificationPreference `json:"preferences"`
+}
+
+// NotificationPreferencesResponse represents the preferences of every category
+type NotificationPreferencesResponse struct {
+	Preferences []models.NotificationPreference `json:"preferences"`
+}
+
+// NotificationsResponse represents a page of the current user's notifications
+type NotificationsResponse struct {
+	Notifications []models.Notification `json:"notifications"`
+	Unread        int                   `json:"unread"`
+	Total         int                   `json:"total"`
+	Page          int                   `json:"page"`
+	Limit         int                   `json:"limit"`
+}
+
+// MarkReadRequest represents the request body for marking a notification as read
+type MarkReadRequest struct {
+	ID int64 `json:"id"`
+}
+
+// Preferences handles GET /user/notifications to fetch the current user's preferences and
+// PUT /user/notifications to change them
+func (h *NotificationHandler) Preferences(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		preferences, err := h.notifications.Preferences(userID)
+		if err != nil {
+			log.Printf("[Notifications] Error fetching preferences of user %s: %v", userID, err)
+			http.Error(w, "Failed to fetch notification preferences", http.StatusInternalServerError)
+			return
+		}
+		sendJSONResponse(w, http.StatusOK, NotificationPreferencesResponse{Preferences: preferences})
+
+	case http.MethodPut:
+		var req NotificationPreferencesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		preferences, err := h.notifications.UpdatePreferences(userID, req.Preferences)
+		if errors.Is(err, notifications.ErrInvalidPreference) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("[Notifications] Error updating preferences of user %s: %v", userID, err)
+			http.Error(w, "Failed to update notification preferences", http.StatusInternalServerError)
+			return
+		}
+		sendJSONResponse(w, http.StatusOK, NotificationPreferencesResponse{Preferences: preferences})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Unsubscribe handles /api/notifications/unsubscribe?token=...
+// POST turns the email's category off right away, which is what mail clients send for
+// RFC 8058 one-click unsubscribes and what the frontend unsubscribe page calls. GET, e.g.
+// from the link in an email footer, redirects to that page, so link scanners can't
+// unsubscribe anyone.
+func (h *NotificationHandler) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	switch r.Method {
+	case http.MethodGet:
+		target := strings.TrimRight(os.Getenv("FRONTEND_URL"), "/") + "/notifications/unsubscribe?token=" + url.QueryEscape(token)
+		http.Redirect(w, r, target, http.StatusFound)
+
+	case http.MethodPost:
+		address, category, err := h.notifications.Unsubscribe(token)
+		switch {
+		case errors.Is(err, signedlink.ErrExpired), errors.Is(err, signedlink.ErrInvalid):
+			http.Error(w, "This link is invalid", http.StatusBadRequest)
+			return
+		case err != nil:
+			log.Printf("[Notifications] Error processing unsubscribe link: %v", err)
+			http.Error(w, "Failed to process request", http.StatusInternalServerError)
+			return
+		}
+		sendJSONResponse(w, http.StatusOK, map[string]string{
+			"message":  "You have been unsubscribed.",
+			"email":    address,
+			"category": category,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Inbox handles GET /user/notifications/inbox?unread=true
+// It lists the current user's notifications, newest first, with the number still unread.
+func (h *NotificationHandler) Inbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := middleware.GetUserID(r.Context())
+
+	page, limit := parsePagination(r)
+	list, total, err := h.notifications.List(userID, r.URL.Query().Get("unread") == "true", page, limit)
+	if err != nil {
+		log.Printf("[Notifications] Error listing notifications of user %s: %v", userID, err)
+		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+		return
+	}
+	unread, err := h.notifications.UnreadCount(userID)
+	if err != nil {
+		log.Printf("[Notifications] Error counting unread notifications of user %s: %v", userID, err)
+		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, NotificationsResponse{
+		Notifications: list,
+		Unread:        unread,
+		Total:         total,
+		Page:          page,
+		Limit:         limit,
+	})
+}
+
+// MarkRead handles POST /user/notifications/read with the notification's ID in the body
+func (h *NotificationHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := middleware.GetUserID(r.Context())
+
+	var req MarkReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID <= 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := h.notifications.MarkRead(userID, req.ID)
+	if errors.Is(err, notifications.ErrNotFound) {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[Notifications] Error marking notification %d of user %s as read: %v", req.ID, userID, err)
+		http.Error(w, "Failed to update notification", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, "Notification marked as read")
+}
+
+// MarkAllRead handles POST /user/notifications/read-all
+func (h *NotificationHandler) MarkAllRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := middleware.GetUserID(r.Context())
+
+	count, err := h.notifications.MarkAllRead(userID)
+	if err != nil {
+		log.Printf("[Notifications] Error marking notifications of user %s as read: %v", userID, err)
+		http.Error(w, "Failed to update notifications", http.StatusInternalServerError)
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "All notifications marked as read",
+		"updated": count,
+	})
+}
+
+// Stream handles GET /user/notifications/stream
+// It is a Server-Sent Events stream that starts with an "unread" event holding the unread
+// count and then sends a "notification" event for each new notification. Browsers'
+// EventSource sends the auth cookie and reconnects on its own.
+func (h *NotificationHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	userID := middleware.GetUserID(r.Context())
+
+	// Subscribe before counting, so nothing published in between is missed
+	updates, unsubscribe := h.notifications.Subscribe(userID)
+	defer unsubscribe()
+
+	unread, err := h.notifications.UnreadCount(userID)
+	if err != nil {
+		log.Printf("[Notifications] Error counting unread notifications of user %s: %v", userID, err)
+		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Keep nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	writeEvent(w, "unread", map[string]int{"unread": unread})
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case n, ok := <-updates:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, "notification", n); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes a Server-Sent Event with a JSON payload
+func writeEvent(w http.ResponseWriter, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"saas-server/middleware"
+	"saas-server/models"
+	"saas-server/pkg/referrals"
+)
+
+// referralCookieName is the cookie remembering the first referral link a visitor opened
+const referralCookieName = "referral"
+
+// referralCodePattern matches referral codes
+var referralCodePattern = regexp.MustCompile(`^[A-Za-z0-9]{4,32}$`)
+
+// ReferralHandler serves referral links and the user's referral dashboard
+type ReferralHandler struct {
+	referrals *referrals.Service
+}
+
+// NewReferralHandler creates a new ReferralHandler
+func NewReferralHandler(referralService *referrals.Service) *ReferralHandler {
+	return &ReferralHandler{referrals: referralService}
+}
+
+// ReferralDashboardResponse represents the user's referral code, link and referrals
+type ReferralDashboardResponse struct {
+	Code      string            `json:"code"`
+	Link      string            `json:"link"`
+	Signups   int               `json:"signups"`
+	Qualified int               `json:"qualified"`
+	Rewarded  int               `json:"rewarded"`
+	Referrals []models.Referral `json:"referrals"`
+}
+
+// Redirect handles GET /r/{code}
+// It remembers the referral code in a cookie and sends the visitor to the frontend. Only the
+// first link opened is kept, so attribution is first-touch.
+func (h *ReferralHandler) Redirect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	code := strings.TrimPrefix(r.URL.Path, "/r/")
+	if referralCodePattern.MatchString(code) {
+		if _, _, ok := readReferralCookie(r); !ok {
+			now := time.Now()
+			http.SetCookie(w, &http.Cookie{
+				Name:     referralCookieName,
+				Value:    fmt.Sprintf("%s.%d", strings.ToUpper(code), now.Unix()),
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   true,
+				SameSite: http.SameSiteLaxMode,
+				Expires:  now.Add(h.referrals.AttributionWindow()),
+			})
+		}
+	}
+
+	http.Redirect(w, r, os.Getenv("FRONTEND_URL")+"/", http.StatusFound)
+}
+
+// Dashboard handles GET /api/user/referrals
+// It returns the user's referral code and link along with the users they referred and the rewards earned.
+func (h *ReferralHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	code, err := h.referrals.CodeFor(userID)
+	if err != nil {
+		log.Printf("[Referrals] Error getting referral code for user %s: %v", userID, err)
+		http.Error(w, "Failed to fetch referrals", http.StatusInternalServerError)
+		return
+	}
+
+	list, err := h.referrals.Referrals(userID)
+	if err != nil {
+		log.Printf("[Referrals] Error getting referrals for user %s: %v", userID, err)
+		http.Error(w, "Failed to fetch referrals", http.StatusInternalServerError)
+		return
+	}
+
+	resp := ReferralDashboardResponse{
+		Code:      code,
+		Link:      h.referrals.Link(code),
+		Signups:   len(list),
+		Referrals: list,
+	}
+	for _, ref := range list {
+		if ref.QualifiedAt != nil {
+			resp.Qualified++
+		}
+		if ref.Status == "rewarded" {
+			resp.Rewarded++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// readReferralCookie returns the referral code and the time its link was first opened
+func readReferralCookie(r *http.Request) (string, time.Time, bool) {
+	cookie, err := r.Cookie(referralCookieName)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	code, ts, found := strings.Cut(cookie.Value, ".")
+	if !found || !referralCodePattern.MatchString(code) {
+		return "", time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return code, time.Unix(unix, 0), true
+}
+
+// clearReferralCookie removes the referral cookie once it has been used
+func clearReferralCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     referralCookieName,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Now().Add(-1 * time.Hour),
+	})
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"saas-server/database"
+	"saas-server/models"
+	"saas-server/pkg/revenue"
+	"time"
+)
+
+// RevenueHandler serves the admin revenue metrics
+type RevenueHandler struct {
+	db       *database.DB
+	currency string
+}
+
+// NewRevenueHandler creates a new RevenueHandler reporting amounts in currency
+func NewRevenueHandler(db *database.DB, currency string) *RevenueHandler {
+	return &RevenueHandler{
+		db:       db,
+		currency: currency,
+	}
+}
+
+// RevenueMetricsResponse represents the revenue metrics for a date range
+type RevenueMetricsResponse struct {
+	Currency string                   `json:"currency"`
+	From     string                   `json:"from"`
+	To       string                   `json:"to"`
+	Summary  models.RevenueSummary    `json:"summary"`
+	Series   []models.RevenueSnapshot `json:"series"`
+}
+
+// CohortMetricsResponse represents the lifetime value of monthly customer cohorts
+type CohortMetricsResponse struct {
+	Currency string             `json:"currency"`
+	From     string             `json:"from"`
+	To       string             `json:"to"`
+	Cohorts  []models.CohortLTV `json:"cohorts"`
+}
+
+// TaxReportResponse represents the tax collected on orders in a date range
+type TaxReportResponse struct {
+	From string                `json:"from"`
+	To   string                `json:"to"`
+	Rows []models.TaxReportRow `json:"rows"`
+}
+
+// GetRevenueMetrics handles GET /admin/metrics/revenue?from=YYYY-MM-DD&to=YYYY-MM-DD
+// It returns daily MRR/ARR and the MRR movements, churn, ARPU and trial conversion for the range.
+func (h *RevenueHandler) GetRevenueMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, ok := parseDateRange(w, r, 30)
+	if !ok {
+		return
+	}
+
+	snapshots, err := h.db.GetRevenueSnapshots(from, to)
+	if err != nil {
+		log.Printf("[Revenue] Error getting revenue snapshots: %v", err)
+		http.Error(w, "Failed to fetch revenue metrics", http.StatusInternalServerError)
+		return
+	}
+
+	trialsEnded, trialsConverted, err := h.db.GetTrialConversion(from, to.AddDate(0, 0, 1))
+	if err != nil {
+		log.Printf("[Revenue] Error getting trial conversion: %v", err)
+		http.Error(w, "Failed to fetch revenue metrics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RevenueMetricsResponse{
+		Currency: h.currency,
+		From:     from.Format("2006-01-02"),
+		To:       to.Format("2006-01-02"),
+		Summary:  revenue.Summarize(snapshots, trialsEnded, trialsConverted, h.currency),
+		Series:   snapshots,
+	})
+}
+
+// GetCohortMetrics handles GET /admin/metrics/cohorts?from=YYYY-MM-DD&to=YYYY-MM-DD
+// It returns the lifetime value of customers grouped by the month of their first paid order.
+func (h *RevenueHandler) GetCohortMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, ok := parseDateRange(w, r, 365)
+	if !ok {
+		return
+	}
+
+	cohorts, err := h.db.GetCohortRevenue(from, to, h.currency)
+	if err != nil {
+		log.Printf("[Revenue] Error getting cohort revenue: %v", err)
+		http.Error(w, "Failed to fetch cohort metrics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CohortMetricsResponse{
+		Currency: h.currency,
+		From:     from.Format("2006-01-02"),
+		To:       to.Format("2006-01-02"),
+		Cohorts:  revenue.ComputeLTV(cohorts, h.currency),
+	})
+}
+
+// GetTaxReport handles GET /admin/metrics/tax?from=YYYY-MM-DD&to=YYYY-MM-DD
+// It returns order totals and tax grouped by billing country, tax name and rate, e.g. for VAT returns.
+func (h *RevenueHandler) GetTaxReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, ok := parseDateRange(w, r, 90)
+	if !ok {
+		return
+	}
+
+	rows, err := h.db.GetTaxReport(from, to.AddDate(0, 0, 1))
+	if err != nil {
+		log.Printf("[Revenue] Error getting tax report: %v", err)
+		http.Error(w, "Failed to fetch tax report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TaxReportResponse{
+		From: from.Format("2006-01-02"),
+		To:   to.Format("2006-01-02"),
+		Rows: rows,
+	})
+}
+
+// parseDateRange reads the from and to query parameters (YYYY-MM-DD, UTC).
+// Missing values default to the last defaultDays days up to today.
+func parseDateRange(w http.ResponseWriter, r *http.Request, defaultDays int) (time.Time, time.Time, bool) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	to := today
+	from := today.AddDate(0, 0, -defaultDays)
+
+	if v := r.URL.Query().Get("to"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			http.Error(w, "Invalid to date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return time.Time{}, time.Time{}, false
+		}
+		to = parsed
+		from = to.AddDate(0, 0, -defaultDays)
+	}
+	if v := r.URL.Query().Get("from"); v != "" {
+		parsed, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			http.Error(w, "Invalid from date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return time.Time{}, time.Time{}, false
+		}
+		from = parsed
+	}
+
+	if from.After(to) {
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
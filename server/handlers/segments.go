@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/segments"
+)
+
+// SegmentHandler serves the admin endpoints for building and saving audience segments
+type SegmentHandler struct {
+	segments *segments.Service
+}
+
+// NewSegmentHandler creates a new SegmentHandler
+func NewSegmentHandler(segmentService *segments.Service) *SegmentHandler {
+	return &SegmentHandler{segments: segmentService}
+}
+
+// SegmentRequest represents the request body for creating or updating a segment
+type SegmentRequest struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Definition  json.RawMessage `json:"definition"`
+}
+
+// SegmentsResponse represents the list of saved segments
+type SegmentsResponse struct {
+	Segments []models.Segment `json:"segments"`
+}
+
+// SegmentResponse represents a segment with its current size
+type SegmentResponse struct {
+	models.Segment
+	Count int `json:"count"`
+}
+
+// SegmentMembersResponse represents a page of a segment's members
+type SegmentMembersResponse struct {
+	Members []models.SegmentMember `json:"members"`
+	Total   int                    `json:"total"`
+	Page    int                    `json:"page"`
+	Limit   int                    `json:"limit"`
+}
+
+// Segments handles GET /admin/segments to list saved segments and POST /admin/segments
+// to save one
+func (h *SegmentHandler) Segments(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list, err := h.segments.List()
+		if err != nil {
+			log.Printf("[Segments] Error listing segments: %v", err)
+			http.Error(w, "Failed to fetch segments", http.StatusInternalServerError)
+			return
+		}
+		sendJSONResponse(w, http.StatusOK, SegmentsResponse{Segments: list})
+
+	case http.MethodPost:
+		var req SegmentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		segment := req.segment(0)
+		if err := h.segments.Create(segment); err != nil {
+			writeSegmentError(w, "creating", 0, err)
+			return
+		}
+
+		log.Printf("[Segments] Created segment %d", segment.ID)
+		sendJSONResponse(w, http.StatusCreated, segment)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Segment handles GET, PUT and DELETE /admin/segments/detail?id=
+// GET includes the segment's current size.
+func (h *SegmentHandler) Segment(w http.ResponseWriter, r *http.Request) {
+	id, ok := segmentID(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		segment, err := h.segments.Get(id)
+		if err != nil {
+			writeSegmentError(w, "fetching", id, err)
+			return
+		}
+		_, count, err := h.segments.Members(id, 1, 1)
+		if err != nil {
+			writeSegmentError(w, "counting", id, err)
+			return
+		}
+		sendJSONResponse(w, http.StatusOK, SegmentResponse{Segment: *segment, Count: count})
+
+	case http.MethodPut:
+		var req SegmentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		segment, err := h.segments.Update(req.segment(id))
+		if err != nil {
+			writeSegmentError(w, "updating", id, err)
+			return
+		}
+		sendJSONResponse(w, http.StatusOK, segment)
+
+	case http.MethodDelete:
+		if err := h.segments.Delete(id); err != nil {
+			writeSegmentError(w, "deleting", id, err)
+			return
+		}
+		log.Printf("[Segments] Deleted segment %d", id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Fields handles GET /admin/segments/fields
+// It lists the fields and operators of each source for building rules.
+func (h *SegmentHandler) Fields(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, segments.Fields())
+}
+
+// Preview handles POST /admin/segments/preview
+// The body is a definition, saved or not; the response is its size and a sample of members.
+func (h *SegmentHandler) Preview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	def, err := segments.Parse(body)
+	if err != nil {
+		writeSegmentError(w, "previewing", 0, err)
+		return
+	}
+
+	preview, err := h.segments.Preview(def)
+	if err != nil {
+		writeSegmentError(w, "previewing", 0, err)
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, preview)
+}
+
+// Members handles GET /admin/segments/members?id=
+func (h *SegmentHandler) Members(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := segmentID(w, r)
+	if !ok {
+		return
+	}
+
+	page, limit := parsePagination(r)
+	members, total, err := h.segments.Members(id, page, limit)
+	if err != nil {
+		writeSegmentError(w, "listing members of", id, err)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, SegmentMembersResponse{
+		Members: members,
+		Total:   total,
+		Page:    page,
+		Limit:   limit,
+	})
+}
+
+// Export handles GET /admin/segments/export?id=
+// It downloads every member of the segment as CSV.
+func (h *SegmentHandler) Export(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := segmentID(w, r)
+	if !ok {
+		return
+	}
+
+	members, _, err := h.segments.Members(id, 1, 0)
+	if err != nil {
+		writeSegmentError(w, "exporting", id, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="segment-%d.csv"`, id))
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"email", "name", "user_id", "signed_up_at"})
+	for _, m := range members {
+		writer.Write([]string{csvSafe(m.Email), csvSafe(m.Name), m.UserID, m.SignedUpAt.UTC().Format(time.RFC3339)})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("[Segments] Error writing export of segment %d: %v", id, err)
+	}
+}
+
+// segment converts the request to a segment with the given ID
+func (req SegmentRequest) segment(id int) *models.Segment {
+	return &models.Segment{
+		ID:          id,
+		Name:        req.Name,
+		Description: req.Description,
+		Definition:  req.Definition,
+	}
+}
+
+// segmentID reads the segment ID from the query and rejects the request if it is missing
+func segmentID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid segment ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// csvSafe keeps user-supplied values from being run as formulas when the export is opened
+// in a spreadsheet
+func csvSafe(value string) string {
+	if value != "" && (value[0] == '=' || value[0] == '+' || value[0] == '-' || value[0] == '@') {
+		return "'" + value
+	}
+	return value
+}
+
+// writeSegmentError maps segment service errors to responses
+func writeSegmentError(w http.ResponseWriter, action string, id int, err error) {
+	switch {
+	case errors.Is(err, segments.ErrNotFound):
+		http.Error(w, "Segment not found", http.StatusNotFound)
+	case errors.Is(err, segments.ErrInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, segments.ErrInvalidSegment):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("[Segments] Error %s segment %d: %v", action, id, err)
+		http.Error(w, "Failed to process segment", http.StatusInternalServerError)
+	}
+}
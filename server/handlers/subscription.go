@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"saas-server/database"
+	"saas-server/middleware"
+	"saas-server/models"
+	"saas-server/pkg/lemonsqueezy"
+)
+
+// SubscriptionHandler handles first-party subscription lifecycle actions
+// (cancel, resume, pause and plan changes) for the authenticated user
+type SubscriptionHandler struct {
+	DB     database.DBInterface
+	client *lemonsqueezy.Client
+}
+
+// SubscriptionActionRequest identifies the subscription an action applies to.
+// When SubscriptionID is empty the user's most recent subscription is used.
+type SubscriptionActionRequest struct {
+	SubscriptionID string `json:"subscriptionId,omitempty"`
+}
+
+// PauseSubscriptionRequest represents the request body for pausing a subscription
+type PauseSubscriptionRequest struct {
+	SubscriptionActionRequest
+	Mode      string     `json:"mode,omitempty"`      // "void" (default) or "free"
+	ResumesAt *time.Time `json:"resumesAt,omitempty"` // Optional date the subscription resumes automatically
+}
+
+// ChangePlanRequest represents the request body for changing a subscription's plan
+type ChangePlanRequest struct {
+	SubscriptionActionRequest
+	VariantID          int  `json:"variantId"`
+	Preview            bool `json:"preview,omitempty"`            // Only return the proration preview
+	InvoiceImmediately bool `json:"invoiceImmediately,omitempty"` // Charge the prorated amount now instead of on the next invoice
+	DisableProrations  bool `json:"disableProrations,omitempty"`
+}
+
+// ProrationPreview is an estimate of the charge resulting from a plan change.
+// Amounts are in the currency's minor unit (e.g. cents).
+type ProrationPreview struct {
+	CurrentVariantID  int        `json:"current_variant_id"`
+	NewVariantID      int        `json:"new_variant_id"`
+	CurrentPrice      int        `json:"current_price"`
+	NewPrice          int        `json:"new_price"`
+	RemainingFraction float64    `json:"remaining_fraction"`
+	Credit            int        `json:"credit"`
+	Charge            int        `json:"charge"`
+	AmountDue         int        `json:"amount_due"`
+	PeriodEndsAt      *time.Time `json:"period_ends_at,omitempty"`
+	IntervalChanged   bool       `json:"interval_changed"`
+}
+
+// NewSubscriptionHandler creates a new subscription handler
+func NewSubscriptionHandler(db database.DBInterface) *SubscriptionHandler {
+	return &SubscriptionHandler{
+		DB:     db,
+		client: lemonsqueezy.NewClient(),
+	}
+}
+
+// Cancel handles POST /api/user/subscription/cancel
+// The subscription stays active until the end of the current billing period.
+func (h *SubscriptionHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SubscriptionActionRequest
+	if !decodeOptionalBody(w, r, &req) {
+		return
+	}
+
+	userID, subscription, ok := h.loadOwnedSubscription(w, r, req.SubscriptionID)
+	if !ok {
+		return
+	}
+
+	updated, err := h.client.CancelSubscription(subscription.SubscriptionID)
+	if err != nil {
+		log.Printf("[Subscription] Error cancelling subscription %s: %v", subscription.SubscriptionID, err)
+		http.Error(w, "Failed to cancel subscription", http.StatusBadGateway)
+		return
+	}
+
+	h.respondWithReconciled(w, userID, updated, "api_cancel")
+}
+
+// Resume handles POST /api/user/subscription/resume
+// It reverses a pending cancellation and lifts any active pause.
+func (h *SubscriptionHandler) Resume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SubscriptionActionRequest
+	if !decodeOptionalBody(w, r, &req) {
+		return
+	}
+
+	userID, subscription, ok := h.loadOwnedSubscription(w, r, req.SubscriptionID)
+	if !ok {
+		return
+	}
+
+	if subscription.Status == "expired" {
+		http.Error(w, "Expired subscriptions cannot be resumed", http.StatusConflict)
+		return
+	}
+
+	updated, err := h.client.ResumeSubscription(subscription.SubscriptionID)
+	if err != nil {
+		log.Printf("[Subscription] Error resuming subscription %s: %v", subscription.SubscriptionID, err)
+		http.Error(w, "Failed to resume subscription", http.StatusBadGateway)
+		return
+	}
+
+	h.respondWithReconciled(w, userID, updated, "api_resume")
+}
+
+// Pause handles POST /api/user/subscription/pause
+func (h *SubscriptionHandler) Pause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PauseSubscriptionRequest
+	if !decodeOptionalBody(w, r, &req) {
+		return
+	}
+
+	if req.Mode == "" {
+		req.Mode = "void"
+	}
+	if req.Mode != "void" && req.Mode != "free" {
+		http.Error(w, "Pause mode must be 'void' or 'free'", http.StatusBadRequest)
+		return
+	}
+	if req.ResumesAt != nil && !req.ResumesAt.After(time.Now()) {
+		http.Error(w, "Resume date must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	userID, subscription, ok := h.loadOwnedSubscription(w, r, req.SubscriptionID)
+	if !ok {
+		return
+	}
+
+	if subscription.Status != "active" {
+		http.Error(w, "Only active subscriptions can be paused", http.StatusConflict)
+		return
+	}
+
+	updated, err := h.client.PauseSubscription(subscription.SubscriptionID, req.Mode, req.ResumesAt)
+	if err != nil {
+		log.Printf("[Subscription] Error pausing subscription %s: %v", subscription.SubscriptionID, err)
+		http.Error(w, "Failed to pause subscription", http.StatusBadGateway)
+		return
+	}
+
+	h.respondWithReconciled(w, userID, updated, "api_pause")
+}
+
+// ChangePlan handles POST /api/user/subscription/change-plan
+// With "preview": true it only returns the estimated proration without changing the plan.
+func (h *SubscriptionHandler) ChangePlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ChangePlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.VariantID <= 0 {
+		http.Error(w, "Variant ID is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, subscription, ok := h.loadOwnedSubscription(w, r, req.SubscriptionID)
+	if !ok {
+		return
+	}
+
+	if subscription.VariantID == req.VariantID {
+		http.Error(w, "Subscription is already on this plan", http.StatusConflict)
+		return
+	}
+
+	// The variant comes from the client, so it must be a plan of this store that is on sale
+	storeID := os.Getenv("LEMON_SQUEEZY_STORE_ID")
+	if storeID == "" {
+		http.Error(w, "Missing required environment configuration", http.StatusInternalServerError)
+		return
+	}
+	variant, ok := catalogVariant(w, h.client, storeID, strconv.Itoa(req.VariantID), "")
+	if !ok {
+		return
+	}
+	if !variant.IsSubscription {
+		http.Error(w, "This plan is not a subscription", http.StatusBadRequest)
+		return
+	}
+
+	if req.Preview {
+		preview, err := h.previewProration(subscription, req.VariantID, variant, time.Now())
+		if err != nil {
+			log.Printf("[Subscription] Error previewing plan change: %v", err)
+			http.Error(w, "Failed to preview plan change", http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(preview)
+		return
+	}
+
+	updated, err := h.client.ChangeSubscriptionPlan(subscription.SubscriptionID, req.VariantID, req.InvoiceImmediately, req.DisableProrations)
+	if err != nil {
+		log.Printf("[Subscription] Error changing plan for subscription %s: %v", subscription.SubscriptionID, err)
+		http.Error(w, "Failed to change plan", http.StatusBadGateway)
+		return
+	}
+
+	h.respondWithReconciled(w, userID, updated, "api_change_plan")
+}
+
+// SubscriptionHistoryResponse represents a page of subscription history events
+type SubscriptionHistoryResponse struct {
+	Events []models.SubscriptionEvent `json:"events"`
+	Total  int                        `json:"total"`
+	Page   int                        `json:"page"`
+	Limit  int                        `json:"limit"`
+}
+
+// History handles GET /api/user/subscription/history
+// It returns the state transitions of the user's subscriptions, newest first.
+func (h *SubscriptionHandler) History(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+	if userID == "" {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return
+	}
+
+	page, limit := parsePagination(r)
+	events, total, err := h.DB.GetSubscriptionEvents(userID, 0, page, limit)
+	if err != nil {
+		log.Printf("[Subscription] Error getting subscription history for user %s: %v", userID, err)
+		http.Error(w, "Failed to fetch subscription history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SubscriptionHistoryResponse{
+		Events: events,
+		Total:  total,
+		Page:   page,
+		Limit:  limit,
+	})
+}
+
+// loadOwnedSubscription resolves the subscription an action targets and verifies
+// that it belongs to the authenticated user. It writes an error response and
+// returns ok=false on failure.
+func (h *SubscriptionHandler) loadOwnedSubscription(w http.ResponseWriter, r *http.Request, subscriptionID string) (string, *models.Subscription, bool) {
+	userID := middleware.GetUserID(r.Context())
+	if userID == "" {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return "", nil, false
+	}
+
+	var subscription *models.Subscription
+	var err error
+	if subscriptionID == "" {
+		subscription, err = h.DB.GetSubscriptionByUserID(userID)
+	} else {
+		id, convErr := strconv.Atoi(subscriptionID)
+		if convErr != nil {
+			http.Error(w, "Invalid subscription ID", http.StatusBadRequest)
+			return "", nil, false
+		}
+		subscription, err = h.DB.GetSubscriptionBySubscriptionID(id)
+	}
+
+	if err != nil || subscription == nil || subscription.UserID != userID {
+		if err != nil {
+			log.Printf("[Subscription] Error loading subscription for user %s: %v", userID, err)
+		}
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return "", nil, false
+	}
+
+	// A free trial has no subscription at the payment provider to act on
+	if subscription.IsTrial {
+		http.Error(w, "Free trials can't be changed, choose a plan to subscribe", http.StatusConflict)
+		return "", nil, false
+	}
+
+	return userID, subscription, true
+}
+
+// respondWithReconciled writes the provider's view of a subscription to the
+// subscriptions table and re-syncs users.latest_* so the change is visible before the
+// corresponding webhook arrives, then responds with the stored subscription.
+// source is recorded in the subscription history as the cause of the change.
+func (h *SubscriptionHandler) respondWithReconciled(w http.ResponseWriter, userID string, updated *lemonsqueezy.SubscriptionResponse, source string) {
+	subscriptionID, err := strconv.Atoi(updated.Data.ID)
+	if err != nil {
+		log.Printf("[Subscription] Invalid subscription ID in provider response: %s", updated.Data.ID)
+		http.Error(w, "Invalid provider response", http.StatusBadGateway)
+		return
+	}
+
+	attrs := updated.Data.Attributes
+	if err := h.DB.UpdateSubscription(subscriptionID, attrs.Status, attrs.Cancelled, attrs.ProductID, attrs.VariantID, attrs.RenewsAt, attrs.EndsAt, attrs.TrialEndsAt, source); err != nil {
+		log.Printf("[Subscription] Error updating subscription %d: %v", subscriptionID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.DB.SyncUserPrimarySubscription(userID); err != nil {
+		log.Printf("[Subscription] Error updating user subscription for user %s: %v", userID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.DB.InvalidateUserCache(userID)
+
+	subscription, err := h.DB.GetSubscriptionBySubscriptionID(subscriptionID)
+	if err != nil {
+		log.Printf("[Subscription] Error reloading subscription %d: %v", subscriptionID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subscription)
+}
+
+// previewProration estimates the prorated amount for switching a subscription to newVariantID,
+// whose attributes are next, at now. The unused share of the current price is credited and the
+// same share of the new price is charged.
+func (h *SubscriptionHandler) previewProration(subscription *models.Subscription, newVariantID int, next *lemonsqueezy.VariantAttributes, now time.Time) (*ProrationPreview, error) {
+	current, err := h.client.GetVariant(strconv.Itoa(subscription.VariantID))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching current variant: %w", err)
+	}
+
+	currentAttrs := current.Data.Attributes
+	nextAttrs := *next
+	preview := &ProrationPreview{
+		CurrentVariantID: subscription.VariantID,
+		NewVariantID:     newVariantID,
+		CurrentPrice:     currentAttrs.Price,
+		NewPrice:         nextAttrs.Price,
+		PeriodEndsAt:     subscription.RenewsAt,
+		IntervalChanged:  currentAttrs.Interval != nextAttrs.Interval || currentAttrs.IntervalCount != nextAttrs.IntervalCount,
+	}
+
+	preview.RemainingFraction = remainingPeriodFraction(subscription.RenewsAt, currentAttrs.Interval, currentAttrs.IntervalCount, now)
+	preview.Credit = int(math.Round(float64(preview.CurrentPrice) * preview.RemainingFraction))
+	preview.Charge = int(math.Round(float64(preview.NewPrice) * preview.RemainingFraction))
+	preview.AmountDue = preview.Charge - preview.Credit
+
+	return preview, nil
+}
+
+// remainingPeriodFraction returns the share of the current billing period left at now, between 0 and 1
+func remainingPeriodFraction(renewsAt *time.Time, interval string, intervalCount int, now time.Time) float64 {
+	if renewsAt == nil || !renewsAt.After(now) {
+		return 0
+	}
+	if intervalCount < 1 {
+		intervalCount = 1
+	}
+
+	var periodStart time.Time
+	switch interval {
+	case "day":
+		periodStart = renewsAt.AddDate(0, 0, -intervalCount)
+	case "week":
+		periodStart = renewsAt.AddDate(0, 0, -7*intervalCount)
+	case "year":
+		periodStart = renewsAt.AddDate(-intervalCount, 0, 0)
+	default:
+		periodStart = renewsAt.AddDate(0, -intervalCount, 0)
+	}
+
+	period := renewsAt.Sub(periodStart)
+	if period <= 0 {
+		return 0
+	}
+
+	fraction := float64(renewsAt.Sub(now)) / float64(period)
+	if fraction > 1 {
+		return 1
+	}
+	return fraction
+}
+
+// decodeOptionalBody decodes a JSON request body that may be empty.
+// It writes a 400 response and returns false when the body is malformed.
+func decodeOptionalBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Body == nil {
+		return true
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"saas-server/database"
+	"saas-server/middleware"
+	"saas-server/models"
+	"saas-server/pkg/lemonsqueezy"
+	"saas-server/pkg/trials"
+)
+
+// TrialHandler lets users start their free trial and convert it into a paid subscription
+type TrialHandler struct {
+	db     *database.DB
+	trials *trials.Service
+	client *lemonsqueezy.Client
+}
+
+// NewTrialHandler creates a new TrialHandler
+func NewTrialHandler(db *database.DB, trialService *trials.Service) *TrialHandler {
+	return &TrialHandler{
+		db:     db,
+		trials: trialService,
+		client: lemonsqueezy.NewClient(),
+	}
+}
+
+// TrialResponse represents the user's trial. Trial is nil when the user never had one.
+type TrialResponse struct {
+	Eligible bool          `json:"eligible"`
+	Trial    *models.Trial `json:"trial"`
+}
+
+// ConvertTrialRequest represents the request body for converting a trial.
+// VariantID defaults to the trial's plan.
+type ConvertTrialRequest struct {
+	VariantID int `json:"variantId,omitempty"`
+}
+
+// Trial handles GET /api/user/trial to fetch the user's trial and POST /api/user/trial to start it
+func (h *TrialHandler) Trial(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		trial, err := h.db.GetTrialByUserID(userID)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("[Trials] Error getting trial for user %s: %v", userID, err)
+			http.Error(w, "Failed to fetch trial", http.StatusInternalServerError)
+			return
+		}
+
+		eligible := trial == nil && h.trials.Enabled()
+		if eligible {
+			active, err := h.db.GetActiveSubscriptionsByUserID(userID)
+			if err != nil {
+				log.Printf("[Trials] Error getting subscriptions for user %s: %v", userID, err)
+				http.Error(w, "Failed to fetch trial", http.StatusInternalServerError)
+				return
+			}
+			eligible = len(active) == 0
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TrialResponse{Eligible: eligible, Trial: trial})
+
+	case http.MethodPost:
+		trial, err := h.trials.Start(userID)
+		switch err {
+		case nil:
+		case trials.ErrTrialsDisabled:
+			http.Error(w, "Trials are not available", http.StatusNotFound)
+			return
+		case database.ErrTrialAlreadyUsed:
+			http.Error(w, "You have already used your free trial", http.StatusConflict)
+			return
+		case trials.ErrAlreadySubscribed:
+			http.Error(w, "You already have an active subscription", http.StatusConflict)
+			return
+		default:
+			log.Printf("[Trials] Error starting trial for user %s: %v", userID, err)
+			http.Error(w, "Failed to start trial", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(TrialResponse{Eligible: false, Trial: trial})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Convert handles POST /api/user/trial/convert
+// It creates a checkout for the trial's plan so the subscription webhook can link it back to the user.
+func (h *TrialHandler) Convert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req ConvertTrialRequest
+	if !decodeOptionalBody(w, r, &req) {
+		return
+	}
+
+	trial, err := h.db.GetTrialByUserID(userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "No trial found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[Trials] Error getting trial for user %s: %v", userID, err)
+		http.Error(w, "Failed to fetch trial", http.StatusInternalServerError)
+		return
+	}
+	if trial.Status == "converted" {
+		http.Error(w, "Trial has already been converted", http.StatusConflict)
+		return
+	}
+
+	user, err := h.db.GetUserByID(userID)
+	if err != nil {
+		log.Printf("[Trials] Error getting user %s: %v", userID, err)
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return
+	}
+
+	storeID := os.Getenv("LEMON_SQUEEZY_STORE_ID")
+	if storeID == "" {
+		http.Error(w, "Missing required environment configuration", http.StatusInternalServerError)
+		return
+	}
+
+	variantID := trial.VariantID
+	if req.VariantID != 0 {
+		variantID = req.VariantID
+	}
+
+	checkoutData := lemonsqueezy.CheckoutData{
+		Custom: map[string]interface{}{
+			"user_id": userID,
+		},
+	}
+	prefillBillingDetails(h.db, userID, &checkoutData)
+
+	checkout, err := h.client.CreateCheckout(
+		storeID,
+		strconv.Itoa(variantID),
+		map[string]interface{}{
+			"email":         user.Email,
+			"checkout_data": checkoutData,
+		},
+	)
+	if err != nil {
+		log.Printf("[Trials] Error creating checkout for user %s: %v", userID, err)
+		http.Error(w, "Failed to create checkout", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"checkoutURL": checkout.Data.Attributes.URL,
+	})
+}
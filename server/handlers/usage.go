@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"saas-server/database"
+	"saas-server/middleware"
+	"saas-server/models"
+	"saas-server/pkg/lemonsqueezy"
+	"saas-server/pkg/metering"
+	"saas-server/pkg/validation"
+
+	"github.com/google/uuid"
+)
+
+// maxUsageEventAge is how long after it occurred a usage event can still be recorded. Usage
+// is billed in the period it is reported in, so older events are rejected.
+const maxUsageEventAge = 7 * 24 * time.Hour
+
+// maxUsageEventSkew is how far in the future a usage event's time can be, to allow for clock skew
+const maxUsageEventSkew = 5 * time.Minute
+
+// UsageHandler handles metered usage recording and reporting
+type UsageHandler struct {
+	db       database.DBInterface
+	client   *lemonsqueezy.Client
+	reporter *metering.UsageReportingService
+}
+
+// PlanUsageLimitRequest represents the request body for setting a plan's usage limits
+type PlanUsageLimitRequest struct {
+	VariantID     int    `json:"variant_id"`
+	IncludedUnits int    `json:"included_units"`
+	HardLimit     *int   `json:"hard_limit,omitempty"`
+	UnitLabel     string `json:"unit_label,omitempty"`
+}
+
+// ResolveUsageReportRequest represents the request body for resolving a usage report that
+// needed review. Recorded tells whether the provider has its usage record.
+type ResolveUsageReportRequest struct {
+	ID                    int    `json:"id"`
+	Recorded              bool   `json:"recorded"`
+	ProviderUsageRecordID string `json:"provider_usage_record_id,omitempty"`
+}
+
+// NewUsageHandler creates a new usage handler
+func NewUsageHandler(db database.DBInterface, reporter *metering.UsageReportingService) *UsageHandler {
+	return &UsageHandler{
+		db:       db,
+		client:   lemonsqueezy.NewClient(),
+		reporter: reporter,
+	}
+}
+
+// RecordUsage handles POST /internal/usage
+// Events are de-duplicated by idempotency key; replaying a key returns the original event.
+// Usage is only recorded for active usage-based subscriptions and up to their plan's hard limit.
+func (h *UsageHandler) RecordUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.UsageEventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.IdempotencyKey = validation.SanitizeInput(req.IdempotencyKey, 255)
+	if req.IdempotencyKey == "" {
+		http.Error(w, "Idempotency key is required", http.StatusBadRequest)
+		return
+	}
+	if req.Quantity <= 0 {
+		http.Error(w, "Quantity must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" && req.SubscriptionID == 0 {
+		http.Error(w, "Either user_id or subscription_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.UserID != "" {
+		if _, err := uuid.Parse(req.UserID); err != nil {
+			http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var subscription *models.Subscription
+	var err error
+	if req.SubscriptionID != 0 {
+		subscription, err = h.db.GetSubscriptionBySubscriptionID(req.SubscriptionID)
+	} else {
+		subscription, err = h.usageSubscription(req.UserID)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("[Usage] Error loading subscription: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if req.UserID != "" && subscription.UserID != req.UserID {
+		http.Error(w, "Subscription does not belong to user", http.StatusBadRequest)
+		return
+	}
+
+	// Only usage of entitled usage-based subscriptions can be reported to the provider
+	if !subscription.IsUsageBased || subscription.SubscriptionItemID == 0 {
+		http.Error(w, "Subscription is not usage-based", http.StatusConflict)
+		return
+	}
+	entitled, err := h.entitled(subscription)
+	if err != nil {
+		log.Printf("[Usage] Error checking entitlement of subscription %s: %v", subscription.SubscriptionID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !entitled {
+		http.Error(w, "Subscription is not active", http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	occurredAt := now
+	if req.OccurredAt != nil {
+		occurredAt = *req.OccurredAt
+		if occurredAt.After(now.Add(maxUsageEventSkew)) {
+			http.Error(w, "Occurred at can't be in the future", http.StatusBadRequest)
+			return
+		}
+		if occurredAt.Before(now.Add(-maxUsageEventAge)) {
+			http.Error(w, "Occurred at is too far in the past", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var hardLimit *int
+	limit, err := h.db.GetPlanUsageLimit(subscription.VariantID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("[Usage] Error loading plan limits for variant %d: %v", subscription.VariantID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if limit != nil {
+		hardLimit = limit.HardLimit
+	}
+
+	// The hard limit applies to the billing period estimated from the renewal date, so recording
+	// usage doesn't depend on the provider being reachable
+	periodStart, _ := fallbackUsagePeriod(subscription, now)
+	subscriptionID, _ := strconv.Atoi(subscription.SubscriptionID)
+	event, created, err := h.db.RecordUsageEvent(subscription.UserID, subscriptionID, req.Quantity, req.IdempotencyKey, occurredAt, periodStart, hardLimit)
+	if errors.Is(err, database.ErrUsageLimitExceeded) {
+		http.Error(w, "Usage limit of the plan reached", http.StatusPaymentRequired)
+		return
+	}
+	if err != nil {
+		log.Printf("[Usage] Error recording usage event: %v", err)
+		http.Error(w, "Failed to record usage", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusCreated
+	if !created {
+		status = http.StatusOK
+	}
+	sendJSONResponse(w, status, event)
+}
+
+// GetUserUsage handles GET /api/user/usage
+// It returns the current period's usage of a subscription against its plan limits: the one given by
+// ?subscription_id=, otherwise the user's usage-based subscription.
+func (h *UsageHandler) GetUserUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+	if userID == "" {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return
+	}
+
+	var subscription *models.Subscription
+	var err error
+	if v := r.URL.Query().Get("subscription_id"); v != "" {
+		id, convErr := strconv.Atoi(v)
+		if convErr != nil {
+			http.Error(w, "Invalid subscription ID", http.StatusBadRequest)
+			return
+		}
+		subscription, err = h.db.GetSubscriptionBySubscriptionID(id)
+		if err == nil && subscription.UserID != userID {
+			err = sql.ErrNoRows
+		}
+	} else {
+		subscription, err = h.usageSubscription(userID)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "No subscription found", http.StatusNotFound)
+			return
+		}
+		log.Printf("[Usage] Error loading subscription for user %s: %v", userID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	subscriptionID, _ := strconv.Atoi(subscription.SubscriptionID)
+	summary := models.UsageSummary{
+		SubscriptionID: subscriptionID,
+		VariantID:      subscription.VariantID,
+		UnitLabel:      "calls",
+	}
+
+	// Prefer the provider's billing period; fall back to the month before renewal
+	summary.PeriodStart, summary.PeriodEnd = fallbackUsagePeriod(subscription, time.Now())
+	if subscription.SubscriptionItemID > 0 {
+		current, err := h.client.GetCurrentUsage(subscription.SubscriptionItemID)
+		if err != nil {
+			log.Printf("[Usage] Error fetching current usage from provider: %v", err)
+		} else {
+			periodEnd := current.Meta.PeriodEnd
+			summary.PeriodStart = current.Meta.PeriodStart
+			summary.PeriodEnd = &periodEnd
+			summary.Reported = current.Meta.Quantity
+		}
+	}
+
+	summary.Used, err = h.db.GetSubscriptionUsageSince(summary.SubscriptionID, summary.PeriodStart)
+	if err != nil {
+		log.Printf("[Usage] Error summing usage for subscription %d: %v", summary.SubscriptionID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	limit, err := h.db.GetPlanUsageLimit(subscription.VariantID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("[Usage] Error loading plan limits for variant %d: %v", subscription.VariantID, err)
+	}
+	if limit != nil {
+		summary.IncludedUnits = limit.IncludedUnits
+		summary.HardLimit = limit.HardLimit
+		summary.UnitLabel = limit.UnitLabel
+	}
+
+	if summary.Used > summary.IncludedUnits {
+		summary.Overage = summary.Used - summary.IncludedUnits
+	}
+	if summary.IncludedUnits > 0 {
+		summary.PercentIncluded = float64(summary.Used) / float64(summary.IncludedUnits) * 100
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// PlanUsageLimits handles GET and PUT /admin/usage/limits
+func (h *UsageHandler) PlanUsageLimits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		limits, err := h.db.GetAllPlanUsageLimits()
+		if err != nil {
+			log.Printf("[Usage] Error fetching plan limits: %v", err)
+			http.Error(w, "Failed to fetch plan limits", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(limits)
+
+	case http.MethodPut:
+		var req PlanUsageLimitRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.VariantID <= 0 || req.IncludedUnits < 0 || (req.HardLimit != nil && *req.HardLimit < 0) {
+			http.Error(w, "Invalid plan limits", http.StatusBadRequest)
+			return
+		}
+		req.UnitLabel = validation.SanitizeInput(req.UnitLabel, 50)
+		if req.UnitLabel == "" {
+			req.UnitLabel = "calls"
+		}
+
+		if err := h.db.UpsertPlanUsageLimit(req.VariantID, req.IncludedUnits, req.HardLimit, req.UnitLabel); err != nil {
+			log.Printf("[Usage] Error saving plan limits: %v", err)
+			http.Error(w, "Failed to save plan limits", http.StatusInternalServerError)
+			return
+		}
+		sendSuccessResponse(w, "Plan limits saved")
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ReportsNeedingReview handles GET /admin/usage/reports
+// It lists the usage reports that were sent without a confirmed outcome or failed too often.
+func (h *UsageHandler) ReportsNeedingReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reports, err := h.reporter.ReportsNeedingReview()
+	if err != nil {
+		log.Printf("[Usage] Error fetching usage reports needing review: %v", err)
+		http.Error(w, "Failed to fetch usage reports", http.StatusInternalServerError)
+		return
+	}
+	if reports == nil {
+		reports = []models.UsageReport{}
+	}
+	sendJSONResponse(w, http.StatusOK, reports)
+}
+
+// ResolveReport handles POST /admin/usage/reports/resolve
+// The admin checked the report against the provider: recorded reports are kept as reported,
+// others are sent again on the next run.
+func (h *UsageHandler) ResolveReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ResolveUsageReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID <= 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.ProviderUsageRecordID = validation.SanitizeInput(req.ProviderUsageRecordID, 255)
+
+	err := h.reporter.ResolveReport(req.ID, req.Recorded, req.ProviderUsageRecordID)
+	if errors.Is(err, metering.ErrNotFound) {
+		http.Error(w, "Usage report not found or doesn't need review", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[Usage] Error resolving usage report %d: %v", req.ID, err)
+		http.Error(w, "Failed to resolve usage report", http.StatusInternalServerError)
+		return
+	}
+	sendSuccessResponse(w, "Usage report resolved")
+}
+
+// fallbackUsagePeriod estimates the current billing period as the month before the next renewal,
+// or the current calendar month when the renewal date is unknown
+func fallbackUsagePeriod(subscription *models.Subscription, now time.Time) (time.Time, *time.Time) {
+	if subscription.RenewsAt != nil && subscription.RenewsAt.After(now) {
+		return subscription.RenewsAt.AddDate(0, -1, 0), subscription.RenewsAt
+	}
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	end := start.AddDate(0, 1, 0)
+	return start, &end
+}
+
+// entitled reports whether a subscription is one of its user's entitled subscriptions
+func (h *UsageHandler) entitled(subscription *models.Subscription) (bool, error) {
+	active, err := h.db.GetActiveSubscriptionsByUserID(subscription.UserID)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range active {
+		if s.SubscriptionID == subscription.SubscriptionID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// usageSubscription picks the subscription usage is metered against when none is given:
+// the user's first entitled usage-based subscription, otherwise their primary subscription
+func (h *UsageHandler) usageSubscription(userID string) (*models.Subscription, error) {
+	active, err := h.db.GetActiveSubscriptionsByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range active {
+		if active[i].IsUsageBased {
+			return &active[i], nil
+		}
+	}
+	subscription, err := h.db.GetSubscriptionByUserID(userID)
+	if err == nil && subscription.IsTrial {
+		// Usage is reported against a provider subscription, which trials don't have
+		return nil, sql.ErrNoRows
+	}
+	return subscription, err
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"saas-server/database"
+	"saas-server/models"
+)
+
+// fakeUsageDB implements the database operations RecordUsage uses; the embedded interface
+// panics on anything else
+type fakeUsageDB struct {
+	database.DBInterface
+	subscriptions map[int]*models.Subscription
+	limits        map[int]*models.PlanUsageLimit
+	events        []models.UsageEvent
+}
+
+func (db *fakeUsageDB) GetSubscriptionBySubscriptionID(subscriptionID int) (*models.Subscription, error) {
+	subscription, ok := db.subscriptions[subscriptionID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *subscription
+	return &copied, nil
+}
+
+func (db *fakeUsageDB) GetActiveSubscriptionsByUserID(userID string) ([]models.Subscription, error) {
+	var active []models.Subscription
+	for _, subscription := range db.subscriptions {
+		if subscription.UserID == userID && subscription.Status == "active" {
+			active = append(active, *subscription)
+		}
+	}
+	return active, nil
+}
+
+func (db *fakeUsageDB) GetPlanUsageLimit(variantID int) (*models.PlanUsageLimit, error) {
+	limit, ok := db.limits[variantID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return limit, nil
+}
+
+func (db *fakeUsageDB) RecordUsageEvent(userID string, subscriptionID int, quantity int, idempotencyKey string, occurredAt time.Time, periodStart time.Time, hardLimit *int) (*models.UsageEvent, bool, error) {
+	used := 0
+	for _, e := range db.events {
+		if e.IdempotencyKey == idempotencyKey {
+			return &e, false, nil
+		}
+		if e.SubscriptionID == subscriptionID && !e.OccurredAt.Before(periodStart) {
+			used += e.Quantity
+		}
+	}
+	if hardLimit != nil && used+quantity > *hardLimit {
+		return nil, false, database.ErrUsageLimitExceeded
+	}
+	event := models.UsageEvent{
+		ID:             int64(len(db.events) + 1),
+		UserID:         userID,
+		SubscriptionID: subscriptionID,
+		Quantity:       quantity,
+		IdempotencyKey: idempotencyKey,
+		OccurredAt:     occurredAt,
+	}
+	db.events = append(db.events, event)
+	return &event, true, nil
+}
+
+const usageTestUser = "6f1c7c1e-8d7a-4f0e-9b4e-3c2a1d0e9f8a"
+
+func newUsageTestHandler() (*UsageHandler, *fakeUsageDB) {
+	renewsAt := time.Now().AddDate(0, 0, 20)
+	hardLimit := 10
+	db := &fakeUsageDB{
+		subscriptions: map[int]*models.Subscription{
+			1: {SubscriptionID: "1", UserID: usageTestUser, Status: "active", VariantID: 100, IsUsageBased: true, SubscriptionItemID: 501, RenewsAt: &renewsAt},
+			2: {SubscriptionID: "2", UserID: usageTestUser, Status: "active", VariantID: 200, RenewsAt: &renewsAt},
+			3: {SubscriptionID: "3", UserID: usageTestUser, Status: "expired", VariantID: 100, IsUsageBased: true, SubscriptionItemID: 503},
+		},
+		limits: map[int]*models.PlanUsageLimit{
+			100: {VariantID: 100, IncludedUnits: 5, HardLimit: &hardLimit},
+		},
+	}
+	return NewUsageHandler(db, nil), db
+}
+
+func recordUsage(h *UsageHandler, subscriptionID int, quantity int, key string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(models.UsageEventRequest{SubscriptionID: subscriptionID, Quantity: quantity, IdempotencyKey: key})
+	w := httptest.NewRecorder()
+	h.RecordUsage(w, httptest.NewRequest(http.MethodPost, "/internal/usage", bytes.NewReader(body)))
+	return w
+}
+
+func TestRecordUsageRequiresEntitledUsageBasedSubscription(t *testing.T) {
+	h, db := newUsageTestHandler()
+
+	tests := []struct {
+		name           string
+		subscriptionID int
+		want           int
+	}{
+		{"active usage-based", 1, http.StatusCreated},
+		{"flat-price", 2, http.StatusConflict},
+		{"expired usage-based", 3, http.StatusConflict},
+		{"unknown", 4, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := recordUsage(h, tt.subscriptionID, 1, "key-"+strconv.Itoa(tt.subscriptionID))
+			if w.Code != tt.want {
+				t.Errorf("status = %d (%s), want %d", w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+	if len(db.events) != 1 {
+		t.Errorf("recorded %d events, want 1", len(db.events))
+	}
+}
+
+func TestRecordUsageEnforcesHardLimit(t *testing.T) {
+	h, db := newUsageTestHandler()
+
+	steps := []struct {
+		quantity int
+		key      string
+		want     int
+	}{
+		{8, "a", http.StatusCreated},
+		{3, "b", http.StatusPaymentRequired},
+		{8, "a", http.StatusOK}, // A replay returns the original event
+		{2, "c", http.StatusCreated},
+		{1, "d", http.StatusPaymentRequired},
+	}
+	for _, step := range steps {
+		if w := recordUsage(h, 1, step.quantity, step.key); w.Code != step.want {
+			t.Errorf("recording %d units with key %q: status = %d (%s), want %d", step.quantity, step.key, w.Code, w.Body.String(), step.want)
+		}
+	}
+
+	used := 0
+	for _, e := range db.events {
+		used += e.Quantity
+	}
+	if used != 10 {
+		t.Errorf("recorded %d units, want the hard limit of 10", used)
+	}
+}
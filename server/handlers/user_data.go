@@ -62,6 +62,49 @@ func (h *UserDataHandler) GetUserOrders(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(orders)
 }
 
+// UserInvoicesResponse represents a page of the user's billing history
+type UserInvoicesResponse struct {
+	Invoices []models.BillingDocument `json:"invoices"`
+	Total    int                      `json:"total"`
+	Page     int                      `json:"page"`
+	Limit    int                      `json:"limit"`
+}
+
+// GetUserInvoices handles GET /api/user/invoices
+// It lists the user's orders and subscription renewal invoices, newest first.
+func (h *UserDataHandler) GetUserInvoices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+	if userID == "" {
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return
+	}
+
+	page, limit := parsePagination(r)
+
+	documents, total, err := h.DB.GetUserBillingHistory(userID, page, limit)
+	if err != nil {
+		log.Printf("[UserData] Error getting billing history for user %s: %v", userID, err)
+		http.Error(w, "Failed to fetch invoices", http.StatusInternalServerError)
+		return
+	}
+	if documents == nil {
+		documents = []models.BillingDocument{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UserInvoicesResponse{
+		Invoices: documents,
+		Total:    total,
+		Page:     page,
+		Limit:    limit,
+	})
+}
+
 // GetUserSubscription handles GET /api/user/subscription
 func (h *UserDataHandler) GetUserSubscription(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -86,16 +129,16 @@ func (h *UserDataHandler) GetUserSubscription(w http.ResponseWriter, r *http.Req
 	// Set content type header early
 	w.Header().Set("Content-Type", "application/json")
 
-	// Get subscription from database
-	subscription, err := h.DB.GetSubscriptionByUserID(userID)
+	// Get all subscriptions from database, the primary one first
+	subscriptions, err := h.DB.GetSubscriptionsByUserID(userID)
 	if err != nil {
-		log.Printf("[UserData] Error getting subscription for user %s: %v", userID, err)
+		log.Printf("[UserData] Error getting subscriptions for user %s: %v", userID, err)
 		// Return empty array for any database error (no rows, table doesn't exist, or other errors)
 		json.NewEncoder(w).Encode([]models.Subscription{})
 		return
 	}
 
-	json.NewEncoder(w).Encode([]models.Subscription{*subscription})
+	json.NewEncoder(w).Encode(subscriptions)
 }
 
 // GetBillingPortal handles GET /api/user/subscription/billing
@@ -40,27 +40,36 @@ type WebhookLinks struct {
 type WebhookAttributes interface{}
 
 type OrderAttributes struct {
-	StoreID                 int        `json:"store_id"`
-	CustomerID              int        `json:"customer_id"`
-	OrderID                 int        `json:"order_number"`
-	Status                  string     `json:"status"`
-	UserName                string     `json:"user_name"`
-	UserEmail               string     `json:"user_email"`
-	Refunded                bool       `json:"refunded"`
-	RefundedAt              *time.Time `json:"refunded_at"`
-	CreatedAt               time.Time  `json:"created_at"`
-	UpdatedAt               time.Time  `json:"updated_at"`
-	SubtotalFormatted       string     `json:"subtotal_formatted"`
-	TaxFormatted            string     `json:"tax_formatted"`
-	TotalFormatted          string     `json:"total_formatted"`
-	TaxInclusive            bool       `json:"tax_inclusive"`
-	RefundedAmountFormatted string     `json:"refunded_amount_formatted"`
+	StoreID                 int         `json:"store_id"`
+	CustomerID              int         `json:"customer_id"`
+	OrderID                 int         `json:"order_number"`
+	Status                  string      `json:"status"`
+	UserName                string      `json:"user_name"`
+	UserEmail               string      `json:"user_email"`
+	Refunded                bool        `json:"refunded"`
+	RefundedAt              *time.Time  `json:"refunded_at"`
+	CreatedAt               time.Time   `json:"created_at"`
+	UpdatedAt               time.Time   `json:"updated_at"`
+	Currency                string      `json:"currency"`
+	Subtotal                int         `json:"subtotal"` // Amounts are in the currency's minor unit
+	Tax                     int         `json:"tax"`
+	Total                   int         `json:"total"`
+	RefundedAmount          int         `json:"refunded_amount"`
+	DiscountTotal           int         `json:"discount_total"`
+	TaxName                 string      `json:"tax_name"`
+	TaxRate                 json.Number `json:"tax_rate"`
+	SubtotalFormatted       string      `json:"subtotal_formatted"`
+	TaxFormatted            string      `json:"tax_formatted"`
+	TotalFormatted          string      `json:"total_formatted"`
+	TaxInclusive            bool        `json:"tax_inclusive"`
+	RefundedAmountFormatted string      `json:"refunded_amount_formatted"`
 	URLs                    struct {
 		Receipt string `json:"receipt"`
 	} `json:"urls"`
 	FirstOrderItem struct {
 		ProductID int `json:"product_id"`
 		VariantID int `json:"variant_id"`
+		Quantity  int `json:"quantity"`
 	} `json:"first_order_item"`
 }
 
@@ -107,6 +116,34 @@ type SubscriptionAttributes struct {
 	} `json:"first_subscription_item"`
 }
 
+// SubscriptionInvoiceAttributes holds the attributes of the subscription invoice
+// sent with subscription_payment_* events. Amounts are in the currency's minor unit.
+type SubscriptionInvoiceAttributes struct {
+	StoreID        int        `json:"store_id"`
+	SubscriptionID int        `json:"subscription_id"`
+	CustomerID     int        `json:"customer_id"`
+	UserName       string     `json:"user_name"`
+	UserEmail      string     `json:"user_email"`
+	BillingReason  string     `json:"billing_reason"`
+	CardBrand      string     `json:"card_brand"`
+	CardLastFour   string     `json:"card_last_four"`
+	Currency       string     `json:"currency"`
+	Status         string     `json:"status"`
+	Refunded       bool       `json:"refunded"`
+	RefundedAt     *time.Time `json:"refunded_at"`
+	RefundedAmount int        `json:"refunded_amount"`
+	Subtotal       int        `json:"subtotal"`
+	DiscountTotal  int        `json:"discount_total"`
+	Tax            int        `json:"tax"`
+	Total          int        `json:"total"`
+	URLs           struct {
+		InvoiceURL string `json:"invoice_url"`
+	} `json:"urls"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	TestMode  bool      `json:"test_mode"`
+}
+
 func validateWebhookSignature(payload []byte, signature string, secret string) bool {
 	h := hmac.New(sha256.New, []byte(secret))
 	h.Write(payload)
@@ -118,21 +155,56 @@ func validateWebhookSignature(payload []byte, signature string, secret string) b
 // Implemented by database.DBInterface
 type Database interface {
 	// Order operations
-	CreateOrder(userID string, orderID int, customerID int, productID int, variantID int, status string, subtotalFormatted string, taxFormatted string, totalFormatted string, taxInclusive bool) error
-	UpdateOrderRefund(orderID int, refundedAt *time.Time, refundedAmountFormatted string) error
+	CreateOrder(userID string, orderID int, customerID int, productID int, variantID int, status string, currency string, subtotal int, tax int, total int, taxInclusive bool, receiptURL string, taxDetails models.OrderTaxDetails) error
+	UpdateOrderRefund(orderID int, refundedAt *time.Time, refundedAmount int) error
+
+	// Invoice operations
+	UpsertInvoice(invoice *models.Invoice) error
 
 	// Subscription operations
 	GetSubscriptionByUserID(userID string) (*models.Subscription, error)
-	CreateSubscription(userID string, subscriptionID int, orderID int, customerID int, productID int, variantID int, status string, renewsAt *time.Time, endsAt *time.Time, trialEndsAt *time.Time) error
-	UpdateSubscription(subscriptionID int, status string, cancelled bool, productID int, variantID int, renewsAt *time.Time, endsAt *time.Time, trialEndsAt *time.Time) error
-	UpdateUserSubscription(userID string, subscriptionID int, status string, productID int, variantID int, renewalDate *time.Time, endDate *time.Time) error
+	GetSubscriptionBySubscriptionID(subscriptionID int) (*models.Subscription, error)
+	CreateSubscription(userID string, subscriptionID int, orderID int, customerID int, productID int, variantID int, status string, renewsAt *time.Time, endsAt *time.Time, trialEndsAt *time.Time, source string) error
+	UpdateSubscription(subscriptionID int, status string, cancelled bool, productID int, variantID int, renewsAt *time.Time, endsAt *time.Time, trialEndsAt *time.Time, source string) error
+	SyncUserPrimarySubscription(userID string) error
+	UpdateSubscriptionItem(subscriptionID int, subscriptionItemID int, isUsageBased bool) error
+	UpdateSubscriptionPaymentMethodURL(subscriptionID int, url string) error
+
+	// Discount operations
+	RecordDiscountRedemption(code string, orderID int, userID string, currency string, discountTotal int, orderTotal int) error
+
+	// Trial operations
+	MarkTrialConverted(userID string, subscriptionID int, convertedAt time.Time) error
 
 	// Cache operations
 	InvalidateUserCache(userID string)
 }
 
+// PaymentRecovery reacts to failed and recovered subscription payments
+// Implemented by dunning.Service
+type PaymentRecovery interface {
+	PaymentFailed(subscriptionID int) error
+	PaymentRecovered(subscriptionID int) error
+}
+
+// ReferralRewards rewards referrers when the users they referred pay.
+// Implemented by referrals.Service
+type ReferralRewards interface {
+	OrderPaid(userID string, orderID int) error
+}
+
+// CreditTopUps credits top-ups bought with one-time orders and takes them back on refunds.
+// Implemented by credits.Service
+type CreditTopUps interface {
+	OrderPaid(userID string, orderID int, variantID int, quantity int) error
+	OrderRefunded(orderID int, total int, refundedAmount int) error
+}
+
 type WebhookHandler struct {
-	DB Database
+	DB        Database
+	Dunning   PaymentRecovery
+	Referrals ReferralRewards
+	Credits   CreditTopUps
 }
 
 func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
@@ -173,6 +245,7 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 	// Parse attributes based on event type
 	var orderAttrs OrderAttributes
 	var subscriptionAttrs SubscriptionAttributes
+	var invoiceAttrs SubscriptionInvoiceAttributes
 	var err2 error
 
 	// Convert attributes to appropriate type based on event
@@ -189,6 +262,21 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid payload attributes", http.StatusBadRequest)
 			return
 		}
+	case "subscription_payment_success",
+		"subscription_payment_failed",
+		"subscription_payment_recovered",
+		"subscription_payment_refunded":
+		attrsBytes, err := json.Marshal(payload.Data.Attributes)
+		if err != nil {
+			log.Printf("[Webhook] Error marshaling attributes: %v", err)
+			http.Error(w, "Invalid payload attributes", http.StatusBadRequest)
+			return
+		}
+		if err := json.Unmarshal(attrsBytes, &invoiceAttrs); err != nil {
+			log.Printf("[Webhook] Error unmarshaling invoice attributes: %v", err)
+			http.Error(w, "Invalid payload attributes", http.StatusBadRequest)
+			return
+		}
 	default:
 		attrsBytes, err := json.Marshal(payload.Data.Attributes)
 		if err != nil {
@@ -221,11 +309,49 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 			orderAttrs.FirstOrderItem.ProductID,
 			orderAttrs.FirstOrderItem.VariantID,
 			orderAttrs.Status,
-			orderAttrs.SubtotalFormatted,
-			orderAttrs.TaxFormatted,
-			orderAttrs.TotalFormatted,
+			orderAttrs.Currency,
+			orderAttrs.Subtotal,
+			orderAttrs.Tax,
+			orderAttrs.Total,
 			orderAttrs.TaxInclusive,
+			orderAttrs.URLs.Receipt,
+			models.OrderTaxDetails{
+				DiscountTotal:  orderAttrs.DiscountTotal,
+				TaxName:        orderAttrs.TaxName,
+				TaxRate:        orderAttrs.TaxRate.String(),
+				BillingCountry: payload.Meta.CustomData["billing_country"],
+				TaxNumber:      payload.Meta.CustomData["tax_number"],
+			},
 		)
+		if err2 == nil && payload.Meta.CustomData["discount_code"] != "" {
+			if err := h.DB.RecordDiscountRedemption(
+				payload.Meta.CustomData["discount_code"],
+				orderAttrs.OrderID,
+				payload.Meta.CustomData["user_id"],
+				orderAttrs.Currency,
+				orderAttrs.DiscountTotal,
+				orderAttrs.Total,
+			); err != nil {
+				log.Printf("[Webhook] Error recording discount redemption: %v", err)
+			}
+		}
+		if err2 == nil && orderAttrs.Status == "paid" && orderAttrs.Total > 0 && h.Referrals != nil {
+			if err := h.Referrals.OrderPaid(payload.Meta.CustomData["user_id"], orderAttrs.OrderID); err != nil {
+				log.Printf("[Webhook] Error rewarding referral: %v", err)
+			}
+		}
+		// Credited even if the order was stored by an earlier delivery, top-ups are only credited once
+		if orderAttrs.Status == "paid" && h.Credits != nil {
+			if err := h.Credits.OrderPaid(
+				payload.Meta.CustomData["user_id"],
+				orderAttrs.OrderID,
+				orderAttrs.FirstOrderItem.VariantID,
+				orderAttrs.FirstOrderItem.Quantity,
+			); err != nil {
+				log.Printf("[Webhook] Error crediting top-up: %v", err)
+				err2 = err
+			}
+		}
 		log.Printf("[Webhook] Processed order creation")
 
 	case "order_refunded":
@@ -233,12 +359,18 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 		err2 = h.DB.UpdateOrderRefund(
 			orderAttrs.OrderID,
 			orderAttrs.RefundedAt,
-			orderAttrs.RefundedAmountFormatted,
+			orderAttrs.RefundedAmount,
 		)
 		if err2 == nil && len(payload.Meta.CustomData) > 0 {
 			// Invalidate user cache after refund
 			h.DB.InvalidateUserCache(payload.Meta.CustomData["user_id"])
 		}
+		if h.Credits != nil {
+			if err := h.Credits.OrderRefunded(orderAttrs.OrderID, orderAttrs.Total, orderAttrs.RefundedAmount); err != nil {
+				log.Printf("[Webhook] Error reversing top-up: %v", err)
+				err2 = err
+			}
+		}
 		log.Printf("[Webhook] Processed order refund")
 
 	case "subscription_created":
@@ -275,6 +407,7 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 			subscriptionAttrs.RenewsAt,
 			subscriptionAttrs.EndsAt,
 			subscriptionAttrs.TrialEndsAt,
+			payload.Meta.EventName,
 		)
 		if err2 != nil {
 			log.Printf("[Webhook] Error creating subscription: %v", err2)
@@ -282,15 +415,15 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err2 = h.DB.UpdateUserSubscription(
-			userID,
-			subscriptionID,
-			subscriptionAttrs.Status,
-			subscriptionAttrs.ProductID,
-			subscriptionAttrs.VariantID,
-			subscriptionAttrs.RenewsAt,
-			subscriptionAttrs.EndsAt,
-		)
+		h.recordSubscriptionItem(subscriptionID, subscriptionAttrs)
+		h.recordPaymentMethodURL(subscriptionID, subscriptionAttrs)
+
+		// A subscription bought by a user on a free trial converts the trial
+		if err := h.DB.MarkTrialConverted(userID, subscriptionID, time.Now()); err != nil {
+			log.Printf("[Webhook] Error marking trial converted for user %s: %v", userID, err)
+		}
+
+		err2 = h.DB.SyncUserPrimarySubscription(userID)
 		if err2 != nil {
 			log.Printf("[Webhook] Error updating user subscription: %v", err2)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -301,17 +434,79 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 		h.DB.InvalidateUserCache(userID)
 		log.Printf("[Webhook] Successfully processed subscription creation and invalidated cache")
 
-	case "subscription_updated",
-		"subscription_payment_success",
+	case "subscription_payment_success",
+		"subscription_payment_failed",
 		"subscription_payment_recovered",
+		"subscription_payment_refunded":
+		log.Printf("[Webhook] Processing subscription invoice event: %s", payload.Meta.EventName)
+
+		invoiceID, err := strconv.Atoi(payload.Data.ID)
+		if err != nil {
+			log.Printf("[Webhook] Error converting invoice ID: %v", err)
+			http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+			return
+		}
+
+		userID := payload.Meta.CustomData["user_id"]
+		if _, err := uuid.Parse(userID); err != nil {
+			userID = ""
+		}
+
+		err2 = h.DB.UpsertInvoice(&models.Invoice{
+			InvoiceID:      invoiceID,
+			SubscriptionID: invoiceAttrs.SubscriptionID,
+			UserID:         userID,
+			CustomerID:     invoiceAttrs.CustomerID,
+			BillingReason:  invoiceAttrs.BillingReason,
+			Status:         invoiceAttrs.Status,
+			Currency:       invoiceAttrs.Currency,
+			Subtotal:       invoiceAttrs.Subtotal,
+			DiscountTotal:  invoiceAttrs.DiscountTotal,
+			Tax:            invoiceAttrs.Tax,
+			Total:          invoiceAttrs.Total,
+			Refunded:       invoiceAttrs.Refunded,
+			RefundedAmount: invoiceAttrs.RefundedAmount,
+			RefundedAt:     invoiceAttrs.RefundedAt,
+			CardBrand:      invoiceAttrs.CardBrand,
+			CardLastFour:   invoiceAttrs.CardLastFour,
+			InvoiceURL:     invoiceAttrs.URLs.InvoiceURL,
+			CreatedAt:      invoiceAttrs.CreatedAt,
+		})
+		if err2 != nil {
+			log.Printf("[Webhook] Error storing invoice %d: %v", invoiceID, err2)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// Failed and refunded payments change the subscription's status; the invoice
+		// payload only carries the subscription ID, so the rest is kept as stored.
+		// A failed payment stays entitled as past due while the dunning sequence runs.
+		switch payload.Meta.EventName {
+		case "subscription_payment_failed":
+			err2 = h.setSubscriptionStatus(invoiceAttrs.SubscriptionID, "past_due", payload.Meta.EventName)
+			if err2 == nil && h.Dunning != nil {
+				if err := h.Dunning.PaymentFailed(invoiceAttrs.SubscriptionID); err != nil {
+					log.Printf("[Webhook] Error starting dunning for subscription %d: %v", invoiceAttrs.SubscriptionID, err)
+				}
+			}
+		case "subscription_payment_success", "subscription_payment_recovered":
+			if h.Dunning != nil {
+				if err := h.Dunning.PaymentRecovered(invoiceAttrs.SubscriptionID); err != nil {
+					log.Printf("[Webhook] Error closing dunning for subscription %d: %v", invoiceAttrs.SubscriptionID, err)
+				}
+			}
+		case "subscription_payment_refunded":
+			err2 = h.setSubscriptionStatus(invoiceAttrs.SubscriptionID, "refunded", payload.Meta.EventName)
+		}
+		log.Printf("[Webhook] Processed subscription invoice event: %s", payload.Meta.EventName)
+
+	case "subscription_updated",
 		"subscription_plan_changed",
 		"subscription_paused",
 		"subscription_cancelled",
 		"subscription_expired",
 		"subscription_unpaused",
-		"subscription_resumed",
-		"subscription_payment_failed",
-		"subscription_payment_refunded":
+		"subscription_resumed":
 		log.Printf("[Webhook] Processing subscription event: %s", payload.Meta.EventName)
 
 		subscriptionID, err := strconv.Atoi(payload.Data.ID)
@@ -331,12 +526,6 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 		case "subscription_unpaused", "subscription_resumed":
 			status = "active"
 			cancelled = false
-		case "subscription_payment_failed":
-			status = "failed"
-			cancelled = false
-		case "subscription_payment_refunded":
-			status = "refunded"
-			cancelled = false
 		case "subscription_paused":
 			status = "pause"
 			cancelled = false
@@ -351,6 +540,7 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 			subscriptionAttrs.RenewsAt,
 			subscriptionAttrs.EndsAt,
 			subscriptionAttrs.TrialEndsAt,
+			payload.Meta.EventName,
 		)
 		if err2 != nil {
 			log.Printf("[Webhook] Error updating subscription: %v", err2)
@@ -358,19 +548,14 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		h.recordSubscriptionItem(subscriptionID, subscriptionAttrs)
+		h.recordPaymentMethodURL(subscriptionID, subscriptionAttrs)
+
 		// Update user's subscription details
 		if len(payload.Meta.CustomData) > 0 {
 			userID := payload.Meta.CustomData["user_id"]
 			log.Printf("[Webhook] Updating user subscription details - UserID: %s", userID)
-			err2 = h.DB.UpdateUserSubscription(
-				userID,
-				subscriptionID,
-				status,
-				subscriptionAttrs.ProductID,
-				subscriptionAttrs.VariantID,
-				subscriptionAttrs.RenewsAt,
-				subscriptionAttrs.EndsAt,
-			)
+			err2 = h.DB.SyncUserPrimarySubscription(userID)
 			if err2 != nil {
 				log.Printf("[Webhook] Error updating user subscription: %v", err2)
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -399,3 +584,45 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// recordSubscriptionItem stores the subscription item usage is reported against.
+// Failures are logged only since usage reporting retries once the item is known.
+func (h *WebhookHandler) recordSubscriptionItem(subscriptionID int, attrs SubscriptionAttributes) {
+	item := attrs.FirstSubscriptionItem
+	if item.ID == 0 {
+		return
+	}
+	if err := h.DB.UpdateSubscriptionItem(subscriptionID, item.ID, item.IsUsageBased); err != nil {
+		log.Printf("[Webhook] Error recording subscription item %d: %v", item.ID, err)
+	}
+}
+
+// recordPaymentMethodURL stores the signed payment method link sent with subscription events
+// so dunning emails can fall back to it
+func (h *WebhookHandler) recordPaymentMethodURL(subscriptionID int, attrs SubscriptionAttributes) {
+	if attrs.URLs.UpdatePaymentMethod == "" {
+		return
+	}
+	if err := h.DB.UpdateSubscriptionPaymentMethodURL(subscriptionID, attrs.URLs.UpdatePaymentMethod); err != nil {
+		log.Printf("[Webhook] Error recording payment method link for subscription %d: %v", subscriptionID, err)
+	}
+}
+
+// setSubscriptionStatus changes the status of a stored subscription and re-syncs its user's primary subscription
+func (h *WebhookHandler) setSubscriptionStatus(subscriptionID int, status string, source string) error {
+	subscription, err := h.DB.GetSubscriptionBySubscriptionID(subscriptionID)
+	if err != nil {
+		log.Printf("[Webhook] Error loading subscription %d: %v", subscriptionID, err)
+		return err
+	}
+
+	if err := h.DB.UpdateSubscription(subscriptionID, status, subscription.Cancelled, subscription.ProductID, subscription.VariantID, subscription.RenewsAt, subscription.EndsAt, subscription.TrialEndsAt, source); err != nil {
+		return err
+	}
+	if err := h.DB.SyncUserPrimarySubscription(subscription.UserID); err != nil {
+		return err
+	}
+
+	h.DB.InvalidateUserCache(subscription.UserID)
+	return nil
+}
@@ -9,11 +9,29 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"saas-server/database"
 	"saas-server/handlers"
 	"saas-server/middleware"
+	"saas-server/pkg/campaigns"
+	"saas-server/pkg/clock"
+	"saas-server/pkg/credits"
+	"saas-server/pkg/discounts"
+	"saas-server/pkg/dunning"
+	"saas-server/pkg/email"
+	"saas-server/pkg/lemonsqueezy"
+	"saas-server/pkg/lifecycle"
+	"saas-server/pkg/metering"
+	"saas-server/pkg/newsletter"
+	"saas-server/pkg/notifications"
+	"saas-server/pkg/referrals"
+	"saas-server/pkg/revenue"
+	"saas-server/pkg/segments"
+	"saas-server/pkg/ses"
+	"saas-server/pkg/signedlink"
+	"saas-server/pkg/trials"
 
 	"github.com/joho/godotenv"
 	"github.com/rs/cors"
@@ -60,13 +78,54 @@ func main() {
 	}
 	log.Println("Database migrations applied successfully")
 
+	// Email transport: Plunk or SMTP in production, a log or .eml file sink in development
+	mailer, err := email.NewMailer(email.LoadConfig())
+	if err != nil {
+		log.Fatal("Error configuring email:", err)
+	}
+
+	// Confirmation and unsubscribe links in emails are signed, so they work without a login.
+	// Unsubscribe links never expire, so the key has to be the same across restarts and
+	// replicas: without EMAIL_LINK_SECRET it is derived from JWT_SECRET.
+	var linkSigner *signedlink.Signer
+	switch {
+	case os.Getenv("EMAIL_LINK_SECRET") != "":
+		linkSigner = signedlink.NewSigner(os.Getenv("EMAIL_LINK_SECRET"))
+	case os.Getenv("JWT_SECRET") != "":
+		log.Println("Warning: EMAIL_LINK_SECRET is not set, deriving the key for links in emails from JWT_SECRET")
+		linkSigner = signedlink.NewDerivedSigner(os.Getenv("JWT_SECRET"), "email-links")
+	default:
+		log.Fatal("EMAIL_LINK_SECRET or JWT_SECRET must be set to sign links in emails")
+	}
+
+	// Users choose which notifications they get; emails they can opt out of carry a signed
+	// unsubscribe link. In-app notifications reach open streams through the broker.
+	notificationConfig := notifications.LoadConfig()
+	notificationBroker, err := notifications.NewBroker(notificationConfig.Broker, db, dbURL)
+	if err != nil {
+		log.Fatal("Error starting notification broker:", err)
+	}
+	notificationService := notifications.NewService(db, notificationBroker, linkSigner, clock.System{}, notificationConfig)
+
+	// Emails are queued in the outbox and delivered in the background with retries
+	outbox := email.NewOutbox(db, mailer, notificationService, clock.System{}, email.LoadOutboxConfig())
+	outbox.StartWorker(10 * time.Second)
+
 	// Initialize handlers and middleware
-	authHandler := handlers.NewAuthHandler(db, os.Getenv("JWT_SECRET"))
+	authHandler := handlers.NewAuthHandler(db, os.Getenv("JWT_SECRET"), mailer)
 	authMiddleware := middleware.NewAuthMiddleware(db, os.Getenv("JWT_SECRET"))
 	adminHandler := handlers.NewAdminHandler(db)
 	adminMiddleware := middleware.NewAdminMiddleware()
 	analyticsHandler := handlers.NewAnalyticsHandler(db)
 
+	// Signing in links the browser's anonymous page views and sessions to the user
+	authHandler.Visitors = db
+
+	// Free trials managed by the app, started on request or at signup
+	trialService := trials.NewService(db, lemonsqueezy.NewClient(), trials.EmailNotifier{Outbox: outbox}, clock.System{}, trials.LoadConfig())
+	trialService.StartTrialJob(1 * time.Hour)
+	authHandler.Trials = trialService
+
 	// Create router
 	mux := http.NewServeMux()
 
@@ -89,8 +148,34 @@ func main() {
 	mux.Handle("/user/profile/update", authMiddleware.RequireAuth(http.HandlerFunc(authHandler.UpdateProfile)))
 	mux.Handle("/user/verify-user", authMiddleware.RequireAuth(http.HandlerFunc(authHandler.VerifyUser)))
 
+	// Notification preferences, in-app notifications with their live stream, and the unsubscribe link in emails - public, signed
+	notificationHandler := handlers.NewNotificationHandler(notificationService)
+	mux.Handle("/user/notifications", authMiddleware.RequireAuth(http.HandlerFunc(notificationHandler.Preferences)))
+	mux.Handle("/user/notifications/inbox", authMiddleware.RequireAuth(http.HandlerFunc(notificationHandler.Inbox)))
+	mux.Handle("/user/notifications/read", authMiddleware.RequireAuth(http.HandlerFunc(notificationHandler.MarkRead)))
+	mux.Handle("/user/notifications/read-all", authMiddleware.RequireAuth(http.HandlerFunc(notificationHandler.MarkAllRead)))
+	mux.Handle("/user/notifications/stream", authMiddleware.RequireAuth(http.HandlerFunc(notificationHandler.Stream)))
+	mux.HandleFunc("/api/notifications/unsubscribe", notificationHandler.Unsubscribe)
+
+	// Discount codes, used at checkout and for referral rewards
+	discountService := discounts.NewService(db, lemonsqueezy.NewClient(), clock.System{}, os.Getenv("LEMON_SQUEEZY_STORE_ID"))
+
+	// Prepaid credits: top-ups, grants, debits and expiry
+	creditService := credits.NewService(db, clock.System{}, credits.LoadConfig())
+	creditService.StartExpiryJob(1 * time.Hour)
+
+	// Referral program: links, signup attribution and rewards after the first paid order
+	referralService := referrals.NewService(db, discountService, creditService, clock.System{}, referrals.LoadConfig())
+	referralService.StartRewardJob(1 * time.Hour)
+	authHandler.Referrals = referralService
+	referralHandler := handlers.NewReferralHandler(referralService)
+	mux.HandleFunc("/r/", referralHandler.Redirect)
+	mux.Handle("/api/user/referrals", authMiddleware.RequireAuth(http.HandlerFunc(referralHandler.Dashboard)))
+
 	// Payment webhook routes - initialize handler once for better resource management
-	webhookHandler := &handlers.WebhookHandler{DB: db}
+	dunningService := dunning.NewService(db, lemonsqueezy.NewClient(), dunning.EmailNotifier{Outbox: outbox}, notificationService, clock.System{}, dunning.LoadConfig())
+	dunningService.StartDunningJob(1 * time.Hour)
+	webhookHandler := &handlers.WebhookHandler{DB: db, Dunning: dunningService, Referrals: referralService, Credits: creditService}
 	mux.HandleFunc("/payment/webhook", webhookHandler.HandleWebhook)
 
 	// Product routes
@@ -99,22 +184,64 @@ func main() {
 	mux.HandleFunc("/api/products/", productsHandler.GetProduct)
 	mux.HandleFunc("/api/products/store/", productsHandler.GetProductsByStore)
 
-	// Checkout routes
-	checkoutHandler := handlers.NewCheckoutHandler(db)
-	mux.HandleFunc("/api/checkout", checkoutHandler.CreateCheckout)
+	// Checkout routes (protected)
+	checkoutHandler := handlers.NewCheckoutHandler(db, discountService)
+	mux.Handle("/api/checkout", authMiddleware.RequireAuth(http.HandlerFunc(checkoutHandler.CreateCheckout)))
 
 	// User data routes (protected)
 	userDataHandler := handlers.NewUserDataHandler(db)
 	mux.Handle("/api/user/orders", authMiddleware.RequireAuth(http.HandlerFunc(userDataHandler.GetUserOrders)))
+	mux.Handle("/api/user/invoices", authMiddleware.RequireAuth(http.HandlerFunc(userDataHandler.GetUserInvoices)))
 	mux.Handle("/api/user/subscription", authMiddleware.RequireAuth(http.HandlerFunc(userDataHandler.GetUserSubscription)))
 	mux.Handle("/api/user/subscription/billing", authMiddleware.RequireAuth(http.HandlerFunc(userDataHandler.GetBillingPortal)))
 
+	// Billing profile routes (protected)
+	billingProfileHandler := handlers.NewBillingProfileHandler(db)
+	mux.Handle("/api/user/billing-profile", authMiddleware.RequireAuth(http.HandlerFunc(billingProfileHandler.BillingProfile)))
+
+	// Trial routes (protected)
+	trialHandler := handlers.NewTrialHandler(db, trialService)
+	mux.Handle("/api/user/trial", authMiddleware.RequireAuth(http.HandlerFunc(trialHandler.Trial)))
+	mux.Handle("/api/user/trial/convert", authMiddleware.RequireAuth(http.HandlerFunc(trialHandler.Convert)))
+
+	// Subscription lifecycle routes (protected)
+	subscriptionHandler := handlers.NewSubscriptionHandler(db)
+	mux.Handle("/api/user/subscription/cancel", authMiddleware.RequireAuth(http.HandlerFunc(subscriptionHandler.Cancel)))
+	mux.Handle("/api/user/subscription/resume", authMiddleware.RequireAuth(http.HandlerFunc(subscriptionHandler.Resume)))
+	mux.Handle("/api/user/subscription/pause", authMiddleware.RequireAuth(http.HandlerFunc(subscriptionHandler.Pause)))
+	mux.Handle("/api/user/subscription/change-plan", authMiddleware.RequireAuth(http.HandlerFunc(subscriptionHandler.ChangePlan)))
+	mux.Handle("/api/user/subscription/history", authMiddleware.RequireAuth(http.HandlerFunc(subscriptionHandler.History)))
+
+	// Usage metering routes. Aggregated usage is reported to Lemon Squeezy every hour, and the
+	// admin is alerted about reports that need to be checked against it.
+	usageReporter := metering.NewUsageReportingService(db, lemonsqueezy.NewClient(), outbox, clock.System{}, metering.LoadConfig())
+	usageReporter.StartReportingJob(1 * time.Hour)
+	usageHandler := handlers.NewUsageHandler(db, usageReporter)
+	internalMiddleware := middleware.NewInternalMiddleware(os.Getenv("INTERNAL_API_KEY"))
+	mux.Handle("/internal/usage", internalMiddleware.RequireInternalKey(http.HandlerFunc(usageHandler.RecordUsage)))
+	mux.Handle("/api/user/usage", authMiddleware.RequireAuth(http.HandlerFunc(usageHandler.GetUserUsage)))
+
+	// Credit routes
+	creditsHandler := handlers.NewCreditsHandler(creditService)
+	mux.Handle("/internal/credits/debit", internalMiddleware.RequireInternalKey(http.HandlerFunc(creditsHandler.Debit)))
+	mux.Handle("/api/user/credits", authMiddleware.RequireAuth(http.HandlerFunc(creditsHandler.Balance)))
+	mux.Handle("/api/user/credits/transactions", authMiddleware.RequireAuth(http.HandlerFunc(creditsHandler.Transactions)))
+
+	// Revenue metrics, snapshotted hourly so the current day stays up to date
+	revenueCurrency := os.Getenv("REVENUE_CURRENCY")
+	if revenueCurrency == "" {
+		revenueCurrency = "USD"
+	}
+	revenue.NewSnapshotService(db, lemonsqueezy.NewClient(), revenueCurrency).StartSnapshotJob(1 * time.Hour)
+	revenueHandler := handlers.NewRevenueHandler(db, revenueCurrency)
+
 	// Analytics routes (public)
 	mux.HandleFunc("/api/analytics/pageview", analyticsHandler.TrackPageView)
 
 	// Admin routes
 	mux.HandleFunc("/admin/login", adminHandler.Login)
 	mux.Handle("/admin/users", adminMiddleware.RequireAdmin(http.HandlerFunc(adminHandler.GetUsers)))
+	mux.Handle("/admin/subscriptions/history", adminMiddleware.RequireAdmin(http.HandlerFunc(adminHandler.GetSubscriptionHistory)))
 
 	// Admin health check endpoint (for connection testing)
 	mux.HandleFunc("/admin/health", func(w http.ResponseWriter, r *http.Request) {
@@ -123,14 +250,23 @@ func main() {
 	})
 
 	// Add the new admin email route
-	emailHandler := &handlers.Handler{DB: db}
+	emailHandler := &handlers.Handler{DB: db, Outbox: outbox}
 	mux.Handle("/admin/send-email", adminMiddleware.RequireAdmin(http.HandlerFunc(emailHandler.AdminSendEmailHandler)))
+	mux.Handle("/admin/emails/templates", adminMiddleware.RequireAdmin(http.HandlerFunc(emailHandler.ListEmailTemplates)))
+	mux.Handle("/admin/emails/preview", adminMiddleware.RequireAdmin(http.HandlerFunc(emailHandler.PreviewEmailTemplate)))
+
+	// Bounce and complaint notifications Amazon SES publishes to SNS, and the resulting
+	// suppression list. Plunk doesn't forward bounces, so this needs the smtp transport via SES.
+	emailSuppressionHandler := handlers.NewEmailSuppressionHandler(db, ses.NewReceiver(strings.Split(os.Getenv("EMAIL_EVENTS_TOPIC_ARNS"), ",")))
+	mux.HandleFunc("/webhooks/email", emailSuppressionHandler.HandleEvents)
+	mux.Handle("/admin/emails/suppressions", adminMiddleware.RequireAdmin(http.HandlerFunc(emailSuppressionHandler.Suppressions)))
+	mux.Handle("/admin/emails/suppressions/lift", adminMiddleware.RequireAdmin(http.HandlerFunc(emailSuppressionHandler.LiftSuppression)))
 
 	// Rate limiter for public endpoints (e.g., 5 requests per minute)
 	publicRateLimiter := middleware.NewRateLimiter(1 * time.Minute, 5)
 
 	// Contact form route - public, rate-limited only (no CSRF)
-	contactHandler := handlers.NewContactHandler()
+	contactHandler := handlers.NewContactHandler(outbox)
 	mux.Handle("/api/contact", publicRateLimiter.Limit(http.HandlerFunc(contactHandler.SendContactEmail)))
 
 	// Early access waitlist route - public, rate-limited only (no CSRF)
@@ -140,13 +276,68 @@ func main() {
 	// Admin-only route to view all early access registrations
 	mux.Handle("/admin/early-access", adminMiddleware.RequireAdmin(http.HandlerFunc(earlyAccessHandler.GetAllEarlyAccessRegistrations)))
 
-	// Newsletter subscription routes - public, no authentication required
-	newsletterHandler := handlers.NewNewsletterHandler(db)
-	mux.HandleFunc("/api/newsletter/subscribe", newsletterHandler.Subscribe)
+	// Newsletter subscription routes - public, no authentication required. Subscribing sends a
+	// confirmation email, so it is rate-limited.
+	newsletterService := newsletter.NewService(db, outbox, mailer, linkSigner, clock.System{}, newsletter.LoadConfig())
+	newsletterHandler := handlers.NewNewsletterHandler(db, newsletterService)
+	mux.Handle("/api/newsletter/subscribe", publicRateLimiter.Limit(http.HandlerFunc(newsletterHandler.Subscribe)))
+	mux.HandleFunc("/api/newsletter/confirm", newsletterHandler.Confirm)
+	mux.HandleFunc("/api/newsletter/unsubscribe", newsletterHandler.Unsubscribe)
 
 	// Admin-only route to view all newsletter subscriptions
 	mux.Handle("/admin/newsletter", adminMiddleware.RequireAdmin(http.HandlerFunc(newsletterHandler.GetAllNewsletterSubscriptions)))
 
+	// Admin audience segment routes
+	segmentHandler := handlers.NewSegmentHandler(segments.NewService(db, clock.System{}))
+	mux.Handle("/admin/segments", adminMiddleware.RequireAdmin(http.HandlerFunc(segmentHandler.Segments)))
+	mux.Handle("/admin/segments/detail", adminMiddleware.RequireAdmin(http.HandlerFunc(segmentHandler.Segment)))
+	mux.Handle("/admin/segments/fields", adminMiddleware.RequireAdmin(http.HandlerFunc(segmentHandler.Fields)))
+	mux.Handle("/admin/segments/preview", adminMiddleware.RequireAdmin(http.HandlerFunc(segmentHandler.Preview)))
+	mux.Handle("/admin/segments/members", adminMiddleware.RequireAdmin(http.HandlerFunc(segmentHandler.Members)))
+	mux.Handle("/admin/segments/export", adminMiddleware.RequireAdmin(http.HandlerFunc(segmentHandler.Export)))
+
+	// Newsletter campaigns: scheduled campaigns are queued batch by batch every minute
+	campaignService := campaigns.NewService(db, outbox, newsletterService, linkSigner, clock.System{}, campaigns.LoadConfig())
+	campaignService.StartCampaignJob(1 * time.Minute)
+	campaignHandler := handlers.NewCampaignHandler(campaignService)
+	mux.Handle("/admin/campaigns", adminMiddleware.RequireAdmin(http.HandlerFunc(campaignHandler.Campaigns)))
+	mux.Handle("/admin/campaigns/detail", adminMiddleware.RequireAdmin(http.HandlerFunc(campaignHandler.Campaign)))
+	mux.Handle("/admin/campaigns/preview", adminMiddleware.RequireAdmin(http.HandlerFunc(campaignHandler.Preview)))
+	mux.Handle("/admin/campaigns/test", adminMiddleware.RequireAdmin(http.HandlerFunc(campaignHandler.SendTest)))
+	mux.Handle("/admin/campaigns/schedule", adminMiddleware.RequireAdmin(http.HandlerFunc(campaignHandler.Schedule)))
+	mux.Handle("/admin/campaigns/cancel", adminMiddleware.RequireAdmin(http.HandlerFunc(campaignHandler.Cancel)))
+	mux.Handle("/admin/campaigns/recipients", adminMiddleware.RequireAdmin(http.HandlerFunc(campaignHandler.Recipients)))
+
+	// Campaign open and click tracking - public, linked from campaign emails
+	mux.HandleFunc("/api/email/open", campaignHandler.TrackOpen)
+	mux.HandleFunc("/api/email/click", campaignHandler.TrackClick)
+
+	// Lifecycle emails: rules following up on product events are checked every 15 minutes
+	lifecycleService := lifecycle.NewService(db, outbox, clock.System{}, lifecycle.LoadConfig())
+	lifecycleService.StartLifecycleJob(15 * time.Minute)
+	lifecycleHandler := handlers.NewLifecycleHandler(lifecycleService)
+	mux.Handle("/admin/lifecycle", adminMiddleware.RequireAdmin(http.HandlerFunc(lifecycleHandler.Rules)))
+	mux.Handle("/admin/lifecycle/detail", adminMiddleware.RequireAdmin(http.HandlerFunc(lifecycleHandler.Rule)))
+	mux.Handle("/admin/lifecycle/options", adminMiddleware.RequireAdmin(http.HandlerFunc(lifecycleHandler.Options)))
+
+	// Admin-only route to manage plan usage limits
+	mux.Handle("/admin/usage/limits", adminMiddleware.RequireAdmin(http.HandlerFunc(usageHandler.PlanUsageLimits)))
+	mux.Handle("/admin/usage/reports", adminMiddleware.RequireAdmin(http.HandlerFunc(usageHandler.ReportsNeedingReview)))
+	mux.Handle("/admin/usage/reports/resolve", adminMiddleware.RequireAdmin(http.HandlerFunc(usageHandler.ResolveReport)))
+
+	// Admin discount code routes
+	discountHandler := handlers.NewDiscountHandler(discountService)
+	mux.Handle("/admin/discounts", adminMiddleware.RequireAdmin(http.HandlerFunc(discountHandler.Discounts)))
+	mux.Handle("/admin/discounts/disable", adminMiddleware.RequireAdmin(http.HandlerFunc(discountHandler.Disable)))
+
+	// Admin route to grant credits
+	mux.Handle("/admin/credits/grant", adminMiddleware.RequireAdmin(http.HandlerFunc(creditsHandler.Grant)))
+
+	// Admin revenue metrics routes
+	mux.Handle("/admin/metrics/revenue", adminMiddleware.RequireAdmin(http.HandlerFunc(revenueHandler.GetRevenueMetrics)))
+	mux.Handle("/admin/metrics/cohorts", adminMiddleware.RequireAdmin(http.HandlerFunc(revenueHandler.GetCohortMetrics)))
+	mux.Handle("/admin/metrics/tax", adminMiddleware.RequireAdmin(http.HandlerFunc(revenueHandler.GetTaxReport)))
+
 	// Analytics routes (protected)
 	mux.Handle("/admin/analytics/user-journey", adminMiddleware.RequireAdmin(http.HandlerFunc(analyticsHandler.GetUserJourney)))
 	mux.Handle("/admin/analytics/visitor-journey", adminMiddleware.RequireAdmin(http.HandlerFunc(analyticsHandler.GetVisitorJourney)))
@@ -165,7 +356,7 @@ func main() {
 			os.Getenv("FRONTEND_URL"),
 		},
 		AllowedMethods:      []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:      []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Requested-With"},
+		AllowedHeaders:      []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Requested-With", "X-Visitor-ID"},
 		ExposedHeaders:      []string{"Link"},
 		AllowCredentials:    true,
 		MaxAge:              300, // Maximum value not ignored by any of major browsers
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+)
+
+// InternalAPIKeyHeader is the header internal services use to authenticate
+const InternalAPIKeyHeader = "X-Internal-API-Key"
+
+// InternalMiddleware protects routes that are only called by our own services
+type InternalMiddleware struct {
+	apiKey []byte
+}
+
+// NewInternalMiddleware creates a new InternalMiddleware with the shared API key
+func NewInternalMiddleware(apiKey string) *InternalMiddleware {
+	return &InternalMiddleware{apiKey: []byte(apiKey)}
+}
+
+// RequireInternalKey rejects requests that don't carry the internal API key.
+// All requests are rejected when no key is configured.
+func (m *InternalMiddleware) RequireInternalKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(m.apiKey) == 0 {
+			log.Printf("[Internal Middleware] INTERNAL_API_KEY not set, rejecting request to %s", r.URL.Path)
+			http.Error(w, "Internal API disabled", http.StatusServiceUnavailable)
+			return
+		}
+
+		key := r.Header.Get(InternalAPIKeyHeader)
+		if subtle.ConstantTimeCompare([]byte(key), m.apiKey) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+)
+
+// BillingProfile holds the billing address and tax ID used to pre-fill checkouts and invoices.
+// Country is an ISO 3166-1 alpha-2 code and TaxNumber is normalised, e.g. "DE123456789".
+type BillingProfile struct {
+	UserID       string    `json:"user_id"`
+	Name         string    `json:"name"`
+	Company      string    `json:"company"`
+	AddressLine1 string    `json:"address_line1"`
+	AddressLine2 string    `json:"address_line2"`
+	City         string    `json:"city"`
+	State        string    `json:"state"`
+	PostalCode   string    `json:"postal_code"`
+	Country      string    `json:"country"`
+	TaxNumber    string    `json:"tax_number"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// OrderTaxDetails is the tax breakdown of an order. BillingCountry and TaxNumber are the
+// values the checkout was pre-filled with. TaxRate is a percentage, e.g. "20.00".
+type OrderTaxDetails struct {
+	DiscountTotal  int    `json:"discount_total"`
+	TaxName        string `json:"tax_name,omitempty"`
+	TaxRate        string `json:"tax_rate"`
+	BillingCountry string `json:"billing_country,omitempty"`
+	TaxNumber      string `json:"tax_number,omitempty"`
+}
+
+// TaxReportRow aggregates orders sharing a country, tax name, rate and currency.
+// Amounts are in the currency's minor unit; Refunded is the part of Total that was refunded.
+type TaxReportRow struct {
+	Country  string `json:"country"`
+	TaxName  string `json:"tax_name"`
+	TaxRate  string `json:"tax_rate"`
+	Currency string `json:"currency"`
+	Orders   int    `json:"orders"`
+	Subtotal int    `json:"subtotal"`
+	Tax      int    `json:"tax"`
+	Total    int    `json:"total"`
+	Refunded int    `json:"refunded"`
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"time"
+)
+
+// Campaign audiences
+const (
+	AudienceNewsletter  = "newsletter"   // Confirmed newsletter subscribers
+	AudienceUsers       = "users"        // Users with a verified email address
+	AudienceEarlyAccess = "early_access" // Early access signups
+	AudienceSegment     = "segment"      // Members of a saved segment
+)
+
+// Campaign statuses
+const (
+	CampaignDraft     = "draft"
+	CampaignScheduled = "scheduled"
+	CampaignSending   = "sending"
+	CampaignSent      = "sent"
+	CampaignCancelled = "cancelled"
+)
+
+// Campaign is a newsletter email broadcast to an audience
+type Campaign struct {
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Subject     string     `json:"subject"`
+	HTMLBody    string     `json:"html_body"`
+	TextBody    string     `json:"text_body,omitempty"`
+	Audience    string     `json:"audience"`
+	SegmentID   *int       `json:"segment_id,omitempty"` // Set for the segment audience
+	Status      string     `json:"status"`
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// CampaignStats summarizes the delivery and engagement of a campaign.
+// Opens are a lower bound, as many clients block the tracking pixel.
+type CampaignStats struct {
+	Recipients int `json:"recipients"`
+	Pending    int `json:"pending"`    // Not queued yet
+	Queued     int `json:"queued"`     // Waiting in the outbox
+	Sent       int `json:"sent"`       // Accepted by the provider
+	Failed     int `json:"failed"`     // Given up on after retries, or skipped
+	Suppressed int `json:"suppressed"` // Not sent because the address bounced, complained or opted out
+	Opened     int `json:"opened"`     // Unique opens
+	Clicked    int `json:"clicked"`    // Unique clicks
+	Clicks     int `json:"clicks"`     // Total clicks
+}
+
+// CampaignLinkStats counts the clicks on one link of a campaign
+type CampaignLinkStats struct {
+	URL     string `json:"url"`
+	Clicks  int    `json:"clicks"`
+	Clicked int    `json:"clicked"` // Unique recipients
+}
+
+// CampaignWithStats is a campaign with its stats
+type CampaignWithStats struct {
+	Campaign
+	Stats CampaignStats       `json:"stats"`
+	Links []CampaignLinkStats `json:"links,omitempty"`
+}
+
+// CampaignRecipient is a single recipient of a campaign with their delivery status, which
+// is one of pending, queued, sent, failed, suppressed, opted_out, skipped or cancelled
+type CampaignRecipient struct {
+	ID         int64      `json:"id"`
+	CampaignID int        `json:"campaign_id"`
+	Email      string     `json:"email"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	SentAt     *time.Time `json:"sent_at,omitempty"`
+	OpenedAt   *time.Time `json:"opened_at,omitempty"`
+	OpenCount  int        `json:"open_count"`
+	ClickedAt  *time.Time `json:"clicked_at,omitempty"`
+	ClickCount int        `json:"click_count"`
+}
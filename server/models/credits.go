@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+)
+
+// Credit transaction types
+const (
+	CreditPurchase    = "purchase"    // Top-up bought through a one-time checkout
+	CreditGrant       = "grant"       // Credits given away, e.g. by an admin or as a referral reward
+	CreditConsumption = "consumption" // Credits spent on prepaid usage
+	CreditExpiration  = "expiration"  // Purchased or granted credits that expired unused
+	CreditReversal    = "reversal"    // Purchased credits taken back after a refund
+)
+
+// CreditBalance is a user's spendable credit balance. Version changes with every
+// transaction and can be passed back when debiting to detect concurrent changes.
+type CreditBalance struct {
+	Balance  int         `json:"balance"`
+	Version  int         `json:"version"`
+	Expiring []CreditLot `json:"expiring"` // Credits with an expiry date, soonest first
+}
+
+// CreditLot is what remains of a single purchase or grant
+type CreditLot struct {
+	ID        int64      `json:"id"`
+	Amount    int        `json:"amount"`
+	Remaining int        `json:"remaining"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// CreditTransaction is a single change of a user's credit balance. Each transaction is
+// backed by balanced ledger entries against one of the system accounts.
+type CreditTransaction struct {
+	ID           int64     `json:"id"`
+	UserID       string    `json:"user_id"`
+	Type         string    `json:"type"`
+	Amount       int       `json:"amount"` // Negative for debits
+	BalanceAfter int       `json:"balance_after"`
+	Description  string    `json:"description,omitempty"`
+	OrderID      *int      `json:"order_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CreditDebitRequest represents the data sent by internal services to spend a user's credits
+type CreditDebitRequest struct {
+	UserID          string `json:"user_id"`
+	Amount          int    `json:"amount"`
+	IdempotencyKey  string `json:"idempotency_key"`
+	ExpectedVersion *int   `json:"expected_version,omitempty"` // Fail instead of debiting if the balance changed since it was read
+	Description     string `json:"description,omitempty"`
+}
+
+// CreditGrantRequest represents the data sent by an admin to give a user credits
+type CreditGrantRequest struct {
+	UserID      string     `json:"user_id"`
+	Amount      int        `json:"amount"`
+	Description string     `json:"description,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
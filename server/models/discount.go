@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+// Discount is a discount code created through the payment provider.
+// Amount is a percentage when AmountType is "percent", otherwise it is in the store currency's minor unit.
+type Discount struct {
+	ID                 int        `json:"id"`
+	ProviderDiscountID int        `json:"provider_discount_id"`
+	Name               string     `json:"name"`
+	Code               string     `json:"code"`
+	Amount             int        `json:"amount"`
+	AmountType         string     `json:"amount_type"`
+	Duration           string     `json:"duration"`
+	DurationInMonths   *int       `json:"duration_in_months,omitempty"`
+	MaxRedemptions     *int       `json:"max_redemptions,omitempty"`
+	VariantIDs         []int      `json:"variant_ids"`
+	StartsAt           *time.Time `json:"starts_at,omitempty"`
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`
+	Status             string     `json:"status"`
+	DisabledAt         *time.Time `json:"disabled_at,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// DiscountStats summarises the redemptions of a discount.
+// Totals are per currency, in the currency's minor unit.
+type DiscountStats struct {
+	Redemptions    int                     `json:"redemptions"`
+	LastRedeemedAt *time.Time              `json:"last_redeemed_at,omitempty"`
+	Totals         []DiscountCurrencyTotal `json:"totals"`
+}
+
+// DiscountCurrencyTotal is the amount discounted and the revenue of orders placed with a discount in one currency
+type DiscountCurrencyTotal struct {
+	Currency      string `json:"currency"`
+	DiscountTotal int    `json:"discount_total"`
+	OrderTotal    int    `json:"order_total"`
+}
+
+// DiscountWithStats is a discount together with its redemption stats
+type DiscountWithStats struct {
+	Discount
+	Stats DiscountStats `json:"stats"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// DunningCase tracks a failed subscription payment from the first failure until the
+// payment is recovered or the grace period runs out and the subscription is downgraded
+type DunningCase struct {
+	ID             int        `json:"id"`
+	SubscriptionID int        `json:"subscription_id"`
+	UserID         string     `json:"user_id"`
+	Status         string     `json:"status"`
+	FailedAt       time.Time  `json:"failed_at"`
+	GraceEndsAt    time.Time  `json:"grace_ends_at"`
+	EmailsSent     int        `json:"emails_sent"`
+	LastEmailAt    *time.Time `json:"last_email_at,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+// OutboundEmail is an email waiting in the outbox or already delivered by the outbox worker
+type OutboundEmail struct {
+	ID                int64             `json:"id"`
+	IdempotencyKey    string            `json:"idempotency_key,omitempty"`
+	To                string            `json:"to"`
+	Subject           string            `json:"subject"`
+	HTML              string            `json:"html"`
+	Text              string            `json:"text,omitempty"`
+	Headers           map[string]string `json:"headers,omitempty"`
+	Critical          bool              `json:"critical"`           // Security-critical, e.g. password resets
+	Category          string            `json:"category,omitempty"` // Notification category recipients can opt out of
+	Status            string            `json:"status"`
+	Attempts          int               `json:"attempts"`
+	NextAttemptAt     time.Time         `json:"next_attempt_at"`
+	LastError         string            `json:"last_error,omitempty"`
+	ProviderMessageID string            `json:"provider_message_id,omitempty"`
+	SentAt            *time.Time        `json:"sent_at,omitempty"`
+	CreatedAt         time.Time         `json:"created_at"`
+	UpdatedAt         time.Time         `json:"updated_at"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// Reasons an email address is suppressed
+const (
+	SuppressionBounce    = "bounce"
+	SuppressionComplaint = "complaint"
+)
+
+// EmailSuppression is an address that hard bounced or marked our email as spam.
+// Emails to it are skipped until an admin lifts the suppression.
+type EmailSuppression struct {
+	ID                int        `json:"id"`
+	Email             string     `json:"email"`
+	Reason            string     `json:"reason"`
+	Details           string     `json:"details,omitempty"`
+	ProviderMessageID string     `json:"provider_message_id,omitempty"`
+	EventCount        int        `json:"event_count"`
+	LiftedAt          *time.Time `json:"lifted_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+// Invoice represents a subscription invoice. Amounts are in the currency's minor unit.
+type Invoice struct {
+	ID             int        `json:"id"`
+	InvoiceID      int        `json:"invoice_id"`
+	SubscriptionID int        `json:"subscription_id"`
+	UserID         string     `json:"user_id,omitempty"`
+	CustomerID     int        `json:"customer_id"`
+	BillingReason  string     `json:"billing_reason"`
+	Status         string     `json:"status"`
+	Currency       string     `json:"currency"`
+	Subtotal       int        `json:"subtotal"`
+	DiscountTotal  int        `json:"discount_total"`
+	Tax            int        `json:"tax"`
+	Total          int        `json:"total"`
+	Refunded       bool       `json:"refunded"`
+	RefundedAmount int        `json:"refunded_amount"`
+	RefundedAt     *time.Time `json:"refunded_at,omitempty"`
+	CardBrand      string     `json:"card_brand,omitempty"`
+	CardLastFour   string     `json:"card_last_four,omitempty"`
+	InvoiceURL     string     `json:"invoice_url,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// BillingDocument is a single entry in a user's invoice and receipt history.
+// Type is "order" for one-off and initial purchases and "subscription_invoice" for renewals.
+type BillingDocument struct {
+	Type           string    `json:"type"`
+	ID             int       `json:"id"`
+	Status         string    `json:"status"`
+	BillingReason  string    `json:"billing_reason,omitempty"`
+	Currency       string    `json:"currency"`
+	Total          int       `json:"total"`
+	TotalFormatted string    `json:"total_formatted"`
+	Refunded       bool      `json:"refunded"`
+	DownloadURL    string    `json:"download_url,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
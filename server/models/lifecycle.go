@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+)
+
+// Lifecycle events that rules are triggered by
+const (
+	LifecycleSignup      = "signup"       // The user signed up
+	LifecycleUnverified  = "unverified"   // The user signed up and hasn't verified their email address
+	LifecycleTrialEnding = "trial_ending" // The user's free trial ends
+	LifecycleInactive    = "inactive"     // The user was last seen
+	LifecycleCancelled   = "cancelled"    // The user cancelled a subscription
+)
+
+// Lifecycle exit conditions, which stop an email to users who meet them when it is due
+const (
+	LifecycleExitVerified   = "verified"   // The user verified their email address
+	LifecycleExitSubscribed = "subscribed" // The user has an active subscription that isn't cancelled
+)
+
+// Lifecycle send statuses
+const (
+	LifecycleSent   = "sent"
+	LifecycleExited = "exited"
+)
+
+// LifecycleRule sends an email template a fixed time after a product event
+type LifecycleRule struct {
+	ID             int        `json:"id"`
+	Name           string     `json:"name"`
+	Event          string     `json:"event"`
+	DelayMinutes   int        `json:"delay_minutes"` // Negative to send before events known in advance, like the end of a trial
+	Template       string     `json:"template"`
+	ExitConditions []string   `json:"exit_conditions"`
+	Enabled        bool       `json:"enabled"`
+	EnabledAt      *time.Time `json:"enabled_at,omitempty"`
+	Sent           int        `json:"sent"`   // Users the email was sent to
+	Exited         int        `json:"exited"` // Users who met an exit condition instead
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// LifecycleCandidate is a user a lifecycle rule is due for
+type LifecycleCandidate struct {
+	UserID        string
+	Email         string
+	Name          string
+	Language      string
+	EventAt       time.Time
+	ExitCondition string // The exit condition the user meets, if any
+}
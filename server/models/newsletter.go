@@ -4,13 +4,19 @@ import (
 	"time"
 )
 
-// NewsletterSubscription represents a newsletter subscriber
+// NewsletterSubscription represents a newsletter subscriber. Subscribed is only true once
+// the address was confirmed through the double opt-in email.
 type NewsletterSubscription struct {
-	ID         int       `json:"id"`
-	Email      string    `json:"email"`
-	Subscribed bool      `json:"subscribed"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID             int        `json:"id"`
+	Email          string     `json:"email"`
+	Subscribed     bool       `json:"subscribed"`
+	RequestedAt    *time.Time `json:"requested_at,omitempty"` // Pending confirmation email
+	RequestIP      string     `json:"request_ip,omitempty"`
+	ConfirmedAt    *time.Time `json:"confirmed_at,omitempty"` // When consent was given
+	ConfirmIP      string     `json:"confirm_ip,omitempty"`   // Where consent was given from
+	UnsubscribedAt *time.Time `json:"unsubscribed_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
 }
 
 // NewsletterSubscriptionRequest represents the data sent from the frontend
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// Notification is an in-app notification shown to a user
+type Notification struct {
+	ID        int64      `json:"id"`
+	UserID    string     `json:"-"`
+	Category  string     `json:"category"`
+	Type      string     `json:"type"` // What happened, e.g. payment_failed
+	Title     string     `json:"title"`
+	Body      string     `json:"body,omitempty"`
+	Link      string     `json:"link,omitempty"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Notification types published by other modules
+const (
+	NotificationTypePaymentFailed = "payment_failed"
+	NotificationTypeTeamInvite    = "team_invite"
+	NotificationTypeExportReady   = "export_ready"
+)
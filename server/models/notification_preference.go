@@ -0,0 +1,31 @@
+package models
+
+// Notification categories
+const (
+	NotificationSecurity   = "security"   // Password resets, verification; can't be turned off
+	NotificationBilling    = "billing"    // Payment failures, trial reminders
+	NotificationProduct    = "product"    // Product updates and tips
+	NotificationNewsletter = "newsletter" // Newsletter campaigns
+)
+
+// NotificationCategories lists the notification categories in display order
+var NotificationCategories = []string{
+	NotificationSecurity,
+	NotificationBilling,
+	NotificationProduct,
+	NotificationNewsletter,
+}
+
+// Notification channels
+const (
+	ChannelEmail = "email"
+	ChannelInApp = "in_app"
+)
+
+// NotificationPreference is whether a user gets a category of notifications on each channel
+type NotificationPreference struct {
+	Category string `json:"category"`
+	Email    bool   `json:"email"`
+	InApp    bool   `json:"in_app"`
+	Locked   bool   `json:"locked"` // Security notifications can't be turned off
+}
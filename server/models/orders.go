@@ -1,9 +1,13 @@
 package models
 
 import (
+	"saas-server/pkg/money"
 	"time"
 )
 
+// Orders represents a Lemon Squeezy order. Amounts are in the currency's minor unit
+// and the *_formatted fields are generated from them with FormatAmounts.
+// The embedded OrderTaxDetails hold the tax breakdown.
 type Orders struct {
 	ID                      int        `json:"id"`
 	OrderID                 int        `json:"order_id"`
@@ -12,12 +16,30 @@ type Orders struct {
 	ProductID               int        `json:"product_id"`
 	VariantID               int        `json:"variant_id"`
 	Status                  string     `json:"status"`
+	Currency                string     `json:"currency"`
+	Subtotal                int        `json:"subtotal"`
+	Tax                     int        `json:"tax"`
+	Total                   int        `json:"total"`
 	SubtotalFormatted       string     `json:"subtotal_formatted"`
 	TaxFormatted            string     `json:"tax_formatted"`
 	TotalFormatted          string     `json:"total_formatted"`
 	TaxInclusive            bool       `json:"tax_inclusive"`
 	RefundedAt              *time.Time `json:"refunded_at,omitempty"`
+	RefundedAmount          int        `json:"refunded_amount,omitempty"`
 	RefundedAmountFormatted string     `json:"refunded_amount_formatted,omitempty"`
+	ReceiptURL              string     `json:"receipt_url,omitempty"`
 	CreatedAt               time.Time  `json:"created_at"`
 	UpdatedAt               time.Time  `json:"updated_at"`
+	OrderTaxDetails
+}
+
+// FormatAmounts fills the formatted amount fields from the minor unit amounts
+func (o *Orders) FormatAmounts() {
+	o.SubtotalFormatted = money.Format(o.Subtotal, o.Currency)
+	o.TaxFormatted = money.Format(o.Tax, o.Currency)
+	o.TotalFormatted = money.Format(o.Total, o.Currency)
+	o.RefundedAmountFormatted = ""
+	if o.RefundedAmount > 0 {
+		o.RefundedAmountFormatted = money.Format(o.RefundedAmount, o.Currency)
+	}
 }
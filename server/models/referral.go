@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+// Referral links a user who signed up through a referral link to the user who shared it.
+// The referrer is rewarded once the referred user's first paid order comes in.
+type Referral struct {
+	ID                int        `json:"id"`
+	ReferrerID        string     `json:"referrer_id"`
+	ReferredUserID    string     `json:"referred_user_id"`
+	ReferredEmail     string     `json:"referred_email,omitempty"` // Masked when shown to the referrer
+	Code              string     `json:"code"`
+	Status            string     `json:"status"`
+	FirstTouchAt      time.Time  `json:"first_touch_at"`
+	SignedUpAt        time.Time  `json:"signed_up_at"`
+	QualifyingOrderID *int       `json:"qualifying_order_id,omitempty"`
+	QualifiedAt       *time.Time `json:"qualified_at,omitempty"`
+	RewardType        string     `json:"reward_type,omitempty"`
+	RewardAmount      *int       `json:"reward_amount,omitempty"`
+	RewardCode        string     `json:"reward_code,omitempty"`
+	RewardedAt        *time.Time `json:"rewarded_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
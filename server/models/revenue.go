@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+)
+
+// SubscriptionMRR is the monthly recurring revenue of one subscription on a given day.
+// MRR is in the reporting currency's minor unit.
+type SubscriptionMRR struct {
+	SubscriptionID int    `json:"subscription_id"`
+	UserID         string `json:"user_id"`
+	VariantID      int    `json:"variant_id"`
+	Status         string `json:"status"`
+	MRR            int    `json:"mrr"`
+}
+
+// RevenueSnapshot holds the revenue metrics of a single day.
+// Movements compare each customer's MRR with the previous snapshot.
+type RevenueSnapshot struct {
+	Date              time.Time `json:"date"`
+	Currency          string    `json:"currency"`
+	MRR               int       `json:"mrr"`
+	ARR               int64     `json:"arr"`
+	ActiveCustomers   int       `json:"active_customers"`
+	TrialingCustomers int       `json:"trialing_customers"`
+	NewMRR            int       `json:"new_mrr"`
+	ExpansionMRR      int       `json:"expansion_mrr"`
+	ContractionMRR    int       `json:"contraction_mrr"`
+	ChurnedMRR        int       `json:"churned_mrr"`
+	NewCustomers      int       `json:"new_customers"`
+	ChurnedCustomers  int       `json:"churned_customers"`
+}
+
+// RevenueSummary aggregates revenue snapshots over a date range.
+// Rates are fractions, e.g. 0.05 for 5%.
+type RevenueSummary struct {
+	StartingMRR         int     `json:"starting_mrr"`
+	EndingMRR           int     `json:"ending_mrr"`
+	EndingARR           int64   `json:"ending_arr"`
+	MRRFormatted        string  `json:"mrr_formatted"`
+	NewMRR              int     `json:"new_mrr"`
+	ExpansionMRR        int     `json:"expansion_mrr"`
+	ContractionMRR      int     `json:"contraction_mrr"`
+	ChurnedMRR          int     `json:"churned_mrr"`
+	NetNewMRR           int     `json:"net_new_mrr"`
+	StartingCustomers   int     `json:"starting_customers"`
+	EndingCustomers     int     `json:"ending_customers"`
+	NewCustomers        int     `json:"new_customers"`
+	ChurnedCustomers    int     `json:"churned_customers"`
+	LogoChurnRate       float64 `json:"logo_churn_rate"`
+	RevenueChurnRate    float64 `json:"revenue_churn_rate"`
+	NetRevenueChurn     float64 `json:"net_revenue_churn_rate"`
+	ARPU                int     `json:"arpu"`
+	ARPUFormatted       string  `json:"arpu_formatted"`
+	TrialsEnded         int     `json:"trials_ended"`
+	TrialsConverted     int     `json:"trials_converted"`
+	TrialConversionRate float64 `json:"trial_conversion_rate"`
+}
+
+// CohortLTV is the lifetime value of customers whose first paid order fell in the same month.
+// MonthlyRevenue[i] is the net revenue earned in month i of the cohort and CumulativeLTV[i]
+// the average net revenue per customer by the end of that month.
+type CohortLTV struct {
+	Cohort         string `json:"cohort"`
+	Customers      int    `json:"customers"`
+	Revenue        int    `json:"revenue"`
+	LTV            int    `json:"ltv"`
+	LTVFormatted   string `json:"ltv_formatted"`
+	MonthlyRevenue []int  `json:"monthly_revenue"`
+	CumulativeLTV  []int  `json:"cumulative_ltv"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Segment is a saved audience defined by rules over user or subscriber attributes
+type Segment struct {
+	ID          int             `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Source      string          `json:"source"`     // users, newsletter or early_access
+	Definition  json.RawMessage `json:"definition"` // Rule tree, see pkg/segments
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// SegmentMember is a user or subscriber matched by a segment
+type SegmentMember struct {
+	UserID     string    `json:"user_id,omitempty"` // Set for subscribers who also have an account
+	Email      string    `json:"email"`
+	Name       string    `json:"name,omitempty"`
+	SignedUpAt time.Time `json:"signed_up_at"`
+}
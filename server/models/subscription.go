@@ -4,20 +4,27 @@ import (
 	"time"
 )
 
+// Subscription represents a Lemon Squeezy subscription. A user can hold several at once;
+// IsPrimary marks the one mirrored into the user's latest_* columns.
 type Subscription struct {
-	ID             int        `json:"id"`
-	SubscriptionID string     `json:"subscription_id"`
-	UserID         string     `json:"user_id"`
-	OrderID        int        `json:"order_id"`
-	CustomerID     int        `json:"customer_id"`
-	ProductID      int        `json:"product_id"`
-	VariantID      int        `json:"variant_id"`
-	OrderItemID    int        `json:"order_item_id"`
-	Status         string     `json:"status"`
-	Cancelled      bool       `json:"cancelled"`
-	RenewsAt       *time.Time `json:"renews_at,omitempty"`
-	EndsAt         *time.Time `json:"ends_at,omitempty"`
-	TrialEndsAt    *time.Time `json:"trial_ends_at,omitempty"`
-	CreatedAt      time.Time  `json:"created_at"`
-	UpdatedAt      time.Time  `json:"updated_at"`
+	ID                     int        `json:"id"`
+	SubscriptionID         string     `json:"subscription_id"`
+	UserID                 string     `json:"user_id"`
+	OrderID                int        `json:"order_id"`
+	CustomerID             int        `json:"customer_id"`
+	ProductID              int        `json:"product_id"`
+	VariantID              int        `json:"variant_id"`
+	OrderItemID            int        `json:"order_item_id"`
+	SubscriptionItemID     int        `json:"subscription_item_id,omitempty"`
+	IsUsageBased           bool       `json:"is_usage_based"`
+	Status                 string     `json:"status"`
+	Cancelled              bool       `json:"cancelled"`
+	RenewsAt               *time.Time `json:"renews_at,omitempty"`
+	EndsAt                 *time.Time `json:"ends_at,omitempty"`
+	TrialEndsAt            *time.Time `json:"trial_ends_at,omitempty"`
+	UpdatePaymentMethodURL string     `json:"update_payment_method_url,omitempty"`
+	IsPrimary              bool       `json:"is_primary"`
+	IsTrial                bool       `json:"is_trial,omitempty"` // An app-managed trial, which has no subscription at the payment provider
+	CreatedAt              time.Time  `json:"created_at"`
+	UpdatedAt              time.Time  `json:"updated_at"`
 }
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// SubscriptionEvent records a single transition of a subscription's state.
+// Old values are nil for the event that created the subscription.
+type SubscriptionEvent struct {
+	ID             int64     `json:"id"`
+	SubscriptionID int       `json:"subscription_id"`
+	UserID         string    `json:"user_id"`
+	Source         string    `json:"source"`
+	OldStatus      *string   `json:"old_status"`
+	NewStatus      string    `json:"new_status"`
+	OldVariantID   *int      `json:"old_variant_id"`
+	NewVariantID   int       `json:"new_variant_id"`
+	OldCancelled   *bool     `json:"old_cancelled"`
+	NewCancelled   bool      `json:"new_cancelled"`
+	CreatedAt      time.Time `json:"created_at"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// Trial is a free trial managed by the app rather than the payment provider.
+// Status is "active" until the trial is converted into a subscription or expires.
+type Trial struct {
+	ID             int        `json:"id"`
+	UserID         string     `json:"user_id"`
+	ProductID      int        `json:"product_id"`
+	VariantID      int        `json:"variant_id"`
+	Status         string     `json:"status"`
+	StartedAt      time.Time  `json:"started_at"`
+	EndsAt         time.Time  `json:"ends_at"`
+	ReminderSentAt *time.Time `json:"reminder_sent_at,omitempty"`
+	ConvertedAt    *time.Time `json:"converted_at,omitempty"`
+	SubscriptionID *int       `json:"subscription_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
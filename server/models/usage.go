@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+)
+
+// UsageEvent represents a single metered usage event for a subscription
+type UsageEvent struct {
+	ID             int64     `json:"id"`
+	UserID         string    `json:"user_id"`
+	SubscriptionID int       `json:"subscription_id"`
+	Quantity       int       `json:"quantity"`
+	IdempotencyKey string    `json:"idempotency_key"`
+	UsageReportID  *int      `json:"usage_report_id,omitempty"`
+	OccurredAt     time.Time `json:"occurred_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// UsageEventRequest represents the data sent by internal services to record usage
+type UsageEventRequest struct {
+	UserID         string     `json:"user_id,omitempty"`
+	SubscriptionID int        `json:"subscription_id,omitempty"`
+	Quantity       int        `json:"quantity"`
+	IdempotencyKey string     `json:"idempotency_key"`
+	OccurredAt     *time.Time `json:"occurred_at,omitempty"`
+}
+
+// UsageReport represents aggregated usage sent to the payment provider
+type UsageReport struct {
+	ID                    int        `json:"id"`
+	SubscriptionID        int        `json:"subscription_id"`
+	SubscriptionItemID    int        `json:"subscription_item_id"`
+	Quantity              int        `json:"quantity"`
+	PeriodStart           time.Time  `json:"period_start"`
+	PeriodEnd             time.Time  `json:"period_end"`
+	Status                string     `json:"status"`
+	ProviderUsageRecordID string     `json:"provider_usage_record_id,omitempty"`
+	Attempts              int        `json:"attempts"`
+	LastError             string     `json:"last_error,omitempty"`
+	ReportedAt            *time.Time `json:"reported_at,omitempty"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+}
+
+// PlanUsageLimit represents the usage included in a plan variant
+type PlanUsageLimit struct {
+	VariantID     int       `json:"variant_id"`
+	IncludedUnits int       `json:"included_units"`
+	HardLimit     *int      `json:"hard_limit,omitempty"`
+	UnitLabel     string    `json:"unit_label"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// UsageSummary represents a subscription's usage in the current billing period
+type UsageSummary struct {
+	SubscriptionID  int        `json:"subscription_id"`
+	VariantID       int        `json:"variant_id"`
+	PeriodStart     time.Time  `json:"period_start"`
+	PeriodEnd       *time.Time `json:"period_end,omitempty"`
+	Used            int        `json:"used"`
+	Reported        int        `json:"reported"`
+	IncludedUnits   int        `json:"included_units"`
+	HardLimit       *int       `json:"hard_limit,omitempty"`
+	UnitLabel       string     `json:"unit_label"`
+	Overage         int        `json:"overage"`
+	PercentIncluded float64    `json:"percent_included"`
+}
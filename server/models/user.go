@@ -14,6 +14,7 @@ type User struct {
 	Password             string     `json:"-"`
 	Name                 string     `json:"name"`
 	EmailVerified        bool       `json:"email_verified"`
+	Language             string     `json:"language,omitempty"` // Preferred email locale, empty for the default
 	LatestStatus         string     `json:"latest_status"`
 	LatestProductID      int        `json:"latest_product_id,omitempty"`
 	LatestVariantID      int        `json:"latest_variant_id,omitempty"`
@@ -24,11 +25,26 @@ type User struct {
 	UpdatedAt            time.Time  `json:"updated_at"`
 }
 
-// UserSubscriptionStatus represents the subscription status of a user
+// UserSubscriptionStatus represents the subscription status of a user.
+// Status, ProductID and VariantID describe the primary subscription; Subscriptions lists
+// every subscription that currently grants access, so add-ons are not lost.
 type UserSubscriptionStatus struct {
-	Status    *string `json:"status"`
-	ProductID *int    `json:"product_id"`
-	VariantID *int    `json:"variant_id"`
+	Status        *string                   `json:"status"`
+	ProductID     *int                      `json:"product_id"`
+	VariantID     *int                      `json:"variant_id"`
+	Subscriptions []SubscriptionEntitlement `json:"subscriptions"`
+}
+
+// SubscriptionEntitlement is a subscription that currently grants access to a plan.
+// App-managed free trials are listed with IsTrial set, status "on_trial" and no SubscriptionID.
+type SubscriptionEntitlement struct {
+	SubscriptionID string     `json:"subscription_id"`
+	Status         string     `json:"status"`
+	ProductID      int        `json:"product_id"`
+	VariantID      int        `json:"variant_id"`
+	IsPrimary      bool       `json:"is_primary"`
+	IsTrial        bool       `json:"is_trial,omitempty"`
+	EndsAt         *time.Time `json:"ends_at,omitempty"`
 }
 
 // HashPassword hashes the user's password using bcrypt
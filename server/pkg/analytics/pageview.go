@@ -11,6 +11,7 @@ type PageView struct {
 	ID        int64
 	UserID    *uuid.UUID
 	VisitorID string
+	SessionID *int64
 	Path      string
 	Referrer  string
 	UserAgent string
@@ -38,11 +39,13 @@ type ReferrerStats struct {
 
 // PageViewResponse represents the complete analytics response
 type PageViewResponse struct {
-	PageStats     []PageViewStats `json:"pageStats"`
-	DailyStats    []DailyStats    `json:"dailyStats"`
-	ReferrerStats []ReferrerStats `json:"referrerStats"`
-	TotalViews    int             `json:"totalViews"`
-	UniquePaths   int             `json:"uniquePaths"`
+	PageStats      []PageViewStats `json:"pageStats"`
+	DailyStats     []DailyStats    `json:"dailyStats"`
+	ReferrerStats  []ReferrerStats `json:"referrerStats"`
+	TotalViews     int             `json:"totalViews"`
+	UniquePaths    int             `json:"uniquePaths"`
+	UniqueVisitors int             `json:"uniqueVisitors"` // Signed-in users count once across devices
+	Sessions       int             `json:"sessions"`
 }
 
 // PageViewService defines the interface for page view analytics
@@ -0,0 +1,31 @@
+package analytics
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VisitorCookieName is the first-party cookie that keeps a visitor's ID across visits
+const VisitorCookieName = "visitor_id"
+
+// VisitorCookieLifetime is how long the visitor cookie lasts without a visit
+const VisitorCookieLifetime = 365 * 24 * time.Hour
+
+// SessionTimeout is how long a visitor can be inactive before their next page view
+// starts a new session
+const SessionTimeout = 30 * time.Minute
+
+// visitorIDPattern matches visitor IDs, whether generated here or by the client
+var visitorIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{8,64}$`)
+
+// NewVisitorID generates a random visitor ID
+func NewVisitorID() string {
+	return "v_" + uuid.NewString()
+}
+
+// ValidVisitorID reports whether a visitor ID is well-formed
+func ValidVisitorID(id string) bool {
+	return visitorIDPattern.MatchString(id)
+}
@@ -0,0 +1,446 @@
+// Package campaigns sends newsletter campaigns: admins draft a campaign, preview and
+// test-send it, and schedule it for an audience. Due campaigns are sent in throttled batches
+// through the email outbox, and opens and clicks are tracked with signed pixel and redirect
+// links.
+package campaigns
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/clock"
+	"saas-server/pkg/email"
+	"saas-server/pkg/signedlink"
+	"saas-server/pkg/validation"
+)
+
+// Purposes of the signed tracking links
+const (
+	openPurpose  = "campaign-open"
+	clickPurpose = "campaign-click"
+)
+
+// ErrInvalidCampaign wraps the reason a campaign was rejected
+var ErrInvalidCampaign = errors.New("invalid campaign")
+
+// ErrNotEditable is returned when changing a campaign that is no longer a draft
+var ErrNotEditable = errors.New("only draft campaigns can be changed")
+
+// ErrNotFound is returned for campaigns that don't exist
+var ErrNotFound = errors.New("campaign not found")
+
+// CampaignDB defines the database operations required by the campaign service
+type CampaignDB interface {
+	CreateCampaign(c *models.Campaign) error
+	UpdateCampaign(c *models.Campaign) (*models.Campaign, error)
+	GetCampaign(id int) (*models.Campaign, error)
+	GetCampaigns(page int, limit int) ([]models.CampaignWithStats, int, error)
+	GetCampaignWithStats(id int) (*models.CampaignWithStats, error)
+	GetCampaignRecipients(campaignID int, status string, page int, limit int) ([]models.CampaignRecipient, int, error)
+	ScheduleCampaign(id int, sendAt time.Time) (*models.Campaign, error)
+	CancelCampaign(id int) (*models.Campaign, error)
+	StartDueCampaigns(now time.Time) ([]models.Campaign, error)
+	GetSendingCampaigns() ([]models.Campaign, error)
+	GetPendingCampaignRecipients(campaignID int, limit int) ([]models.CampaignRecipient, error)
+	QueueCampaignEmail(recipientID int64, e *models.OutboundEmail) error
+	SkipCampaignRecipient(recipientID int64, reason string) error
+	CompleteCampaign(id int, completedAt time.Time) (bool, error)
+	RecordCampaignOpen(recipientID int64, openedAt time.Time) error
+	RecordCampaignClick(recipientID int64, url string, clickedAt time.Time) error
+	GetSegment(id int) (*models.Segment, error)
+}
+
+// Unsubscriber provides the one-click unsubscribe link every campaign email carries
+type Unsubscriber interface {
+	UnsubscribeURL(address string) string
+}
+
+// Config controls how campaigns are sent
+type Config struct {
+	// BatchSize is how many emails of each sending campaign are queued per run, which
+	// throttles large campaigns
+	BatchSize int
+	// TrackingURL is the public API prefix of the open and click tracking endpoints
+	TrackingURL string
+	// Tracking enables open and click tracking
+	Tracking bool
+}
+
+// LoadConfig reads the campaign configuration from the environment.
+// CAMPAIGN_BATCH_SIZE sets how many emails per campaign are queued each minute (default 100),
+// and CAMPAIGN_TRACKING=false turns off open and click tracking.
+func LoadConfig() Config {
+	config := Config{
+		BatchSize:   100,
+		TrackingURL: strings.TrimRight(os.Getenv("API_URL"), "/") + "/api/email",
+		Tracking:    true,
+	}
+
+	if v := os.Getenv("CAMPAIGN_BATCH_SIZE"); v != "" {
+		if size, err := strconv.Atoi(v); err == nil && size > 0 {
+			config.BatchSize = size
+		} else {
+			log.Printf("[Campaigns] Ignoring invalid CAMPAIGN_BATCH_SIZE %q", v)
+		}
+	}
+	if v := os.Getenv("CAMPAIGN_TRACKING"); v != "" {
+		if tracking, err := strconv.ParseBool(v); err == nil {
+			config.Tracking = tracking
+		} else {
+			log.Printf("[Campaigns] Ignoring invalid CAMPAIGN_TRACKING %q", v)
+		}
+	}
+
+	return config
+}
+
+// Service manages campaigns
+type Service struct {
+	db           CampaignDB
+	outbox       *email.Outbox
+	unsubscriber Unsubscriber
+	signer       *signedlink.Signer
+	clock        clock.Clock
+	config       Config
+}
+
+// NewService creates a new instance of Service
+func NewService(db CampaignDB, outbox *email.Outbox, unsubscriber Unsubscriber, signer *signedlink.Signer, clock clock.Clock, config Config) *Service {
+	return &Service{
+		db:           db,
+		outbox:       outbox,
+		unsubscriber: unsubscriber,
+		signer:       signer,
+		clock:        clock,
+		config:       config,
+	}
+}
+
+// StartCampaignJob starts a background job that starts due campaigns and queues the next
+// batch of every campaign that is being sent
+func (s *Service) StartCampaignJob(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			if err := s.ProcessCampaigns(); err != nil {
+				log.Printf("Error processing campaigns: %v", err)
+			}
+		}
+	}()
+}
+
+// Create validates and stores a draft campaign
+func (s *Service) Create(c *models.Campaign) error {
+	if err := s.validate(c); err != nil {
+		return err
+	}
+	return s.db.CreateCampaign(c)
+}
+
+// Update changes the content and audience of a draft campaign
+func (s *Service) Update(c *models.Campaign) (*models.Campaign, error) {
+	if err := s.validate(c); err != nil {
+		return nil, err
+	}
+	updated, err := s.db.UpdateCampaign(c)
+	if err == sql.ErrNoRows {
+		if _, err := s.get(c.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrNotEditable
+	}
+	return updated, err
+}
+
+// Get returns a campaign with its stats
+func (s *Service) Get(id int) (*models.CampaignWithStats, error) {
+	c, err := s.db.GetCampaignWithStats(id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return c, err
+}
+
+// List returns a page of campaigns with their stats, newest first
+func (s *Service) List(page int, limit int) ([]models.CampaignWithStats, int, error) {
+	return s.db.GetCampaigns(page, limit)
+}
+
+// Recipients returns a page of the recipients of a campaign, optionally filtered by status
+func (s *Service) Recipients(id int, status string, page int, limit int) ([]models.CampaignRecipient, int, error) {
+	if _, err := s.get(id); err != nil {
+		return nil, 0, err
+	}
+	return s.db.GetCampaignRecipients(id, status, page, limit)
+}
+
+// Preview renders a campaign as it is sent, without tracking
+func (s *Service) Preview(id int) (email.Message, error) {
+	c, err := s.get(id)
+	if err != nil {
+		return email.Message{}, err
+	}
+	return s.render(c, "preview@example.com", 0)
+}
+
+// SendTest sends a campaign to a single address without tracking, e.g. to check how it
+// looks in an email client. It can be sent in any state.
+func (s *Service) SendTest(id int, to string) error {
+	if !validation.ValidateEmail(to) {
+		return fmt.Errorf("%w: invalid test address", ErrInvalidCampaign)
+	}
+	c, err := s.get(id)
+	if err != nil {
+		return err
+	}
+	msg, err := s.render(c, to, 0)
+	if err != nil {
+		return err
+	}
+	msg.Subject = "[Test] " + msg.Subject
+	return s.outbox.Enqueue(msg, "")
+}
+
+// Schedule schedules a draft campaign to be sent at sendAt, or reschedules a scheduled one.
+// Campaigns scheduled in the past are sent on the next run.
+func (s *Service) Schedule(id int, sendAt time.Time) (*models.Campaign, error) {
+	c, err := s.db.ScheduleCampaign(id, sendAt)
+	if err == sql.ErrNoRows {
+		if _, err := s.get(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrNotEditable
+	}
+	if err == nil {
+		log.Printf("[Campaigns] Scheduled campaign %d for %s", id, sendAt.Format(time.RFC3339))
+	}
+	return c, err
+}
+
+// Cancel unschedules a scheduled campaign or stops one that is being sent. Emails that
+// were already queued are still delivered.
+func (s *Service) Cancel(id int) (*models.Campaign, error) {
+	c, err := s.db.CancelCampaign(id)
+	if err == sql.ErrNoRows {
+		if _, err := s.get(id); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: only scheduled or sending campaigns can be cancelled", ErrInvalidCampaign)
+	}
+	if err == nil {
+		log.Printf("[Campaigns] Cancelled campaign %d, now %s", id, c.Status)
+	}
+	return c, err
+}
+
+// ProcessCampaigns starts the campaigns that are due and queues the next batch of each
+// campaign that is being sent. Campaigns are marked as sent once every recipient is queued.
+func (s *Service) ProcessCampaigns() error {
+	started, err := s.db.StartDueCampaigns(s.clock.Now())
+	for _, c := range started {
+		log.Printf("[Campaigns] Started campaign %d (%s)", c.ID, c.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	campaigns, err := s.db.GetSendingCampaigns()
+	if err != nil {
+		return err
+	}
+	for _, c := range campaigns {
+		if err := s.sendBatch(&c); err != nil {
+			log.Printf("[Campaigns] Error sending campaign %d: %v", c.ID, err)
+		}
+	}
+	return nil
+}
+
+// sendBatch queues the next batch of a campaign's emails and completes the campaign when
+// none are left
+func (s *Service) sendBatch(c *models.Campaign) error {
+	recipients, err := s.db.GetPendingCampaignRecipients(c.ID, s.config.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range recipients {
+		outbound, err := s.outbound(c, r)
+		if err != nil {
+			log.Printf("[Campaigns] Skipping %s in campaign %d: %v", r.Email, c.ID, err)
+			if err := s.db.SkipCampaignRecipient(r.ID, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.db.QueueCampaignEmail(r.ID, outbound); err != nil {
+			return err
+		}
+	}
+
+	if len(recipients) < s.config.BatchSize {
+		completed, err := s.db.CompleteCampaign(c.ID, s.clock.Now())
+		if err != nil {
+			return err
+		}
+		if completed {
+			log.Printf("[Campaigns] Queued all emails of campaign %d", c.ID)
+		}
+	}
+	return nil
+}
+
+// outbound builds the tracked email of a recipient
+func (s *Service) outbound(c *models.Campaign, r models.CampaignRecipient) (*models.OutboundEmail, error) {
+	recipientID := r.ID
+	if !s.config.Tracking {
+		recipientID = 0
+	}
+	msg, err := s.render(c, r.Email, recipientID)
+	if err != nil {
+		return nil, err
+	}
+	return email.NewOutbound(msg, fmt.Sprintf("campaign:%d:%d", c.ID, r.ID))
+}
+
+// render renders a campaign for a recipient. Links and an open pixel are tracked for
+// recipientID, or not at all if it is 0.
+func (s *Service) render(c *models.Campaign, to string, recipientID int64) (email.Message, error) {
+	body := validation.SanitizeHTML(c.HTMLBody)
+	text := c.TextBody
+	if text == "" {
+		text = email.PlainText(body)
+	}
+
+	data := email.CampaignData{
+		Subject: c.Subject,
+		Text:    text,
+	}
+	if recipientID != 0 {
+		body = s.trackLinks(body, recipientID)
+		data.OpenPixelURL = s.trackingURL("open", s.signer.Sign(openPurpose, strconv.FormatInt(recipientID, 10), time.Time{}))
+	}
+	// The body was sanitized above, so it is safe to insert as HTML
+	data.Body = htmltemplate.HTML(body)
+
+	msg, err := email.CampaignEmail(to, data)
+	if err != nil {
+		return email.Message{}, err
+	}
+	msg.SetListUnsubscribe(s.unsubscriber.UnsubscribeURL(to))
+	return msg, nil
+}
+
+// linkPattern matches the web links in sanitized HTML, which always quotes attributes
+var linkPattern = regexp.MustCompile(`href="(https?://[^"]+)"`)
+
+// trackLinks points the web links of a campaign body to the click tracking endpoint
+func (s *Service) trackLinks(body string, recipientID int64) string {
+	return linkPattern.ReplaceAllStringFunc(body, func(match string) string {
+		target := html.UnescapeString(linkPattern.FindStringSubmatch(match)[1])
+		subject := strconv.FormatInt(recipientID, 10) + "|" + target
+		tracked := s.trackingURL("click", s.signer.Sign(clickPurpose, subject, time.Time{}))
+		return `href="` + html.EscapeString(tracked) + `"`
+	})
+}
+
+// trackingURL returns the link of a tracking endpoint. Tracking links don't expire, as old
+// campaigns must keep working.
+func (s *Service) trackingURL(endpoint string, token string) string {
+	return s.config.TrackingURL + "/" + endpoint + "?t=" + url.QueryEscape(token)
+}
+
+// RecordOpen records the open of a tracking pixel token
+func (s *Service) RecordOpen(token string) error {
+	subject, err := s.signer.Verify(openPurpose, token, s.clock.Now())
+	if err != nil {
+		return err
+	}
+	recipientID, err := strconv.ParseInt(subject, 10, 64)
+	if err != nil {
+		return signedlink.ErrInvalid
+	}
+	return s.db.RecordCampaignOpen(recipientID, s.clock.Now())
+}
+
+// RecordClick records the click of a tracked link and returns the link's target. The target
+// is returned even if recording fails, so the recipient still gets where they wanted to go.
+func (s *Service) RecordClick(token string) (string, error) {
+	subject, err := s.signer.Verify(clickPurpose, token, s.clock.Now())
+	if err != nil {
+		return "", err
+	}
+	id, target, ok := strings.Cut(subject, "|")
+	recipientID, err := strconv.ParseInt(id, 10, 64)
+	if !ok || err != nil {
+		return "", signedlink.ErrInvalid
+	}
+	return target, s.db.RecordCampaignClick(recipientID, target, s.clock.Now())
+}
+
+// get returns a campaign, mapping a missing campaign to ErrNotFound
+func (s *Service) get(id int) (*models.Campaign, error) {
+	c, err := s.db.GetCampaign(id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return c, err
+}
+
+// validate normalizes a campaign and checks that its segment exists
+func (s *Service) validate(c *models.Campaign) error {
+	if err := normalize(c); err != nil {
+		return err
+	}
+	if c.SegmentID == nil {
+		return nil
+	}
+	_, err := s.db.GetSegment(*c.SegmentID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: segment %d doesn't exist", ErrInvalidCampaign, *c.SegmentID)
+	}
+	return err
+}
+
+// normalize sanitizes the fields of a campaign and checks they are complete
+func normalize(c *models.Campaign) error {
+	c.Name = validation.SanitizeInput(c.Name, 200)
+	c.Subject = validation.SanitizeInput(c.Subject, 200)
+	c.HTMLBody = validation.SanitizeHTML(c.HTMLBody)
+	c.TextBody = strings.TrimSpace(c.TextBody)
+
+	switch {
+	case c.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidCampaign)
+	case c.Subject == "":
+		return fmt.Errorf("%w: subject is required", ErrInvalidCampaign)
+	case strings.TrimSpace(c.HTMLBody) == "":
+		return fmt.Errorf("%w: content is required", ErrInvalidCampaign)
+	}
+
+	switch c.Audience {
+	case models.AudienceNewsletter, models.AudienceUsers, models.AudienceEarlyAccess:
+		c.SegmentID = nil
+		return nil
+	case models.AudienceSegment:
+		if c.SegmentID == nil {
+			return fmt.Errorf("%w: segment_id is required for the segment audience", ErrInvalidCampaign)
+		}
+		return nil
+	case "":
+		return fmt.Errorf("%w: audience is required", ErrInvalidCampaign)
+	default:
+		return fmt.Errorf("%w: unknown audience %q", ErrInvalidCampaign, c.Audience)
+	}
+}
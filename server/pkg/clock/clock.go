@@ -0,0 +1,19 @@
+// Package clock abstracts the current time so scheduled jobs can be driven by a fake clock in tests
+package clock
+
+import (
+	"time"
+)
+
+// Clock returns the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// System is the Clock backed by time.Now
+type System struct{}
+
+// Now returns the current time
+func (System) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,243 @@
+// Package credits runs the prepaid credits wallet. Credits are bought as top-ups through
+// one-time checkouts or granted, spent through the debit API and may expire. Every balance
+// change is recorded in a double-entry ledger.
+package credits
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/clock"
+)
+
+// ErrInvalidAmount is returned for zero or negative credit amounts
+var ErrInvalidAmount = errors.New("credit amount must be positive")
+
+// CreditDB defines the database operations required by the credits service
+type CreditDB interface {
+	GetCreditBalance(userID string, now time.Time) (*models.CreditBalance, error)
+	GetCreditTransactions(userID string, page int, limit int) ([]models.CreditTransaction, int, error)
+	GetOrderCreditPurchase(orderID int) (*models.CreditTransaction, error)
+	AddCredits(userID string, txType string, amount int, reference string, description string, orderID *int, expiresAt *time.Time) (*models.CreditTransaction, bool, error)
+	DebitCredits(userID string, amount int, expectedVersion *int, reference string, description string, now time.Time) (*models.CreditTransaction, bool, error)
+	ReverseOrderCredits(orderID int, reverseTotal int, reference string) (*models.CreditTransaction, bool, error)
+	ExpireCredits(now time.Time) (int, error)
+}
+
+// TopUp is a one-time variant that buys a fixed number of credits
+type TopUp struct {
+	VariantID int `json:"variant_id"`
+	Credits   int `json:"credits"`
+}
+
+// Config controls top-ups and expiry of credits
+type Config struct {
+	// TopUps maps one-time variant IDs to the credits each purchase adds
+	TopUps map[int]int
+	// PurchaseExpiry is how long purchased credits last, zero for no expiry
+	PurchaseExpiry time.Duration
+	// GrantExpiry is how long granted credits last unless the grant says otherwise, zero for no expiry
+	GrantExpiry time.Duration
+}
+
+// LoadConfig reads the credits configuration from the environment.
+// CREDIT_TOPUP_VARIANTS lists top-ups as variant:credits pairs, e.g. "1234:500,1235:1200".
+// CREDIT_PURCHASE_EXPIRY_DAYS and CREDIT_GRANT_EXPIRY_DAYS set how long purchased and granted
+// credits last (default 0, no expiry).
+func LoadConfig() Config {
+	config := Config{TopUps: map[int]int{}}
+
+	for _, pair := range strings.Split(os.Getenv("CREDIT_TOPUP_VARIANTS"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		variant, amount, _ := strings.Cut(pair, ":")
+		variantID, err1 := strconv.Atoi(strings.TrimSpace(variant))
+		credits, err2 := strconv.Atoi(strings.TrimSpace(amount))
+		if err1 != nil || err2 != nil || variantID <= 0 || credits <= 0 {
+			log.Printf("[Credits] Ignoring invalid CREDIT_TOPUP_VARIANTS entry %q", pair)
+			continue
+		}
+		config.TopUps[variantID] = credits
+	}
+
+	config.PurchaseExpiry = expiryDays("CREDIT_PURCHASE_EXPIRY_DAYS")
+	config.GrantExpiry = expiryDays("CREDIT_GRANT_EXPIRY_DAYS")
+	return config
+}
+
+// expiryDays reads a number of days from the environment, zero when unset or invalid
+func expiryDays(key string) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0
+	}
+	days, err := strconv.Atoi(v)
+	if err != nil || days < 0 {
+		log.Printf("[Credits] Ignoring invalid %s %q", key, v)
+		return 0
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Service manages users' credit balances
+type Service struct {
+	db     CreditDB
+	clock  clock.Clock
+	config Config
+}
+
+// NewService creates a new instance of Service
+func NewService(db CreditDB, clock clock.Clock, config Config) *Service {
+	return &Service{
+		db:     db,
+		clock:  clock,
+		config: config,
+	}
+}
+
+// StartExpiryJob starts the background job that expires unspent credits
+func (s *Service) StartExpiryJob(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			if err := s.ExpireCredits(); err != nil {
+				log.Printf("Error expiring credits: %v", err)
+			}
+		}
+	}()
+}
+
+// TopUps returns the configured top-ups, ordered by variant ID
+func (s *Service) TopUps() []TopUp {
+	topUps := make([]TopUp, 0, len(s.config.TopUps))
+	for variantID, credits := range s.config.TopUps {
+		topUps = append(topUps, TopUp{VariantID: variantID, Credits: credits})
+	}
+	sort.Slice(topUps, func(i, j int) bool { return topUps[i].VariantID < topUps[j].VariantID })
+	return topUps
+}
+
+// Balance returns a user's spendable balance
+func (s *Service) Balance(userID string) (*models.CreditBalance, error) {
+	return s.db.GetCreditBalance(userID, s.clock.Now())
+}
+
+// Transactions returns a page of a user's credit history, newest first
+func (s *Service) Transactions(userID string, page int, limit int) ([]models.CreditTransaction, int, error) {
+	return s.db.GetCreditTransactions(userID, page, limit)
+}
+
+// Debit spends a user's credits. Keys are scoped to the user, so replaying a key returns the
+// original transaction with created=false instead of debiting twice.
+func (s *Service) Debit(req models.CreditDebitRequest) (*models.CreditTransaction, bool, error) {
+	if req.Amount <= 0 {
+		return nil, false, ErrInvalidAmount
+	}
+	reference := fmt.Sprintf("debit:%s:%s", req.UserID, req.IdempotencyKey)
+	return s.db.DebitCredits(req.UserID, req.Amount, req.ExpectedVersion, reference, req.Description, s.clock.Now())
+}
+
+// Grant gives a user credits. They expire at expiresAt, or after the configured grant expiry
+// when it is nil. Replaying a non-empty reference doesn't grant twice.
+func (s *Service) Grant(userID string, amount int, description string, expiresAt *time.Time, reference string) (*models.CreditTransaction, error) {
+	if amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+	if expiresAt == nil {
+		expiresAt = s.expiry(s.config.GrantExpiry)
+	}
+	t, _, err := s.db.AddCredits(userID, models.CreditGrant, amount, reference, description, nil, expiresAt)
+	return t, err
+}
+
+// GrantReferralCredit gives a referrer their reward for a referral
+func (s *Service) GrantReferralCredit(userID string, amount int, referralID int) error {
+	_, err := s.Grant(userID, amount, fmt.Sprintf("Referral reward #%d", referralID), nil, fmt.Sprintf("referral:%d", referralID))
+	return err
+}
+
+// OrderPaid credits the top-up bought with a paid order. Orders for other variants are
+// ignored, as are orders that were already credited.
+func (s *Service) OrderPaid(userID string, orderID int, variantID int, quantity int) error {
+	credits, ok := s.config.TopUps[variantID]
+	if !ok {
+		return nil
+	}
+	if quantity < 1 {
+		quantity = 1
+	}
+
+	t, created, err := s.db.AddCredits(
+		userID,
+		models.CreditPurchase,
+		credits*quantity,
+		fmt.Sprintf("order:%d", orderID),
+		fmt.Sprintf("Top-up order %d", orderID),
+		&orderID,
+		s.expiry(s.config.PurchaseExpiry),
+	)
+	if err != nil {
+		return err
+	}
+	if created {
+		log.Printf("[Credits] Credited %d credits to user %s for order %d", t.Amount, userID, orderID)
+	}
+	return nil
+}
+
+// OrderRefunded takes back the credits of a refunded top-up in proportion to the refunded
+// amount. refundedAmount is the order's total refunded so far, so repeated partial refunds
+// only take back the difference. Credits already spent can't be taken back.
+func (s *Service) OrderRefunded(orderID int, total int, refundedAmount int) error {
+	purchase, err := s.db.GetOrderCreditPurchase(orderID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if total <= 0 || refundedAmount <= 0 {
+		return nil
+	}
+	if refundedAmount > total {
+		refundedAmount = total
+	}
+
+	reverseTotal := purchase.Amount * refundedAmount / total
+	t, created, err := s.db.ReverseOrderCredits(orderID, reverseTotal, fmt.Sprintf("refund:%d:%d", orderID, refundedAmount))
+	if err != nil {
+		return err
+	}
+	if created {
+		log.Printf("[Credits] Reversed %d credits of user %s for refunded order %d", -t.Amount, t.UserID, orderID)
+	}
+	return nil
+}
+
+// ExpireCredits expires the unspent credits of every lot past its expiry
+func (s *Service) ExpireCredits() error {
+	expired, err := s.db.ExpireCredits(s.clock.Now())
+	if expired > 0 {
+		log.Printf("[Credits] Expired %d credit lots", expired)
+	}
+	return err
+}
+
+// expiry returns when credits granted now with the given lifetime expire, nil for never
+func (s *Service) expiry(lifetime time.Duration) *time.Time {
+	if lifetime == 0 {
+		return nil
+	}
+	expiresAt := s.clock.Now().Add(lifetime)
+	return &expiresAt
+}
@@ -0,0 +1,235 @@
+// Package discounts manages discount codes with the payment provider and validates
+// codes before they are applied to a checkout
+package discounts
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"saas-server/models"
+	"saas-server/pkg/clock"
+	"saas-server/pkg/lemonsqueezy"
+)
+
+// Validation errors, their messages are safe to show to users
+var (
+	ErrInvalidCode            = errors.New("discount code is not valid")
+	ErrNotStarted             = errors.New("discount code is not active yet")
+	ErrExpired                = errors.New("discount code has expired")
+	ErrRedemptionLimitReached = errors.New("discount code has reached its redemption limit")
+	ErrNotApplicable          = errors.New("discount code does not apply to this plan")
+)
+
+// ErrInvalidDiscount wraps the reason a new discount was rejected before reaching the provider
+var ErrInvalidDiscount = errors.New("invalid discount")
+
+// DiscountDB defines the database operations required by the discount service
+type DiscountDB interface {
+	CreateDiscount(d *models.Discount) (*models.Discount, error)
+	GetDiscountByID(id int) (*models.Discount, error)
+	GetActiveDiscountByCode(code string) (*models.Discount, error)
+	GetDiscounts() ([]models.DiscountWithStats, error)
+	CountDiscountRedemptions(discountID int) (int, error)
+	DisableDiscount(id int) error
+}
+
+// Provider creates and deletes discounts with the payment provider
+type Provider interface {
+	CreateDiscount(storeID string, input lemonsqueezy.DiscountInput) (*lemonsqueezy.DiscountResponse, error)
+	DeleteDiscount(discountID string) error
+}
+
+// Service manages discount codes
+type Service struct {
+	db       DiscountDB
+	provider Provider
+	clock    clock.Clock
+	storeID  string
+}
+
+// NewService creates a new instance of Service for the given store
+func NewService(db DiscountDB, provider Provider, clock clock.Clock, storeID string) *Service {
+	return &Service{
+		db:       db,
+		provider: provider,
+		clock:    clock,
+		storeID:  storeID,
+	}
+}
+
+// Create creates a discount with the payment provider and stores it
+func (s *Service) Create(input lemonsqueezy.DiscountInput) (*models.Discount, error) {
+	input.Code = strings.ToUpper(strings.TrimSpace(input.Code))
+	if input.Duration == "" {
+		input.Duration = "once"
+	}
+	if err := validateInput(input); err != nil {
+		return nil, err
+	}
+
+	if existing, err := s.db.GetActiveDiscountByCode(input.Code); err == nil && existing != nil {
+		return nil, fmt.Errorf("%w: an active discount already uses the code %s", ErrInvalidDiscount, input.Code)
+	} else if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	resp, err := s.provider.CreateDiscount(s.storeID, input)
+	if err != nil {
+		return nil, err
+	}
+
+	providerID, err := strconv.Atoi(resp.Data.ID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid discount ID %q from provider", resp.Data.ID)
+	}
+
+	d := &models.Discount{
+		ProviderDiscountID: providerID,
+		Name:               input.Name,
+		Code:               input.Code,
+		Amount:             input.Amount,
+		AmountType:         input.AmountType,
+		Duration:           input.Duration,
+		VariantIDs:         input.VariantIDs,
+		StartsAt:           input.StartsAt,
+		ExpiresAt:          input.ExpiresAt,
+	}
+	if input.Duration == "repeating" {
+		d.DurationInMonths = &input.DurationInMonths
+	}
+	if input.MaxRedemptions > 0 {
+		d.MaxRedemptions = &input.MaxRedemptions
+	}
+
+	return s.db.CreateDiscount(d)
+}
+
+// List returns every discount with its redemption stats
+func (s *Service) List() ([]models.DiscountWithStats, error) {
+	return s.db.GetDiscounts()
+}
+
+// Disable deletes the discount from the payment provider so it can't be redeemed and
+// keeps the local record for its stats. It returns sql.ErrNoRows if there is no active discount with that ID.
+func (s *Service) Disable(id int) error {
+	d, err := s.db.GetDiscountByID(id)
+	if err != nil {
+		return err
+	}
+	if d.Status != "active" {
+		return sql.ErrNoRows
+	}
+
+	if err := s.provider.DeleteDiscount(strconv.Itoa(d.ProviderDiscountID)); err != nil {
+		return err
+	}
+	return s.db.DisableDiscount(id)
+}
+
+// Validate checks that a code can be applied to a checkout for the variant and returns its discount.
+// The provider validates the code again at checkout, this catches problems before the user gets there.
+func (s *Service) Validate(code string, variantID int) (*models.Discount, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, ErrInvalidCode
+	}
+
+	d, err := s.db.GetActiveDiscountByCode(code)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidCode
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	if d.StartsAt != nil && now.Before(*d.StartsAt) {
+		return nil, ErrNotStarted
+	}
+	if d.ExpiresAt != nil && !now.Before(*d.ExpiresAt) {
+		return nil, ErrExpired
+	}
+
+	if len(d.VariantIDs) > 0 {
+		applies := false
+		for _, id := range d.VariantIDs {
+			if id == variantID {
+				applies = true
+				break
+			}
+		}
+		if !applies {
+			return nil, ErrNotApplicable
+		}
+	}
+
+	if d.MaxRedemptions != nil {
+		redemptions, err := s.db.CountDiscountRedemptions(d.ID)
+		if err != nil {
+			return nil, err
+		}
+		if redemptions >= *d.MaxRedemptions {
+			return nil, ErrRedemptionLimitReached
+		}
+	}
+
+	return d, nil
+}
+
+// IsValidationError reports whether err is one of the validation errors returned by Validate
+func IsValidationError(err error) bool {
+	switch err {
+	case ErrInvalidCode, ErrNotStarted, ErrExpired, ErrRedemptionLimitReached, ErrNotApplicable:
+		return true
+	}
+	return false
+}
+
+// validateInput checks a new discount before it is sent to the provider
+func validateInput(input lemonsqueezy.DiscountInput) error {
+	if input.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidDiscount)
+	}
+	if len(input.Code) < 3 || len(input.Code) > 64 {
+		return fmt.Errorf("%w: code must be between 3 and 64 characters", ErrInvalidDiscount)
+	}
+	for _, r := range input.Code {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return fmt.Errorf("%w: code may only contain letters and numbers", ErrInvalidDiscount)
+		}
+	}
+
+	switch input.AmountType {
+	case "percent":
+		if input.Amount < 1 || input.Amount > 100 {
+			return fmt.Errorf("%w: percent amount must be between 1 and 100", ErrInvalidDiscount)
+		}
+	case "fixed":
+		if input.Amount < 1 {
+			return fmt.Errorf("%w: fixed amount must be positive", ErrInvalidDiscount)
+		}
+	default:
+		return fmt.Errorf("%w: amount_type must be percent or fixed", ErrInvalidDiscount)
+	}
+
+	switch input.Duration {
+	case "once", "forever":
+	case "repeating":
+		if input.DurationInMonths < 1 {
+			return fmt.Errorf("%w: duration_in_months is required for repeating discounts", ErrInvalidDiscount)
+		}
+	default:
+		return fmt.Errorf("%w: duration must be once, repeating or forever", ErrInvalidDiscount)
+	}
+
+	if input.MaxRedemptions < 0 {
+		return fmt.Errorf("%w: max_redemptions must not be negative", ErrInvalidDiscount)
+	}
+	if input.StartsAt != nil && input.ExpiresAt != nil && !input.ExpiresAt.After(*input.StartsAt) {
+		return fmt.Errorf("%w: expires_at must be after starts_at", ErrInvalidDiscount)
+	}
+	return nil
+}
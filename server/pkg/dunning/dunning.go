@@ -0,0 +1,312 @@
+// Package dunning follows up on failed subscription payments with reminder emails,
+// keeps the subscription usable for a grace period and downgrades it when that runs out
+package dunning
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/clock"
+	"saas-server/pkg/email"
+	"saas-server/pkg/lemonsqueezy"
+)
+
+// DunningDB defines the database operations required by the dunning service
+type DunningDB interface {
+	OpenDunningCase(subscriptionID int, userID string, failedAt time.Time, graceEndsAt time.Time) (*models.DunningCase, bool, error)
+	GetOpenDunningCases() ([]models.DunningCase, error)
+	MarkDunningEmailSent(caseID int, emailsSent int, sentAt time.Time) error
+	MarkDunningCaseDowngraded(caseID int) error
+	ResolveDunningCase(subscriptionID int, resolvedAt time.Time) (*models.DunningCase, error)
+	GetUserByID(id string) (*models.User, error)
+	GetSubscriptionBySubscriptionID(subscriptionID int) (*models.Subscription, error)
+	UpdateSubscription(subscriptionID int, status string, cancelled bool, productID int, variantID int, renewsAt *time.Time, endsAt *time.Time, trialEndsAt *time.Time, source string) error
+	SyncUserPrimarySubscription(userID string) error
+	InvalidateUserCache(userID string)
+}
+
+// SubscriptionSource fetches a subscription from the payment provider to get a fresh payment method link
+type SubscriptionSource interface {
+	GetSubscription(subscriptionID string) (*lemonsqueezy.SubscriptionResponse, error)
+}
+
+// Notifier sends the dunning emails
+type Notifier interface {
+	SendPaymentFailed(to string, locale string, updatePaymentURL string, reminder int, graceEndsAt time.Time) error
+	SendPaymentRecovered(to string, locale string) error
+}
+
+// Publisher publishes in-app notifications
+type Publisher interface {
+	Publish(n *models.Notification) error
+}
+
+// EmailNotifier queues dunning emails in the email outbox
+type EmailNotifier struct {
+	Outbox *email.Outbox
+}
+
+// SendPaymentFailed sends a failed payment email
+func (n EmailNotifier) SendPaymentFailed(to string, locale string, updatePaymentURL string, reminder int, graceEndsAt time.Time) error {
+	msg, err := email.PaymentFailedEmail(to, locale, updatePaymentURL, reminder, graceEndsAt)
+	if err != nil {
+		return err
+	}
+	return n.Outbox.Enqueue(msg, "")
+}
+
+// SendPaymentRecovered sends a payment recovered email
+func (n EmailNotifier) SendPaymentRecovered(to string, locale string) error {
+	msg, err := email.PaymentRecoveredEmail(to, locale)
+	if err != nil {
+		return err
+	}
+	return n.Outbox.Enqueue(msg, "")
+}
+
+// Config controls the dunning sequence
+type Config struct {
+	// EmailDays are the days after the first failure on which an email is sent, e.g. 0, 3 and 7
+	EmailDays []int
+	// GracePeriod is how long the subscription stays usable after the first failure
+	GracePeriod time.Duration
+	// BillingURL is linked in emails when no payment method link is available
+	BillingURL string
+}
+
+// LoadConfig reads the dunning configuration from the environment.
+// DUNNING_EMAIL_DAYS is a comma separated list of days (default "0,3,7") and
+// DUNNING_GRACE_DAYS the grace period in days (default 10).
+func LoadConfig() Config {
+	config := Config{
+		EmailDays:   []int{0, 3, 7},
+		GracePeriod: 10 * 24 * time.Hour,
+		BillingURL:  os.Getenv("FRONTEND_URL") + "/profile",
+	}
+
+	if v := os.Getenv("DUNNING_EMAIL_DAYS"); v != "" {
+		var days []int
+		for _, part := range strings.Split(v, ",") {
+			day, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || day < 0 {
+				log.Printf("[Dunning] Ignoring invalid DUNNING_EMAIL_DAYS %q", v)
+				days = nil
+				break
+			}
+			days = append(days, day)
+		}
+		if len(days) > 0 {
+			sort.Ints(days)
+			config.EmailDays = days
+		}
+	}
+
+	if v := os.Getenv("DUNNING_GRACE_DAYS"); v != "" {
+		if days, err := strconv.Atoi(v); err == nil && days > 0 {
+			config.GracePeriod = time.Duration(days) * 24 * time.Hour
+		} else {
+			log.Printf("[Dunning] Ignoring invalid DUNNING_GRACE_DAYS %q", v)
+		}
+	}
+
+	return config
+}
+
+// Service runs the dunning workflow
+type Service struct {
+	db            DunningDB
+	subscriptions SubscriptionSource
+	notifier      Notifier
+	publisher     Publisher
+	clock         clock.Clock
+	config        Config
+}
+
+// NewService creates a new instance of Service
+func NewService(db DunningDB, subscriptions SubscriptionSource, notifier Notifier, publisher Publisher, clock clock.Clock, config Config) *Service {
+	return &Service{
+		db:            db,
+		subscriptions: subscriptions,
+		notifier:      notifier,
+		publisher:     publisher,
+		clock:         clock,
+		config:        config,
+	}
+}
+
+// StartDunningJob starts the background job that sends due reminders and downgrades
+// subscriptions whose grace period has ended
+func (s *Service) StartDunningJob(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			if err := s.ProcessDueCases(); err != nil {
+				log.Printf("Error processing dunning cases: %v", err)
+			}
+		}
+	}()
+}
+
+// PaymentFailed opens a dunning case for the subscription and sends the first email.
+// Repeated failures while a case is unresolved keep the original schedule.
+func (s *Service) PaymentFailed(subscriptionID int) error {
+	subscription, err := s.db.GetSubscriptionBySubscriptionID(subscriptionID)
+	if err != nil {
+		return err
+	}
+
+	now := s.clock.Now()
+	c, created, err := s.db.OpenDunningCase(subscriptionID, subscription.UserID, now, now.Add(s.config.GracePeriod))
+	if err != nil {
+		return err
+	}
+	if !created {
+		log.Printf("[Dunning] Subscription %d already has an unresolved dunning case %d", subscriptionID, c.ID)
+		return nil
+	}
+
+	log.Printf("[Dunning] Opened dunning case %d for subscription %d", c.ID, subscriptionID)
+
+	// The email is what matters, so a failed in-app notification doesn't fail the webhook
+	if err := s.publisher.Publish(&models.Notification{
+		UserID:   c.UserID,
+		Category: models.NotificationBilling,
+		Type:     models.NotificationTypePaymentFailed,
+		Title:    "Your payment failed",
+		Body:     fmt.Sprintf("Please update your payment method by %s to keep your subscription.", c.GraceEndsAt.Format("January 2, 2006")),
+		Link:     s.config.BillingURL,
+	}); err != nil {
+		log.Printf("[Dunning] Error publishing notification for dunning case %d: %v", c.ID, err)
+	}
+
+	return s.processCase(*c, now)
+}
+
+// PaymentRecovered closes the subscription's dunning case, restores a downgraded subscription
+// and confirms the payment to the user. It does nothing if there is no unresolved case.
+func (s *Service) PaymentRecovered(subscriptionID int) error {
+	c, err := s.db.ResolveDunningCase(subscriptionID, s.clock.Now())
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.Status == "downgraded" {
+		if err := s.setStatus(subscriptionID, "active", "dunning_recovered"); err != nil {
+			return err
+		}
+	} else {
+		// Entitlements depend on the case status, so cached ones are stale either way
+		s.db.InvalidateUserCache(c.UserID)
+	}
+
+	log.Printf("[Dunning] Subscription %d recovered, closed dunning case %d", subscriptionID, c.ID)
+
+	user, err := s.db.GetUserByID(c.UserID)
+	if err != nil {
+		return err
+	}
+	return s.notifier.SendPaymentRecovered(user.Email, user.Language)
+}
+
+// ProcessDueCases sends every due reminder and downgrades cases whose grace period has ended
+func (s *Service) ProcessDueCases() error {
+	cases, err := s.db.GetOpenDunningCases()
+	if err != nil {
+		return err
+	}
+
+	now := s.clock.Now()
+	for _, c := range cases {
+		if err := s.processCase(c, now); err != nil {
+			log.Printf("[Dunning] Error processing dunning case %d: %v", c.ID, err)
+		}
+	}
+	return nil
+}
+
+// processCase advances a single open case to where it should be at now
+func (s *Service) processCase(c models.DunningCase, now time.Time) error {
+	if !now.Before(c.GraceEndsAt) {
+		return s.downgrade(c)
+	}
+
+	// Only the latest due email is sent, so a job that was down doesn't send a burst of reminders
+	due := 0
+	for i, day := range s.config.EmailDays {
+		if !now.Before(c.FailedAt.AddDate(0, 0, day)) {
+			due = i + 1
+		}
+	}
+	if due <= c.EmailsSent {
+		return nil
+	}
+
+	user, err := s.db.GetUserByID(c.UserID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.notifier.SendPaymentFailed(user.Email, user.Language, s.updatePaymentURL(c.SubscriptionID), due-1, c.GraceEndsAt); err != nil {
+		return err
+	}
+	return s.db.MarkDunningEmailSent(c.ID, due, now)
+}
+
+// downgrade ends a case's grace period and removes the subscription's entitlements
+func (s *Service) downgrade(c models.DunningCase) error {
+	if err := s.db.MarkDunningCaseDowngraded(c.ID); err != nil {
+		return err
+	}
+	if err := s.setStatus(c.SubscriptionID, "unpaid", "dunning_grace_expired"); err != nil {
+		return err
+	}
+
+	log.Printf("[Dunning] Grace period ended, downgraded subscription %d", c.SubscriptionID)
+	return nil
+}
+
+// setStatus changes a subscription's status and refreshes its user's primary subscription
+func (s *Service) setStatus(subscriptionID int, status string, source string) error {
+	subscription, err := s.db.GetSubscriptionBySubscriptionID(subscriptionID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.UpdateSubscription(subscriptionID, status, subscription.Cancelled, subscription.ProductID, subscription.VariantID, subscription.RenewsAt, subscription.EndsAt, subscription.TrialEndsAt, source); err != nil {
+		return err
+	}
+	if err := s.db.SyncUserPrimarySubscription(subscription.UserID); err != nil {
+		return err
+	}
+
+	s.db.InvalidateUserCache(subscription.UserID)
+	return nil
+}
+
+// updatePaymentURL returns a fresh signed link for updating the payment method. The links
+// expire after a day, so the stored one from the last webhook is only used as a fallback.
+func (s *Service) updatePaymentURL(subscriptionID int) string {
+	resp, err := s.subscriptions.GetSubscription(strconv.Itoa(subscriptionID))
+	if err == nil && resp.Data.Attributes.URLs.UpdatePaymentMethod != "" {
+		return resp.Data.Attributes.URLs.UpdatePaymentMethod
+	}
+	if err != nil {
+		log.Printf("[Dunning] Error fetching subscription %d, using stored payment link: %v", subscriptionID, err)
+	}
+
+	subscription, err := s.db.GetSubscriptionBySubscriptionID(subscriptionID)
+	if err != nil || subscription.UpdatePaymentMethodURL == "" {
+		return s.config.BillingURL
+	}
+	return subscription.UpdatePaymentMethodURL
+}
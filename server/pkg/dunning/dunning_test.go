@@ -0,0 +1,408 @@
+package dunning
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/clock"
+	"saas-server/pkg/lemonsqueezy"
+)
+
+// fakeDB keeps dunning cases and subscriptions in memory
+type fakeDB struct {
+	cases         []*models.DunningCase
+	subscriptions map[int]*models.Subscription
+	users         map[string]*models.User
+	synced        []string
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		subscriptions: map[int]*models.Subscription{
+			42: {SubscriptionID: "42", UserID: "user-1", Status: "past_due", ProductID: 1, VariantID: 2},
+		},
+		users: map[string]*models.User{
+			"user-1": {ID: "user-1", Email: "user@example.com", Language: "en"},
+		},
+	}
+}
+
+func (db *fakeDB) unresolved(subscriptionID int) *models.DunningCase {
+	for _, c := range db.cases {
+		if c.SubscriptionID == subscriptionID && (c.Status == "open" || c.Status == "downgraded") {
+			return c
+		}
+	}
+	return nil
+}
+
+func (db *fakeDB) OpenDunningCase(subscriptionID int, userID string, failedAt time.Time, graceEndsAt time.Time) (*models.DunningCase, bool, error) {
+	if c := db.unresolved(subscriptionID); c != nil {
+		copied := *c
+		return &copied, false, nil
+	}
+	c := &models.DunningCase{
+		ID:             len(db.cases) + 1,
+		SubscriptionID: subscriptionID,
+		UserID:         userID,
+		Status:         "open",
+		FailedAt:       failedAt,
+		GraceEndsAt:    graceEndsAt,
+	}
+	db.cases = append(db.cases, c)
+	copied := *c
+	return &copied, true, nil
+}
+
+func (db *fakeDB) GetOpenDunningCases() ([]models.DunningCase, error) {
+	var cases []models.DunningCase
+	for _, c := range db.cases {
+		if c.Status == "open" {
+			cases = append(cases, *c)
+		}
+	}
+	return cases, nil
+}
+
+func (db *fakeDB) caseByID(id int) (*models.DunningCase, error) {
+	for _, c := range db.cases {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (db *fakeDB) MarkDunningEmailSent(caseID int, emailsSent int, sentAt time.Time) error {
+	c, err := db.caseByID(caseID)
+	if err != nil {
+		return err
+	}
+	c.EmailsSent = emailsSent
+	c.LastEmailAt = &sentAt
+	return nil
+}
+
+func (db *fakeDB) MarkDunningCaseDowngraded(caseID int) error {
+	c, err := db.caseByID(caseID)
+	if err != nil {
+		return err
+	}
+	c.Status = "downgraded"
+	return nil
+}
+
+func (db *fakeDB) ResolveDunningCase(subscriptionID int, resolvedAt time.Time) (*models.DunningCase, error) {
+	c := db.unresolved(subscriptionID)
+	if c == nil {
+		return nil, sql.ErrNoRows
+	}
+	previous := *c
+	c.Status = "recovered"
+	c.ResolvedAt = &resolvedAt
+	return &previous, nil
+}
+
+func (db *fakeDB) GetUserByID(id string) (*models.User, error) {
+	user, ok := db.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (db *fakeDB) GetSubscriptionBySubscriptionID(subscriptionID int) (*models.Subscription, error) {
+	subscription, ok := db.subscriptions[subscriptionID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *subscription
+	return &copied, nil
+}
+
+func (db *fakeDB) UpdateSubscription(subscriptionID int, status string, cancelled bool, productID int, variantID int, renewsAt *time.Time, endsAt *time.Time, trialEndsAt *time.Time, source string) error {
+	subscription, ok := db.subscriptions[subscriptionID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	subscription.Status = status
+	subscription.Cancelled = cancelled
+	return nil
+}
+
+func (db *fakeDB) SyncUserPrimarySubscription(userID string) error {
+	db.synced = append(db.synced, userID)
+	return nil
+}
+
+func (db *fakeDB) InvalidateUserCache(userID string) {}
+
+// fakeSource has no payment method links, so the stored one is used
+type fakeSource struct{}
+
+func (fakeSource) GetSubscription(subscriptionID string) (*lemonsqueezy.SubscriptionResponse, error) {
+	return nil, errors.New("not available")
+}
+
+// fakeNotifier records the emails it was asked to send
+type fakeNotifier struct {
+	reminders []int
+	recovered []string
+}
+
+func (n *fakeNotifier) SendPaymentFailed(to string, locale string, updatePaymentURL string, reminder int, graceEndsAt time.Time) error {
+	n.reminders = append(n.reminders, reminder)
+	return nil
+}
+
+func (n *fakeNotifier) SendPaymentRecovered(to string, locale string) error {
+	n.recovered = append(n.recovered, to)
+	return nil
+}
+
+// fakePublisher accepts every notification
+type fakePublisher struct {
+	published int
+}
+
+func (p *fakePublisher) Publish(n *models.Notification) error {
+	p.published++
+	return nil
+}
+
+var start = time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+
+func newTestService() (*Service, *fakeDB, *fakeNotifier, *clock.Fake) {
+	db := newFakeDB()
+	notifier := &fakeNotifier{}
+	clk := clock.NewFake(start)
+	config := Config{
+		EmailDays:   []int{0, 3, 7},
+		GracePeriod: 10 * 24 * time.Hour,
+		BillingURL:  "https://app.example.com/profile",
+	}
+	return NewService(db, fakeSource{}, notifier, &fakePublisher{}, clk, config), db, notifier, clk
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReminderSchedule(t *testing.T) {
+	s, db, notifier, clk := newTestService()
+
+	if err := s.PaymentFailed(42); err != nil {
+		t.Fatalf("PaymentFailed: %v", err)
+	}
+	if !equalInts(notifier.reminders, []int{0}) {
+		t.Fatalf("reminders after failure = %v, want [0]", notifier.reminders)
+	}
+
+	steps := []struct {
+		advance time.Duration
+		want    []int
+	}{
+		{24 * time.Hour, []int{0}},
+		{47 * time.Hour, []int{0}},
+		{time.Hour, []int{0, 1}},             // day 3
+		{24 * time.Hour, []int{0, 1}},        // day 4
+		{3 * 24 * time.Hour, []int{0, 1, 2}}, // day 7
+		{2 * 24 * time.Hour, []int{0, 1, 2}}, // day 9
+	}
+	for _, step := range steps {
+		clk.Advance(step.advance)
+		if err := s.ProcessDueCases(); err != nil {
+			t.Fatalf("ProcessDueCases: %v", err)
+		}
+		if !equalInts(notifier.reminders, step.want) {
+			t.Fatalf("reminders at %s = %v, want %v", clk.Now().Sub(start), notifier.reminders, step.want)
+		}
+	}
+
+	if db.cases[0].Status != "open" {
+		t.Errorf("case status before grace period ends = %q, want open", db.cases[0].Status)
+	}
+}
+
+func TestMissedRemindersSendOnlyLatest(t *testing.T) {
+	s, _, notifier, clk := newTestService()
+
+	if err := s.PaymentFailed(42); err != nil {
+		t.Fatalf("PaymentFailed: %v", err)
+	}
+	clk.Advance(8 * 24 * time.Hour)
+	if err := s.ProcessDueCases(); err != nil {
+		t.Fatalf("ProcessDueCases: %v", err)
+	}
+	if !equalInts(notifier.reminders, []int{0, 2}) {
+		t.Errorf("reminders = %v, want [0 2]", notifier.reminders)
+	}
+}
+
+func TestRepeatedFailureKeepsSchedule(t *testing.T) {
+	s, db, notifier, clk := newTestService()
+
+	if err := s.PaymentFailed(42); err != nil {
+		t.Fatalf("PaymentFailed: %v", err)
+	}
+	clk.Advance(2 * 24 * time.Hour)
+	if err := s.PaymentFailed(42); err != nil {
+		t.Fatalf("second PaymentFailed: %v", err)
+	}
+
+	if len(db.cases) != 1 {
+		t.Fatalf("cases = %d, want 1", len(db.cases))
+	}
+	c := db.cases[0]
+	if !c.FailedAt.Equal(start) {
+		t.Errorf("failed at = %v, want %v", c.FailedAt, start)
+	}
+	if want := start.Add(10 * 24 * time.Hour); !c.GraceEndsAt.Equal(want) {
+		t.Errorf("grace ends at = %v, want %v", c.GraceEndsAt, want)
+	}
+	if !equalInts(notifier.reminders, []int{0}) {
+		t.Errorf("reminders = %v, want [0]", notifier.reminders)
+	}
+
+	// The day 3 reminder still follows the first failure
+	clk.Advance(24 * time.Hour)
+	if err := s.ProcessDueCases(); err != nil {
+		t.Fatalf("ProcessDueCases: %v", err)
+	}
+	if !equalInts(notifier.reminders, []int{0, 1}) {
+		t.Errorf("reminders at day 3 = %v, want [0 1]", notifier.reminders)
+	}
+}
+
+func TestDowngradeWhenGraceEnds(t *testing.T) {
+	s, db, notifier, clk := newTestService()
+
+	if err := s.PaymentFailed(42); err != nil {
+		t.Fatalf("PaymentFailed: %v", err)
+	}
+	c := *db.cases[0]
+
+	if err := s.processCase(c, c.GraceEndsAt.Add(-time.Second)); err != nil {
+		t.Fatalf("processCase before grace ends: %v", err)
+	}
+	if db.cases[0].Status != "open" || db.subscriptions[42].Status != "past_due" {
+		t.Fatalf("downgraded before the grace period ended")
+	}
+	if !equalInts(notifier.reminders, []int{0, 2}) {
+		t.Fatalf("reminders before grace ends = %v, want [0 2]", notifier.reminders)
+	}
+
+	clk.Set(c.GraceEndsAt)
+	if err := s.processCase(*db.cases[0], clk.Now()); err != nil {
+		t.Fatalf("processCase: %v", err)
+	}
+	if db.cases[0].Status != "downgraded" {
+		t.Errorf("case status = %q, want downgraded", db.cases[0].Status)
+	}
+	if db.subscriptions[42].Status != "unpaid" {
+		t.Errorf("subscription status = %q, want unpaid", db.subscriptions[42].Status)
+	}
+	if len(db.synced) != 1 || db.synced[0] != "user-1" {
+		t.Errorf("synced users = %v, want [user-1]", db.synced)
+	}
+	if len(notifier.reminders) != 2 {
+		t.Errorf("reminders = %v, want no reminder on downgrade", notifier.reminders)
+	}
+
+	// Downgraded cases are no longer processed
+	clk.Advance(24 * time.Hour)
+	if err := s.ProcessDueCases(); err != nil {
+		t.Fatalf("ProcessDueCases: %v", err)
+	}
+	if len(notifier.reminders) != 2 {
+		t.Errorf("reminders after downgrade = %v", notifier.reminders)
+	}
+}
+
+func TestRecoveryRestoresDowngradedSubscription(t *testing.T) {
+	s, db, notifier, clk := newTestService()
+
+	if err := s.PaymentFailed(42); err != nil {
+		t.Fatalf("PaymentFailed: %v", err)
+	}
+	clk.Advance(11 * 24 * time.Hour)
+	if err := s.ProcessDueCases(); err != nil {
+		t.Fatalf("ProcessDueCases: %v", err)
+	}
+	if db.subscriptions[42].Status != "unpaid" {
+		t.Fatalf("subscription status = %q, want unpaid", db.subscriptions[42].Status)
+	}
+
+	if err := s.PaymentRecovered(42); err != nil {
+		t.Fatalf("PaymentRecovered: %v", err)
+	}
+	if db.subscriptions[42].Status != "active" {
+		t.Errorf("subscription status = %q, want active", db.subscriptions[42].Status)
+	}
+	if db.cases[0].Status != "recovered" {
+		t.Errorf("case status = %q, want recovered", db.cases[0].Status)
+	}
+	if len(notifier.recovered) != 1 {
+		t.Errorf("recovered emails = %d, want 1", len(notifier.recovered))
+	}
+
+	// A later failure starts a new case
+	if err := s.PaymentFailed(42); err != nil {
+		t.Fatalf("PaymentFailed after recovery: %v", err)
+	}
+	if len(db.cases) != 2 {
+		t.Errorf("cases = %d, want 2", len(db.cases))
+	}
+}
+
+func TestRecoveryWithoutCase(t *testing.T) {
+	s, db, notifier, _ := newTestService()
+
+	if err := s.PaymentRecovered(42); err != nil {
+		t.Fatalf("PaymentRecovered: %v", err)
+	}
+	if len(notifier.recovered) != 0 {
+		t.Errorf("recovered emails = %d, want 0", len(notifier.recovered))
+	}
+	if got := db.subscriptions[42].Status; got != "past_due" {
+		t.Errorf("subscription status = %q, want past_due", got)
+	}
+}
+
+func TestRecoveryBeforeDowngradeKeepsStatus(t *testing.T) {
+	s, db, notifier, clk := newTestService()
+
+	if err := s.PaymentFailed(42); err != nil {
+		t.Fatalf("PaymentFailed: %v", err)
+	}
+	clk.Advance(4 * 24 * time.Hour)
+	if err := s.PaymentRecovered(42); err != nil {
+		t.Fatalf("PaymentRecovered: %v", err)
+	}
+	// The webhook that reported the payment sets the status, not the dunning service
+	if got := db.subscriptions[42].Status; got != "past_due" {
+		t.Errorf("subscription status = %q, want it unchanged", got)
+	}
+	if len(notifier.recovered) != 1 {
+		t.Errorf("recovered emails = %d, want 1", len(notifier.recovered))
+	}
+
+	clk.Advance(4 * 24 * time.Hour)
+	if err := s.ProcessDueCases(); err != nil {
+		t.Fatalf("ProcessDueCases: %v", err)
+	}
+	if !equalInts(notifier.reminders, []int{0}) {
+		t.Errorf("reminders after recovery = %v, want [0]", notifier.reminders)
+	}
+}
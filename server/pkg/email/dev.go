@@ -0,0 +1,56 @@
+package email
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// unsafeFileChars matches characters not kept in .eml file names
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// DevMailer is a transport for local development that never sends email. It writes each
+// message to an .eml file in Dir, which mail clients can open, or only logs it when Dir is empty.
+type DevMailer struct {
+	Dir  string
+	From string
+}
+
+// Send writes or logs the email. The ID is the written file's path, or empty when logging.
+func (m *DevMailer) Send(msg Message) (string, error) {
+	if m.Dir == "" {
+		log.Printf("[Email] Not sent (log transport): to=%s subject=%q", msg.To, msg.Subject)
+		return "", nil
+	}
+
+	from := m.From
+	if from == "" {
+		from = "dev@localhost"
+	}
+	now := time.Now()
+	data, _, err := buildMIME(from, msg, now)
+	if err != nil {
+		return "", fmt.Errorf("error building email: %w", err)
+	}
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return "", fmt.Errorf("error creating email directory: %w", err)
+	}
+	name := fmt.Sprintf("%s-%s.eml", now.Format("20060102-150405.000000"), unsafeFileChars.ReplaceAllString(msg.To, "_"))
+	path := filepath.Join(m.Dir, name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", fmt.Errorf("error writing email: %w", err)
+	}
+
+	log.Printf("[Email] Not sent (file transport): to=%s subject=%q written to %s", msg.To, msg.Subject, path)
+	return path, nil
+}
+
+// Track logs the event
+func (m *DevMailer) Track(event Event) error {
+	log.Printf("[Email] Tracked %s event for %s", event.Name, event.Email)
+	return nil
+}
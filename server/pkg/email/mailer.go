@@ -0,0 +1,237 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a single email
+type Message struct {
+	To      string
+	Subject string
+	HTML    string
+	// Text is the plain-text alternative of HTML, sent as a second part when set by
+	// transports that support it. The Plunk API only takes an HTML body, so Plunk drops it.
+	Text string
+	// Headers are extra headers such as List-Unsubscribe
+	Headers map[string]string
+	// Critical marks security emails such as password resets, which can be configured to
+	// reach addresses on the suppression list
+	Critical bool
+	// Category is the notification category recipients can opt out of, or empty for
+	// transactional emails
+	Category string
+	// rendered marks messages produced by Render, whose HTML is escaped by the template
+	// engine and must not be run through the user content sanitizer
+	rendered bool
+}
+
+// Event is a contact event tracked with the email provider, e.g. to start one of its automations
+type Event struct {
+	Name       string
+	Email      string
+	Subscribed bool
+	Data       map[string]string
+}
+
+// Mailer delivers emails and tracks contact events. Implementations don't validate
+// messages, so emails should go through NewOutbound and the outbox.
+type Mailer interface {
+	// Send delivers an email and returns the provider's ID for it. The SMTP and file
+	// transports send multipart/alternative emails with Text as the plain-text part; Plunk
+	// only sends the HTML part, as its API has no field for a plain-text body.
+	Send(msg Message) (string, error)
+	// Track records a contact event. Transports without contacts ignore it.
+	Track(event Event) error
+}
+
+// Config selects and configures the email transport
+type Config struct {
+	// Transport is "plunk", "smtp", "file" or "log"
+	Transport string
+	// From is the sender address used by the SMTP and file transports
+	From string
+	// PlunkAPIKey is the secret key of the Plunk API
+	PlunkAPIKey string
+	// SMTP server settings. Username may be empty for servers without authentication.
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	// Dir is where the file transport writes .eml files
+	Dir string
+}
+
+// LoadConfig reads the email configuration from the environment.
+// EMAIL_TRANSPORT picks the transport; it defaults to "plunk" when ENV=production and to
+// "log" otherwise, so local development sends no real email. SMTP uses SMTP_HOST, SMTP_PORT
+// (default 587), SMTP_USERNAME and SMTP_PASSWORD, and the file transport EMAIL_DIR
+// (default "tmp/emails"). EMAIL_FROM is the sender address.
+func LoadConfig() Config {
+	config := Config{
+		Transport:    os.Getenv("EMAIL_TRANSPORT"),
+		From:         os.Getenv("EMAIL_FROM"),
+		PlunkAPIKey:  os.Getenv("PLUNK_SECRET_API_KEY"),
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     587,
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		Dir:          os.Getenv("EMAIL_DIR"),
+	}
+
+	if config.Transport == "" {
+		config.Transport = "log"
+		if os.Getenv("ENV") == "production" {
+			config.Transport = "plunk"
+		}
+	}
+
+	if v := os.Getenv("SMTP_PORT"); v != "" {
+		if port, err := strconv.Atoi(v); err == nil && port > 0 {
+			config.SMTPPort = port
+		} else {
+			log.Printf("[Email] Ignoring invalid SMTP_PORT %q", v)
+		}
+	}
+
+	if config.Dir == "" {
+		config.Dir = "tmp/emails"
+	}
+
+	return config
+}
+
+// NewMailer creates the transport selected by config
+func NewMailer(config Config) (Mailer, error) {
+	switch config.Transport {
+	case "plunk":
+		if config.PlunkAPIKey == "" {
+			return nil, fmt.Errorf("PLUNK_SECRET_API_KEY not set")
+		}
+		log.Println("[Email] Plunk only sends the HTML part of emails, use the smtp transport to include the plain-text part")
+		return NewPlunkMailer(config.PlunkAPIKey), nil
+	case "smtp":
+		if config.SMTPHost == "" || config.From == "" {
+			return nil, fmt.Errorf("SMTP_HOST and EMAIL_FROM are required for the smtp transport")
+		}
+		return &SMTPMailer{
+			Host:     config.SMTPHost,
+			Port:     config.SMTPPort,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+			From:     config.From,
+		}, nil
+	case "file":
+		return &DevMailer{Dir: config.Dir, From: config.From}, nil
+	case "log":
+		return &DevMailer{From: config.From}, nil
+	default:
+		return nil, fmt.Errorf("unknown EMAIL_TRANSPORT %q", config.Transport)
+	}
+}
+
+// buildMIME renders a message as an RFC 5322 email with a quoted-printable HTML body,
+// wrapped in a multipart/alternative with the plain-text part when the message has one.
+// It returns the email and its Message-ID.
+func buildMIME(from string, msg Message, now time.Time) ([]byte, string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = strings.Trim(from[at+1:], "<> ")
+	}
+
+	messageID := fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: %s\r\n", messageID)
+	names := make([]string, 0, len(msg.Headers))
+	for name := range msg.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, msg.Headers[name])
+	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.Text == "" {
+		if err := writeMIMEPart(&buf, "text/html", msg.HTML); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), messageID, nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	// Clients show the last part they support, so the HTML part goes last
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", msg.Text},
+		{"text/html", msg.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=UTF-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), messageID, nil
+}
+
+// writeMIMEPart writes the headers and quoted-printable body of a single-part email
+func writeMIMEPart(buf *bytes.Buffer, contentType string, body string) error {
+	fmt.Fprintf(buf, "Content-Type: %s; charset=UTF-8\r\n", contentType)
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	buf.WriteString("\r\n")
+	return writeQuotedPrintable(buf, body)
+}
+
+// writeQuotedPrintable writes body to w in quoted-printable encoding
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// SetListUnsubscribe sets the unsubscribe link of the email footer and adds the
+// List-Unsubscribe headers, including the RFC 8058 one-click header that lets mail clients
+// unsubscribe with a POST to unsubscribeURL
+func (m *Message) SetListUnsubscribe(unsubscribeURL string) {
+	m.HTML = strings.ReplaceAll(m.HTML, UnsubscribePlaceholder, html.EscapeString(unsubscribeURL))
+	m.Text = strings.ReplaceAll(m.Text, UnsubscribePlaceholder, unsubscribeURL)
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers["List-Unsubscribe"] = "<" + unsubscribeURL + ">"
+	m.Headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+}
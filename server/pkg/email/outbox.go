@@ -0,0 +1,215 @@
+package email
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/clock"
+)
+
+// OutboxDB defines the database operations required by the outbox
+type OutboxDB interface {
+	EnqueueEmail(e *models.OutboundEmail) (bool, error)
+	ClaimDueEmails(now time.Time, limit int, lease time.Duration) ([]models.OutboundEmail, error)
+	MarkEmailSent(id int64, providerMessageID string, sentAt time.Time) error
+	MarkEmailFailed(id int64, errMsg string, retryAt *time.Time) error
+	MarkEmailSuppressed(id int64) error
+	IsEmailSuppressed(email string) (bool, error)
+	MarkEmailOptedOut(id int64) error
+	IsEmailNotificationEnabled(email string, category string) (bool, error)
+}
+
+// Unsubscriber provides the signed link that turns off a notification category for a recipient
+type Unsubscriber interface {
+	UnsubscribeURL(address string, category string) string
+}
+
+// OutboxConfig controls how the outbox worker retries failed emails
+type OutboxConfig struct {
+	// MaxAttempts is how often an email is tried before it is given up on
+	MaxAttempts int
+	// RetryBase is the delay before the first retry; each further retry waits twice as long
+	RetryBase time.Duration
+	// RetryMax caps the delay between retries
+	RetryMax time.Duration
+	// BatchSize is how many emails are claimed per run
+	BatchSize int
+	// Lease is how long a claimed email is reserved for the worker sending it
+	Lease time.Duration
+	// CriticalBypassesSuppression delivers security-critical emails to suppressed addresses
+	CriticalBypassesSuppression bool
+}
+
+// LoadOutboxConfig reads the outbox configuration from the environment.
+// EMAIL_MAX_ATTEMPTS sets how often an email is tried (default 8). Retries start after a
+// minute and back off exponentially up to six hours. EMAIL_SUPPRESSION_ALLOW_CRITICAL
+// controls whether security-critical emails still go to suppressed addresses (default true).
+func LoadOutboxConfig() OutboxConfig {
+	config := OutboxConfig{
+		MaxAttempts: 8,
+		RetryBase:   time.Minute,
+		RetryMax:    6 * time.Hour,
+		BatchSize:   50,
+		Lease:       5 * time.Minute,
+
+		CriticalBypassesSuppression: true,
+	}
+
+	if v := os.Getenv("EMAIL_MAX_ATTEMPTS"); v != "" {
+		if attempts, err := strconv.Atoi(v); err == nil && attempts > 0 {
+			config.MaxAttempts = attempts
+		} else {
+			log.Printf("[Email] Ignoring invalid EMAIL_MAX_ATTEMPTS %q", v)
+		}
+	}
+
+	if v := os.Getenv("EMAIL_SUPPRESSION_ALLOW_CRITICAL"); v != "" {
+		if allow, err := strconv.ParseBool(v); err == nil {
+			config.CriticalBypassesSuppression = allow
+		} else {
+			log.Printf("[Email] Ignoring invalid EMAIL_SUPPRESSION_ALLOW_CRITICAL %q", v)
+		}
+	}
+
+	return config
+}
+
+// Outbox queues emails in the database and delivers them in the background, so a provider
+// outage delays emails instead of failing requests or losing them
+type Outbox struct {
+	db           OutboxDB
+	mailer       Mailer
+	unsubscriber Unsubscriber
+	clock        clock.Clock
+	config       OutboxConfig
+}
+
+// NewOutbox creates a new instance of Outbox. Emails recipients can opt out of get their
+// unsubscribe link from unsubscriber when they are enqueued.
+func NewOutbox(db OutboxDB, mailer Mailer, unsubscriber Unsubscriber, clock clock.Clock, config OutboxConfig) *Outbox {
+	return &Outbox{
+		db:           db,
+		mailer:       mailer,
+		unsubscriber: unsubscriber,
+		clock:        clock,
+		config:       config,
+	}
+}
+
+// StartWorker starts the background job that delivers queued emails
+func (o *Outbox) StartWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			if err := o.ProcessDue(); err != nil {
+				log.Printf("Error sending queued emails: %v", err)
+			}
+		}
+	}()
+}
+
+// Enqueue validates an email and adds it to the outbox. Emails with a non-empty
+// idempotency key are only queued once.
+func (o *Outbox) Enqueue(msg Message, idempotencyKey string) error {
+	if Optional(msg.Category) && msg.Headers["List-Unsubscribe"] == "" && o.unsubscriber != nil {
+		msg.SetListUnsubscribe(o.unsubscriber.UnsubscribeURL(msg.To, msg.Category))
+	}
+	outbound, err := NewOutbound(msg, idempotencyKey)
+	if err != nil {
+		return err
+	}
+	_, err = o.db.EnqueueEmail(outbound)
+	return err
+}
+
+// ProcessDue sends the emails that are due, batch by batch until none are left
+func (o *Outbox) ProcessDue() error {
+	for {
+		emails, err := o.db.ClaimDueEmails(o.clock.Now(), o.config.BatchSize, o.config.Lease)
+		if err != nil {
+			return err
+		}
+		for _, e := range emails {
+			o.deliver(e)
+		}
+		if len(emails) < o.config.BatchSize {
+			return nil
+		}
+	}
+}
+
+// deliver sends a claimed email and records the outcome. Emails to suppressed addresses
+// are skipped unless they are critical and the config lets those through, and emails of a
+// category the recipient turned off are skipped.
+func (o *Outbox) deliver(e models.OutboundEmail) {
+	if !e.Critical || !o.config.CriticalBypassesSuppression {
+		suppressed, err := o.db.IsEmailSuppressed(e.To)
+		if err != nil {
+			o.fail(e, fmt.Errorf("error checking suppression list: %w", err))
+			return
+		}
+		if suppressed {
+			if err := o.db.MarkEmailSuppressed(e.ID); err != nil {
+				log.Printf("[Email] Error marking email %d as suppressed: %v", e.ID, err)
+			}
+			log.Printf("[Email] Skipped email %d to suppressed address %s", e.ID, e.To)
+			return
+		}
+	}
+
+	if Optional(e.Category) {
+		enabled, err := o.db.IsEmailNotificationEnabled(e.To, e.Category)
+		if err != nil {
+			o.fail(e, fmt.Errorf("error checking notification preferences: %w", err))
+			return
+		}
+		if !enabled {
+			if err := o.db.MarkEmailOptedOut(e.ID); err != nil {
+				log.Printf("[Email] Error marking email %d as opted out: %v", e.ID, err)
+			}
+			log.Printf("[Email] Skipped %s email %d to %s, who turned those off", e.Category, e.ID, e.To)
+			return
+		}
+	}
+
+	providerID, err := o.mailer.Send(Message{To: e.To, Subject: e.Subject, HTML: e.HTML, Text: e.Text, Headers: e.Headers, Critical: e.Critical, Category: e.Category})
+	if err != nil {
+		o.fail(e, err)
+		return
+	}
+	if err := o.db.MarkEmailSent(e.ID, providerID, o.clock.Now()); err != nil {
+		log.Printf("[Email] Error marking email %d as sent: %v", e.ID, err)
+	}
+	log.Printf("[Email] Sent email %d to %s with subject: %s", e.ID, e.To, e.Subject)
+}
+
+// fail records a failed delivery attempt and schedules a retry unless the email is out of attempts
+func (o *Outbox) fail(e models.OutboundEmail, err error) {
+	var retryAt *time.Time
+	if e.Attempts < o.config.MaxAttempts {
+		next := o.clock.Now().Add(o.backoff(e.Attempts))
+		retryAt = &next
+		log.Printf("[Email] Error sending email %d (attempt %d), retrying at %s: %v", e.ID, e.Attempts, next.Format(time.RFC3339), err)
+	} else {
+		log.Printf("[Email] Giving up on email %d after %d attempts: %v", e.ID, e.Attempts, err)
+	}
+	if err := o.db.MarkEmailFailed(e.ID, err.Error(), retryAt); err != nil {
+		log.Printf("[Email] Error recording failure of email %d: %v", e.ID, err)
+	}
+}
+
+// backoff returns the delay before the retry following the given attempt
+func (o *Outbox) backoff(attempt int) time.Duration {
+	delay := o.config.RetryBase
+	for i := 1; i < attempt && delay < o.config.RetryMax; i++ {
+		delay *= 2
+	}
+	if delay > o.config.RetryMax {
+		delay = o.config.RetryMax
+	}
+	return delay
+}
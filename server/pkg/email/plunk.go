@@ -0,0 +1,108 @@
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// plunkBaseURL is the base URL of the Plunk API
+const plunkBaseURL = "https://api.useplunk.com/v1"
+
+// PlunkEmailRequest represents the request format for Plunk API. It has no plain-text body.
+type PlunkEmailRequest struct {
+	To      string            `json:"to"`
+	Subject string            `json:"subject"`
+	HTML    string            `json:"html"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// PlunkSendResponse is the response of the Plunk API to a sent email
+type PlunkSendResponse struct {
+	Success bool `json:"success"`
+	Emails  []struct {
+		Email string `json:"email"` // ID of the sent email
+	} `json:"emails"`
+}
+
+// PlunkTrackRequest represents a contact event sent to the Plunk API
+type PlunkTrackRequest struct {
+	Event      string            `json:"event"`
+	Email      string            `json:"email"`
+	Subscribed bool              `json:"subscribed"`
+	Data       map[string]string `json:"data,omitempty"`
+}
+
+// PlunkMailer sends emails and tracks events through the Plunk API
+type PlunkMailer struct {
+	apiKey string
+	client *http.Client
+}
+
+// NewPlunkMailer creates a new PlunkMailer
+func NewPlunkMailer(apiKey string) *PlunkMailer {
+	return &PlunkMailer{
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Send sends an email through Plunk. The Plunk API only takes an HTML body, so msg.Text is
+// not sent and recipients get an HTML-only email.
+func (p *PlunkMailer) Send(msg Message) (string, error) {
+	var resp PlunkSendResponse
+	if err := p.post("/send", PlunkEmailRequest{To: msg.To, Subject: msg.Subject, HTML: msg.HTML, Headers: msg.Headers}, &resp); err != nil {
+		return "", fmt.Errorf("error sending email: %w", err)
+	}
+	if len(resp.Emails) == 0 {
+		return "", nil
+	}
+	return resp.Emails[0].Email, nil
+}
+
+// Track records a contact event in Plunk
+func (p *PlunkMailer) Track(event Event) error {
+	err := p.post("/track", PlunkTrackRequest{
+		Event:      event.Name,
+		Email:      event.Email,
+		Subscribed: event.Subscribed,
+		Data:       event.Data,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error tracking %s event: %w", event.Name, err)
+	}
+	return nil
+}
+
+// post sends a JSON request to the Plunk API and decodes the response into out unless it is nil
+func (p *PlunkMailer) post(path string, body interface{}, out interface{}) error {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", plunkBaseURL+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error response from Plunk API: %d", resp.StatusCode)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("error decoding response: %w", err)
+		}
+	}
+	return nil
+}
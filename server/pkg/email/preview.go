@@ -0,0 +1,73 @@
+package email
+
+import (
+	"fmt"
+	"time"
+
+	"saas-server/models"
+)
+
+// previewData returns sample data for each template, used to preview emails without
+// triggering them
+func previewData(name string) (interface{}, bool) {
+	now := time.Now()
+	switch name {
+	case "password_reset":
+		return LinkData{URL: "https://example.com/reset-password?token=preview"}, true
+	case "verify_email":
+		return LinkData{URL: "https://example.com/verify-email?token=preview"}, true
+	case "newsletter_confirm":
+		return LinkData{URL: "https://example.com/newsletter/confirm?token=preview"}, true
+	case "payment_failed":
+		return PaymentFailedData{URL: "https://example.com/profile", GraceEndsAt: now.AddDate(0, 0, 10)}, true
+	case "payment_recovered":
+		return nil, true
+	case "trial_ending":
+		return TrialEndingData{URL: "https://example.com/pricing", EndsAt: now.AddDate(0, 0, 3)}, true
+	case "trial_expired":
+		return LinkData{URL: "https://example.com/pricing"}, true
+	case "campaign":
+		return CampaignData{
+			Subject: "What's new this month",
+			Body:    "<h1>What's new this month</h1><p>We shipped <a href=\"https://example.com/changelog\">a lot of improvements</a>.</p>",
+			Text:    "What's new this month\n\nWe shipped a lot of improvements (https://example.com/changelog).",
+		}, true
+	case "lifecycle_welcome", "lifecycle_inactive":
+		return LifecycleData{Name: "Jane", URL: "https://example.com", EventAt: now}, true
+	case "lifecycle_verify_reminder":
+		return LifecycleData{Name: "Jane", URL: "https://example.com/profile", EventAt: now.AddDate(0, 0, -1)}, true
+	case "lifecycle_trial_ending":
+		return LifecycleData{Name: "Jane", URL: "https://example.com/pricing", EventAt: now.AddDate(0, 0, 3)}, true
+	case "lifecycle_cancelled":
+		return LifecycleData{Name: "Jane", URL: "https://example.com/pricing", EventAt: now}, true
+	case "contact_form":
+		return ContactFormData{
+			Name:    "Jane Doe",
+			Email:   "jane@example.com",
+			Subject: "Question about pricing",
+			Message: "Hi,\ndo you offer discounts for non-profits?\n\nThanks!",
+		}, true
+	case "usage_review":
+		return UsageReviewData{Reports: []models.UsageReport{
+			{ID: 42, SubscriptionID: 1001, SubscriptionItemID: 2001, Quantity: 1250, Attempts: 1, LastError: "request timed out"},
+		}}, true
+	default:
+		return nil, false
+	}
+}
+
+// Preview renders a template with sample data
+func Preview(name string, locale string) (Message, error) {
+	data, ok := previewData(name)
+	if !ok {
+		return Message{}, fmt.Errorf("no preview data for email template %q", name)
+	}
+	msg, err := Render(name, locale, data)
+	if err != nil {
+		return Message{}, err
+	}
+	if Optional(msg.Category) {
+		msg.SetListUnsubscribe("https://example.com/api/notifications/unsubscribe?token=preview")
+	}
+	return msg, nil
+}
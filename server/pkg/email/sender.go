@@ -1,145 +1,209 @@
-// Package email provides utilities for sending emails with validation and sanitization
+// Package email builds, queues and delivers emails with validation and sanitization
 package email
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"log"
-	"net/http"
-	"os"
+	htmltemplate "html/template"
+	"strings"
+	"time"
 
+	"saas-server/models"
 	"saas-server/pkg/validation"
 )
 
-// PlunkEmailRequest represents the request format for Plunk API
-type PlunkEmailRequest struct {
-	To      string `json:"to"`
-	Subject string `json:"subject"`
-	HTML    string `json:"html"`
-}
-
-// SendEmail sends an email using the Plunk API with validation and sanitization
-func SendEmail(to, subject, htmlContent string) error {
+// NewOutbound validates and sanitizes an email and prepares it for the outbox. The
+// plain-text part is stored as is, since it is never interpreted as markup.
+// Enqueueing an email with the same non-empty idempotency key again is a no-op.
+func NewOutbound(msg Message, idempotencyKey string) (*models.OutboundEmail, error) {
 	// Validate email address
-	if !validation.ValidateEmail(to) {
-		return fmt.Errorf("invalid email address: %s", to)
+	if !validation.ValidateEmail(msg.To) {
+		return nil, fmt.Errorf("invalid email address: %s", msg.To)
 	}
 
 	// Sanitize subject
-	subject = validation.SanitizeInput(subject, 200)
+	subject := validation.SanitizeInput(msg.Subject, 200)
 	if subject == "" {
-		return fmt.Errorf("email subject cannot be empty")
+		return nil, fmt.Errorf("email subject cannot be empty")
 	}
 
-	// Sanitize HTML content
-	htmlContent = validation.SanitizeHTML(htmlContent)
-	if htmlContent == "" {
-		return fmt.Errorf("email content cannot be empty")
+	// Sanitize HTML content unless it comes from a template, which escapes all data
+	htmlContent := msg.HTML
+	if !msg.rendered {
+		htmlContent = validation.SanitizeHTML(htmlContent)
 	}
-
-	// Get Plunk API key
-	plunkAPIKey := os.Getenv("PLUNK_SECRET_API_KEY")
-	if plunkAPIKey == "" {
-		return fmt.Errorf("PLUNK_SECRET_API_KEY not set")
+	if strings.TrimSpace(htmlContent) == "" {
+		return nil, fmt.Errorf("email content cannot be empty")
 	}
 
-	// Create email request
-	emailReq := PlunkEmailRequest{
-		To:      to,
-		Subject: subject,
-		HTML:    htmlContent,
+	// Emails recipients can opt out of must carry their unsubscribe link
+	if Optional(msg.Category) && (strings.Contains(htmlContent, UnsubscribePlaceholder) || strings.Contains(msg.Text, UnsubscribePlaceholder)) {
+		return nil, fmt.Errorf("email has no unsubscribe link")
 	}
 
-	// Marshal request to JSON
-	jsonData, err := json.Marshal(emailReq)
-	if err != nil {
-		return fmt.Errorf("error marshaling email request: %w", err)
+	// Header values are written to the email verbatim, so line breaks would inject headers
+	for name, value := range msg.Headers {
+		if strings.ContainsAny(name+value, "\r\n") || strings.ContainsAny(name, ": ") {
+			return nil, fmt.Errorf("invalid email header %q", name)
+		}
 	}
 
-	// Create HTTP request to Plunk API
-	req, err := http.NewRequest("POST", "https://api.useplunk.com/v1/send", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("error creating email request: %w", err)
-	}
+	return &models.OutboundEmail{
+		IdempotencyKey: idempotencyKey,
+		To:             msg.To,
+		Subject:        subject,
+		HTML:           htmlContent,
+		Text:           msg.Text,
+		Headers:        msg.Headers,
+		Critical:       msg.Critical,
+		Category:       msg.Category,
+	}, nil
+}
+
+// TrackUserSignup records a new user as a subscribed contact
+func TrackUserSignup(m Mailer, to string, name string) error {
+	return m.Track(Event{
+		Name:       "user-signup",
+		Email:      to,
+		Subscribed: true,
+		Data:       map[string]string{"name": name},
+	})
+}
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+plunkAPIKey)
+// TrackNewsletterUnsubscribe records that a newsletter subscriber unsubscribed
+func TrackNewsletterUnsubscribe(m Mailer, to string) error {
+	return m.Track(Event{
+		Name:       "newsletter-unsubscribe",
+		Email:      to,
+		Subscribed: false,
+	})
+}
+
+// TrackNewsletterSubscription records a newsletter subscriber as a subscribed contact
+func TrackNewsletterSubscription(m Mailer, to string) error {
+	return m.Track(Event{
+		Name:       "newsletter-subscription",
+		Email:      to,
+		Subscribed: true,
+	})
+}
 
-	// Send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// render renders a template and addresses the email to to
+func render(to string, name string, locale string, data interface{}) (Message, error) {
+	msg, err := Render(name, locale, data)
 	if err != nil {
-		return fmt.Errorf("error sending email: %w", err)
+		return Message{}, err
 	}
-	defer resp.Body.Close()
+	msg.To = to
+	return msg, nil
+}
+
+// LinkData is the template data of emails whose main content is a single link
+type LinkData struct {
+	URL string
+}
+
+// PaymentFailedData is the template data of the payment failed email
+type PaymentFailedData struct {
+	URL         string
+	Reminder    int // 0 for the first email of the dunning sequence, counting up for follow-ups
+	GraceEndsAt time.Time
+}
+
+// TrialEndingData is the template data of the trial ending email
+type TrialEndingData struct {
+	URL    string
+	EndsAt time.Time
+}
+
+// ContactFormData is the template data of the contact form email sent to the admin
+type ContactFormData struct {
+	Name    string
+	Email   string
+	Subject string
+	Message string
+}
+
+// UsageReviewData is the template data of the email telling the admin about usage reports
+// that need to be checked against the payment provider
+type UsageReviewData struct {
+	Reports []models.UsageReport
+}
 
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error response from Plunk API: %d", resp.StatusCode)
+// LifecycleData is the template data of lifecycle emails
+type LifecycleData struct {
+	Name    string
+	URL     string
+	EventAt time.Time // When the event the email follows up on happened, e.g. when the trial ends
+}
+
+// CampaignData is the template data of a newsletter campaign email
+type CampaignData struct {
+	Subject      string
+	Body         htmltemplate.HTML // Sanitized campaign content
+	Text         string
+	OpenPixelURL string // Empty to send without open tracking
+}
+
+// PasswordResetEmail builds a password reset email with a secure token
+func PasswordResetEmail(to string, locale string, resetURL string) (Message, error) {
+	msg, err := render(to, "password_reset", locale, LinkData{URL: resetURL})
+	msg.Critical = true
+	return msg, err
+}
+
+// VerificationEmail builds the email verification link sent to the user
+func VerificationEmail(to string, locale string, verificationURL string) (Message, error) {
+	msg, err := render(to, "verify_email", locale, LinkData{URL: verificationURL})
+	msg.Critical = true
+	return msg, err
+}
+
+// PaymentFailedEmail asks the user to update their payment method after a failed subscription payment.
+// reminder is 0 for the first email of the dunning sequence and counts up for follow-ups.
+func PaymentFailedEmail(to string, locale string, updatePaymentURL string, reminder int, graceEndsAt time.Time) (Message, error) {
+	return render(to, "payment_failed", locale, PaymentFailedData{URL: updatePaymentURL, Reminder: reminder, GraceEndsAt: graceEndsAt})
+}
+
+// PaymentRecoveredEmail confirms that a previously failed subscription payment has gone through
+func PaymentRecoveredEmail(to string, locale string) (Message, error) {
+	return render(to, "payment_recovered", locale, nil)
+}
+
+// TrialEndingEmail reminds the user that their free trial ends soon and links to the upgrade page
+func TrialEndingEmail(to string, locale string, upgradeURL string, endsAt time.Time) (Message, error) {
+	return render(to, "trial_ending", locale, TrialEndingData{URL: upgradeURL, EndsAt: endsAt})
+}
+
+// TrialExpiredEmail tells the user their free trial has ended
+func TrialExpiredEmail(to string, locale string, upgradeURL string) (Message, error) {
+	return render(to, "trial_expired", locale, LinkData{URL: upgradeURL})
+}
+
+// NewsletterConfirmationEmail asks a new subscriber to confirm their newsletter subscription
+func NewsletterConfirmationEmail(to string, locale string, confirmURL string) (Message, error) {
+	return render(to, "newsletter_confirm", locale, LinkData{URL: confirmURL})
+}
+
+// LifecycleEmail builds an email sent by a lifecycle rule with one of the lifecycle templates
+func LifecycleEmail(to string, template string, locale string, data LifecycleData) (Message, error) {
+	if !strings.HasPrefix(template, lifecyclePrefix) {
+		return Message{}, fmt.Errorf("%q is not a lifecycle email template", template)
 	}
+	return render(to, template, locale, data)
+}
+
+// CampaignEmail builds a newsletter campaign email
+func CampaignEmail(to string, data CampaignData) (Message, error) {
+	return render(to, "campaign", DefaultLocale, data)
+}
+
+// UsageReviewEmail tells the admin about usage reports that need to be checked against the
+// payment provider
+func UsageReviewEmail(to string, data UsageReviewData) (Message, error) {
+	return render(to, "usage_review", DefaultLocale, data)
+}
 
-	log.Printf("[Email] Successfully sent email to %s with subject: %s", to, subject)
-	return nil
-}
-
-// SendPasswordResetEmail sends a password reset email with a secure token
-func SendPasswordResetEmail(to string, resetURL string) error {
-	subject := "Password Reset Request"
-
-	htmlContent := `
-	<h1>Password Reset</h1>
-	<p>You've requested to reset your password. Click the link below to reset your password:</p>
-	<p><a href="` + resetURL + `">Reset Password</a></p>
-	<p>This link will expire in 1 hour.</p>
-	<p>If you didn't request this, you can safely ignore this email.</p>
-	`
-
-	return SendEmail(to, subject, htmlContent)
-}
-
-// SendVerificationEmail sends an email verification link to the user
-func SendVerificationEmail(to string, verificationURL string) error {
-	subject := "Verify Your Email Address"
-
-	htmlContent := `
-	<!DOCTYPE html>
-	<html>
-	<head>
-		<style>
-			body { font-family: Arial, sans-serif; line-height: 1.6; color: #333; }
-			.container { max-width: 600px; margin: 0 auto; padding: 20px; }
-			.button {
-				display: inline-block;
-				padding: 12px 24px;
-				background-color: #3b82f6;
-				color: white;
-				text-decoration: none;
-				border-radius: 6px;
-				margin: 20px 0;
-			}
-			.footer { margin-top: 30px; font-size: 14px; color: #666; }
-		</style>
-	</head>
-	<body>
-		<div class="container">
-			<h2>Verify Your Email Address</h2>
-			<p>Thank you for signing up! Please click the button below to verify your email address:</p>
-			
-			<a href="` + verificationURL + `" class="button">Verify Email</a>
-			
-			<p>If the button doesn't work, you can also copy and paste this link into your browser:</p>
-			<p>` + verificationURL + `</p>
-			
-			<div class="footer">
-				<p>This link will expire in 24 hours for security reasons.</p>
-				<p>If you didn't create an account, you can safely ignore this email.</p>
-			</div>
-		</div>
-	</body>
-	</html>
-	`
-
-	return SendEmail(to, subject, htmlContent)
+// ContactFormEmail forwards a contact form submission to the admin
+func ContactFormEmail(to string, data ContactFormData) (Message, error) {
+	return render(to, "contact_form", DefaultLocale, data)
 }
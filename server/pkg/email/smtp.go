@@ -0,0 +1,105 @@
+package email
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTPMailer sends emails through an SMTP server. The connection is upgraded with STARTTLS,
+// which is required unless the server is on localhost, e.g. a local mail catcher.
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string // Empty for servers without authentication
+	Password string
+	From     string
+}
+
+// Send delivers an email to the SMTP server. The ID is the email's Message-ID header.
+func (m *SMTPMailer) Send(msg Message) (string, error) {
+	data, messageID, err := buildMIME(m.From, msg, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("error building email: %w", err)
+	}
+
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	conn, err := net.DialTimeout("tcp", addr, 15*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("error connecting to SMTP server: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return "", fmt.Errorf("error starting SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.Host}); err != nil {
+			return "", fmt.Errorf("error starting TLS: %w", err)
+		}
+	} else if !isLocalhost(m.Host) {
+		return "", fmt.Errorf("SMTP server %s doesn't support STARTTLS", m.Host)
+	}
+
+	if m.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return "", fmt.Errorf("error authenticating with SMTP server: %w", err)
+		}
+	}
+
+	if err := client.Mail(addressOf(m.From)); err != nil {
+		return "", fmt.Errorf("error setting sender: %w", err)
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return "", fmt.Errorf("error setting recipient: %w", err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return "", fmt.Errorf("error starting email data: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return "", fmt.Errorf("error writing email data: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("error sending email: %w", err)
+	}
+
+	// The server accepted the email once the data is closed, so a failed QUIT must not make
+	// the outbox send it again
+	if err := client.Quit(); err != nil {
+		log.Printf("[Email] Error closing SMTP session after %s was accepted: %v", messageID, err)
+	}
+	return messageID, nil
+}
+
+// Track is a no-op, SMTP has no contacts
+func (m *SMTPMailer) Track(event Event) error {
+	return nil
+}
+
+// isLocalhost reports whether host is the local machine
+func isLocalhost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// addressOf returns the bare address of a sender like "Acme <hello@acme.com>"
+func addressOf(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		return addr.Address
+	}
+	return from
+}
@@ -0,0 +1,87 @@
+package email
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+// serveSMTP accepts one connection on a local port and answers like an SMTP server without
+// STARTTLS, replying dataReply to the email data and quitReply to QUIT
+func serveSMTP(t *testing.T, dataReply string, quitReply string) *SMTPMailer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 Go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+				}
+				reply(dataReply)
+			case cmd == "QUIT":
+				reply(quitReply)
+				return
+			default:
+				reply("502 Not implemented")
+			}
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return &SMTPMailer{Host: "127.0.0.1", Port: addr.Port, From: "Acme <hello@example.com>"}
+}
+
+func testMessage() Message {
+	return Message{To: "user@example.com", Subject: "Hello", HTML: "<p>Hello</p>", Text: "Hello\n"}
+}
+
+func TestSMTPSendIgnoresQuitErrorAfterAccept(t *testing.T) {
+	m := serveSMTP(t, "250 Queued", "421 Closing connection")
+
+	id, err := m.Send(testMessage())
+	if err != nil {
+		t.Fatalf("Send returned %v for an accepted email, the outbox would send it again", err)
+	}
+	if id == "" {
+		t.Error("Send returned no message ID")
+	}
+}
+
+func TestSMTPSendReportsRejectedData(t *testing.T) {
+	m := serveSMTP(t, "554 Message rejected", "221 Bye")
+
+	if _, err := m.Send(testMessage()); err == nil {
+		t.Fatal("Send succeeded for an email the server rejected")
+	}
+}
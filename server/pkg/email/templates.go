@@ -0,0 +1,292 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"saas-server/models"
+)
+
+// DefaultLocale is used for users without a supported language and for templates
+// that have no translation for the user's locale
+const DefaultLocale = "en"
+
+// templateFS holds the email templates. templates/layout.tmpl wraps every email; each
+// locale has a directory with one <name>.tmpl per email defining "subject", "html" and
+// "text", plus partials starting with "_" that are shared by that locale's emails.
+//
+//go:embed templates/*.tmpl templates/*/*.tmpl
+var templateFS embed.FS
+
+// emailTemplate is a single email in one locale
+type emailTemplate struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// UnsubscribePlaceholder stands in for the unsubscribe link in the footer of emails
+// recipients can opt out of, until SetListUnsubscribe fills in the recipient's link
+const UnsubscribePlaceholder = "__UNSUBSCRIBE_URL__"
+
+// templateCategories assigns templates to the notification category that decides whether
+// recipients can opt out of them. Templates without a category are always sent.
+var templateCategories = map[string]string{
+	"password_reset":    models.NotificationSecurity,
+	"verify_email":      models.NotificationSecurity,
+	"payment_failed":    models.NotificationBilling,
+	"payment_recovered": models.NotificationBilling,
+	"trial_ending":      models.NotificationBilling,
+	"trial_expired":     models.NotificationBilling,
+	"campaign":          models.NotificationNewsletter,
+
+	"lifecycle_welcome":         models.NotificationProduct,
+	"lifecycle_verify_reminder": models.NotificationProduct,
+	"lifecycle_trial_ending":    models.NotificationBilling,
+	"lifecycle_inactive":        models.NotificationProduct,
+	"lifecycle_cancelled":       models.NotificationProduct,
+}
+
+// lifecyclePrefix starts the names of templates that lifecycle rules can send
+const lifecyclePrefix = "lifecycle_"
+
+// Optional reports whether recipients can opt out of emails of a notification category.
+// Security emails and transactional emails without a category are always sent.
+func Optional(category string) bool {
+	return category != "" && category != models.NotificationSecurity
+}
+
+// registry maps template name and locale to the parsed templates
+var registry = mustLoadTemplates()
+
+// monthNames are the localized month names used by the date template function
+var monthNames = map[string][]string{
+	"de": {"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+}
+
+// mustLoadTemplates parses every embedded template and panics on errors, which can only
+// come from a broken template shipped with the binary
+func mustLoadTemplates() map[string]map[string]*emailTemplate {
+	templates := make(map[string]map[string]*emailTemplate)
+
+	locales, err := fs.ReadDir(templateFS, "templates")
+	if err != nil {
+		panic(err)
+	}
+	for _, dir := range locales {
+		if !dir.IsDir() {
+			continue
+		}
+		locale := dir.Name()
+		files, err := fs.Glob(templateFS, path.Join("templates", locale, "*.tmpl"))
+		if err != nil {
+			panic(err)
+		}
+		for _, file := range files {
+			name := strings.TrimSuffix(path.Base(file), ".tmpl")
+			if strings.HasPrefix(name, "_") {
+				continue
+			}
+			t, err := parseTemplate(locale, file)
+			if err != nil {
+				panic(fmt.Sprintf("email template %s: %v", file, err))
+			}
+			if templates[name] == nil {
+				templates[name] = make(map[string]*emailTemplate)
+			}
+			templates[name][locale] = t
+		}
+	}
+	return templates
+}
+
+// parseTemplate parses an email together with the layout and the partials of its locale
+func parseTemplate(locale string, file string) (*emailTemplate, error) {
+	patterns := []string{"templates/layout.tmpl"}
+	if partials, _ := fs.Glob(templateFS, path.Join("templates", locale, "_*.tmpl")); len(partials) > 0 {
+		patterns = append(patterns, partials...)
+	}
+	patterns = append(patterns, file)
+	funcs := templateFuncs(locale, templateCategories[strings.TrimSuffix(path.Base(file), ".tmpl")])
+
+	html, err := htmltemplate.New(path.Base(file)).Funcs(htmltemplate.FuncMap(funcs)).ParseFS(templateFS, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	text, err := texttemplate.New(path.Base(file)).Funcs(funcs).ParseFS(templateFS, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	for _, required := range []string{"subject", "html", "text"} {
+		if text.Lookup(required) == nil {
+			return nil, fmt.Errorf("missing %q template", required)
+		}
+	}
+	return &emailTemplate{html: html, text: text}, nil
+}
+
+// templateFuncs returns the functions available to the templates of a locale and category.
+// unsubscribeURL is empty for emails that can't be opted out of.
+func templateFuncs(locale string, category string) texttemplate.FuncMap {
+	return texttemplate.FuncMap{
+		"appName": appName,
+		"date": func(t time.Time) string {
+			return formatDate(locale, t)
+		},
+		"unsubscribeURL": func() string {
+			if Optional(category) {
+				return UnsubscribePlaceholder
+			}
+			return ""
+		},
+	}
+}
+
+// appName is the product name shown in emails, set with APP_NAME
+func appName() string {
+	if name := os.Getenv("APP_NAME"); name != "" {
+		return name
+	}
+	return "SaaS Kit"
+}
+
+// formatDate formats a date the way it is written in the locale
+func formatDate(locale string, t time.Time) string {
+	if months, ok := monthNames[locale]; ok {
+		return fmt.Sprintf("%d. %s %d", t.Day(), months[t.Month()-1], t.Year())
+	}
+	return t.Format("January 2, 2006")
+}
+
+// Render renders an email template in the given locale, falling back to the default
+// locale when there is no translation. The recipient is left for the caller to set.
+func Render(name string, locale string, data interface{}) (Message, error) {
+	variants, ok := registry[name]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown email template %q", name)
+	}
+	t, ok := variants[ResolveLocale(locale)]
+	if !ok {
+		t = variants[DefaultLocale]
+	}
+	if t == nil {
+		return Message{}, fmt.Errorf("email template %q has no %s variant", name, DefaultLocale)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, fmt.Errorf("error rendering subject of %s: %w", name, err)
+	}
+	if err := t.text.ExecuteTemplate(&text, "layout.txt", data); err != nil {
+		return Message{}, fmt.Errorf("error rendering text of %s: %w", name, err)
+	}
+	if err := t.html.ExecuteTemplate(&html, "layout.html", data); err != nil {
+		return Message{}, fmt.Errorf("error rendering HTML of %s: %w", name, err)
+	}
+
+	return Message{
+		Subject:  strings.TrimSpace(subject.String()),
+		HTML:     html.String(),
+		Text:     strings.TrimSpace(text.String()) + "\n",
+		Category: templateCategories[name],
+		rendered: true,
+	}, nil
+}
+
+// ResolveLocale maps a language tag such as "de-AT" to a locale there are templates
+// for, or the default locale
+func ResolveLocale(language string) string {
+	if locale, ok := SupportedLocale(language); ok {
+		return locale
+	}
+	return DefaultLocale
+}
+
+// SupportedLocale returns the locale of a language tag if there are templates for it
+func SupportedLocale(language string) (string, bool) {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if i := strings.IndexAny(language, "-_"); i >= 0 {
+		language = language[:i]
+	}
+	for _, locale := range Locales() {
+		if locale == language {
+			return locale, true
+		}
+	}
+	return "", false
+}
+
+// LocaleFromAcceptLanguage picks the preferred supported locale from an Accept-Language
+// header. It returns an empty string if none of the languages is supported.
+func LocaleFromAcceptLanguage(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= bestQ {
+			continue
+		}
+		if locale, ok := SupportedLocale(tag); ok {
+			best, bestQ = locale, q
+		}
+	}
+	return best
+}
+
+// Locales lists the locales there are templates for
+func Locales() []string {
+	seen := make(map[string]bool)
+	for _, variants := range registry {
+		for locale := range variants {
+			seen[locale] = true
+		}
+	}
+	locales := make([]string, 0, len(seen))
+	for locale := range seen {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// LifecycleTemplates lists the templates lifecycle rules can send
+func LifecycleTemplates() []string {
+	var names []string
+	for name := range registry {
+		if strings.HasPrefix(name, lifecyclePrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TemplateLocales lists every template with the locales it is translated to
+func TemplateLocales() map[string][]string {
+	result := make(map[string][]string, len(registry))
+	for name, variants := range registry {
+		locales := make([]string, 0, len(variants))
+		for locale := range variants {
+			locales = append(locales, locale)
+		}
+		sort.Strings(locales)
+		result[name] = locales
+	}
+	return result
+}
@@ -0,0 +1,128 @@
+package email
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"saas-server/models"
+)
+
+// update rewrites the golden files with the current output: go test ./pkg/email -update
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+var goldenTime = time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+
+// goldenURL has a query string, so the golden files show how each template escapes it
+const goldenURL = "https://example.com/action?token=abc123&next=/profile"
+
+// goldenData returns fixed template data, so the golden files don't change between runs
+func goldenData(name string, url string) (interface{}, bool) {
+	switch name {
+	case "password_reset", "verify_email", "newsletter_confirm", "trial_expired":
+		return LinkData{URL: url}, true
+	case "payment_failed":
+		return PaymentFailedData{URL: url, Reminder: 1, GraceEndsAt: goldenTime}, true
+	case "payment_recovered":
+		return nil, true
+	case "trial_ending":
+		return TrialEndingData{URL: url, EndsAt: goldenTime}, true
+	case "campaign":
+		return CampaignData{
+			Subject:      "What's new this month",
+			Body:         "<h1>What's new this month</h1><p>We shipped <a href=\"https://example.com/changelog\">a lot of improvements</a>.</p>",
+			Text:         "What's new this month\n\nWe shipped a lot of improvements (https://example.com/changelog).",
+			OpenPixelURL: url,
+		}, true
+	case "contact_form":
+		return ContactFormData{
+			Name:    "Jane <Doe>",
+			Email:   "jane@example.com",
+			Subject: "Question about pricing & discounts",
+			Message: "Hi,\ndo you offer discounts for non-profits?\n\nThanks!",
+		}, true
+	case "usage_review":
+		return UsageReviewData{Reports: []models.UsageReport{
+			{ID: 42, SubscriptionID: 1001, SubscriptionItemID: 2001, Quantity: 1250, Attempts: 1, LastError: "request timed out"},
+			{ID: 43, SubscriptionID: 1002, SubscriptionItemID: 2002, Quantity: 7, Attempts: 10, LastError: "usage record rejected with status 422"},
+		}}, true
+	}
+	if strings.HasPrefix(name, lifecyclePrefix) {
+		return LifecycleData{Name: "Jane", URL: url, EventAt: goldenTime}, true
+	}
+	return nil, false
+}
+
+func renderGolden(t *testing.T, name string, locale string, url string) Message {
+	t.Helper()
+	data, ok := goldenData(name, url)
+	if !ok {
+		t.Fatalf("no test data for email template %q", name)
+	}
+	msg, err := Render(name, locale, data)
+	if err != nil {
+		t.Fatalf("Render(%s, %s): %v", name, locale, err)
+	}
+	if Optional(msg.Category) {
+		msg.SetListUnsubscribe("https://example.com/api/notifications/unsubscribe?token=abc&category=" + msg.Category)
+	}
+	return msg
+}
+
+func compareGolden(t *testing.T, file string, got string) {
+	t.Helper()
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("reading golden file (run with -update to create it): %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the rendered email (run with -update to accept it):\n--- got ---\n%s\n--- want ---\n%s", file, got, want)
+	}
+}
+
+// TestTemplatesGolden renders every template in every locale, including the ones that
+// fall back to the default locale, and compares the output with testdata
+func TestTemplatesGolden(t *testing.T) {
+	t.Setenv("APP_NAME", "")
+
+	for name := range TemplateLocales() {
+		for _, locale := range Locales() {
+			name, locale := name, locale
+			t.Run(locale+"/"+name, func(t *testing.T) {
+				msg := renderGolden(t, name, locale, goldenURL)
+				base := filepath.Join("testdata", locale, name)
+				compareGolden(t, base+".html.golden", msg.HTML)
+				compareGolden(t, base+".txt.golden", "Subject: "+msg.Subject+"\n\n"+msg.Text)
+			})
+		}
+	}
+}
+
+// TestTemplatesEscapeURLs checks that links in the data can't break out of the HTML
+func TestTemplatesEscapeURLs(t *testing.T) {
+	const hostile = `https://example.com/?a=1&b="><script>alert(1)</script>`
+
+	for name := range TemplateLocales() {
+		for _, locale := range Locales() {
+			msg := renderGolden(t, name, locale, hostile)
+			if strings.Contains(msg.HTML, "<script>") || strings.Contains(msg.HTML, `"><`) {
+				t.Errorf("%s/%s: URL isn't escaped in HTML", locale, name)
+			}
+			if strings.Contains(msg.HTML, "?a=1&b") {
+				t.Errorf("%s/%s: ampersand in URL isn't escaped in HTML", locale, name)
+			}
+		}
+	}
+}
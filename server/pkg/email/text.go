@@ -0,0 +1,31 @@
+package email
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+var (
+	// textLinkPattern matches links, which keep their target in the plain-text version
+	textLinkPattern = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	// textBreakPattern matches tags that end a line
+	textBreakPattern = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|h[1-6]|li|tr|blockquote)>`)
+	// textBlankLines matches runs of blank lines
+	textBlankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// PlainText derives a plain-text version of HTML content for emails that only have HTML
+func PlainText(content string) string {
+	content = textLinkPattern.ReplaceAllString(content, "$2 ($1)")
+	content = textBreakPattern.ReplaceAllString(content, "\n\n")
+	content = html.UnescapeString(bluemonday.StrictPolicy().Sanitize(content))
+
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.TrimSpace(textBlankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
@@ -100,6 +100,26 @@ func (c *Client) GetProduct(productID string) (*ProductResponse, error) {
 	return &result, nil
 }
 
+// GetProductByID retrieves a single product
+func (c *Client) GetProductByID(productID string) (*SingleProductResponse, error) {
+	resp, err := c.doRequest(http.MethodGet, fmt.Sprintf("/products/%s", productID), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch product: %d", resp.StatusCode)
+	}
+
+	var result SingleProductResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
 // GetVariants retrieves all variants for a product
 func (c *Client) GetVariants(productID string) (*VariantResponse, error) {
 	resp, err := c.doRequest(http.MethodGet, fmt.Sprintf("/variants?filter[product_id]=%s", productID), nil)
@@ -116,6 +136,26 @@ func (c *Client) GetVariants(productID string) (*VariantResponse, error) {
 	return &result, nil
 }
 
+// GetVariant retrieves a specific variant
+func (c *Client) GetVariant(variantID string) (*SingleVariantResponse, error) {
+	resp, err := c.doRequest(http.MethodGet, fmt.Sprintf("/variants/%s", variantID), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch variant: %d", resp.StatusCode)
+	}
+
+	var result SingleVariantResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
 // CheckoutResponse represents the response from creating a checkout
 type CheckoutResponse struct {
 	Data struct {
@@ -136,13 +176,23 @@ type CheckoutOptions struct {
 
 // ProductOptions represents the options for the product in checkout
 type ProductOptions struct {
-	EnabledVariants []int `json:"enabled_variants,omitempty"`
+	EnabledVariants []int  `json:"enabled_variants,omitempty"`
+	RedirectURL     string `json:"redirect_url,omitempty"` // Where the user is sent after a successful purchase
+}
+
+// BillingAddress pre-fills the billing address fields of the checkout
+type BillingAddress struct {
+	Country string `json:"country,omitempty"` // ISO 3166-1 alpha-2
+	Zip     string `json:"zip,omitempty"`
 }
 
 // CheckoutData represents additional data for the checkout
 type CheckoutData struct {
-	DiscountCode string                 `json:"discount_code,omitempty"`
-	Custom       map[string]interface{} `json:"custom,omitempty"`
+	Name           string                 `json:"name,omitempty"`
+	BillingAddress *BillingAddress        `json:"billing_address,omitempty"`
+	TaxNumber      string                 `json:"tax_number,omitempty"`
+	DiscountCode   string                 `json:"discount_code,omitempty"`
+	Custom         map[string]interface{} `json:"custom,omitempty"`
 }
 
 // CreateCheckout creates a new checkout
@@ -154,6 +204,18 @@ func (c *Client) CreateCheckout(storeID string, variantID string, options map[st
 	}
 	if customData, ok := options["checkout_data"].(CheckoutData); ok {
 		checkoutData["custom"] = customData.Custom
+		if customData.DiscountCode != "" {
+			checkoutData["discount_code"] = customData.DiscountCode
+		}
+		if customData.Name != "" {
+			checkoutData["name"] = customData.Name
+		}
+		if customData.BillingAddress != nil {
+			checkoutData["billing_address"] = customData.BillingAddress
+		}
+		if customData.TaxNumber != "" {
+			checkoutData["tax_number"] = customData.TaxNumber
+		}
 	}
 
 	body := map[string]interface{}{
@@ -0,0 +1,175 @@
+package lemonsqueezy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DiscountAttributes represents the attributes of a discount.
+// Amount is a percentage when AmountType is "percent", otherwise it is in the store currency's minor unit.
+type DiscountAttributes struct {
+	StoreID              int        `json:"store_id"`
+	Name                 string     `json:"name"`
+	Code                 string     `json:"code"`
+	Amount               int        `json:"amount"`
+	AmountType           string     `json:"amount_type"`
+	IsLimitedToProducts  bool       `json:"is_limited_to_products"`
+	IsLimitedRedemptions bool       `json:"is_limited_redemptions"`
+	MaxRedemptions       int        `json:"max_redemptions"`
+	StartsAt             *time.Time `json:"starts_at"`
+	ExpiresAt            *time.Time `json:"expires_at"`
+	Duration             string     `json:"duration"`
+	DurationInMonths     int        `json:"duration_in_months"`
+	Status               string     `json:"status"`
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at"`
+}
+
+// DiscountData represents a single discount in the API response
+type DiscountData struct {
+	Type       string             `json:"type"`
+	ID         string             `json:"id"`
+	Attributes DiscountAttributes `json:"attributes"`
+}
+
+// DiscountResponse represents the response from the Lemon Squeezy API for one discount
+type DiscountResponse struct {
+	Data DiscountData `json:"data"`
+}
+
+// DiscountListResponse represents the response from the Lemon Squeezy API for a list of discounts
+type DiscountListResponse struct {
+	Data []DiscountData `json:"data"`
+}
+
+// DiscountInput describes a discount to create. When VariantIDs is empty the discount applies to every product.
+type DiscountInput struct {
+	Name             string
+	Code             string
+	Amount           int
+	AmountType       string // "percent" or "fixed"
+	Duration         string // "once", "repeating" or "forever"
+	DurationInMonths int
+	MaxRedemptions   int // 0 means unlimited
+	StartsAt         *time.Time
+	ExpiresAt        *time.Time
+	VariantIDs       []int
+}
+
+// CreateDiscount creates a discount code in the store
+func (c *Client) CreateDiscount(storeID string, input DiscountInput) (*DiscountResponse, error) {
+	attributes := map[string]interface{}{
+		"name":                   input.Name,
+		"code":                   input.Code,
+		"amount":                 input.Amount,
+		"amount_type":            input.AmountType,
+		"is_limited_to_products": len(input.VariantIDs) > 0,
+		"is_limited_redemptions": input.MaxRedemptions > 0,
+	}
+	if input.MaxRedemptions > 0 {
+		attributes["max_redemptions"] = input.MaxRedemptions
+	}
+	if input.Duration != "" {
+		attributes["duration"] = input.Duration
+	}
+	if input.Duration == "repeating" {
+		attributes["duration_in_months"] = input.DurationInMonths
+	}
+	if input.StartsAt != nil {
+		attributes["starts_at"] = input.StartsAt.UTC().Format(time.RFC3339)
+	}
+	if input.ExpiresAt != nil {
+		attributes["expires_at"] = input.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+
+	relationships := map[string]interface{}{
+		"store": map[string]interface{}{
+			"data": map[string]interface{}{
+				"type": "stores",
+				"id":   storeID,
+			},
+		},
+	}
+	if len(input.VariantIDs) > 0 {
+		variants := make([]map[string]interface{}, 0, len(input.VariantIDs))
+		for _, id := range input.VariantIDs {
+			variants = append(variants, map[string]interface{}{
+				"type": "variants",
+				"id":   strconv.Itoa(id),
+			})
+		}
+		relationships["variants"] = map[string]interface{}{
+			"data": variants,
+		}
+	}
+
+	body := map[string]interface{}{
+		"data": map[string]interface{}{
+			"type":          "discounts",
+			"attributes":    attributes,
+			"relationships": relationships,
+		},
+	}
+
+	resp, err := c.doRequest(http.MethodPost, "/discounts", body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make discount request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("failed to create discount: status=%d body=%s", resp.StatusCode, string(respBody))
+	}
+
+	var result DiscountResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// GetDiscounts retrieves the discounts of a store
+func (c *Client) GetDiscounts(storeID string) (*DiscountListResponse, error) {
+	resp, err := c.doRequest(http.MethodGet, fmt.Sprintf("/discounts?filter[store_id]=%s", storeID), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch discounts: %d", resp.StatusCode)
+	}
+
+	var result DiscountListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// DeleteDiscount deletes a discount so it can no longer be redeemed.
+// The API can't edit discounts, so deleting is the only way to disable one.
+func (c *Client) DeleteDiscount(discountID string) error {
+	resp, err := c.doRequest(http.MethodDelete, fmt.Sprintf("/discounts/%s", discountID), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to delete discount: %d", resp.StatusCode)
+	}
+
+	return nil
+}
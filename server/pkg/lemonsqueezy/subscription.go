@@ -0,0 +1,156 @@
+package lemonsqueezy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// SubscriptionResponse represents the response from the Lemon Squeezy API for a subscription
+type SubscriptionResponse struct {
+	Data SubscriptionData `json:"data"`
+}
+
+// SubscriptionData represents a single subscription in the API response
+type SubscriptionData struct {
+	ID         string                 `json:"id"`
+	Type       string                 `json:"type"`
+	Attributes SubscriptionAttributes `json:"attributes"`
+}
+
+// SubscriptionAttributes represents the attributes of a subscription
+type SubscriptionAttributes struct {
+	StoreID         int                `json:"store_id"`
+	CustomerID      int                `json:"customer_id"`
+	OrderID         int                `json:"order_id"`
+	OrderItemID     int                `json:"order_item_id"`
+	ProductID       int                `json:"product_id"`
+	VariantID       int                `json:"variant_id"`
+	ProductName     string             `json:"product_name"`
+	VariantName     string             `json:"variant_name"`
+	Status          string             `json:"status"`
+	StatusFormatted string             `json:"status_formatted"`
+	Cancelled       bool               `json:"cancelled"`
+	Pause           *SubscriptionPause `json:"pause"`
+	BillingAnchor   int                `json:"billing_anchor"`
+	RenewsAt        *time.Time         `json:"renews_at"`
+	EndsAt          *time.Time         `json:"ends_at"`
+	TrialEndsAt     *time.Time         `json:"trial_ends_at"`
+	URLs            SubscriptionURLs   `json:"urls"`
+	CreatedAt       time.Time          `json:"created_at"`
+	UpdatedAt       time.Time          `json:"updated_at"`
+}
+
+// SubscriptionURLs holds the signed customer links of a subscription, valid for 24 hours
+type SubscriptionURLs struct {
+	UpdatePaymentMethod string `json:"update_payment_method"`
+	CustomerPortal      string `json:"customer_portal"`
+}
+
+// SubscriptionPause represents the pause settings of a subscription.
+// Mode is either "void" (no service while paused) or "free" (service continues for free).
+type SubscriptionPause struct {
+	Mode      string     `json:"mode"`
+	ResumesAt *time.Time `json:"resumes_at,omitempty"`
+}
+
+// GetSubscription retrieves a specific subscription
+func (c *Client) GetSubscription(subscriptionID string) (*SubscriptionResponse, error) {
+	resp, err := c.doRequest(http.MethodGet, fmt.Sprintf("/subscriptions/%s", subscriptionID), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch subscription: %d", resp.StatusCode)
+	}
+
+	var result SubscriptionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// UpdateSubscription patches a subscription with the given attributes.
+// A nil value for an attribute is sent as JSON null (e.g. "pause": nil unpauses).
+func (c *Client) UpdateSubscription(subscriptionID string, attributes map[string]interface{}) (*SubscriptionResponse, error) {
+	body := map[string]interface{}{
+		"data": map[string]interface{}{
+			"type":       "subscriptions",
+			"id":         subscriptionID,
+			"attributes": attributes,
+		},
+	}
+
+	resp, err := c.doRequest(http.MethodPatch, fmt.Sprintf("/subscriptions/%s", subscriptionID), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make subscription request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeSubscriptionResponse(resp, "update subscription")
+}
+
+// CancelSubscription cancels a subscription at the end of the current billing period
+func (c *Client) CancelSubscription(subscriptionID string) (*SubscriptionResponse, error) {
+	resp, err := c.doRequest(http.MethodDelete, fmt.Sprintf("/subscriptions/%s", subscriptionID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make subscription request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeSubscriptionResponse(resp, "cancel subscription")
+}
+
+// ResumeSubscription reverses a pending cancellation and lifts any pause on a subscription
+func (c *Client) ResumeSubscription(subscriptionID string) (*SubscriptionResponse, error) {
+	return c.UpdateSubscription(subscriptionID, map[string]interface{}{
+		"cancelled": false,
+		"pause":     nil,
+	})
+}
+
+// PauseSubscription pauses payment collection for a subscription until resumesAt.
+// A nil resumesAt pauses the subscription indefinitely.
+func (c *Client) PauseSubscription(subscriptionID string, mode string, resumesAt *time.Time) (*SubscriptionResponse, error) {
+	return c.UpdateSubscription(subscriptionID, map[string]interface{}{
+		"pause": SubscriptionPause{
+			Mode:      mode,
+			ResumesAt: resumesAt,
+		},
+	})
+}
+
+// ChangeSubscriptionPlan moves a subscription to another variant.
+// Proration is applied by Lemon Squeezy unless disableProrations is set.
+func (c *Client) ChangeSubscriptionPlan(subscriptionID string, variantID int, invoiceImmediately bool, disableProrations bool) (*SubscriptionResponse, error) {
+	return c.UpdateSubscription(subscriptionID, map[string]interface{}{
+		"variant_id":          variantID,
+		"invoice_immediately": invoiceImmediately,
+		"disable_prorations":  disableProrations,
+	})
+}
+
+// decodeSubscriptionResponse reads a subscription response and reports non-200 statuses as errors
+func decodeSubscriptionResponse(resp *http.Response, action string) (*SubscriptionResponse, error) {
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to %s: status=%d body=%s", action, resp.StatusCode, string(respBody))
+	}
+
+	var result SubscriptionResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
@@ -6,6 +6,11 @@ type ProductResponse struct {
 	Meta Meta          `json:"meta"`
 }
 
+// SingleProductResponse represents the response from the Lemon Squeezy API for one product
+type SingleProductResponse struct {
+	Data ProductData `json:"data"`
+}
+
 // ProductData represents a single product in the API response
 type ProductData struct {
 	ID            string            `json:"id"`
@@ -44,13 +49,21 @@ type VariantData struct {
 
 // VariantAttributes represents the attributes of a variant
 type VariantAttributes struct {
-	ProductID   int    `json:"product_id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Price       int    `json:"price"`
-	Status      string `json:"status"`
-	CreatedAt   string `json:"created_at"`
-	UpdatedAt   string `json:"updated_at"`
+	ProductID      int    `json:"product_id"`
+	Name           string `json:"name"`
+	Description    string `json:"description"`
+	Price          int    `json:"price"`
+	IsSubscription bool   `json:"is_subscription"`
+	Interval       string `json:"interval"`
+	IntervalCount  int    `json:"interval_count"`
+	Status         string `json:"status"`
+	CreatedAt      string `json:"created_at"`
+	UpdatedAt      string `json:"updated_at"`
+}
+
+// SingleVariantResponse represents the response from the Lemon Squeezy API for one variant
+type SingleVariantResponse struct {
+	Data VariantData `json:"data"`
 }
 
 // Relationships represents the relationships of a product or variant
@@ -0,0 +1,113 @@
+package lemonsqueezy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// UsageRecordResponse represents the response from the Lemon Squeezy API for a usage record
+type UsageRecordResponse struct {
+	Data struct {
+		ID         string `json:"id"`
+		Type       string `json:"type"`
+		Attributes struct {
+			SubscriptionItemID int       `json:"subscription_item_id"`
+			Quantity           int       `json:"quantity"`
+			Action             string    `json:"action"`
+			CreatedAt          time.Time `json:"created_at"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// UsageRecordRejectedError is returned when Lemon Squeezy answers a usage record request
+// with a client error, so the usage was not recorded and the request can be retried
+type UsageRecordRejectedError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *UsageRecordRejectedError) Error() string {
+	return fmt.Sprintf("usage record rejected: status=%d body=%s", e.StatusCode, e.Body)
+}
+
+// CurrentUsageResponse represents the usage of a subscription item in its current billing period
+type CurrentUsageResponse struct {
+	Meta struct {
+		PeriodStart      time.Time `json:"period_start"`
+		PeriodEnd        time.Time `json:"period_end"`
+		Quantity         int       `json:"quantity"`
+		IntervalUnit     string    `json:"interval_unit"`
+		IntervalQuantity int       `json:"interval_quantity"`
+	} `json:"meta"`
+}
+
+// CreateUsageRecord adds quantity to the usage of a subscription item in its current billing period.
+// Requests Lemon Squeezy rejected return a *UsageRecordRejectedError.
+func (c *Client) CreateUsageRecord(subscriptionItemID int, quantity int) (*UsageRecordResponse, error) {
+	body := map[string]interface{}{
+		"data": map[string]interface{}{
+			"type": "usage-records",
+			"attributes": map[string]interface{}{
+				"quantity": quantity,
+				"action":   "increment",
+			},
+			"relationships": map[string]interface{}{
+				"subscription-item": map[string]interface{}{
+					"data": map[string]interface{}{
+						"type": "subscription-items",
+						"id":   strconv.Itoa(subscriptionItemID),
+					},
+				},
+			},
+		},
+	}
+
+	resp, err := c.doRequest(http.MethodPost, "/usage-records", body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make usage record request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return nil, &UsageRecordRejectedError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("failed to create usage record: status=%d body=%s", resp.StatusCode, string(respBody))
+	}
+
+	var result UsageRecordResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// GetCurrentUsage retrieves the reported usage of a subscription item in its current billing period
+func (c *Client) GetCurrentUsage(subscriptionItemID int) (*CurrentUsageResponse, error) {
+	resp, err := c.doRequest(http.MethodGet, fmt.Sprintf("/subscription-items/%d/current-usage", subscriptionItemID), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch current usage: %d", resp.StatusCode)
+	}
+
+	var result CurrentUsageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
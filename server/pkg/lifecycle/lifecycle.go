@@ -0,0 +1,305 @@
+// Package lifecycle sends automated emails that follow up on product events. Each rule
+// sends an email template a fixed time after an event such as a signup or a cancelled
+// subscription, unless the user met one of the rule's exit conditions by then. A scheduled
+// job looks for due users, and every user gets a rule's email once per event.
+package lifecycle
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/clock"
+	"saas-server/pkg/email"
+	"saas-server/pkg/validation"
+)
+
+// maxDelay limits how far from its event a rule's email can be sent
+const maxDelay = 365 * 24 * time.Hour
+
+// ErrInvalidRule wraps the reason a rule was rejected
+var ErrInvalidRule = errors.New("invalid lifecycle rule")
+
+// ErrNotFound is returned for rules that don't exist
+var ErrNotFound = errors.New("lifecycle rule not found")
+
+// LifecycleDB defines the database operations required by the lifecycle service
+type LifecycleDB interface {
+	CreateLifecycleRule(r *models.LifecycleRule, at time.Time) error
+	UpdateLifecycleRule(r *models.LifecycleRule, at time.Time) (*models.LifecycleRule, error)
+	GetLifecycleRule(id int) (*models.LifecycleRule, error)
+	GetLifecycleRules(enabledOnly bool) ([]models.LifecycleRule, error)
+	DeleteLifecycleRule(id int) (bool, error)
+	GetLifecycleCandidates(r *models.LifecycleRule, notBefore time.Time, now time.Time, limit int) ([]models.LifecycleCandidate, error)
+	RecordLifecycleSend(ruleID int, userID string, eventAt time.Time, status string, exitCondition string) error
+}
+
+// Sender queues emails for delivery. Implemented by email.Outbox
+type Sender interface {
+	Enqueue(msg email.Message, idempotencyKey string) error
+}
+
+// Events lists the events rules can follow up on
+var Events = []string{
+	models.LifecycleSignup,
+	models.LifecycleUnverified,
+	models.LifecycleTrialEnding,
+	models.LifecycleInactive,
+	models.LifecycleCancelled,
+}
+
+// ExitConditions lists the conditions that stop a rule's email
+var ExitConditions = []string{
+	models.LifecycleExitVerified,
+	models.LifecycleExitSubscribed,
+}
+
+// eventPaths are the pages of the app the emails of each event link to
+var eventPaths = map[string]string{
+	models.LifecycleSignup:      "/",
+	models.LifecycleUnverified:  "/profile",
+	models.LifecycleTrialEnding: "/pricing",
+	models.LifecycleInactive:    "/",
+	models.LifecycleCancelled:   "/pricing",
+}
+
+// Config controls how lifecycle emails are sent
+type Config struct {
+	// BatchSize is how many users each rule handles per run
+	BatchSize int
+	// MaxLateness is how long after it was due an email is still sent, e.g. when the job
+	// was down. Later ones are skipped, as a welcome email a week late does more harm than good.
+	MaxLateness time.Duration
+	// AppURL is the frontend the emails link to
+	AppURL string
+}
+
+// LoadConfig reads the lifecycle configuration from the environment.
+// LIFECYCLE_BATCH_SIZE sets how many users each rule handles per run (default 100) and
+// LIFECYCLE_MAX_LATE_HOURS how late an email may still be sent (default 48).
+func LoadConfig() Config {
+	config := Config{
+		BatchSize:   100,
+		MaxLateness: 48 * time.Hour,
+		AppURL:      strings.TrimRight(os.Getenv("FRONTEND_URL"), "/"),
+	}
+
+	if v := os.Getenv("LIFECYCLE_BATCH_SIZE"); v != "" {
+		if size, err := strconv.Atoi(v); err == nil && size > 0 {
+			config.BatchSize = size
+		} else {
+			log.Printf("[Lifecycle] Ignoring invalid LIFECYCLE_BATCH_SIZE %q", v)
+		}
+	}
+
+	if v := os.Getenv("LIFECYCLE_MAX_LATE_HOURS"); v != "" {
+		if hours, err := strconv.Atoi(v); err == nil && hours > 0 {
+			config.MaxLateness = time.Duration(hours) * time.Hour
+		} else {
+			log.Printf("[Lifecycle] Ignoring invalid LIFECYCLE_MAX_LATE_HOURS %q", v)
+		}
+	}
+
+	return config
+}
+
+// Service manages lifecycle rules and sends their emails
+type Service struct {
+	db     LifecycleDB
+	sender Sender
+	clock  clock.Clock
+	config Config
+}
+
+// NewService creates a new instance of Service
+func NewService(db LifecycleDB, sender Sender, clock clock.Clock, config Config) *Service {
+	return &Service{
+		db:     db,
+		sender: sender,
+		clock:  clock,
+		config: config,
+	}
+}
+
+// StartLifecycleJob starts the background job that sends the emails of every enabled rule
+// that are due
+func (s *Service) StartLifecycleJob(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			if err := s.ProcessRules(); err != nil {
+				log.Printf("Error processing lifecycle rules: %v", err)
+			}
+		}
+	}()
+}
+
+// Create validates and stores a rule
+func (s *Service) Create(r *models.LifecycleRule) error {
+	if err := normalize(r); err != nil {
+		return err
+	}
+	return s.db.CreateLifecycleRule(r, s.clock.Now())
+}
+
+// Update validates and saves the changes to a rule
+func (s *Service) Update(r *models.LifecycleRule) (*models.LifecycleRule, error) {
+	if err := normalize(r); err != nil {
+		return nil, err
+	}
+	updated, err := s.db.UpdateLifecycleRule(r, s.clock.Now())
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return updated, err
+}
+
+// Get returns a rule
+func (s *Service) Get(id int) (*models.LifecycleRule, error) {
+	r, err := s.db.GetLifecycleRule(id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return r, err
+}
+
+// List returns every rule
+func (s *Service) List() ([]models.LifecycleRule, error) {
+	return s.db.GetLifecycleRules(false)
+}
+
+// Delete deletes a rule
+func (s *Service) Delete(id int) error {
+	found, err := s.db.DeleteLifecycleRule(id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ProcessRules sends the due emails of every enabled rule
+func (s *Service) ProcessRules() error {
+	rules, err := s.db.GetLifecycleRules(true)
+	if err != nil {
+		return err
+	}
+
+	now := s.clock.Now()
+	for i := range rules {
+		if err := s.processRule(&rules[i], now); err != nil {
+			log.Printf("[Lifecycle] Error processing rule %d: %v", rules[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// processRule sends a batch of a rule's due emails and records users who met an exit
+// condition instead. Emails that fail to queue are retried on the next run.
+func (s *Service) processRule(r *models.LifecycleRule, now time.Time) error {
+	notBefore := now.Add(-s.config.MaxLateness)
+	if r.EnabledAt != nil && r.EnabledAt.After(notBefore) {
+		notBefore = *r.EnabledAt
+	}
+
+	candidates, err := s.db.GetLifecycleCandidates(r, notBefore, now, s.config.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	sent, exited := 0, 0
+	for _, c := range candidates {
+		if c.ExitCondition != "" {
+			if err := s.db.RecordLifecycleSend(r.ID, c.UserID, c.EventAt, models.LifecycleExited, c.ExitCondition); err != nil {
+				return err
+			}
+			exited++
+			continue
+		}
+
+		if err := s.send(r, c); err != nil {
+			log.Printf("[Lifecycle] Error sending rule %d to user %s: %v", r.ID, c.UserID, err)
+			continue
+		}
+		if err := s.db.RecordLifecycleSend(r.ID, c.UserID, c.EventAt, models.LifecycleSent, ""); err != nil {
+			return err
+		}
+		sent++
+	}
+
+	if sent > 0 || exited > 0 {
+		log.Printf("[Lifecycle] Rule %d sent %d emails, %d users exited", r.ID, sent, exited)
+	}
+	return nil
+}
+
+// send queues a rule's email to a user. The idempotency key makes queueing it again a
+// no-op if recording the send failed.
+func (s *Service) send(r *models.LifecycleRule, c models.LifecycleCandidate) error {
+	msg, err := email.LifecycleEmail(c.Email, r.Template, c.Language, email.LifecycleData{
+		Name:    c.Name,
+		URL:     s.config.AppURL + eventPaths[r.Event],
+		EventAt: c.EventAt,
+	})
+	if err != nil {
+		return err
+	}
+	return s.sender.Enqueue(msg, fmt.Sprintf("lifecycle:%d:%s:%d", r.ID, c.UserID, c.EventAt.UnixMicro()))
+}
+
+// normalize sanitizes the fields of a rule and checks they are valid
+func normalize(r *models.LifecycleRule) error {
+	r.Name = validation.SanitizeInput(r.Name, 200)
+	if r.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidRule)
+	}
+
+	if !contains(Events, r.Event) {
+		return fmt.Errorf("%w: unknown event %q", ErrInvalidRule, r.Event)
+	}
+
+	delay := time.Duration(r.DelayMinutes) * time.Minute
+	if delay < -maxDelay || delay > maxDelay {
+		return fmt.Errorf("%w: delay must be at most a year", ErrInvalidRule)
+	}
+	if delay < 0 && r.Event != models.LifecycleTrialEnding {
+		return fmt.Errorf("%w: only trial_ending rules can be sent before their event", ErrInvalidRule)
+	}
+
+	if !contains(email.LifecycleTemplates(), r.Template) {
+		return fmt.Errorf("%w: unknown template %q", ErrInvalidRule, r.Template)
+	}
+
+	seen := make(map[string]bool, len(r.ExitConditions))
+	for _, condition := range r.ExitConditions {
+		if !contains(ExitConditions, condition) {
+			return fmt.Errorf("%w: unknown exit condition %q", ErrInvalidRule, condition)
+		}
+		if seen[condition] {
+			return fmt.Errorf("%w: exit condition %s is listed twice", ErrInvalidRule, condition)
+		}
+		seen[condition] = true
+	}
+	if r.ExitConditions == nil {
+		r.ExitConditions = []string{}
+	}
+	return nil
+}
+
+// contains reports whether list contains value
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
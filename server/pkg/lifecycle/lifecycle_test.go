@@ -0,0 +1,369 @@
+package lifecycle
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/clock"
+	"saas-server/pkg/email"
+)
+
+// fakeUser is the state of a user the fake database derives events from
+type fakeUser struct {
+	id         string
+	email      string
+	createdAt  time.Time
+	lastSeenAt time.Time
+	verified   bool
+	subscribed bool
+}
+
+// send is a recorded application of a rule to an event
+type send struct {
+	ruleID        int
+	userID        string
+	eventAt       time.Time
+	status        string
+	exitCondition string
+}
+
+// fakeDB keeps rules, users and sends in memory and selects candidates the way the SQL does
+type fakeDB struct {
+	rules []*models.LifecycleRule
+	users []*fakeUser
+	sends []send
+}
+
+func (db *fakeDB) CreateLifecycleRule(r *models.LifecycleRule, at time.Time) error {
+	r.ID = len(db.rules) + 1
+	if r.Enabled {
+		r.EnabledAt = &at
+	}
+	copied := *r
+	db.rules = append(db.rules, &copied)
+	return nil
+}
+
+func (db *fakeDB) UpdateLifecycleRule(r *models.LifecycleRule, at time.Time) (*models.LifecycleRule, error) {
+	for _, existing := range db.rules {
+		if existing.ID != r.ID {
+			continue
+		}
+		enabledAt := existing.EnabledAt
+		switch {
+		case !r.Enabled:
+			enabledAt = nil
+		case !existing.Enabled:
+			enabledAt = &at
+		}
+		*existing = *r
+		existing.EnabledAt = enabledAt
+		copied := *existing
+		return &copied, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (db *fakeDB) GetLifecycleRule(id int) (*models.LifecycleRule, error) {
+	for _, r := range db.rules {
+		if r.ID == id {
+			copied := *r
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (db *fakeDB) GetLifecycleRules(enabledOnly bool) ([]models.LifecycleRule, error) {
+	rules := []models.LifecycleRule{}
+	for _, r := range db.rules {
+		if r.Enabled || !enabledOnly {
+			rules = append(rules, *r)
+		}
+	}
+	return rules, nil
+}
+
+func (db *fakeDB) DeleteLifecycleRule(id int) (bool, error) {
+	for i, r := range db.rules {
+		if r.ID == id {
+			db.rules = append(db.rules[:i], db.rules[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (db *fakeDB) GetLifecycleCandidates(r *models.LifecycleRule, notBefore time.Time, now time.Time, limit int) ([]models.LifecycleCandidate, error) {
+	var candidates []models.LifecycleCandidate
+	for _, u := range db.users {
+		var eventAt time.Time
+		switch r.Event {
+		case models.LifecycleSignup:
+			eventAt = u.createdAt
+		case models.LifecycleUnverified:
+			if u.verified {
+				continue
+			}
+			eventAt = u.createdAt
+		case models.LifecycleInactive:
+			eventAt = u.lastSeenAt
+		default:
+			return nil, errors.New("event not supported by the fake")
+		}
+
+		due := eventAt.Add(time.Duration(r.DelayMinutes) * time.Minute)
+		if due.Before(notBefore) || due.After(now) || db.applied(r.ID, u.id, eventAt) {
+			continue
+		}
+
+		c := models.LifecycleCandidate{UserID: u.id, Email: u.email, Name: "Jane", EventAt: eventAt}
+		for _, condition := range r.ExitConditions {
+			if (condition == models.LifecycleExitVerified && u.verified) ||
+				(condition == models.LifecycleExitSubscribed && u.subscribed) {
+				c.ExitCondition = condition
+				break
+			}
+		}
+		candidates = append(candidates, c)
+		if len(candidates) == limit {
+			break
+		}
+	}
+	return candidates, nil
+}
+
+func (db *fakeDB) applied(ruleID int, userID string, eventAt time.Time) bool {
+	for _, s := range db.sends {
+		if s.ruleID == ruleID && s.userID == userID && s.eventAt.Equal(eventAt) {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *fakeDB) RecordLifecycleSend(ruleID int, userID string, eventAt time.Time, status string, exitCondition string) error {
+	if !db.applied(ruleID, userID, eventAt) {
+		db.sends = append(db.sends, send{ruleID, userID, eventAt, status, exitCondition})
+	}
+	return nil
+}
+
+// fakeSender records queued emails and drops repeated idempotency keys like the outbox
+type fakeSender struct {
+	keys     map[string]bool
+	messages []email.Message
+	fail     bool
+}
+
+func (s *fakeSender) Enqueue(msg email.Message, idempotencyKey string) error {
+	if s.fail {
+		return errors.New("outbox unavailable")
+	}
+	if s.keys[idempotencyKey] {
+		return nil
+	}
+	s.keys[idempotencyKey] = true
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+var start = time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)
+
+func newTestService() (*Service, *fakeDB, *fakeSender, *clock.Fake) {
+	db := &fakeDB{}
+	sender := &fakeSender{keys: make(map[string]bool)}
+	clk := clock.NewFake(start)
+	config := Config{BatchSize: 100, MaxLateness: 48 * time.Hour, AppURL: "https://app.example.com"}
+	return NewService(db, sender, clk, config), db, sender, clk
+}
+
+func createRule(t *testing.T, s *Service, event string, delay time.Duration, template string, exitConditions ...string) *models.LifecycleRule {
+	t.Helper()
+	r := &models.LifecycleRule{
+		Name:           event + " follow-up",
+		Event:          event,
+		DelayMinutes:   int(delay / time.Minute),
+		Template:       template,
+		ExitConditions: exitConditions,
+		Enabled:        true,
+	}
+	if err := s.Create(r); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return r
+}
+
+// run advances the clock and processes the rules
+func run(t *testing.T, s *Service, clk *clock.Fake, d time.Duration) {
+	t.Helper()
+	clk.Advance(d)
+	if err := s.ProcessRules(); err != nil {
+		t.Fatalf("ProcessRules: %v", err)
+	}
+}
+
+func TestUnverifiedReminderAfterDelay(t *testing.T) {
+	s, db, sender, clk := newTestService()
+	createRule(t, s, models.LifecycleUnverified, 24*time.Hour, "lifecycle_verify_reminder", models.LifecycleExitVerified)
+	db.users = []*fakeUser{{id: "u1", email: "u1@example.com", createdAt: start.Add(time.Minute)}}
+
+	run(t, s, clk, 23*time.Hour)
+	if len(sender.messages) != 0 {
+		t.Fatalf("sent %d emails before the delay passed", len(sender.messages))
+	}
+
+	run(t, s, clk, time.Hour+time.Minute)
+	if len(sender.messages) != 1 {
+		t.Fatalf("sent %d emails after 24 hours, want 1", len(sender.messages))
+	}
+	if sender.messages[0].To != "u1@example.com" {
+		t.Errorf("sent to %q", sender.messages[0].To)
+	}
+	if db.sends[0].status != models.LifecycleSent {
+		t.Errorf("recorded status %q, want sent", db.sends[0].status)
+	}
+}
+
+func TestInactiveAfterFourteenDays(t *testing.T) {
+	s, db, sender, clk := newTestService()
+	createRule(t, s, models.LifecycleInactive, 14*24*time.Hour, "lifecycle_inactive")
+	user := &fakeUser{id: "u1", email: "u1@example.com", createdAt: start, lastSeenAt: start.Add(time.Hour)}
+	db.users = []*fakeUser{user}
+
+	// Coming back resets the fourteen days
+	run(t, s, clk, 10*24*time.Hour)
+	user.lastSeenAt = clk.Now()
+	run(t, s, clk, 10*24*time.Hour)
+	if len(sender.messages) != 0 {
+		t.Fatalf("sent %d emails to an active user", len(sender.messages))
+	}
+
+	run(t, s, clk, 4*24*time.Hour)
+	if len(sender.messages) != 1 {
+		t.Fatalf("sent %d emails after 14 inactive days, want 1", len(sender.messages))
+	}
+
+	// Inactive again after another visit is a new event
+	user.lastSeenAt = clk.Now()
+	run(t, s, clk, 14*24*time.Hour)
+	if len(sender.messages) != 2 {
+		t.Errorf("sent %d emails after the second inactive period, want 2", len(sender.messages))
+	}
+}
+
+func TestExitConditionStopsEmail(t *testing.T) {
+	s, db, sender, clk := newTestService()
+	createRule(t, s, models.LifecycleSignup, 3*24*time.Hour, "lifecycle_welcome", models.LifecycleExitSubscribed)
+	db.users = []*fakeUser{
+		{id: "u1", email: "u1@example.com", createdAt: start.Add(time.Minute), subscribed: true},
+		{id: "u2", email: "u2@example.com", createdAt: start.Add(time.Minute)},
+	}
+
+	run(t, s, clk, 3*24*time.Hour+time.Hour)
+	if len(sender.messages) != 1 || sender.messages[0].To != "u2@example.com" {
+		t.Fatalf("sent %v, want only u2", sender.messages)
+	}
+
+	statuses := map[string]send{}
+	for _, recorded := range db.sends {
+		statuses[recorded.userID] = recorded
+	}
+	if got := statuses["u1"]; got.status != models.LifecycleExited || got.exitCondition != models.LifecycleExitSubscribed {
+		t.Errorf("u1 recorded as %+v, want exited on subscribed", got)
+	}
+
+	// A user who exited doesn't get the email when the condition stops holding
+	db.users[0].subscribed = false
+	run(t, s, clk, time.Hour)
+	if len(sender.messages) != 1 {
+		t.Errorf("sent %d emails, want 1", len(sender.messages))
+	}
+}
+
+func TestEmailSentOncePerUserAndEvent(t *testing.T) {
+	s, db, sender, clk := newTestService()
+	createRule(t, s, models.LifecycleSignup, time.Hour, "lifecycle_welcome")
+	db.users = []*fakeUser{{id: "u1", email: "u1@example.com", createdAt: start.Add(time.Minute)}}
+
+	for i := 0; i < 5; i++ {
+		run(t, s, clk, 15*time.Minute)
+	}
+	if len(sender.messages) != 1 {
+		t.Errorf("sent %d emails, want 1", len(sender.messages))
+	}
+	if len(db.sends) != 1 {
+		t.Errorf("recorded %d sends, want 1", len(db.sends))
+	}
+}
+
+func TestFailedSendIsRetried(t *testing.T) {
+	s, db, sender, clk := newTestService()
+	createRule(t, s, models.LifecycleSignup, time.Hour, "lifecycle_welcome")
+	db.users = []*fakeUser{{id: "u1", email: "u1@example.com", createdAt: start.Add(time.Minute)}}
+
+	sender.fail = true
+	run(t, s, clk, 2*time.Hour)
+	if len(db.sends) != 0 {
+		t.Fatalf("recorded a send that failed")
+	}
+
+	sender.fail = false
+	run(t, s, clk, 15*time.Minute)
+	if len(sender.messages) != 1 || len(db.sends) != 1 {
+		t.Errorf("sent %d emails and recorded %d sends after retrying, want 1 each", len(sender.messages), len(db.sends))
+	}
+}
+
+func TestRuleOnlyCoversEventsAfterEnabled(t *testing.T) {
+	s, db, sender, clk := newTestService()
+	// Users who signed up long before the rule existed don't get a late welcome email
+	db.users = []*fakeUser{
+		{id: "old", email: "old@example.com", createdAt: start.Add(-30 * 24 * time.Hour)},
+		{id: "recent", email: "recent@example.com", createdAt: start.Add(-90 * time.Minute)},
+	}
+	createRule(t, s, models.LifecycleSignup, 2*time.Hour, "lifecycle_welcome")
+
+	run(t, s, clk, time.Hour)
+	if len(sender.messages) != 1 || sender.messages[0].To != "recent@example.com" {
+		t.Errorf("sent %v, want only the email due after the rule was enabled", sender.messages)
+	}
+}
+
+func TestLateEmailsAreSkipped(t *testing.T) {
+	s, db, sender, clk := newTestService()
+	createRule(t, s, models.LifecycleSignup, time.Hour, "lifecycle_welcome")
+	db.users = []*fakeUser{{id: "u1", email: "u1@example.com", createdAt: start.Add(time.Minute)}}
+
+	// The job was down for longer than MaxLateness
+	run(t, s, clk, 4*24*time.Hour)
+	if len(sender.messages) != 0 {
+		t.Errorf("sent %d emails more than two days late", len(sender.messages))
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	s, _, _, _ := newTestService()
+
+	rules := []models.LifecycleRule{
+		{Name: "", Event: models.LifecycleSignup, Template: "lifecycle_welcome"},
+		{Name: "x", Event: "unknown", Template: "lifecycle_welcome"},
+		{Name: "x", Event: models.LifecycleSignup, Template: "password_reset"},
+		{Name: "x", Event: models.LifecycleSignup, Template: "lifecycle_welcome", DelayMinutes: -60},
+		{Name: "x", Event: models.LifecycleSignup, Template: "lifecycle_welcome", ExitConditions: []string{"verified", "verified"}},
+	}
+	for _, r := range rules {
+		r := r
+		if err := s.Create(&r); !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("Create(%+v) = %v, want ErrInvalidRule", r, err)
+		}
+	}
+
+	trial := &models.LifecycleRule{Name: "x", Event: models.LifecycleTrialEnding, Template: "lifecycle_trial_ending", DelayMinutes: -3 * 24 * 60}
+	if err := s.Create(trial); err != nil {
+		t.Errorf("Create with a negative delay for trial_ending: %v", err)
+	}
+}
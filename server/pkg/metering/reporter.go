@@ -0,0 +1,248 @@
+// Package metering aggregates recorded usage events and reports them to the payment provider
+package metering
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/clock"
+	"saas-server/pkg/email"
+	"saas-server/pkg/lemonsqueezy"
+)
+
+// ErrNotFound is returned when resolving a usage report that doesn't need review
+var ErrNotFound = errors.New("usage report not found or doesn't need review")
+
+// maxReportAttempts is the number of times a usage report is sent before it is left for manual review
+const maxReportAttempts = 10
+
+// stuckReportAge is how long a usage report can stay claimed before it is flagged for manual review
+const stuckReportAge = time.Hour
+
+// UsageDB defines the database operations required by the usage reporter
+type UsageDB interface {
+	GetUnreportedUsageSubscriptions() ([]models.Subscription, error)
+	CreatePendingUsageReport(subscriptionID int, subscriptionItemID int, periodStart time.Time, periodEnd time.Time) (*models.UsageReport, int, error)
+	GetUnsentUsageReports(maxAttempts int) ([]models.UsageReport, error)
+	MoveUsageReportToPeriod(reportID int, periodStart time.Time, periodEnd time.Time) error
+	ClaimUsageReport(reportID int) (bool, error)
+	MarkUsageReportSent(reportID int, providerUsageRecordID string) error
+	MarkUsageReportFailed(reportID int, reportErr string) error
+	MarkUsageReportUnconfirmed(reportID int, reportErr string) error
+	GetUsageReportsNeedingReview(claimedBefore time.Time, maxAttempts int) ([]models.UsageReport, error)
+	ResolveUsageReport(reportID int, recorded bool, providerUsageRecordID string, claimedBefore time.Time, maxAttempts int) (bool, error)
+}
+
+// UsageRecorder sends usage records to the payment provider and tells the billing period they are added to
+type UsageRecorder interface {
+	CreateUsageRecord(subscriptionItemID int, quantity int) (*lemonsqueezy.UsageRecordResponse, error)
+	GetCurrentUsage(subscriptionItemID int) (*lemonsqueezy.CurrentUsageResponse, error)
+}
+
+// Sender queues emails for delivery. Implemented by email.Outbox
+type Sender interface {
+	Enqueue(msg email.Message, idempotencyKey string) error
+}
+
+// Config controls who is alerted about usage reports that need review
+type Config struct {
+	// AdminEmail receives an alert for every usage report that needs to be checked against the
+	// provider. Empty only logs them.
+	AdminEmail string
+}
+
+// LoadConfig reads the usage reporting configuration from the environment
+func LoadConfig() Config {
+	return Config{AdminEmail: os.Getenv("ADMIN_EMAIL")}
+}
+
+// UsageReportingService periodically reports aggregated usage to the payment provider
+type UsageReportingService struct {
+	db       UsageDB
+	recorder UsageRecorder
+	sender   Sender
+	clock    clock.Clock
+	config   Config
+}
+
+// NewUsageReportingService creates a new instance of UsageReportingService
+func NewUsageReportingService(db UsageDB, recorder UsageRecorder, sender Sender, clk clock.Clock, config Config) *UsageReportingService {
+	return &UsageReportingService{
+		db:       db,
+		recorder: recorder,
+		sender:   sender,
+		clock:    clk,
+		config:   config,
+	}
+}
+
+// StartReportingJob starts the background job that reports usage every interval
+func (s *UsageReportingService) StartReportingJob(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			if err := s.ReportUsage(); err != nil {
+				log.Printf("Error reporting usage: %v", err)
+			}
+		}
+	}()
+}
+
+// ReportUsage aggregates unreported usage events into a report per subscription and sends every
+// unsent report to the provider. Reports the provider rejected are retried on the next run.
+//
+// Usage records increment the provider's total for the current period and can't be de-duplicated
+// or back-dated there. Usage that missed its period, because it was recorded after the last run
+// before the renewal or its report couldn't be sent in time, is billed in the current period
+// instead. Each report is claimed before it is sent. If the outcome is unknown, e.g. the request
+// timed out or marking the report failed, it stays claimed and the admin is alerted to check it
+// instead of risking billing the usage twice.
+func (s *UsageReportingService) ReportUsage() error {
+	periods := make(map[int]*lemonsqueezy.CurrentUsageResponse)
+
+	subscriptions, err := s.db.GetUnreportedUsageSubscriptions()
+	if err != nil {
+		return err
+	}
+	for _, subscription := range subscriptions {
+		period, err := s.currentPeriod(subscription.SubscriptionItemID, periods)
+		if err != nil {
+			log.Printf("Error fetching billing period of subscription %s, aggregating its usage later: %v", subscription.SubscriptionID, err)
+			continue
+		}
+
+		subscriptionID, _ := strconv.Atoi(subscription.SubscriptionID)
+		report, carriedOver, err := s.db.CreatePendingUsageReport(subscriptionID, subscription.SubscriptionItemID, period.Meta.PeriodStart, period.Meta.PeriodEnd)
+		if err != nil {
+			log.Printf("Error aggregating usage of subscription %d: %v", subscriptionID, err)
+			continue
+		}
+		if report != nil {
+			log.Printf("Aggregated %d units of subscription %d into usage report %d", report.Quantity, subscriptionID, report.ID)
+		}
+		if carriedOver > 0 {
+			log.Printf("%d units of subscription %d are from before the current billing period and are billed in it", carriedOver, subscriptionID)
+		}
+	}
+
+	reports, err := s.db.GetUnsentUsageReports(maxReportAttempts)
+	if err != nil {
+		return err
+	}
+
+	for _, report := range reports {
+		period, err := s.currentPeriod(report.SubscriptionItemID, periods)
+		if err != nil {
+			log.Printf("Error fetching billing period for usage report %d, sending it later: %v", report.ID, err)
+			continue
+		}
+		if report.PeriodStart.Before(period.Meta.PeriodStart) {
+			log.Printf("Usage report %d for subscription %d wasn't sent before its billing period ended, billing it in the current one", report.ID, report.SubscriptionID)
+			if err := s.db.MoveUsageReportToPeriod(report.ID, period.Meta.PeriodStart, period.Meta.PeriodEnd); err != nil {
+				log.Printf("Error moving usage report %d to the current billing period: %v", report.ID, err)
+				continue
+			}
+		}
+
+		claimed, err := s.db.ClaimUsageReport(report.ID)
+		if err != nil {
+			log.Printf("Error claiming usage report %d: %v", report.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		record, err := s.recorder.CreateUsageRecord(report.SubscriptionItemID, report.Quantity)
+		if err != nil {
+			log.Printf("Error sending usage report %d for subscription %d: %v", report.ID, report.SubscriptionID, err)
+
+			var rejected *lemonsqueezy.UsageRecordRejectedError
+			if errors.As(err, &rejected) {
+				err = s.db.MarkUsageReportFailed(report.ID, err.Error())
+			} else {
+				err = s.db.MarkUsageReportUnconfirmed(report.ID, err.Error())
+			}
+			if err != nil {
+				log.Printf("Error recording failure of usage report %d: %v", report.ID, err)
+			}
+			continue
+		}
+
+		if err := s.db.MarkUsageReportSent(report.ID, record.Data.ID); err != nil {
+			log.Printf("Error marking usage report %d as sent, it was recorded as usage record %s: %v", report.ID, record.Data.ID, err)
+		}
+	}
+
+	return s.alertReview()
+}
+
+// ReportsNeedingReview returns the usage reports that have to be checked against the provider
+// because they were sent without a confirmed outcome or failed too often
+func (s *UsageReportingService) ReportsNeedingReview() ([]models.UsageReport, error) {
+	return s.db.GetUsageReportsNeedingReview(s.clock.Now().Add(-stuckReportAge), maxReportAttempts)
+}
+
+// ResolveReport records what an admin found when checking a usage report against the provider.
+// recorded means the provider has the usage record, so the report is kept as reported; otherwise
+// it is sent again on the next run. It returns ErrNotFound if the report doesn't need review.
+func (s *UsageReportingService) ResolveReport(reportID int, recorded bool, providerUsageRecordID string) error {
+	resolved, err := s.db.ResolveUsageReport(reportID, recorded, providerUsageRecordID, s.clock.Now().Add(-stuckReportAge), maxReportAttempts)
+	if err != nil {
+		return err
+	}
+	if !resolved {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// alertReview logs the usage reports that need review and emails the admin about them. The
+// email is only sent again when the reports needing review change.
+func (s *UsageReportingService) alertReview() error {
+	reports, err := s.ReportsNeedingReview()
+	if err != nil {
+		return err
+	}
+	if len(reports) == 0 {
+		return nil
+	}
+	log.Printf("%d usage reports need to be checked against the provider", len(reports))
+	if s.config.AdminEmail == "" {
+		return nil
+	}
+
+	var keys []string
+	for _, report := range reports {
+		keys = append(keys, fmt.Sprintf("%d.%d", report.ID, report.Attempts))
+	}
+	msg, err := email.UsageReviewEmail(s.config.AdminEmail, email.UsageReviewData{Reports: reports})
+	if err != nil {
+		return err
+	}
+	// The outbox ignores repeated keys, so the same reports don't raise an alert every run
+	sum := sha256.Sum256([]byte(strings.Join(keys, ",")))
+	return s.sender.Enqueue(msg, "usage-review:"+hex.EncodeToString(sum[:]))
+}
+
+// currentPeriod returns the current billing period of a subscription item, fetching it from the
+// provider once per run
+func (s *UsageReportingService) currentPeriod(subscriptionItemID int, periods map[int]*lemonsqueezy.CurrentUsageResponse) (*lemonsqueezy.CurrentUsageResponse, error) {
+	if period, ok := periods[subscriptionItemID]; ok {
+		return period, nil
+	}
+	period, err := s.recorder.GetCurrentUsage(subscriptionItemID)
+	if err != nil {
+		return nil, err
+	}
+	periods[subscriptionItemID] = period
+	return period, nil
+}
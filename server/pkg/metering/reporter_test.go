@@ -0,0 +1,397 @@
+package metering
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/clock"
+	"saas-server/pkg/email"
+	"saas-server/pkg/lemonsqueezy"
+)
+
+const (
+	testSubscriptionID = 1001
+	testItemID         = 2001
+)
+
+// fakeEvent is a recorded usage event
+type fakeEvent struct {
+	id         int
+	quantity   int
+	occurredAt time.Time
+	reportID   int
+}
+
+// fakeDB keeps usage events and reports of a single subscription in memory and changes
+// report statuses the way the SQL does
+type fakeDB struct {
+	clock   *clock.Fake
+	events  []*fakeEvent
+	reports []*models.UsageReport
+}
+
+func (db *fakeDB) record(quantity int, occurredAt time.Time) {
+	db.events = append(db.events, &fakeEvent{id: len(db.events) + 1, quantity: quantity, occurredAt: occurredAt})
+}
+
+func (db *fakeDB) report(id int) *models.UsageReport {
+	for _, r := range db.reports {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+func (db *fakeDB) update(id int, from []string, change func(r *models.UsageReport)) bool {
+	r := db.report(id)
+	if r == nil {
+		return false
+	}
+	for _, status := range from {
+		if r.Status == status {
+			change(r)
+			r.UpdatedAt = db.clock.Now()
+			return true
+		}
+	}
+	return false
+}
+
+func (db *fakeDB) GetUnreportedUsageSubscriptions() ([]models.Subscription, error) {
+	for _, e := range db.events {
+		if e.reportID == 0 {
+			return []models.Subscription{{
+				SubscriptionID:     strconv.Itoa(testSubscriptionID),
+				SubscriptionItemID: testItemID,
+				IsUsageBased:       true,
+			}}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (db *fakeDB) CreatePendingUsageReport(subscriptionID int, subscriptionItemID int, periodStart time.Time, periodEnd time.Time) (*models.UsageReport, int, error) {
+	var quantity, carriedOver int
+	var events []*fakeEvent
+	for _, e := range db.events {
+		if e.reportID != 0 || !e.occurredAt.Before(periodEnd) {
+			continue
+		}
+		quantity += e.quantity
+		if e.occurredAt.Before(periodStart) {
+			carriedOver += e.quantity
+		}
+		events = append(events, e)
+	}
+	if len(events) == 0 {
+		return nil, 0, nil
+	}
+
+	report := &models.UsageReport{
+		ID:                 len(db.reports) + 1,
+		SubscriptionID:     subscriptionID,
+		SubscriptionItemID: subscriptionItemID,
+		Quantity:           quantity,
+		PeriodStart:        periodStart,
+		PeriodEnd:          periodEnd,
+		Status:             "pending",
+		CreatedAt:          db.clock.Now(),
+		UpdatedAt:          db.clock.Now(),
+	}
+	db.reports = append(db.reports, report)
+	for _, e := range events {
+		e.reportID = report.ID
+	}
+	copied := *report
+	return &copied, carriedOver, nil
+}
+
+func (db *fakeDB) GetUnsentUsageReports(maxAttempts int) ([]models.UsageReport, error) {
+	var reports []models.UsageReport
+	for _, r := range db.reports {
+		if (r.Status == "pending" || r.Status == "failed") && r.Attempts < maxAttempts {
+			reports = append(reports, *r)
+		}
+	}
+	return reports, nil
+}
+
+func (db *fakeDB) MoveUsageReportToPeriod(reportID int, periodStart time.Time, periodEnd time.Time) error {
+	db.update(reportID, []string{"pending", "failed"}, func(r *models.UsageReport) {
+		r.PeriodStart = periodStart
+		r.PeriodEnd = periodEnd
+	})
+	return nil
+}
+
+func (db *fakeDB) ClaimUsageReport(reportID int) (bool, error) {
+	return db.update(reportID, []string{"pending", "failed"}, func(r *models.UsageReport) {
+		r.Status = "sending"
+		r.Attempts++
+	}), nil
+}
+
+func (db *fakeDB) MarkUsageReportSent(reportID int, providerUsageRecordID string) error {
+	db.update(reportID, []string{"sending"}, func(r *models.UsageReport) {
+		r.Status = "reported"
+		r.ProviderUsageRecordID = providerUsageRecordID
+	})
+	return nil
+}
+
+func (db *fakeDB) MarkUsageReportFailed(reportID int, reportErr string) error {
+	db.update(reportID, []string{"sending"}, func(r *models.UsageReport) {
+		r.Status = "failed"
+		r.LastError = reportErr
+	})
+	return nil
+}
+
+func (db *fakeDB) MarkUsageReportUnconfirmed(reportID int, reportErr string) error {
+	db.update(reportID, []string{"sending"}, func(r *models.UsageReport) {
+		r.LastError = reportErr
+	})
+	return nil
+}
+
+func (db *fakeDB) needsReview(r *models.UsageReport, claimedBefore time.Time, maxAttempts int) bool {
+	return (r.Status == "sending" && r.UpdatedAt.Before(claimedBefore)) ||
+		(r.Status == "failed" && r.Attempts >= maxAttempts)
+}
+
+func (db *fakeDB) GetUsageReportsNeedingReview(claimedBefore time.Time, maxAttempts int) ([]models.UsageReport, error) {
+	var reports []models.UsageReport
+	for _, r := range db.reports {
+		if db.needsReview(r, claimedBefore, maxAttempts) {
+			reports = append(reports, *r)
+		}
+	}
+	return reports, nil
+}
+
+func (db *fakeDB) ResolveUsageReport(reportID int, recorded bool, providerUsageRecordID string, claimedBefore time.Time, maxAttempts int) (bool, error) {
+	r := db.report(reportID)
+	if r == nil || !db.needsReview(r, claimedBefore, maxAttempts) {
+		return false, nil
+	}
+	if recorded {
+		r.Status = "reported"
+		r.ProviderUsageRecordID = providerUsageRecordID
+	} else {
+		r.Status = "failed"
+		r.Attempts = 0
+	}
+	r.UpdatedAt = db.clock.Now()
+	return true, nil
+}
+
+// fakeRecorder bills usage records into a billing period that only changes when the test
+// renews it, and fails requests with err while it is set
+type fakeRecorder struct {
+	periodStart time.Time
+	periodEnd   time.Time
+	billed      map[time.Time]int // Quantity recorded per period start
+	err         error
+}
+
+func (p *fakeRecorder) renew() {
+	p.periodStart, p.periodEnd = p.periodEnd, p.periodEnd.AddDate(0, 1, 0)
+}
+
+func (p *fakeRecorder) CreateUsageRecord(subscriptionItemID int, quantity int) (*lemonsqueezy.UsageRecordResponse, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	p.billed[p.periodStart] += quantity
+	record := &lemonsqueezy.UsageRecordResponse{}
+	record.Data.ID = "record-" + strconv.Itoa(len(p.billed))
+	record.Data.Attributes.Quantity = quantity
+	return record, nil
+}
+
+func (p *fakeRecorder) GetCurrentUsage(subscriptionItemID int) (*lemonsqueezy.CurrentUsageResponse, error) {
+	current := &lemonsqueezy.CurrentUsageResponse{}
+	current.Meta.PeriodStart = p.periodStart
+	current.Meta.PeriodEnd = p.periodEnd
+	current.Meta.Quantity = p.billed[p.periodStart]
+	return current, nil
+}
+
+// fakeSender records queued emails and drops repeated idempotency keys like the outbox
+type fakeSender struct {
+	keys     map[string]bool
+	messages []email.Message
+}
+
+func (s *fakeSender) Enqueue(msg email.Message, idempotencyKey string) error {
+	if s.keys[idempotencyKey] {
+		return nil
+	}
+	s.keys[idempotencyKey] = true
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+var periodStart = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestService() (*UsageReportingService, *fakeDB, *fakeRecorder, *fakeSender, *clock.Fake) {
+	clk := clock.NewFake(periodStart.Add(10 * 24 * time.Hour))
+	db := &fakeDB{clock: clk}
+	recorder := &fakeRecorder{periodStart: periodStart, periodEnd: periodStart.AddDate(0, 1, 0), billed: make(map[time.Time]int)}
+	sender := &fakeSender{keys: make(map[string]bool)}
+	return NewUsageReportingService(db, recorder, sender, clk, Config{AdminEmail: "admin@example.com"}), db, recorder, sender, clk
+}
+
+func report(t *testing.T, s *UsageReportingService) {
+	t.Helper()
+	if err := s.ReportUsage(); err != nil {
+		t.Fatalf("ReportUsage: %v", err)
+	}
+}
+
+func TestReportUsageAggregatesEvents(t *testing.T) {
+	s, db, recorder, _, clk := newTestService()
+	db.record(3, clk.Now().Add(-2*time.Hour))
+	db.record(4, clk.Now().Add(-time.Hour))
+
+	report(t, s)
+	if got := recorder.billed[periodStart]; got != 7 {
+		t.Fatalf("billed %d units, want 7", got)
+	}
+	if db.reports[0].Status != "reported" {
+		t.Errorf("report status = %q, want reported", db.reports[0].Status)
+	}
+
+	// Nothing new, so nothing is billed again
+	report(t, s)
+	if got := recorder.billed[periodStart]; got != 7 {
+		t.Errorf("billed %d units after a second run, want 7", got)
+	}
+}
+
+func TestPeriodRolloverCarriesLateUsage(t *testing.T) {
+	s, db, recorder, _, clk := newTestService()
+	renewal := recorder.periodEnd
+
+	// The last run of the period, then usage until just before the renewal
+	clk.Set(renewal.Add(-90 * time.Minute))
+	db.record(5, clk.Now().Add(-time.Minute))
+	report(t, s)
+	db.record(2, renewal.Add(-time.Minute))
+
+	recorder.renew()
+	clk.Set(renewal.Add(30 * time.Minute))
+	db.record(1, clk.Now())
+	report(t, s)
+
+	if got := recorder.billed[periodStart]; got != 5 {
+		t.Errorf("billed %d units in the first period, want 5", got)
+	}
+	if got := recorder.billed[renewal]; got != 3 {
+		t.Errorf("billed %d units in the second period, want 3 including the 2 recorded before the renewal", got)
+	}
+	for _, r := range db.reports {
+		if r.Status != "reported" {
+			t.Errorf("report %d status = %q, want reported", r.ID, r.Status)
+		}
+	}
+}
+
+func TestUnsentReportIsBilledInNextPeriod(t *testing.T) {
+	s, db, recorder, _, clk := newTestService()
+	renewal := recorder.periodEnd
+
+	clk.Set(renewal.Add(-time.Hour))
+	db.record(6, clk.Now())
+	recorder.err = &lemonsqueezy.UsageRecordRejectedError{StatusCode: 422, Body: "{}"}
+	report(t, s)
+	if db.reports[0].Status != "failed" {
+		t.Fatalf("report status after rejection = %q, want failed", db.reports[0].Status)
+	}
+
+	recorder.renew()
+	recorder.err = nil
+	clk.Set(renewal.Add(time.Hour))
+	report(t, s)
+
+	if got := recorder.billed[renewal]; got != 6 {
+		t.Errorf("billed %d units in the next period, want 6", got)
+	}
+	if r := db.reports[0]; r.Status != "reported" || !r.PeriodStart.Equal(renewal) {
+		t.Errorf("report is %q for the period from %v, want reported for the period from %v", r.Status, r.PeriodStart, renewal)
+	}
+}
+
+func TestUnconfirmedReportIsReviewedNotResent(t *testing.T) {
+	s, db, recorder, sender, clk := newTestService()
+	db.record(8, clk.Now())
+
+	recorder.err = errors.New("request timed out")
+	report(t, s)
+	recorder.err = nil
+	if db.reports[0].Status != "sending" {
+		t.Fatalf("report status after timeout = %q, want sending", db.reports[0].Status)
+	}
+
+	clk.Advance(30 * time.Minute)
+	report(t, s)
+	if got := recorder.billed[periodStart]; got != 0 {
+		t.Fatalf("billed %d units of an unconfirmed report, want 0", got)
+	}
+	if len(sender.messages) != 0 {
+		t.Fatalf("sent %d alerts before the report was stuck", len(sender.messages))
+	}
+
+	clk.Advance(time.Hour)
+	report(t, s)
+	report(t, s)
+	if len(sender.messages) != 1 {
+		t.Fatalf("sent %d alerts for the stuck report, want 1", len(sender.messages))
+	}
+	if sender.messages[0].To != "admin@example.com" {
+		t.Errorf("alert sent to %q", sender.messages[0].To)
+	}
+	reports, err := s.ReportsNeedingReview()
+	if err != nil || len(reports) != 1 {
+		t.Fatalf("ReportsNeedingReview = %d reports, %v, want 1", len(reports), err)
+	}
+
+	// The admin found no usage record at the provider, so it is sent again
+	if err := s.ResolveReport(reports[0].ID, false, ""); err != nil {
+		t.Fatalf("ResolveReport: %v", err)
+	}
+	report(t, s)
+	if got := recorder.billed[periodStart]; got != 8 {
+		t.Errorf("billed %d units after resolving, want 8", got)
+	}
+	if err := s.ResolveReport(reports[0].ID, true, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("resolving a reported report = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRejectedReportIsRetried(t *testing.T) {
+	s, db, recorder, sender, clk := newTestService()
+	db.record(2, clk.Now())
+
+	recorder.err = &lemonsqueezy.UsageRecordRejectedError{StatusCode: 429, Body: "{}"}
+	for i := 0; i < maxReportAttempts; i++ {
+		report(t, s)
+	}
+	if r := db.reports[0]; r.Status != "failed" || r.Attempts != maxReportAttempts {
+		t.Fatalf("report is %q after %d attempts, want failed after %d", r.Status, r.Attempts, maxReportAttempts)
+	}
+	if len(sender.messages) != 1 {
+		t.Errorf("sent %d alerts for the failing report, want 1", len(sender.messages))
+	}
+
+	// Given up on until it is resolved
+	recorder.err = nil
+	report(t, s)
+	if got := recorder.billed[periodStart]; got != 0 {
+		t.Errorf("billed %d units of a report that was given up on", got)
+	}
+}
@@ -0,0 +1,144 @@
+// Package money formats monetary amounts stored as integer minor units (e.g. cents)
+package money
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidAmount is returned by Parse for text that isn't an amount
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// amountNumber matches the digits and separators of an amount
+var amountNumber = regexp.MustCompile(`^[0-9]+([.,][0-9]+)*$`)
+
+// currencySymbols maps ISO 4217 codes to the symbol printed before the amount
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+	"INR": "₹",
+	"AUD": "A$",
+	"CAD": "CA$",
+	"NZD": "NZ$",
+	"CHF": "CHF ",
+	"SEK": "SEK ",
+	"NOK": "NOK ",
+	"DKK": "DKK ",
+	"PLN": "PLN ",
+	"BRL": "R$",
+	"KRW": "₩",
+}
+
+// zeroDecimalCurrencies lists ISO 4217 currencies that have no minor unit
+var zeroDecimalCurrencies = map[string]bool{
+	"JPY": true,
+	"KRW": true,
+	"VND": true,
+	"CLP": true,
+	"ISK": true,
+}
+
+// MinorUnitDigits returns the number of decimal digits used by a currency
+func MinorUnitDigits(currency string) int {
+	if zeroDecimalCurrencies[strings.ToUpper(currency)] {
+		return 0
+	}
+	return 2
+}
+
+// Format renders an amount in minor units as a human readable string, e.g. Format(129900, "USD") = "$1,299.00"
+func Format(amount int, currency string) string {
+	currency = strings.ToUpper(currency)
+
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	digits := MinorUnitDigits(currency)
+	divisor := 1
+	for i := 0; i < digits; i++ {
+		divisor *= 10
+	}
+
+	whole := groupThousands(amount / divisor)
+	number := whole
+	if digits > 0 {
+		number = fmt.Sprintf("%s.%0*d", whole, digits, amount%divisor)
+	}
+
+	if symbol, ok := currencySymbols[currency]; ok {
+		return sign + symbol + number
+	}
+	if currency == "" {
+		return sign + number
+	}
+	return sign + number + " " + currency
+}
+
+// Parse reads a formatted amount into minor units of currency. Besides the output of Format
+// it accepts other locales' formats, e.g. Parse("1.234,56 €", "EUR") = 123456: a "." or ","
+// followed by exactly three digits groups thousands, any other separates the decimals.
+// A minus sign or enclosing parentheses make the amount negative. Migration 011 backfilled
+// order amounts with the same rules.
+func Parse(s string, currency string) (int, error) {
+	var number strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' || r == '.' || r == ',' {
+			number.WriteRune(r)
+		}
+	}
+	n := number.String()
+	if !amountNumber.MatchString(n) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+
+	whole, fraction := n, ""
+	if i := strings.LastIndexAny(n, ".,"); i >= 0 && len(n)-i-1 != 3 {
+		whole, fraction = n[:i], n[i+1:]
+	}
+	whole = strings.NewReplacer(".", "", ",", "").Replace(whole)
+
+	// Keep the currency's decimals and round half up on the next one
+	digits := MinorUnitDigits(currency)
+	fraction += strings.Repeat("0", digits+1)
+	amount, err := strconv.Atoi(whole + fraction[:digits])
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if fraction[digits] >= '5' {
+		amount++
+	}
+
+	if strings.ContainsAny(s, "-−") || strings.HasPrefix(strings.TrimSpace(s), "(") {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// groupThousands inserts comma separators into a non-negative integer
+func groupThousands(n int) string {
+	s := fmt.Sprintf("%d", n)
+	if len(s) <= 3 {
+		return s
+	}
+
+	var b strings.Builder
+	lead := len(s) % 3
+	if lead > 0 {
+		b.WriteString(s[:lead])
+	}
+	for i := lead; i < len(s); i += 3 {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(s[i : i+3])
+	}
+	return b.String()
+}
@@ -0,0 +1,105 @@
+package money
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		amount   int
+		currency string
+		want     string
+	}{
+		{129900, "USD", "$1,299.00"},
+		{1200, "eur", "€12.00"},
+		{-1250, "GBP", "-£12.50"},
+		{5, "USD", "$0.05"},
+		{123456789, "USD", "$1,234,567.89"},
+		{1234, "JPY", "¥1,234"},
+		{129900, "PLN", "PLN 1,299.00"},
+		{129900, "CAD", "CA$1,299.00"},
+		{1200, "MXN", "12.00 MXN"},
+		{1200, "", "12.00"},
+		{0, "KRW", "₩0"},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("Format(%d, %q) = %q, want %q", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		formatted string
+		currency  string
+		want      int
+	}{
+		{"$1,299.00", "USD", 129900},
+		{"-$12.00", "USD", -1200},
+		{"$-12.00", "USD", -1200},
+		{"($12.00)", "USD", -1200},
+		{"1.234,56 €", "EUR", 123456},
+		{"-1.234,56 €", "EUR", -123456},
+		{"1 234,56 €", "EUR", 123456},
+		{"CHF 1'234.50", "CHF", 123450},
+		{"12,5 €", "EUR", 1250},
+		{"1,299.00 PLN", "PLN", 129900},
+		{"$1,234,567.89", "USD", 123456789},
+		{"$1,000", "USD", 100000},
+		{"$12", "USD", 1200},
+		{"$0.05", "USD", 5},
+		{"$12.345", "USD", 1234500}, // Three digits after the last separator group thousands
+		{"¥1,234", "JPY", 1234},
+		{"1.234 ¥", "JPY", 1234},
+		{"₩12,000", "KRW", 12000},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.formatted, tt.currency)
+		if err != nil {
+			t.Errorf("Parse(%q, %q) returned %v", tt.formatted, tt.currency, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q, %q) = %d, want %d", tt.formatted, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestParseRoundsToMinorUnits(t *testing.T) {
+	tests := []struct {
+		formatted string
+		currency  string
+		want      int
+	}{
+		{"12.5 ¥", "JPY", 13},
+		{"12.4 ¥", "JPY", 12},
+		{"-12.5 ¥", "JPY", -13},
+	}
+	for _, tt := range tests {
+		if got, err := Parse(tt.formatted, tt.currency); err != nil || got != tt.want {
+			t.Errorf("Parse(%q, %q) = %d, %v, want %d", tt.formatted, tt.currency, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseRejectsInvalidAmounts(t *testing.T) {
+	for _, formatted := range []string{"", "$", "free", "$1..2", "€,50", "1.234,", "$99999999999999999999.00"} {
+		if got, err := Parse(formatted, "USD"); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("Parse(%q) = %d, %v, want ErrInvalidAmount", formatted, got, err)
+		}
+	}
+}
+
+func TestParseReadsFormat(t *testing.T) {
+	for _, currency := range []string{"USD", "EUR", "JPY", "PLN", "BRL", "MXN"} {
+		for _, amount := range []int{0, 7, 1200, -1250, 129900, 123456789} {
+			formatted := Format(amount, currency)
+			got, err := Parse(formatted, currency)
+			if err != nil || got != amount {
+				t.Errorf("Parse(%q, %q) = %d, %v, want %d", formatted, currency, got, err, amount)
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package newsletter manages newsletter subscriptions with double opt-in: subscribers get a
+// signed confirmation link, consent is recorded when they follow it, and every newsletter
+// carries a signed one-click unsubscribe link
+package newsletter
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/clock"
+	"saas-server/pkg/email"
+	"saas-server/pkg/signedlink"
+)
+
+// Purposes of the signed newsletter links
+const (
+	confirmPurpose     = "newsletter-confirm"
+	unsubscribePurpose = "newsletter-unsubscribe"
+)
+
+// ErrConfirmationUsed is returned for confirmation links that were already used or replaced
+// by a newer confirmation email
+var ErrConfirmationUsed = errors.New("confirmation link is no longer valid")
+
+// NewsletterDB defines the database operations required by the newsletter service
+type NewsletterDB interface {
+	RequestNewsletterSubscription(email string, ip string, requestedAt time.Time, resendAfter time.Time) (*models.NewsletterSubscription, error)
+	ConfirmNewsletterSubscription(email string, requestedAt time.Time, ip string, confirmedAt time.Time) (bool, error)
+	UnsubscribeNewsletter(email string, unsubscribedAt time.Time) (bool, error)
+}
+
+// Config controls the newsletter links
+type Config struct {
+	// ConfirmURL is the frontend page confirmation links point to; it posts the token back
+	ConfirmURL string
+	// UnsubscribeURL is the public API endpoint for one-click unsubscribes
+	UnsubscribeURL string
+	// ConfirmExpiry is how long confirmation links stay valid
+	ConfirmExpiry time.Duration
+	// ResendInterval is the minimum time between confirmation emails to the same address
+	ResendInterval time.Duration
+}
+
+// LoadConfig reads the newsletter configuration from the environment.
+// Confirmation links point to FRONTEND_URL/newsletter/confirm and unsubscribe links to
+// API_URL/api/newsletter/unsubscribe. NEWSLETTER_CONFIRM_DAYS sets how long confirmation
+// links stay valid (default 7).
+func LoadConfig() Config {
+	config := Config{
+		ConfirmURL:     strings.TrimRight(os.Getenv("FRONTEND_URL"), "/") + "/newsletter/confirm",
+		UnsubscribeURL: strings.TrimRight(os.Getenv("API_URL"), "/") + "/api/newsletter/unsubscribe",
+		ConfirmExpiry:  7 * 24 * time.Hour,
+		ResendInterval: 10 * time.Minute,
+	}
+
+	if v := os.Getenv("NEWSLETTER_CONFIRM_DAYS"); v != "" {
+		if days, err := strconv.Atoi(v); err == nil && days > 0 {
+			config.ConfirmExpiry = time.Duration(days) * 24 * time.Hour
+		} else {
+			log.Printf("[Newsletter] Ignoring invalid NEWSLETTER_CONFIRM_DAYS %q", v)
+		}
+	}
+
+	return config
+}
+
+// Service manages newsletter subscriptions
+type Service struct {
+	db     NewsletterDB
+	outbox *email.Outbox
+	mailer email.Mailer
+	signer *signedlink.Signer
+	clock  clock.Clock
+	config Config
+}
+
+// NewService creates a new instance of Service
+func NewService(db NewsletterDB, outbox *email.Outbox, mailer email.Mailer, signer *signedlink.Signer, clock clock.Clock, config Config) *Service {
+	return &Service{
+		db:     db,
+		outbox: outbox,
+		mailer: mailer,
+		signer: signer,
+		clock:  clock,
+		config: config,
+	}
+}
+
+// Subscribe records a subscription request and sends the confirmation email. Addresses that
+// are already subscribed or were sent a confirmation recently get no email, and callers
+// shouldn't reveal which case applied.
+func (s *Service) Subscribe(address string, ip string, locale string) error {
+	// Token subjects carry the request time in seconds, so it is stored at that precision
+	now := s.clock.Now().Truncate(time.Second)
+
+	subscription, err := s.db.RequestNewsletterSubscription(address, ip, now, now.Add(-s.config.ResendInterval))
+	if err != nil {
+		return fmt.Errorf("error recording subscription request: %w", err)
+	}
+	if subscription == nil {
+		return nil
+	}
+
+	subject := address + "|" + strconv.FormatInt(now.Unix(), 10)
+	token := s.signer.Sign(confirmPurpose, subject, now.Add(s.config.ConfirmExpiry))
+	msg, err := email.NewsletterConfirmationEmail(address, locale, s.config.ConfirmURL+"?token="+url.QueryEscape(token))
+	if err != nil {
+		return err
+	}
+	return s.outbox.Enqueue(msg, "newsletter-confirm:"+subject)
+}
+
+// Confirm subscribes the address of a confirmation token and records the consent
+func (s *Service) Confirm(token string, ip string) (string, error) {
+	now := s.clock.Now()
+	subject, err := s.signer.Verify(confirmPurpose, token, now)
+	if err != nil {
+		return "", err
+	}
+
+	sep := strings.LastIndex(subject, "|")
+	if sep < 0 {
+		return "", signedlink.ErrInvalid
+	}
+	address := subject[:sep]
+	requestedUnix, err := strconv.ParseInt(subject[sep+1:], 10, 64)
+	if err != nil {
+		return "", signedlink.ErrInvalid
+	}
+
+	confirmed, err := s.db.ConfirmNewsletterSubscription(address, time.Unix(requestedUnix, 0), ip, now)
+	if err != nil {
+		return "", fmt.Errorf("error confirming subscription: %w", err)
+	}
+	if !confirmed {
+		return "", ErrConfirmationUsed
+	}
+
+	log.Printf("[Newsletter] Confirmed subscription of %s", address)
+	if err := email.TrackNewsletterSubscription(s.mailer, address); err != nil {
+		log.Printf("[Newsletter] Error tracking subscription: %v", err)
+	}
+	return address, nil
+}
+
+// Unsubscribe unsubscribes the address of an unsubscribe token. Unsubscribing an address
+// that isn't subscribed succeeds, so links can be followed repeatedly.
+func (s *Service) Unsubscribe(token string) (string, error) {
+	now := s.clock.Now()
+	address, err := s.signer.Verify(unsubscribePurpose, token, now)
+	if err != nil {
+		return "", err
+	}
+
+	unsubscribed, err := s.db.UnsubscribeNewsletter(address, now)
+	if err != nil {
+		return "", fmt.Errorf("error unsubscribing: %w", err)
+	}
+	if unsubscribed {
+		log.Printf("[Newsletter] Unsubscribed %s", address)
+		if err := email.TrackNewsletterUnsubscribe(s.mailer, address); err != nil {
+			log.Printf("[Newsletter] Error tracking unsubscribe: %v", err)
+		}
+	}
+	return address, nil
+}
+
+// UnsubscribeURL returns the signed unsubscribe link of an address. It doesn't expire, as
+// old newsletters must keep working.
+func (s *Service) UnsubscribeURL(address string) string {
+	token := s.signer.Sign(unsubscribePurpose, address, time.Time{})
+	return s.config.UnsubscribeURL + "?token=" + url.QueryEscape(token)
+}
+
+// AddUnsubscribe adds the one-click List-Unsubscribe headers for the recipient to a
+// newsletter email
+func (s *Service) AddUnsubscribe(msg *email.Message) {
+	msg.SetListUnsubscribe(s.UnsubscribeURL(msg.To))
+}
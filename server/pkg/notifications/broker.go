@@ -0,0 +1,177 @@
+package notifications
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"saas-server/models"
+)
+
+// Broker names accepted by NOTIFICATION_BROKER
+const (
+	MemoryBrokerName   = "memory"
+	PostgresBrokerName = "postgres"
+)
+
+// postgresChannel is the LISTEN/NOTIFY channel new notification IDs are sent on
+const postgresChannel = "notifications"
+
+// subscriberBuffer is how many notifications a slow subscriber can fall behind before
+// further ones are dropped for it. They are stored either way and show up in the list.
+const subscriberBuffer = 16
+
+// Broker delivers new notifications to the streams of connected users
+type Broker interface {
+	// Publish hands a stored notification to the user's subscribers
+	Publish(n models.Notification) error
+	// Subscribe returns the user's new notifications and a function that stops them
+	Subscribe(userID string) (<-chan models.Notification, func())
+}
+
+// BrokerDB defines the database operations required by the Postgres broker
+type BrokerDB interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	GetNotification(id int64) (*models.Notification, error)
+}
+
+// NewBroker creates the named broker. The Postgres broker fans notifications out to every
+// server instance through LISTEN/NOTIFY on its own connection to dataSourceName.
+func NewBroker(name string, db BrokerDB, dataSourceName string) (Broker, error) {
+	switch name {
+	case "", MemoryBrokerName:
+		return NewMemoryBroker(), nil
+	case PostgresBrokerName:
+		return NewPostgresBroker(db, dataSourceName)
+	default:
+		return nil, fmt.Errorf("unknown notification broker %q", name)
+	}
+}
+
+// MemoryBroker delivers notifications to subscribers within this process, which is all
+// that's needed on a single node
+type MemoryBroker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan models.Notification]struct{}
+}
+
+// NewMemoryBroker creates a new MemoryBroker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{subscribers: make(map[string]map[chan models.Notification]struct{})}
+}
+
+// Publish sends the notification to each of the user's subscribers that has room for it
+func (b *MemoryBroker) Publish(n models.Notification) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[n.UserID] {
+		select {
+		case ch <- n:
+		default:
+			log.Printf("[Notifications] Subscriber of user %s is falling behind, dropped notification %d", n.UserID, n.ID)
+		}
+	}
+	return nil
+}
+
+// Subscribe registers a subscriber for the user's notifications
+func (b *MemoryBroker) Subscribe(userID string) (<-chan models.Notification, func()) {
+	ch := make(chan models.Notification, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan models.Notification]struct{})
+	}
+	b.subscribers[userID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers[userID], ch)
+			if len(b.subscribers[userID]) == 0 {
+				delete(b.subscribers, userID)
+			}
+			close(ch)
+		})
+	}
+	return ch, unsubscribe
+}
+
+// PostgresBroker announces new notifications with NOTIFY so every server instance can pass
+// them to the subscribers connected to it
+type PostgresBroker struct {
+	db       BrokerDB
+	listener *pq.Listener
+	local    *MemoryBroker
+}
+
+// NewPostgresBroker creates a PostgresBroker and starts listening for notifications
+func NewPostgresBroker(db BrokerDB, dataSourceName string) (*PostgresBroker, error) {
+	listener := pq.NewListener(dataSourceName, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("[Notifications] Listener connection error: %v", err)
+		}
+	})
+	if err := listener.Listen(postgresChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error listening for notifications: %v", err)
+	}
+
+	b := &PostgresBroker{
+		db:       db,
+		listener: listener,
+		local:    NewMemoryBroker(),
+	}
+	go b.listen()
+	return b, nil
+}
+
+// Publish sends the notification's ID to every instance. Only the ID is sent, as NOTIFY
+// payloads are limited in size.
+func (b *PostgresBroker) Publish(n models.Notification) error {
+	_, err := b.db.Exec(`SELECT pg_notify($1, $2)`, postgresChannel, strconv.FormatInt(n.ID, 10))
+	return err
+}
+
+// Subscribe registers a subscriber with this instance
+func (b *PostgresBroker) Subscribe(userID string) (<-chan models.Notification, func()) {
+	return b.local.Subscribe(userID)
+}
+
+// listen loads each announced notification and passes it to the local subscribers
+func (b *PostgresBroker) listen() {
+	for {
+		select {
+		case event := <-b.listener.Notify:
+			// A nil event means the connection was re-established; notifications sent in
+			// between are missed live but still listed
+			if event == nil {
+				continue
+			}
+			id, err := strconv.ParseInt(event.Extra, 10, 64)
+			if err != nil {
+				log.Printf("[Notifications] Ignoring invalid notification payload %q", event.Extra)
+				continue
+			}
+			n, err := b.db.GetNotification(id)
+			if err != nil {
+				log.Printf("[Notifications] Error loading notification %d: %v", id, err)
+				continue
+			}
+			b.local.Publish(*n)
+
+		case <-time.After(90 * time.Second):
+			// Check the connection when it has been quiet for a while
+			go b.listener.Ping()
+		}
+	}
+}
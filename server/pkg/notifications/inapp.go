@@ -0,0 +1,84 @@
+package notifications
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"saas-server/models"
+	"saas-server/pkg/email"
+	"saas-server/pkg/validation"
+)
+
+// Publish stores an in-app notification for a user and pushes it to the user's open
+// streams. Notifications of categories the user turned off in the app are skipped, and
+// n.ID stays 0.
+func (s *Service) Publish(n *models.Notification) error {
+	n.Title = validation.SanitizeInput(n.Title, 255)
+	n.Body = strings.TrimSpace(n.Body)
+	n.Link = strings.TrimSpace(n.Link)
+
+	switch {
+	case n.UserID == "":
+		return fmt.Errorf("%w: user is required", ErrInvalidNotification)
+	case !isCategory(n.Category):
+		return fmt.Errorf("%w: unknown category %q", ErrInvalidNotification, n.Category)
+	case n.Type == "" || len(n.Type) > 50:
+		return fmt.Errorf("%w: type is required and at most 50 characters", ErrInvalidNotification)
+	case n.Title == "":
+		return fmt.Errorf("%w: title is required", ErrInvalidNotification)
+	}
+
+	if email.Optional(n.Category) {
+		enabled, err := s.db.IsInAppNotificationEnabled(n.UserID, n.Category)
+		if err != nil {
+			return fmt.Errorf("error checking notification preferences: %w", err)
+		}
+		if !enabled {
+			return nil
+		}
+	}
+
+	if err := s.db.CreateNotification(n); err != nil {
+		return fmt.Errorf("error storing notification: %w", err)
+	}
+
+	// The notification is stored, so a user who misses it live still sees it in the list
+	if err := s.broker.Publish(*n); err != nil {
+		log.Printf("[Notifications] Error pushing notification %d: %v", n.ID, err)
+	}
+	return nil
+}
+
+// List returns a page of a user's notifications, newest first, and the total
+func (s *Service) List(userID string, unreadOnly bool, page int, limit int) ([]models.Notification, int, error) {
+	return s.db.GetNotifications(userID, unreadOnly, page, limit)
+}
+
+// UnreadCount returns how many of a user's notifications are unread
+func (s *Service) UnreadCount(userID string) (int, error) {
+	return s.db.CountUnreadNotifications(userID)
+}
+
+// MarkRead marks one of a user's notifications as read
+func (s *Service) MarkRead(userID string, id int64) error {
+	found, err := s.db.MarkNotificationRead(userID, id, s.clock.Now())
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MarkAllRead marks all of a user's notifications as read and returns how many were unread
+func (s *Service) MarkAllRead(userID string) (int64, error) {
+	return s.db.MarkAllNotificationsRead(userID, s.clock.Now())
+}
+
+// Subscribe returns the user's new notifications as they are published, and a function
+// to call when the stream closes
+func (s *Service) Subscribe(userID string) (<-chan models.Notification, func()) {
+	return s.broker.Subscribe(userID)
+}
@@ -0,0 +1,180 @@
+// Package notifications manages which notifications users get on each channel and delivers
+// in-app notifications. Users turn categories on or off per channel in their settings or
+// with the signed unsubscribe link in every email they can opt out of; security
+// notifications are always sent. In-app notifications are stored and pushed live to the
+// user's open streams through a Broker.
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/clock"
+	"saas-server/pkg/email"
+	"saas-server/pkg/signedlink"
+)
+
+// unsubscribePurpose is the purpose of signed unsubscribe links
+const unsubscribePurpose = "notification-unsubscribe"
+
+// ErrInvalidPreference wraps the reason a preference update was rejected
+var ErrInvalidPreference = errors.New("invalid notification preference")
+
+// ErrInvalidNotification wraps the reason a notification couldn't be published
+var ErrInvalidNotification = errors.New("invalid notification")
+
+// ErrNotFound is returned when the user has no notification with the ID
+var ErrNotFound = errors.New("notification not found")
+
+// NotificationDB defines the database operations required by the notification service
+type NotificationDB interface {
+	GetNotificationPreferences(userID string) ([]models.NotificationPreference, error)
+	SaveNotificationPreferences(userID string, preferences []models.NotificationPreference) error
+	DisableEmailNotifications(email string, category string) (bool, error)
+	IsInAppNotificationEnabled(userID string, category string) (bool, error)
+	CreateNotification(n *models.Notification) error
+	GetNotifications(userID string, unreadOnly bool, page int, limit int) ([]models.Notification, int, error)
+	CountUnreadNotifications(userID string) (int, error)
+	MarkNotificationRead(userID string, id int64, readAt time.Time) (bool, error)
+	MarkAllNotificationsRead(userID string, readAt time.Time) (int64, error)
+}
+
+// Config controls the unsubscribe links and how in-app notifications reach their streams
+type Config struct {
+	// UnsubscribeURL is the public API endpoint for one-click unsubscribes
+	UnsubscribeURL string
+	// Broker names the Broker passing new notifications to streams, memory or postgres
+	Broker string
+}
+
+// LoadConfig reads the notification configuration from the environment.
+// Unsubscribe links point to API_URL/api/notifications/unsubscribe. NOTIFICATION_BROKER
+// is memory (default) for a single node or postgres to fan out with LISTEN/NOTIFY.
+func LoadConfig() Config {
+	config := Config{
+		UnsubscribeURL: strings.TrimRight(os.Getenv("API_URL"), "/") + "/api/notifications/unsubscribe",
+		Broker:         MemoryBrokerName,
+	}
+
+	switch v := os.Getenv("NOTIFICATION_BROKER"); v {
+	case "":
+	case MemoryBrokerName, PostgresBrokerName:
+		config.Broker = v
+	default:
+		log.Printf("[Notifications] Ignoring invalid NOTIFICATION_BROKER %q", v)
+	}
+
+	return config
+}
+
+// Service manages notification preferences and in-app notifications
+type Service struct {
+	db     NotificationDB
+	broker Broker
+	signer *signedlink.Signer
+	clock  clock.Clock
+	config Config
+}
+
+// NewService creates a new instance of Service
+func NewService(db NotificationDB, broker Broker, signer *signedlink.Signer, clock clock.Clock, config Config) *Service {
+	return &Service{
+		db:     db,
+		broker: broker,
+		signer: signer,
+		clock:  clock,
+		config: config,
+	}
+}
+
+// Preferences returns a user's preferences for every category, with defaults for the
+// categories the user never changed
+func (s *Service) Preferences(userID string) ([]models.NotificationPreference, error) {
+	saved, err := s.db.GetNotificationPreferences(userID)
+	if err != nil {
+		return nil, err
+	}
+	byCategory := make(map[string]models.NotificationPreference, len(saved))
+	for _, p := range saved {
+		byCategory[p.Category] = p
+	}
+
+	preferences := make([]models.NotificationPreference, 0, len(models.NotificationCategories))
+	for _, category := range models.NotificationCategories {
+		p, ok := byCategory[category]
+		if !ok || !email.Optional(category) {
+			p = models.NotificationPreference{Category: category, Email: true, InApp: true}
+		}
+		p.Locked = !email.Optional(category)
+		preferences = append(preferences, p)
+	}
+	return preferences, nil
+}
+
+// UpdatePreferences saves the given categories of a user's preferences and returns all of
+// them. Security notifications can't be turned off.
+func (s *Service) UpdatePreferences(userID string, preferences []models.NotificationPreference) ([]models.NotificationPreference, error) {
+	seen := make(map[string]bool, len(preferences))
+	for _, p := range preferences {
+		if !isCategory(p.Category) {
+			return nil, fmt.Errorf("%w: unknown category %q", ErrInvalidPreference, p.Category)
+		}
+		if seen[p.Category] {
+			return nil, fmt.Errorf("%w: category %s is listed twice", ErrInvalidPreference, p.Category)
+		}
+		seen[p.Category] = true
+		if !email.Optional(p.Category) && (!p.Email || !p.InApp) {
+			return nil, fmt.Errorf("%w: %s notifications can't be turned off", ErrInvalidPreference, p.Category)
+		}
+	}
+
+	if err := s.db.SaveNotificationPreferences(userID, preferences); err != nil {
+		return nil, err
+	}
+	return s.Preferences(userID)
+}
+
+// UnsubscribeURL returns the signed link that turns off emails of a category for an
+// address. It doesn't expire, as old emails must keep working.
+func (s *Service) UnsubscribeURL(address string, category string) string {
+	token := s.signer.Sign(unsubscribePurpose, category+"|"+address, time.Time{})
+	return s.config.UnsubscribeURL + "?token=" + url.QueryEscape(token)
+}
+
+// Unsubscribe turns off the emails of an unsubscribe token's category and returns the
+// address and category. Following a link repeatedly succeeds.
+func (s *Service) Unsubscribe(token string) (string, string, error) {
+	subject, err := s.signer.Verify(unsubscribePurpose, token, s.clock.Now())
+	if err != nil {
+		return "", "", err
+	}
+	category, address, ok := strings.Cut(subject, "|")
+	if !ok || !email.Optional(category) || !isCategory(category) {
+		return "", "", signedlink.ErrInvalid
+	}
+
+	found, err := s.db.DisableEmailNotifications(address, category)
+	if err != nil {
+		return "", "", fmt.Errorf("error disabling notifications: %w", err)
+	}
+	if found {
+		log.Printf("[Notifications] %s unsubscribed from %s emails", address, category)
+	}
+	return address, category, nil
+}
+
+// isCategory reports whether a category exists
+func isCategory(category string) bool {
+	for _, c := range models.NotificationCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
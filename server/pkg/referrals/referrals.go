@@ -0,0 +1,304 @@
+// Package referrals runs the user-to-user referral program: referral codes, attribution of new
+// users to the code they signed up with and rewards once a referred user first pays
+package referrals
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/clock"
+	"saas-server/pkg/lemonsqueezy"
+)
+
+// codeAlphabet leaves out characters that are easy to confuse, like 0/O and 1/I
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// ReferralDB defines the database operations required by the referral service
+type ReferralDB interface {
+	GetReferralCode(userID string) (string, error)
+	CreateReferralCode(userID string, code string) (string, error)
+	GetReferrerByCode(code string) (string, error)
+	CreateReferral(referrerID string, referredUserID string, code string, firstTouchAt time.Time, signedUpAt time.Time) error
+	QualifyReferral(referredUserID string, orderID int, qualifiedAt time.Time) (*models.Referral, error)
+	GetQualifiedReferrals(qualifiedBefore time.Time) ([]models.Referral, error)
+	MarkReferralRewarded(referralID int, rewardType string, rewardAmount int, rewardCode string, rewardedAt time.Time) error
+	GetReferralsByReferrer(referrerID string) ([]models.Referral, error)
+}
+
+// DiscountIssuer creates the discount codes given as rewards.
+// Implemented by discounts.Service
+type DiscountIssuer interface {
+	Create(input lemonsqueezy.DiscountInput) (*models.Discount, error)
+}
+
+// CreditGranter adds credits to a user's balance
+type CreditGranter interface {
+	GrantReferralCredit(userID string, amount int, referralID int) error
+}
+
+// Config controls referral attribution and rewards
+type Config struct {
+	// RewardType is "discount" for a single-use discount code or "credit" for account credits
+	RewardType string
+	// RewardAmount is a percentage or minor currency units for discounts, or a number of credits
+	RewardAmount int
+	// RewardAmountType is "percent" or "fixed" for discount rewards
+	RewardAmountType string
+	// AttributionWindow is how long after opening a referral link a signup is still attributed
+	AttributionWindow time.Duration
+	// LinkBase is the URL referral links are built on, e.g. "https://api.example.com/r/"
+	LinkBase string
+}
+
+// LoadConfig reads the referral configuration from the environment.
+// REFERRAL_REWARD_TYPE is "discount" (default) or "credit", REFERRAL_REWARD_AMOUNT the reward
+// (default 20), REFERRAL_REWARD_AMOUNT_TYPE "percent" (default) or "fixed" and
+// REFERRAL_WINDOW_DAYS how long a referral link is remembered (default 30).
+func LoadConfig() Config {
+	config := Config{
+		RewardType:        "discount",
+		RewardAmount:      20,
+		RewardAmountType:  "percent",
+		AttributionWindow: 30 * 24 * time.Hour,
+		LinkBase:          strings.TrimRight(os.Getenv("API_URL"), "/") + "/r/",
+	}
+
+	if v := os.Getenv("REFERRAL_REWARD_TYPE"); v != "" {
+		if v == "discount" || v == "credit" {
+			config.RewardType = v
+		} else {
+			log.Printf("[Referrals] Ignoring invalid REFERRAL_REWARD_TYPE %q", v)
+		}
+	}
+
+	if v := os.Getenv("REFERRAL_REWARD_AMOUNT"); v != "" {
+		if amount, err := strconv.Atoi(v); err == nil && amount > 0 {
+			config.RewardAmount = amount
+		} else {
+			log.Printf("[Referrals] Ignoring invalid REFERRAL_REWARD_AMOUNT %q", v)
+		}
+	}
+
+	if v := os.Getenv("REFERRAL_REWARD_AMOUNT_TYPE"); v != "" {
+		if v == "percent" || v == "fixed" {
+			config.RewardAmountType = v
+		} else {
+			log.Printf("[Referrals] Ignoring invalid REFERRAL_REWARD_AMOUNT_TYPE %q", v)
+		}
+	}
+
+	if v := os.Getenv("REFERRAL_WINDOW_DAYS"); v != "" {
+		if days, err := strconv.Atoi(v); err == nil && days > 0 {
+			config.AttributionWindow = time.Duration(days) * 24 * time.Hour
+		} else {
+			log.Printf("[Referrals] Ignoring invalid REFERRAL_WINDOW_DAYS %q", v)
+		}
+	}
+
+	return config
+}
+
+// Service runs the referral program
+type Service struct {
+	db        ReferralDB
+	discounts DiscountIssuer
+	credits   CreditGranter
+	clock     clock.Clock
+	config    Config
+}
+
+// NewService creates a new instance of Service. credits may be nil when the reward type is "discount".
+func NewService(db ReferralDB, discounts DiscountIssuer, credits CreditGranter, clock clock.Clock, config Config) *Service {
+	return &Service{
+		db:        db,
+		discounts: discounts,
+		credits:   credits,
+		clock:     clock,
+		config:    config,
+	}
+}
+
+// AttributionWindow returns how long a referral link is remembered
+func (s *Service) AttributionWindow() time.Duration {
+	return s.config.AttributionWindow
+}
+
+// Link returns the shareable referral link for a code
+func (s *Service) Link(code string) string {
+	return s.config.LinkBase + code
+}
+
+// StartRewardJob starts the background job that retries rewards that failed when the order came in
+func (s *Service) StartRewardJob(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			if err := s.RewardQualified(); err != nil {
+				log.Printf("Error rewarding referrals: %v", err)
+			}
+		}
+	}()
+}
+
+// CodeFor returns the user's referral code, creating one on first use
+func (s *Service) CodeFor(userID string) (string, error) {
+	code, err := s.db.GetReferralCode(userID)
+	if err != sql.ErrNoRows {
+		return code, err
+	}
+
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err := randomCode(8)
+		if err != nil {
+			return "", err
+		}
+		code, err = s.db.CreateReferralCode(userID, code)
+		if err == sql.ErrNoRows {
+			// Code taken by another user, try another one
+			continue
+		}
+		return code, err
+	}
+	return "", fmt.Errorf("could not generate a unique referral code")
+}
+
+// AttributeSignup records that a new user signed up after opening a referral link at firstTouchAt.
+// Unknown codes, self-referrals and links opened outside the attribution window are ignored.
+func (s *Service) AttributeSignup(userID string, code string, firstTouchAt time.Time) {
+	now := s.clock.Now()
+	if now.Sub(firstTouchAt) > s.config.AttributionWindow {
+		return
+	}
+
+	referrerID, err := s.db.GetReferrerByCode(code)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("[Referrals] Error looking up referral code %s: %v", code, err)
+		return
+	}
+	if referrerID == userID {
+		return
+	}
+
+	if err := s.db.CreateReferral(referrerID, userID, strings.ToUpper(code), firstTouchAt, now); err != nil {
+		log.Printf("[Referrals] Error recording referral of user %s: %v", userID, err)
+		return
+	}
+	log.Printf("[Referrals] User %s signed up with referral code %s", userID, code)
+}
+
+// OrderPaid rewards the referrer the first time a referred user pays for an order.
+// Orders of users who weren't referred, and later orders, are ignored.
+func (s *Service) OrderPaid(userID string, orderID int) error {
+	referral, err := s.db.QualifyReferral(userID, orderID, s.clock.Now())
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[Referrals] Referral %d qualified with order %d", referral.ID, orderID)
+	return s.reward(*referral)
+}
+
+// RewardQualified gives the rewards of referrals that qualified but weren't rewarded,
+// e.g. because the provider was unavailable. Recently qualified referrals are skipped
+// since OrderPaid may still be rewarding them.
+func (s *Service) RewardQualified() error {
+	referrals, err := s.db.GetQualifiedReferrals(s.clock.Now().Add(-10 * time.Minute))
+	if err != nil {
+		return err
+	}
+
+	for _, referral := range referrals {
+		if err := s.reward(referral); err != nil {
+			log.Printf("[Referrals] Error rewarding referral %d: %v", referral.ID, err)
+		}
+	}
+	return nil
+}
+
+// Referrals returns the referrals made by a user with the referred users' emails masked
+func (s *Service) Referrals(userID string) ([]models.Referral, error) {
+	referrals, err := s.db.GetReferralsByReferrer(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range referrals {
+		referrals[i].ReferredEmail = maskEmail(referrals[i].ReferredEmail)
+	}
+	return referrals, nil
+}
+
+// reward gives the referrer of a qualified referral their reward
+func (s *Service) reward(referral models.Referral) error {
+	rewardCode := ""
+	switch s.config.RewardType {
+	case "credit":
+		if s.credits == nil {
+			return fmt.Errorf("credit rewards are not available")
+		}
+		if err := s.credits.GrantReferralCredit(referral.ReferrerID, s.config.RewardAmount, referral.ID); err != nil {
+			return err
+		}
+
+	default:
+		code, err := randomCode(10)
+		if err != nil {
+			return err
+		}
+		discount, err := s.discounts.Create(lemonsqueezy.DiscountInput{
+			Name:           fmt.Sprintf("Referral reward #%d", referral.ID),
+			Code:           "REF" + code,
+			Amount:         s.config.RewardAmount,
+			AmountType:     s.config.RewardAmountType,
+			Duration:       "once",
+			MaxRedemptions: 1,
+		})
+		if err != nil {
+			return err
+		}
+		rewardCode = discount.Code
+	}
+
+	if err := s.db.MarkReferralRewarded(referral.ID, s.config.RewardType, s.config.RewardAmount, rewardCode, s.clock.Now()); err != nil {
+		return err
+	}
+
+	log.Printf("[Referrals] Rewarded user %s for referral %d", referral.ReferrerID, referral.ID)
+	return nil
+}
+
+// randomCode returns a random code of n characters from codeAlphabet
+func randomCode(n int) (string, error) {
+	b := make([]byte, n)
+	max := big.NewInt(int64(len(codeAlphabet)))
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = codeAlphabet[idx.Int64()]
+	}
+	return string(b), nil
+}
+
+// maskEmail hides most of the local part of an email address, e.g. "j***@example.com"
+func maskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return ""
+	}
+	return email[:1] + "***" + email[at:]
+}
@@ -0,0 +1,79 @@
+package revenue
+
+import (
+	"saas-server/models"
+	"saas-server/pkg/money"
+)
+
+// Summarize aggregates daily snapshots, oldest first, into metrics for the whole range.
+// The first snapshot is the baseline; movements are summed over the days after it.
+func Summarize(snapshots []models.RevenueSnapshot, trialsEnded int, trialsConverted int, currency string) models.RevenueSummary {
+	summary := models.RevenueSummary{
+		TrialsEnded:     trialsEnded,
+		TrialsConverted: trialsConverted,
+	}
+	if trialsEnded > 0 {
+		summary.TrialConversionRate = float64(trialsConverted) / float64(trialsEnded)
+	}
+
+	if len(snapshots) == 0 {
+		summary.MRRFormatted = money.Format(0, currency)
+		summary.ARPUFormatted = money.Format(0, currency)
+		return summary
+	}
+
+	first := snapshots[0]
+	last := snapshots[len(snapshots)-1]
+	summary.StartingMRR = first.MRR
+	summary.StartingCustomers = first.ActiveCustomers
+	summary.EndingMRR = last.MRR
+	summary.EndingARR = last.ARR
+	summary.EndingCustomers = last.ActiveCustomers
+
+	for _, s := range snapshots[1:] {
+		summary.NewMRR += s.NewMRR
+		summary.ExpansionMRR += s.ExpansionMRR
+		summary.ContractionMRR += s.ContractionMRR
+		summary.ChurnedMRR += s.ChurnedMRR
+		summary.NewCustomers += s.NewCustomers
+		summary.ChurnedCustomers += s.ChurnedCustomers
+	}
+	summary.NetNewMRR = summary.NewMRR + summary.ExpansionMRR - summary.ContractionMRR - summary.ChurnedMRR
+
+	if summary.StartingCustomers > 0 {
+		summary.LogoChurnRate = float64(summary.ChurnedCustomers) / float64(summary.StartingCustomers)
+	}
+	if summary.StartingMRR > 0 {
+		lost := summary.ChurnedMRR + summary.ContractionMRR
+		summary.RevenueChurnRate = float64(lost) / float64(summary.StartingMRR)
+		summary.NetRevenueChurn = float64(lost-summary.ExpansionMRR) / float64(summary.StartingMRR)
+	}
+	if summary.EndingCustomers > 0 {
+		summary.ARPU = summary.EndingMRR / summary.EndingCustomers
+	}
+
+	summary.MRRFormatted = money.Format(summary.EndingMRR, currency)
+	summary.ARPUFormatted = money.Format(summary.ARPU, currency)
+	return summary
+}
+
+// ComputeLTV fills in the lifetime value and cumulative per-customer revenue of each cohort
+func ComputeLTV(cohorts []models.CohortLTV, currency string) []models.CohortLTV {
+	for i := range cohorts {
+		c := &cohorts[i]
+		if c.Customers == 0 {
+			continue
+		}
+
+		c.LTV = c.Revenue / c.Customers
+		c.LTVFormatted = money.Format(c.LTV, currency)
+
+		c.CumulativeLTV = make([]int, len(c.MonthlyRevenue))
+		running := 0
+		for month, revenue := range c.MonthlyRevenue {
+			running += revenue
+			c.CumulativeLTV[month] = running / c.Customers
+		}
+	}
+	return cohorts
+}
@@ -0,0 +1,148 @@
+// Package revenue materialises daily revenue snapshots and derives subscription metrics from them
+package revenue
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/lemonsqueezy"
+)
+
+// SnapshotDB defines the database operations required to take revenue snapshots
+type SnapshotDB interface {
+	GetAllSubscriptions() ([]models.Subscription, error)
+	GetLatestInvoiceSubtotals(currency string) (map[int]int, error)
+	SaveRevenueSnapshot(date time.Time, currency string, subscriptions []models.SubscriptionMRR) (*models.RevenueSnapshot, error)
+}
+
+// PriceSource looks up the price and billing interval of a plan variant
+type PriceSource interface {
+	GetVariant(variantID string) (*lemonsqueezy.SingleVariantResponse, error)
+}
+
+// SnapshotService periodically records the MRR of every subscription
+type SnapshotService struct {
+	db       SnapshotDB
+	prices   PriceSource
+	currency string
+}
+
+// NewSnapshotService creates a new instance of SnapshotService.
+// Amounts are reported in currency, which should match the store's currency.
+func NewSnapshotService(db SnapshotDB, prices PriceSource, currency string) *SnapshotService {
+	return &SnapshotService{
+		db:       db,
+		prices:   prices,
+		currency: currency,
+	}
+}
+
+// StartSnapshotJob takes a snapshot immediately and then every interval.
+// Each run replaces the current day's snapshot, so the last run of a day is the one kept.
+func (s *SnapshotService) StartSnapshotJob(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		if err := s.TakeSnapshot(time.Now().UTC()); err != nil {
+			log.Printf("Error taking revenue snapshot: %v", err)
+		}
+		for range ticker.C {
+			if err := s.TakeSnapshot(time.Now().UTC()); err != nil {
+				log.Printf("Error taking revenue snapshot: %v", err)
+			}
+		}
+	}()
+}
+
+// TakeSnapshot computes the MRR of every subscription as of now and stores it as that day's snapshot
+func (s *SnapshotService) TakeSnapshot(now time.Time) error {
+	subscriptions, err := s.db.GetAllSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	invoiceSubtotals, err := s.db.GetLatestInvoiceSubtotals(s.currency)
+	if err != nil {
+		return err
+	}
+
+	variants := make(map[int]*lemonsqueezy.VariantAttributes)
+	var rows []models.SubscriptionMRR
+	for _, sub := range subscriptions {
+		subscriptionID, err := strconv.Atoi(sub.SubscriptionID)
+		if err != nil {
+			continue
+		}
+
+		row := models.SubscriptionMRR{
+			SubscriptionID: subscriptionID,
+			UserID:         sub.UserID,
+			VariantID:      sub.VariantID,
+			Status:         sub.Status,
+		}
+
+		if isRevenueGenerating(sub, now) {
+			variant, ok := variants[sub.VariantID]
+			if !ok {
+				resp, err := s.prices.GetVariant(strconv.Itoa(sub.VariantID))
+				if err != nil {
+					// Skip the whole run rather than record a misleading drop in MRR
+					return fmt.Errorf("failed to fetch variant %d: %w", sub.VariantID, err)
+				}
+				variant = &resp.Data.Attributes
+				variants[sub.VariantID] = variant
+			}
+
+			// Usage-based plans are priced per unit, so use what was actually billed last period
+			price := variant.Price
+			if sub.IsUsageBased {
+				price = invoiceSubtotals[subscriptionID]
+			}
+			row.MRR = MonthlyAmount(price, variant.Interval, variant.IntervalCount)
+		}
+
+		rows = append(rows, row)
+	}
+
+	snapshot, err := s.db.SaveRevenueSnapshot(now, s.currency, rows)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Recorded revenue snapshot for %s: MRR %d %s across %d customers",
+		snapshot.Date.Format("2006-01-02"), snapshot.MRR, snapshot.Currency, snapshot.ActiveCustomers)
+	return nil
+}
+
+// isRevenueGenerating reports whether a subscription is being paid for.
+// Cancelled subscriptions keep contributing until the end of the period they paid for.
+func isRevenueGenerating(sub models.Subscription, now time.Time) bool {
+	switch sub.Status {
+	case "active", "past_due":
+		return true
+	case "cancelled":
+		return sub.EndsAt != nil && sub.EndsAt.After(now)
+	default:
+		return false
+	}
+}
+
+// MonthlyAmount normalises a price charged every intervalCount intervals to a monthly amount
+func MonthlyAmount(price int, interval string, intervalCount int) int {
+	if intervalCount < 1 {
+		intervalCount = 1
+	}
+
+	switch interval {
+	case "day":
+		return price * 365 / 12 / intervalCount
+	case "week":
+		return price * 52 / 12 / intervalCount
+	case "year":
+		return price / 12 / intervalCount
+	default:
+		return price / intervalCount
+	}
+}
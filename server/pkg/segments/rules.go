@@ -0,0 +1,408 @@
+package segments
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sources segments can select from
+const (
+	SourceUsers       = "users"
+	SourceNewsletter  = "newsletter"
+	SourceEarlyAccess = "early_access"
+)
+
+// Limits that keep segment queries reasonably sized
+const (
+	maxDepth  = 4
+	maxRules  = 50
+	maxValues = 100
+)
+
+// Definition is a segment's rule tree. Rules are combined with AND when Match is "all"
+// (the default) and with OR when it is "any"; a definition without rules selects the
+// whole source.
+//
+//	{"source": "users", "match": "all", "rules": [
+//		{"field": "subscription_status", "op": "in", "value": ["active", "on_trial"]},
+//		{"match": "any", "rules": [
+//			{"field": "last_seen", "op": "within_days", "value": 14},
+//			{"field": "referred", "op": "eq", "value": true}
+//		]}
+//	]}
+type Definition struct {
+	Source string `json:"source"`
+	Match  string `json:"match,omitempty"`
+	Rules  []Rule `json:"rules"`
+}
+
+// Rule is either a condition on a field or, when Rules is set, a nested group
+type Rule struct {
+	Field string          `json:"field,omitempty"`
+	Op    string          `json:"op,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Match string          `json:"match,omitempty"`
+	Rules []Rule          `json:"rules,omitempty"`
+}
+
+// Query is a compiled segment. Where only references the source's alias and positional
+// parameters, so callers can embed it in larger queries.
+type Query struct {
+	From    string // Source table with its alias
+	Email   string // Expression of the member's lowercased email address
+	Columns string // User ID, email, name and signup date of a member, in that order
+	Where   string
+	Args    []interface{}
+}
+
+// kind is the type of a field, which decides the operators it supports
+type kind int
+
+const (
+	kindString kind = iota
+	kindNumber
+	kindBool
+	kindTime
+)
+
+// field maps a segment field to its SQL expression. String expressions never return NULL,
+// so unset values compare as the empty string.
+type field struct {
+	expr string
+	kind kind
+}
+
+// source describes a table segments can select from
+type source struct {
+	from    string
+	email   string
+	columns string
+	fields  map[string]field
+}
+
+// sources lists the fields of each source. Field expressions are fixed SQL; only values
+// are passed as parameters.
+var sources = map[string]source{
+	SourceUsers: {
+		from:    "users u",
+		email:   "lower(u.email)",
+		columns: "u.id::text, u.email, u.name, u.created_at",
+		fields: map[string]field{
+			"email":               {"u.email", kindString},
+			"name":                {"u.name", kindString},
+			"language":            {"COALESCE(u.language, '')", kindString},
+			"email_verified":      {"COALESCE(u.email_verified, false)", kindBool},
+			"subscription_status": {"COALESCE(u.latest_status, '')", kindString},
+			"product_id":          {"u.latest_product_id", kindNumber},
+			"variant_id":          {"u.latest_variant_id", kindNumber},
+			"signup_date":         {"u.created_at", kindTime},
+			"last_seen": {`GREATEST(
+				(SELECT MAX(pv.created_at) FROM page_views pv WHERE pv.user_id = u.id),
+				(SELECT MAX(COALESCE(rt.last_used_at, rt.created_at)) FROM refresh_tokens rt WHERE rt.user_id = u.id))`, kindTime},
+			"referrer": {`COALESCE((
+				SELECT pv.referrer FROM page_views pv
+				WHERE pv.user_id = u.id AND pv.referrer <> ''
+				ORDER BY pv.created_at LIMIT 1), '')`, kindString},
+			"referred":   {"EXISTS (SELECT 1 FROM referrals rf WHERE rf.referred_user_id = u.id)", kindBool},
+			"newsletter": {"EXISTS (SELECT 1 FROM newsletter_subscriptions ns WHERE lower(ns.email) = lower(u.email) AND ns.subscribed)", kindBool},
+		},
+	},
+	SourceNewsletter: {
+		from:    "newsletter_subscriptions n",
+		email:   "lower(n.email)",
+		columns: "COALESCE((SELECT u.id::text FROM users u WHERE lower(u.email) = lower(n.email)), ''), n.email, '', n.created_at",
+		fields: map[string]field{
+			"email":           {"n.email", kindString},
+			"subscribed":      {"n.subscribed", kindBool},
+			"signup_date":     {"n.created_at", kindTime},
+			"confirmed_at":    {"n.confirmed_at", kindTime},
+			"unsubscribed_at": {"n.unsubscribed_at", kindTime},
+			"is_user":         {"EXISTS (SELECT 1 FROM users u WHERE lower(u.email) = lower(n.email))", kindBool},
+		},
+	},
+	SourceEarlyAccess: {
+		from:    "early_access e",
+		email:   "lower(e.email)",
+		columns: "COALESCE((SELECT u.id::text FROM users u WHERE lower(u.email) = lower(e.email)), ''), e.email, '', e.created_at",
+		fields: map[string]field{
+			"email":       {"e.email", kindString},
+			"referrer":    {"COALESCE(e.referrer, '')", kindString},
+			"signup_date": {"e.created_at", kindTime},
+			"is_user":     {"EXISTS (SELECT 1 FROM users u WHERE lower(u.email) = lower(e.email))", kindBool},
+		},
+	},
+}
+
+// operators lists the operators each kind of field supports
+var operators = map[kind][]string{
+	kindString: {"eq", "neq", "in", "not_in", "contains", "not_contains", "starts_with", "is_set", "is_not_set"},
+	kindNumber: {"eq", "neq", "in", "not_in", "gt", "gte", "lt", "lte", "is_set", "is_not_set"},
+	kindBool:   {"eq", "neq"},
+	kindTime:   {"before", "after", "within_days", "older_than_days", "is_set", "is_not_set"},
+}
+
+// FieldInfo describes a field for building segments in the admin UI
+type FieldInfo struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Operators []string `json:"operators"`
+}
+
+// Fields lists the fields of each source
+func Fields() map[string][]FieldInfo {
+	kindNames := map[kind]string{kindString: "string", kindNumber: "number", kindBool: "bool", kindTime: "time"}
+	result := make(map[string][]FieldInfo, len(sources))
+	for name, src := range sources {
+		fields := make([]FieldInfo, 0, len(src.fields))
+		for fieldName, f := range src.fields {
+			fields = append(fields, FieldInfo{Name: fieldName, Type: kindNames[f.kind], Operators: operators[f.kind]})
+		}
+		sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+		result[name] = fields
+	}
+	return result
+}
+
+// Parse decodes a definition and checks that it compiles
+func Parse(data []byte) (Definition, error) {
+	var def Definition
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&def); err != nil {
+		return Definition{}, fmt.Errorf("%w: %v", ErrInvalidSegment, err)
+	}
+	if _, err := Compile(def, time.Now(), 0); err != nil {
+		return Definition{}, err
+	}
+	return def, nil
+}
+
+// Compile turns a definition into a SQL condition. Parameters are numbered from
+// argOffset+1, so the condition can follow argOffset parameters of the surrounding query.
+// Relative date rules such as within_days are resolved against now.
+func Compile(def Definition, now time.Time, argOffset int) (*Query, error) {
+	src, ok := sources[def.Source]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown source %q", ErrInvalidSegment, def.Source)
+	}
+
+	c := &compiler{source: src, now: now, argOffset: argOffset}
+	where, err := c.group(def.Match, def.Rules, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Query{
+		From:    src.from,
+		Email:   src.email,
+		Columns: src.columns,
+		Where:   where,
+		Args:    c.args,
+	}, nil
+}
+
+// compiler accumulates the parameters of a definition while it is compiled
+type compiler struct {
+	source    source
+	now       time.Time
+	argOffset int
+	args      []interface{}
+	rules     int
+}
+
+// param adds a parameter and returns its placeholder
+func (c *compiler) param(value interface{}) string {
+	c.args = append(c.args, value)
+	return "$" + strconv.Itoa(c.argOffset+len(c.args))
+}
+
+// group compiles a list of rules joined by their match mode
+func (c *compiler) group(match string, rules []Rule, depth int) (string, error) {
+	if depth > maxDepth {
+		return "", fmt.Errorf("%w: rules are nested more than %d levels deep", ErrInvalidSegment, maxDepth)
+	}
+
+	joiner := " AND "
+	switch match {
+	case "", "all":
+	case "any":
+		joiner = " OR "
+	default:
+		return "", fmt.Errorf("%w: match must be all or any, not %q", ErrInvalidSegment, match)
+	}
+
+	if len(rules) == 0 {
+		return "TRUE", nil
+	}
+
+	conditions := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		c.rules++
+		if c.rules > maxRules {
+			return "", fmt.Errorf("%w: segments can have at most %d rules", ErrInvalidSegment, maxRules)
+		}
+
+		var condition string
+		var err error
+		if rule.Rules != nil {
+			if rule.Field != "" || rule.Op != "" || rule.Value != nil {
+				return "", fmt.Errorf("%w: a group can't also be a condition", ErrInvalidSegment)
+			}
+			condition, err = c.group(rule.Match, rule.Rules, depth+1)
+		} else {
+			condition, err = c.condition(rule)
+		}
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, "("+condition+")")
+	}
+	return strings.Join(conditions, joiner), nil
+}
+
+// condition compiles a single field condition
+func (c *compiler) condition(rule Rule) (string, error) {
+	f, ok := c.source.fields[rule.Field]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown field %q", ErrInvalidSegment, rule.Field)
+	}
+	if !supports(f.kind, rule.Op) {
+		return "", fmt.Errorf("%w: field %s doesn't support the %q operator", ErrInvalidSegment, rule.Field, rule.Op)
+	}
+
+	switch rule.Op {
+	case "is_set", "is_not_set":
+		if rule.Value != nil {
+			return "", fmt.Errorf("%w: %s takes no value", ErrInvalidSegment, rule.Op)
+		}
+		condition := f.expr + " IS NOT NULL"
+		if f.kind == kindString {
+			condition = f.expr + " <> ''"
+		}
+		if rule.Op == "is_not_set" {
+			condition = "NOT (" + condition + ")"
+		}
+		return condition, nil
+
+	case "in", "not_in":
+		var values []json.RawMessage
+		if err := json.Unmarshal(rule.Value, &values); err != nil || len(values) == 0 || len(values) > maxValues {
+			return "", fmt.Errorf("%w: %s of %s needs a list of 1 to %d values", ErrInvalidSegment, rule.Op, rule.Field, maxValues)
+		}
+		placeholders := make([]string, len(values))
+		for i, raw := range values {
+			value, err := decodeValue(f.kind, raw)
+			if err != nil {
+				return "", fmt.Errorf("%w: %s: %v", ErrInvalidSegment, rule.Field, err)
+			}
+			placeholders[i] = c.param(value)
+		}
+		list := strings.Join(placeholders, ", ")
+		if rule.Op == "not_in" {
+			// Unset values are not in any list
+			return "COALESCE(" + f.expr + " NOT IN (" + list + "), TRUE)", nil
+		}
+		return f.expr + " IN (" + list + ")", nil
+
+	case "within_days", "older_than_days":
+		var days int
+		if err := json.Unmarshal(rule.Value, &days); err != nil || days < 0 {
+			return "", fmt.Errorf("%w: %s of %s needs a number of days", ErrInvalidSegment, rule.Op, rule.Field)
+		}
+		cutoff := c.param(c.now.AddDate(0, 0, -days))
+		if rule.Op == "within_days" {
+			return f.expr + " >= " + cutoff, nil
+		}
+		return f.expr + " < " + cutoff, nil
+	}
+
+	value, err := decodeValue(f.kind, rule.Value)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrInvalidSegment, rule.Field, err)
+	}
+
+	switch rule.Op {
+	case "eq":
+		return f.expr + " = " + c.param(value), nil
+	case "neq":
+		return f.expr + " IS DISTINCT FROM " + c.param(value), nil
+	case "gt", "after":
+		return f.expr + " > " + c.param(value), nil
+	case "gte":
+		return f.expr + " >= " + c.param(value), nil
+	case "lt", "before":
+		return f.expr + " < " + c.param(value), nil
+	case "lte":
+		return f.expr + " <= " + c.param(value), nil
+	case "contains":
+		return f.expr + " ILIKE " + c.param("%"+escapeLike(value.(string))+"%"), nil
+	case "not_contains":
+		return f.expr + " NOT ILIKE " + c.param("%"+escapeLike(value.(string))+"%"), nil
+	case "starts_with":
+		return f.expr + " ILIKE " + c.param(escapeLike(value.(string))+"%"), nil
+	default:
+		return "", fmt.Errorf("%w: unknown operator %q", ErrInvalidSegment, rule.Op)
+	}
+}
+
+// supports reports whether a kind of field supports an operator
+func supports(k kind, op string) bool {
+	for _, supported := range operators[k] {
+		if supported == op {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeValue decodes a rule value of the given kind. Times are RFC 3339 timestamps or
+// YYYY-MM-DD dates, which mean midnight UTC.
+func decodeValue(k kind, raw json.RawMessage) (interface{}, error) {
+	if raw == nil {
+		return nil, fmt.Errorf("missing value")
+	}
+	switch k {
+	case kindString:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("value must be a string")
+		}
+		return s, nil
+	case kindNumber:
+		var n int64
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return nil, fmt.Errorf("value must be an integer")
+		}
+		return n, nil
+	case kindBool:
+		var b bool
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return nil, fmt.Errorf("value must be true or false")
+		}
+		return b, nil
+	case kindTime:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("value must be a date")
+		}
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t, nil
+		}
+		if t, err := time.Parse("2006-01-02", s); err == nil {
+			return t, nil
+		}
+		return nil, fmt.Errorf("invalid date %q", s)
+	default:
+		return nil, fmt.Errorf("unsupported field type")
+	}
+}
+
+// escapeLike escapes the LIKE wildcards in a value, so it is matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
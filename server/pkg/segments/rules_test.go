@@ -0,0 +1,353 @@
+package segments
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+
+// compileJSON parses a definition like the API does and compiles it at testNow
+func compileJSON(t *testing.T, definition string, argOffset int) (*Query, error) {
+	t.Helper()
+	def, err := Parse([]byte(definition))
+	if err != nil {
+		return nil, err
+	}
+	return Compile(def, testNow, argOffset)
+}
+
+// userRule wraps a single rule in a definition over users
+func userRule(rule string) string {
+	return `{"source": "users", "rules": [` + rule + `]}`
+}
+
+func TestCompileOperators(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      string
+		wantWhere string
+		wantArgs  []interface{}
+	}{
+		{"string eq", `{"field": "name", "op": "eq", "value": "Ada"}`,
+			"(u.name = $1)", []interface{}{"Ada"}},
+		{"string neq", `{"field": "subscription_status", "op": "neq", "value": "active"}`,
+			"(COALESCE(u.latest_status, '') IS DISTINCT FROM $1)", []interface{}{"active"}},
+		{"string in", `{"field": "subscription_status", "op": "in", "value": ["active", "on_trial"]}`,
+			"(COALESCE(u.latest_status, '') IN ($1, $2))", []interface{}{"active", "on_trial"}},
+		{"string not_in", `{"field": "language", "op": "not_in", "value": ["de"]}`,
+			"(COALESCE(COALESCE(u.language, '') NOT IN ($1), TRUE))", []interface{}{"de"}},
+		{"contains", `{"field": "email", "op": "contains", "value": "50%_off\\"}`,
+			"(u.email ILIKE $1)", []interface{}{`%50\%\_off\\%`}},
+		{"not_contains", `{"field": "name", "op": "not_contains", "value": "test"}`,
+			"(u.name NOT ILIKE $1)", []interface{}{"%test%"}},
+		{"starts_with", `{"field": "email", "op": "starts_with", "value": "admin"}`,
+			"(u.email ILIKE $1)", []interface{}{"admin%"}},
+		{"string is_set", `{"field": "language", "op": "is_set"}`,
+			"(COALESCE(u.language, '') <> '')", nil},
+		{"string is_not_set", `{"field": "language", "op": "is_not_set"}`,
+			"(NOT (COALESCE(u.language, '') <> ''))", nil},
+		{"number eq", `{"field": "product_id", "op": "eq", "value": 7}`,
+			"(u.latest_product_id = $1)", []interface{}{int64(7)}},
+		{"number neq", `{"field": "product_id", "op": "neq", "value": 7}`,
+			"(u.latest_product_id IS DISTINCT FROM $1)", []interface{}{int64(7)}},
+		{"number in", `{"field": "variant_id", "op": "in", "value": [1, 2]}`,
+			"(u.latest_variant_id IN ($1, $2))", []interface{}{int64(1), int64(2)}},
+		{"number not_in", `{"field": "variant_id", "op": "not_in", "value": [3]}`,
+			"(COALESCE(u.latest_variant_id NOT IN ($1), TRUE))", []interface{}{int64(3)}},
+		{"gt", `{"field": "product_id", "op": "gt", "value": 5}`,
+			"(u.latest_product_id > $1)", []interface{}{int64(5)}},
+		{"gte", `{"field": "product_id", "op": "gte", "value": 5}`,
+			"(u.latest_product_id >= $1)", []interface{}{int64(5)}},
+		{"lt", `{"field": "product_id", "op": "lt", "value": 5}`,
+			"(u.latest_product_id < $1)", []interface{}{int64(5)}},
+		{"lte", `{"field": "product_id", "op": "lte", "value": 5}`,
+			"(u.latest_product_id <= $1)", []interface{}{int64(5)}},
+		{"number is_set", `{"field": "variant_id", "op": "is_set"}`,
+			"(u.latest_variant_id IS NOT NULL)", nil},
+		{"number is_not_set", `{"field": "variant_id", "op": "is_not_set"}`,
+			"(NOT (u.latest_variant_id IS NOT NULL))", nil},
+		{"bool eq", `{"field": "email_verified", "op": "eq", "value": true}`,
+			"(COALESCE(u.email_verified, false) = $1)", []interface{}{true}},
+		{"bool neq", `{"field": "email_verified", "op": "neq", "value": false}`,
+			"(COALESCE(u.email_verified, false) IS DISTINCT FROM $1)", []interface{}{false}},
+		{"before date", `{"field": "signup_date", "op": "before", "value": "2026-01-01"}`,
+			"(u.created_at < $1)", []interface{}{time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}},
+		{"after timestamp", `{"field": "signup_date", "op": "after", "value": "2026-01-01T09:30:00Z"}`,
+			"(u.created_at > $1)", []interface{}{time.Date(2026, 1, 1, 9, 30, 0, 0, time.UTC)}},
+		{"within_days", `{"field": "signup_date", "op": "within_days", "value": 14}`,
+			"(u.created_at >= $1)", []interface{}{testNow.AddDate(0, 0, -14)}},
+		{"older_than_days", `{"field": "signup_date", "op": "older_than_days", "value": 30}`,
+			"(u.created_at < $1)", []interface{}{testNow.AddDate(0, 0, -30)}},
+		{"time is_set", `{"field": "signup_date", "op": "is_set"}`,
+			"(u.created_at IS NOT NULL)", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := compileJSON(t, userRule(tt.rule), 0)
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			if q.Where != tt.wantWhere {
+				t.Errorf("Where = %q, want %q", q.Where, tt.wantWhere)
+			}
+			if !sameArgs(q.Args, tt.wantArgs) {
+				t.Errorf("Args = %#v, want %#v", q.Args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+// sameArgs compares parameters, treating nil and empty lists alike
+func sameArgs(got, want []interface{}) bool {
+	if len(got) == 0 && len(want) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+func TestCompileEveryOperatorIsCovered(t *testing.T) {
+	covered := map[string]bool{
+		"eq": true, "neq": true, "in": true, "not_in": true, "contains": true, "not_contains": true,
+		"starts_with": true, "is_set": true, "is_not_set": true, "gt": true, "gte": true, "lt": true,
+		"lte": true, "before": true, "after": true, "within_days": true, "older_than_days": true,
+	}
+	for _, ops := range operators {
+		for _, op := range ops {
+			if !covered[op] {
+				t.Errorf("operator %q has no test in TestCompileOperators", op)
+			}
+		}
+	}
+}
+
+func TestCompileGroups(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		wantWhere  string
+		wantArgs   []interface{}
+	}{
+		{"no rules select the whole source", `{"source": "users", "rules": []}`, "TRUE", nil},
+		{"all joins with AND", `{"source": "users", "match": "all", "rules": [
+				{"field": "email_verified", "op": "eq", "value": true},
+				{"field": "language", "op": "eq", "value": "de"}
+			]}`,
+			"(COALESCE(u.email_verified, false) = $1) AND (COALESCE(u.language, '') = $2)",
+			[]interface{}{true, "de"}},
+		{"any joins with OR", `{"source": "users", "match": "any", "rules": [
+				{"field": "language", "op": "eq", "value": "de"},
+				{"field": "language", "op": "eq", "value": "fr"}
+			]}`,
+			"(COALESCE(u.language, '') = $1) OR (COALESCE(u.language, '') = $2)",
+			[]interface{}{"de", "fr"}},
+		{"nested any inside all", `{"source": "users", "match": "all", "rules": [
+				{"field": "subscription_status", "op": "in", "value": ["active", "on_trial"]},
+				{"match": "any", "rules": [
+					{"field": "signup_date", "op": "within_days", "value": 14},
+					{"field": "referred", "op": "eq", "value": true}
+				]}
+			]}`,
+			"(COALESCE(u.latest_status, '') IN ($1, $2)) AND ((u.created_at >= $3) OR (EXISTS (SELECT 1 FROM referrals rf WHERE rf.referred_user_id = u.id) = $4))",
+			[]interface{}{"active", "on_trial", testNow.AddDate(0, 0, -14), true}},
+		{"nested all inside any", `{"source": "users", "match": "any", "rules": [
+				{"match": "all", "rules": [
+					{"field": "product_id", "op": "eq", "value": 1},
+					{"field": "variant_id", "op": "neq", "value": 2}
+				]},
+				{"match": "all", "rules": [
+					{"field": "product_id", "op": "eq", "value": 3},
+					{"match": "any", "rules": [{"field": "language", "op": "is_not_set"}]}
+				]}
+			]}`,
+			"((u.latest_product_id = $1) AND (u.latest_variant_id IS DISTINCT FROM $2)) OR ((u.latest_product_id = $3) AND ((NOT (COALESCE(u.language, '') <> ''))))",
+			[]interface{}{int64(1), int64(2), int64(3)}},
+		{"empty nested group", `{"source": "users", "rules": [{"match": "any", "rules": []}]}`, "(TRUE)", nil},
+		{"newsletter source", `{"source": "newsletter", "rules": [{"field": "subscribed", "op": "eq", "value": true}]}`,
+			"(n.subscribed = $1)", []interface{}{true}},
+		{"early access source", `{"source": "early_access", "rules": [{"field": "referrer", "op": "contains", "value": "news"}]}`,
+			"(COALESCE(e.referrer, '') ILIKE $1)", []interface{}{"%news%"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := compileJSON(t, tt.definition, 0)
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			if q.Where != tt.wantWhere {
+				t.Errorf("Where = %q, want %q", q.Where, tt.wantWhere)
+			}
+			if !sameArgs(q.Args, tt.wantArgs) {
+				t.Errorf("Args = %#v, want %#v", q.Args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestCompileNumbersParametersAfterOffset(t *testing.T) {
+	q, err := compileJSON(t, userRule(`{"field": "subscription_status", "op": "in", "value": ["active", "paused"]}`), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "(COALESCE(u.latest_status, '') IN ($3, $4))"; q.Where != want {
+		t.Errorf("Where = %q, want %q", q.Where, want)
+	}
+	if len(q.Args) != 2 {
+		t.Errorf("Args = %#v, want the 2 values of the rule", q.Args)
+	}
+}
+
+// nested returns a definition whose rules are nested depth groups deep
+func nested(depth int) string {
+	rule := `{"field": "language", "op": "is_set"}`
+	for i := 0; i < depth; i++ {
+		rule = `{"match": "any", "rules": [` + rule + `]}`
+	}
+	return userRule(rule)
+}
+
+// manyRules returns a definition with n conditions
+func manyRules(n int) string {
+	rules := make([]string, n)
+	for i := range rules {
+		rules[i] = `{"field": "language", "op": "is_set"}`
+	}
+	return userRule(strings.Join(rules, ", "))
+}
+
+// manyValues returns an in rule with n values
+func manyValues(n int) string {
+	values := make([]string, n)
+	for i := range values {
+		values[i] = fmt.Sprintf("%d", i)
+	}
+	return userRule(`{"field": "product_id", "op": "in", "value": [` + strings.Join(values, ", ") + `]}`)
+}
+
+func TestCompileRejectsInvalidDefinitions(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+	}{
+		{"unknown field", userRule(`{"field": "password_hash", "op": "eq", "value": "x"}`)},
+		{"SQL as field", userRule(`{"field": "u.email) OR (1=1", "op": "is_set"}`)},
+		{"field of another source", userRule(`{"field": "is_user", "op": "eq", "value": true}`)},
+		{"unknown field in nested group", userRule(`{"match": "any", "rules": [{"field": "role", "op": "eq", "value": "admin"}]}`)},
+		{"unknown source", `{"source": "orders", "rules": []}`},
+		{"unknown operator", userRule(`{"field": "email", "op": "like", "value": "%"}`)},
+		{"operator of another type", userRule(`{"field": "email_verified", "op": "contains", "value": "t"}`)},
+		{"eq on a time", userRule(`{"field": "signup_date", "op": "eq", "value": "2026-01-01"}`)},
+		{"string for a number", userRule(`{"field": "product_id", "op": "eq", "value": "7"}`)},
+		{"fraction for a number", userRule(`{"field": "product_id", "op": "gt", "value": 1.5}`)},
+		{"string for a bool", userRule(`{"field": "email_verified", "op": "eq", "value": "yes"}`)},
+		{"number for a string", userRule(`{"field": "name", "op": "eq", "value": 1}`)},
+		{"invalid date", userRule(`{"field": "signup_date", "op": "before", "value": "yesterday"}`)},
+		{"missing value", userRule(`{"field": "name", "op": "eq"}`)},
+		{"value for is_set", userRule(`{"field": "name", "op": "is_set", "value": "x"}`)},
+		{"in without a list", userRule(`{"field": "name", "op": "in", "value": "Ada"}`)},
+		{"in with an empty list", userRule(`{"field": "name", "op": "in", "value": []}`)},
+		{"in with too many values", manyValues(maxValues + 1)},
+		{"in with a value of the wrong type", userRule(`{"field": "product_id", "op": "in", "value": [1, "2"]}`)},
+		{"negative days", userRule(`{"field": "signup_date", "op": "within_days", "value": -1}`)},
+		{"unknown match", `{"source": "users", "match": "none", "rules": []}`},
+		{"unknown nested match", userRule(`{"match": "xor", "rules": []}`)},
+		{"group with a condition", userRule(`{"field": "name", "op": "is_set", "rules": []}`)},
+		{"nested too deep", nested(maxDepth + 1)},
+		{"too many rules", manyRules(maxRules + 1)},
+		{"unknown key", `{"source": "users", "rules": [], "where": "1=1"}`},
+		{"unknown rule key", userRule(`{"field": "name", "op": "is_set", "sql": "1=1"}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if q, err := compileJSON(t, tt.definition, 0); !errors.Is(err, ErrInvalidSegment) {
+				t.Errorf("compile = %+v, %v, want ErrInvalidSegment", q, err)
+			}
+		})
+	}
+
+	// The limits themselves are allowed
+	for _, definition := range []string{nested(maxDepth), manyRules(maxRules), manyValues(maxValues)} {
+		if _, err := compileJSON(t, definition, 0); err != nil {
+			t.Errorf("compile at the limit: %v", err)
+		}
+	}
+}
+
+func TestCompileBindsValuesAsParameters(t *testing.T) {
+	injection := `x' OR '1'='1'; DROP TABLE users; --`
+	quoted, _ := json.Marshal(injection)
+
+	rules := []struct {
+		field string
+		op    string
+		value string
+	}{
+		{"email", "eq", string(quoted)},
+		{"email", "neq", string(quoted)},
+		{"name", "in", "[" + string(quoted) + "]"},
+		{"name", "not_in", "[" + string(quoted) + "]"},
+		{"email", "contains", string(quoted)},
+		{"email", "not_contains", string(quoted)},
+		{"email", "starts_with", string(quoted)},
+		{"referrer", "eq", string(quoted)},
+	}
+	for _, rule := range rules {
+		t.Run(rule.op, func(t *testing.T) {
+			q, err := compileJSON(t, userRule(fmt.Sprintf(`{"field": %q, "op": %q, "value": %s}`, rule.field, rule.op, rule.value)), 0)
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			if strings.Contains(q.Where, "DROP") || strings.Contains(q.Where, "'1'") {
+				t.Errorf("value was written into the SQL: %s", q.Where)
+			}
+
+			// The SQL doesn't depend on the value at all
+			plain := `"x"`
+			if strings.HasPrefix(rule.value, "[") {
+				plain = `["x"]`
+			}
+			benign, err := compileJSON(t, userRule(fmt.Sprintf(`{"field": %q, "op": %q, "value": %s}`, rule.field, rule.op, plain)), 0)
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			if q.Where != benign.Where {
+				t.Errorf("Where = %q, want the same SQL as for a plain value: %q", q.Where, benign.Where)
+			}
+
+			found := false
+			for _, arg := range q.Args {
+				if s, ok := arg.(string); ok && strings.Contains(s, "DROP TABLE users") {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Args = %#v, want the value as a parameter", q.Args)
+			}
+		})
+	}
+
+	// Every parameter has exactly one placeholder, numbered in order
+	q, err := compileJSON(t, `{"source": "users", "match": "any", "rules": [
+		{"field": "email", "op": "in", "value": ["a@example.com", "b@example.com"]},
+		{"match": "all", "rules": [
+			{"field": "name", "op": "starts_with", "value": "A"},
+			{"field": "signup_date", "op": "older_than_days", "value": 7}
+		]}
+	]}`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range q.Args {
+		if n := strings.Count(q.Where+" ", fmt.Sprintf("$%d", i+1)); n != 1 {
+			t.Errorf("placeholder $%d appears %d times in %q", i+1, n, q.Where)
+		}
+	}
+	if strings.Contains(q.Where, fmt.Sprintf("$%d", len(q.Args)+1)) {
+		t.Errorf("Where = %q has more placeholders than the %d parameters", q.Where, len(q.Args))
+	}
+}
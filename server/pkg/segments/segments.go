@@ -0,0 +1,172 @@
+// Package segments defines audiences with JSON rules over user and subscriber attributes.
+// Rules are compiled to parameterised SQL, so segments can be counted, exported and used as
+// campaign audiences without admins writing queries.
+package segments
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"saas-server/models"
+	"saas-server/pkg/clock"
+	"saas-server/pkg/validation"
+)
+
+// ErrInvalidSegment wraps the reason a segment definition was rejected
+var ErrInvalidSegment = errors.New("invalid segment")
+
+// ErrNotFound is returned for segments that don't exist
+var ErrNotFound = errors.New("segment not found")
+
+// ErrInUse is returned when deleting a segment that an unsent campaign targets
+var ErrInUse = errors.New("segment is used by a campaign that hasn't been sent")
+
+// previewSize is how many members a preview includes
+const previewSize = 10
+
+// SegmentDB defines the database operations required by the segment service
+type SegmentDB interface {
+	CreateSegment(s *models.Segment) error
+	UpdateSegment(s *models.Segment) (*models.Segment, error)
+	GetSegment(id int) (*models.Segment, error)
+	GetSegments() ([]models.Segment, error)
+	DeleteSegment(id int) (bool, error)
+	CountSegment(q *Query) (int, error)
+	GetSegmentMembers(q *Query, page int, limit int) ([]models.SegmentMember, error)
+}
+
+// Preview is the size of a segment with a sample of its newest members
+type Preview struct {
+	Count  int                    `json:"count"`
+	Sample []models.SegmentMember `json:"sample"`
+}
+
+// Service manages saved segments
+type Service struct {
+	db    SegmentDB
+	clock clock.Clock
+}
+
+// NewService creates a new instance of Service
+func NewService(db SegmentDB, clock clock.Clock) *Service {
+	return &Service{db: db, clock: clock}
+}
+
+// Create validates and saves a segment
+func (s *Service) Create(segment *models.Segment) error {
+	if err := normalize(segment); err != nil {
+		return err
+	}
+	return s.db.CreateSegment(segment)
+}
+
+// Update validates and replaces a segment
+func (s *Service) Update(segment *models.Segment) (*models.Segment, error) {
+	if err := normalize(segment); err != nil {
+		return nil, err
+	}
+	updated, err := s.db.UpdateSegment(segment)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return updated, err
+}
+
+// Get returns a saved segment
+func (s *Service) Get(id int) (*models.Segment, error) {
+	segment, err := s.db.GetSegment(id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return segment, err
+}
+
+// List returns every saved segment
+func (s *Service) List() ([]models.Segment, error) {
+	return s.db.GetSegments()
+}
+
+// Delete deletes a segment that no unsent campaign targets
+func (s *Service) Delete(id int) error {
+	deleted, err := s.db.DeleteSegment(id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		if _, err := s.Get(id); err != nil {
+			return err
+		}
+		return ErrInUse
+	}
+	return nil
+}
+
+// Preview counts the members of a definition, saved or not, and samples the newest ones
+func (s *Service) Preview(def Definition) (*Preview, error) {
+	q, err := Compile(def, s.clock.Now(), 0)
+	if err != nil {
+		return nil, err
+	}
+	count, err := s.db.CountSegment(q)
+	if err != nil {
+		return nil, err
+	}
+	sample, err := s.db.GetSegmentMembers(q, 1, previewSize)
+	if err != nil {
+		return nil, err
+	}
+	return &Preview{Count: count, Sample: sample}, nil
+}
+
+// Members returns a page of a saved segment's members, newest first. A limit of 0 returns
+// every member.
+func (s *Service) Members(id int, page int, limit int) ([]models.SegmentMember, int, error) {
+	q, err := s.query(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	count, err := s.db.CountSegment(q)
+	if err != nil {
+		return nil, 0, err
+	}
+	members, err := s.db.GetSegmentMembers(q, page, limit)
+	return members, count, err
+}
+
+// query loads and compiles a saved segment
+func (s *Service) query(id int) (*Query, error) {
+	segment, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	def, err := Parse(segment.Definition)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(def, s.clock.Now(), 0)
+}
+
+// normalize sanitizes a segment, checks its definition compiles and stores the definition
+// in canonical form
+func normalize(segment *models.Segment) error {
+	segment.Name = validation.SanitizeInput(segment.Name, 255)
+	segment.Description = strings.TrimSpace(segment.Description)
+	if segment.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidSegment)
+	}
+
+	def, err := Parse(segment.Definition)
+	if err != nil {
+		return err
+	}
+	canonical, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+	segment.Source = def.Source
+	segment.Definition = canonical
+	return nil
+}
@@ -0,0 +1,320 @@
+// Package ses receives Amazon SES bounce and complaint notifications, which SES publishes to an
+// Amazon SNS topic and SNS delivers to an HTTPS subscription. Every SNS message is verified
+// against the certificate AWS signed it with before it is trusted.
+package ses
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidMessage is returned for request bodies that aren't SNS messages
+	ErrInvalidMessage = errors.New("invalid SNS message")
+	// ErrInvalidSignature is returned for SNS messages that aren't signed by AWS
+	ErrInvalidSignature = errors.New("invalid SNS message signature")
+	// ErrUnknownTopic is returned for SNS messages of topics that weren't configured
+	ErrUnknownTopic = errors.New("SNS message from an unknown topic")
+)
+
+// snsHost matches the hosts of SNS signing certificates and subscription URLs
+var snsHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// Message is an SNS message as delivered to HTTPS subscriptions
+type Message struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token,omitempty"`
+	TopicARN         string `json:"TopicArn"`
+	Subject          string `json:"Subject,omitempty"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL,omitempty"`
+}
+
+// Event is a bounce or complaint of one recipient
+type Event struct {
+	Type        string // bounce or complaint
+	Email       string
+	BounceType  string // Permanent, Transient or Undetermined for bounces
+	MessageID   string // SES message ID of the email the event is about
+	Description string // Diagnostic code of a bounce or feedback type of a complaint
+}
+
+// notification is the SES notification carried in an SNS message. Notifications sent by
+// the identity set notificationType, ones published by a configuration set eventType.
+type notification struct {
+	NotificationType string `json:"notificationType"`
+	EventType        string `json:"eventType"`
+	Bounce           *struct {
+		BounceType        string `json:"bounceType"`
+		BounceSubType     string `json:"bounceSubType"`
+		BouncedRecipients []struct {
+			EmailAddress   string `json:"emailAddress"`
+			DiagnosticCode string `json:"diagnosticCode"`
+		} `json:"bouncedRecipients"`
+	} `json:"bounce"`
+	Complaint *struct {
+		ComplaintFeedbackType string `json:"complaintFeedbackType"`
+		ComplainedRecipients  []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+	} `json:"complaint"`
+	Mail struct {
+		MessageID string `json:"messageId"`
+	} `json:"mail"`
+}
+
+// Receiver verifies SNS messages of the configured topics and extracts their SES events
+type Receiver struct {
+	topicARNs map[string]bool
+	client    *http.Client
+	certHost  *regexp.Regexp
+
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate
+}
+
+// NewReceiver creates a Receiver that accepts messages of the given SNS topics
+func NewReceiver(topicARNs []string) *Receiver {
+	r := &Receiver{
+		topicARNs: make(map[string]bool),
+		client:    &http.Client{Timeout: 10 * time.Second},
+		certHost:  snsHost,
+		certs:     make(map[string]*x509.Certificate),
+	}
+	for _, arn := range topicARNs {
+		if arn = strings.TrimSpace(arn); arn != "" {
+			r.topicARNs[arn] = true
+		}
+	}
+	return r
+}
+
+// Configured reports whether any topic is accepted
+func (r *Receiver) Configured() bool {
+	return len(r.topicARNs) > 0
+}
+
+// Receive verifies an SNS request body and returns the bounce and complaint events it carries.
+// Subscription confirmations are confirmed with SNS and carry no events.
+func (r *Receiver) Receive(body []byte) ([]Event, error) {
+	var m Message
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	if !r.topicARNs[m.TopicARN] {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTopic, m.TopicARN)
+	}
+	if err := r.verify(&m); err != nil {
+		return nil, err
+	}
+
+	switch m.Type {
+	case "SubscriptionConfirmation":
+		return nil, r.confirm(&m)
+	case "Notification":
+		return parseNotification(m.Message)
+	default:
+		return nil, nil
+	}
+}
+
+// verify checks the signature of an SNS message
+func (r *Receiver) verify(m *Message) error {
+	var hash crypto.Hash
+	switch m.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("%w: unsupported signature version %q", ErrInvalidSignature, m.SignatureVersion)
+	}
+
+	signed, err := stringToSign(m)
+	if err != nil {
+		return err
+	}
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	cert, err := r.certificate(m.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: signing certificate has no RSA key", ErrInvalidSignature)
+	}
+
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(signed))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(signed))
+		digest = sum[:]
+	}
+	if err := rsa.VerifyPKCS1v15(key, hash, digest, signature); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	return nil
+}
+
+// stringToSign builds the text SNS signs from the message's fields in their documented order
+func stringToSign(m *Message) (string, error) {
+	var fields []string
+	switch m.Type {
+	case "Notification":
+		fields = []string{"Message", m.Message, "MessageId", m.MessageID}
+		if m.Subject != "" {
+			fields = append(fields, "Subject", m.Subject)
+		}
+		fields = append(fields, "Timestamp", m.Timestamp, "TopicArn", m.TopicARN, "Type", m.Type)
+	case "SubscriptionConfirmation", "UnsubscribeConfirmation":
+		fields = []string{
+			"Message", m.Message,
+			"MessageId", m.MessageID,
+			"SubscribeURL", m.SubscribeURL,
+			"Timestamp", m.Timestamp,
+			"Token", m.Token,
+			"TopicArn", m.TopicARN,
+			"Type", m.Type,
+		}
+	default:
+		return "", fmt.Errorf("%w: unknown type %q", ErrInvalidMessage, m.Type)
+	}
+	return strings.Join(fields, "\n") + "\n", nil
+}
+
+// certificate returns the signing certificate at certURL, which has to be served by SNS
+func (r *Receiver) certificate(certURL string) (*x509.Certificate, error) {
+	if !r.snsURL(certURL) {
+		return nil, fmt.Errorf("%w: signing certificate isn't served by SNS: %s", ErrInvalidSignature, certURL)
+	}
+
+	r.mu.Lock()
+	cert, ok := r.certs[certURL]
+	r.mu.Unlock()
+	if ok {
+		return cert, nil
+	}
+
+	resp, err := r.client.Get(certURL)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching signing certificate: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching signing certificate: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching signing certificate: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: signing certificate isn't PEM encoded", ErrInvalidSignature)
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	r.mu.Lock()
+	r.certs[certURL] = cert
+	r.mu.Unlock()
+	return cert, nil
+}
+
+// confirm visits the subscribe URL of a verified subscription confirmation, which starts the
+// delivery of the topic's notifications
+func (r *Receiver) confirm(m *Message) error {
+	if !r.snsURL(m.SubscribeURL) {
+		return fmt.Errorf("%w: subscribe URL isn't served by SNS: %s", ErrInvalidMessage, m.SubscribeURL)
+	}
+	resp, err := r.client.Get(m.SubscribeURL)
+	if err != nil {
+		return fmt.Errorf("error confirming subscription: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error confirming subscription: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// snsURL reports whether rawURL is an HTTPS URL of SNS
+func (r *Receiver) snsURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Scheme == "https" && r.certHost.MatchString(u.Host)
+}
+
+// parseNotification returns the events of an SES notification. Deliveries and other
+// notification types carry none.
+func parseNotification(message string) ([]Event, error) {
+	var n notification
+	if err := json.Unmarshal([]byte(message), &n); err != nil {
+		return nil, fmt.Errorf("%w: SES notification: %v", ErrInvalidMessage, err)
+	}
+
+	notificationType := n.NotificationType
+	if notificationType == "" {
+		notificationType = n.EventType
+	}
+
+	var events []Event
+	switch notificationType {
+	case "Bounce":
+		if n.Bounce == nil {
+			return nil, fmt.Errorf("%w: bounce notification without bounce", ErrInvalidMessage)
+		}
+		for _, recipient := range n.Bounce.BouncedRecipients {
+			description := recipient.DiagnosticCode
+			if description == "" {
+				description = n.Bounce.BounceType + "/" + n.Bounce.BounceSubType
+			}
+			events = append(events, Event{
+				Type:        "bounce",
+				Email:       recipient.EmailAddress,
+				BounceType:  n.Bounce.BounceType,
+				MessageID:   n.Mail.MessageID,
+				Description: description,
+			})
+		}
+	case "Complaint":
+		if n.Complaint == nil {
+			return nil, fmt.Errorf("%w: complaint notification without complaint", ErrInvalidMessage)
+		}
+		for _, recipient := range n.Complaint.ComplainedRecipients {
+			events = append(events, Event{
+				Type:        "complaint",
+				Email:       recipient.EmailAddress,
+				MessageID:   n.Mail.MessageID,
+				Description: n.Complaint.ComplaintFeedbackType,
+			})
+		}
+	}
+	return events, nil
+}
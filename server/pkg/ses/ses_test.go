@@ -0,0 +1,236 @@
+package ses
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+const testTopic = "arn:aws:sns:us-east-1:123456789012:ses-notifications"
+
+// fakeSNS serves a signing certificate and subscribe URLs like SNS, and signs messages with
+// the certificate's key. The SES notifications in testdata are the examples of the SES
+// documentation; only the SNS envelope is signed here, as AWS's signature can't be.
+type fakeSNS struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	confirmed []string
+}
+
+func newFakeSNS(t *testing.T) *fakeSNS {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	f := &fakeSNS{key: key}
+	f.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/SimpleNotificationService.pem":
+			w.Write(certPEM)
+		case "/confirm":
+			f.confirmed = append(f.confirmed, r.URL.Query().Get("Token"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// receiver returns a Receiver that trusts the fake's host as SNS
+func (f *fakeSNS) receiver() *Receiver {
+	r := NewReceiver([]string{testTopic})
+	r.client = f.server.Client()
+	u, _ := url.Parse(f.server.URL)
+	r.certHost = regexp.MustCompile("^" + regexp.QuoteMeta(u.Host) + "$")
+	return r
+}
+
+// sign fills in the signature fields of m with the given signature version
+func (f *fakeSNS) sign(t *testing.T, m *Message, version string) []byte {
+	t.Helper()
+	m.SignatureVersion = version
+	m.SigningCertURL = f.server.URL + "/SimpleNotificationService.pem"
+	signed, err := stringToSign(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var signature []byte
+	if version == "1" {
+		sum := sha1.Sum([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA1, sum[:])
+	} else {
+		sum := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, sum[:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Signature = base64.StdEncoding.EncodeToString(signature)
+
+	body, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// snsNotification wraps an SES notification from testdata in an SNS notification
+func snsNotification(t *testing.T, file string) *Message {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Message{
+		Type:      "Notification",
+		MessageID: "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+		TopicARN:  testTopic,
+		Message:   string(data),
+		Timestamp: "2016-01-27T14:59:38.237Z",
+	}
+}
+
+func TestReceiveNotifications(t *testing.T) {
+	f := newFakeSNS(t)
+	r := f.receiver()
+
+	tests := []struct {
+		file    string
+		version string
+		want    []Event
+	}{
+		{"bounce.json", "1", []Event{{
+			Type:        "bounce",
+			Email:       "jane@example.com",
+			BounceType:  "Permanent",
+			MessageID:   "00000138111222aa-33322211-cccc-cccc-cccc-ddddaaaa0680-000000",
+			Description: "smtp; 550 5.1.1 <jane@example.com>... User",
+		}}},
+		{"bounce_transient.json", "2", []Event{{
+			Type:        "bounce",
+			Email:       "mary@example.com",
+			BounceType:  "Transient",
+			MessageID:   "00000138111222aa-33322211-cccc-cccc-cccc-ddddaaaa0681-000000",
+			Description: "smtp; 452 4.2.2 Mailbox full",
+		}}},
+		{"complaint.json", "2", []Event{{
+			Type:        "complaint",
+			Email:       "richard@example.com",
+			MessageID:   "000001378603177f-7a5433e7-8edb-42ae-af10-f0181f34d6ee-000000",
+			Description: "abuse",
+		}}},
+		{"delivery_event.json", "1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			events, err := r.Receive(f.sign(t, snsNotification(t, tt.file), tt.version))
+			if err != nil {
+				t.Fatalf("Receive: %v", err)
+			}
+			if len(events) != len(tt.want) {
+				t.Fatalf("events = %+v, want %+v", events, tt.want)
+			}
+			for i := range events {
+				if events[i] != tt.want[i] {
+					t.Errorf("event %d = %+v, want %+v", i, events[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReceiveRejectsUntrustedMessages(t *testing.T) {
+	f := newFakeSNS(t)
+	r := f.receiver()
+
+	tampered := snsNotification(t, "bounce.json")
+	body := f.sign(t, tampered, "2")
+	var m Message
+	json.Unmarshal(body, &m)
+	m.Message = `{"notificationType":"Complaint","complaint":{"complainedRecipients":[{"emailAddress":"victim@example.com"}]},"mail":{}}`
+	tamperedBody, _ := json.Marshal(m)
+
+	otherTopic := snsNotification(t, "bounce.json")
+	otherTopic.TopicARN = "arn:aws:sns:us-east-1:999999999999:someone-else"
+
+	foreignCert := snsNotification(t, "bounce.json")
+	foreignBody := f.sign(t, foreignCert, "2")
+	json.Unmarshal(foreignBody, &m)
+	m.SigningCertURL = "https://attacker.example.com/SimpleNotificationService.pem"
+	foreignBody, _ = json.Marshal(m)
+
+	tests := []struct {
+		name string
+		body []byte
+		want error
+	}{
+		{"tampered message", tamperedBody, ErrInvalidSignature},
+		{"unknown topic", f.sign(t, otherTopic, "2"), ErrUnknownTopic},
+		{"certificate not served by SNS", foreignBody, ErrInvalidSignature},
+		{"not JSON", []byte("events=1"), ErrInvalidMessage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := r.Receive(tt.body); !errors.Is(err, tt.want) {
+				t.Errorf("Receive = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReceiveConfirmsSubscription(t *testing.T) {
+	f := newFakeSNS(t)
+	r := f.receiver()
+
+	m := &Message{
+		Type:         "SubscriptionConfirmation",
+		MessageID:    "165545c9-2a5c-472c-8df2-7ff2be2b3b1b",
+		Token:        "2336412f37",
+		TopicARN:     testTopic,
+		Message:      "You have chosen to subscribe to the topic " + testTopic + ".\nTo confirm the subscription, visit the SubscribeURL included in this message.",
+		SubscribeURL: f.server.URL + "/confirm?Action=ConfirmSubscription&TopicArn=" + url.QueryEscape(testTopic) + "&Token=2336412f37",
+		Timestamp:    "2012-04-26T20:45:04.751Z",
+	}
+	events, err := r.Receive(f.sign(t, m, "1"))
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("subscription confirmation returned %d events", len(events))
+	}
+	if len(f.confirmed) != 1 || f.confirmed[0] != "2336412f37" {
+		t.Errorf("confirmed tokens = %v, want [2336412f37]", f.confirmed)
+	}
+}
@@ -0,0 +1,263 @@
+// Package trials runs free trials managed by the app, so users can try a plan without
+// entering a card. Trials expire on a schedule and a reminder is sent before they end.
+package trials
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"saas-server/models"
+	"saas-server/pkg/clock"
+	"saas-server/pkg/email"
+	"saas-server/pkg/lemonsqueezy"
+)
+
+var (
+	// ErrTrialsDisabled is returned when no trial variant is configured
+	ErrTrialsDisabled = errors.New("trials are not enabled")
+	// ErrAlreadySubscribed is returned when a user with a subscription asks for a trial
+	ErrAlreadySubscribed = errors.New("user already has a subscription")
+)
+
+// TrialDB defines the database operations required by the trial service
+type TrialDB interface {
+	CreateTrial(userID string, productID int, variantID int, startedAt time.Time, endsAt time.Time) (*models.Trial, error)
+	GetTrialsNeedingReminder(endingBefore time.Time) ([]models.Trial, error)
+	MarkTrialReminderSent(trialID int, sentAt time.Time) error
+	ExpireTrials(now time.Time) ([]models.Trial, error)
+	GetActiveSubscriptionsByUserID(userID string) ([]models.Subscription, error)
+	GetUserByID(id string) (*models.User, error)
+	SyncUserPrimarySubscription(userID string) error
+	InvalidateUserCache(userID string)
+}
+
+// VariantSource looks up the product a plan variant belongs to
+type VariantSource interface {
+	GetVariant(variantID string) (*lemonsqueezy.SingleVariantResponse, error)
+}
+
+// Notifier sends the trial emails
+type Notifier interface {
+	SendTrialEnding(to string, locale string, upgradeURL string, endsAt time.Time) error
+	SendTrialExpired(to string, locale string, upgradeURL string) error
+}
+
+// EmailNotifier queues trial emails in the email outbox
+type EmailNotifier struct {
+	Outbox *email.Outbox
+}
+
+// SendTrialEnding sends a trial ending reminder
+func (n EmailNotifier) SendTrialEnding(to string, locale string, upgradeURL string, endsAt time.Time) error {
+	msg, err := email.TrialEndingEmail(to, locale, upgradeURL, endsAt)
+	if err != nil {
+		return err
+	}
+	return n.Outbox.Enqueue(msg, "")
+}
+
+// SendTrialExpired sends a trial expired email
+func (n EmailNotifier) SendTrialExpired(to string, locale string, upgradeURL string) error {
+	msg, err := email.TrialExpiredEmail(to, locale, upgradeURL)
+	if err != nil {
+		return err
+	}
+	return n.Outbox.Enqueue(msg, "")
+}
+
+// Config controls how trials are granted
+type Config struct {
+	// VariantID is the plan variant a trial gives access to. Trials are disabled when it is 0.
+	VariantID int
+	// Duration is how long a trial lasts
+	Duration time.Duration
+	// ReminderBefore is how long before the end of a trial the reminder is sent
+	ReminderBefore time.Duration
+	// OnSignup starts a trial automatically for every new user
+	OnSignup bool
+	// UpgradeURL is linked in the trial emails
+	UpgradeURL string
+}
+
+// LoadConfig reads the trial configuration from the environment.
+// TRIAL_VARIANT_ID enables trials, TRIAL_DAYS sets their length (default 14),
+// TRIAL_REMINDER_DAYS when the reminder is sent (default 3) and TRIAL_ON_SIGNUP=true
+// starts one for every new user.
+func LoadConfig() Config {
+	config := Config{
+		Duration:       14 * 24 * time.Hour,
+		ReminderBefore: 3 * 24 * time.Hour,
+		OnSignup:       os.Getenv("TRIAL_ON_SIGNUP") == "true",
+		UpgradeURL:     os.Getenv("FRONTEND_URL") + "/pricing",
+	}
+
+	if v := os.Getenv("TRIAL_VARIANT_ID"); v != "" {
+		if id, err := strconv.Atoi(v); err == nil && id > 0 {
+			config.VariantID = id
+		} else {
+			log.Printf("[Trials] Ignoring invalid TRIAL_VARIANT_ID %q", v)
+		}
+	}
+
+	if v := os.Getenv("TRIAL_DAYS"); v != "" {
+		if days, err := strconv.Atoi(v); err == nil && days > 0 {
+			config.Duration = time.Duration(days) * 24 * time.Hour
+		} else {
+			log.Printf("[Trials] Ignoring invalid TRIAL_DAYS %q", v)
+		}
+	}
+
+	if v := os.Getenv("TRIAL_REMINDER_DAYS"); v != "" {
+		if days, err := strconv.Atoi(v); err == nil && days >= 0 {
+			config.ReminderBefore = time.Duration(days) * 24 * time.Hour
+		} else {
+			log.Printf("[Trials] Ignoring invalid TRIAL_REMINDER_DAYS %q", v)
+		}
+	}
+
+	return config
+}
+
+// Service starts, reminds and expires trials
+type Service struct {
+	db       TrialDB
+	variants VariantSource
+	notifier Notifier
+	clock    clock.Clock
+	config   Config
+}
+
+// NewService creates a new instance of Service
+func NewService(db TrialDB, variants VariantSource, notifier Notifier, clock clock.Clock, config Config) *Service {
+	return &Service{
+		db:       db,
+		variants: variants,
+		notifier: notifier,
+		clock:    clock,
+		config:   config,
+	}
+}
+
+// Enabled reports whether trials are configured
+func (s *Service) Enabled() bool {
+	return s.config.VariantID != 0
+}
+
+// VariantID returns the plan variant that trials give access to
+func (s *Service) VariantID() int {
+	return s.config.VariantID
+}
+
+// StartTrialJob starts the background job that sends reminders and expires finished trials
+func (s *Service) StartTrialJob(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			if err := s.ProcessTrials(); err != nil {
+				log.Printf("Error processing trials: %v", err)
+			}
+		}
+	}()
+}
+
+// Start begins a trial for the user. Each user gets a single trial and users who
+// already pay for a plan don't get one.
+func (s *Service) Start(userID string) (*models.Trial, error) {
+	if !s.Enabled() {
+		return nil, ErrTrialsDisabled
+	}
+
+	active, err := s.db.GetActiveSubscriptionsByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(active) > 0 {
+		return nil, ErrAlreadySubscribed
+	}
+
+	variant, err := s.variants.GetVariant(strconv.Itoa(s.config.VariantID))
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	trial, err := s.db.CreateTrial(userID, variant.Data.Attributes.ProductID, s.config.VariantID, now, now.Add(s.config.Duration))
+	if err != nil {
+		return nil, err
+	}
+
+	// The trial is the user's primary subscription now, which users.latest_* mirrors
+	if err := s.db.SyncUserPrimarySubscription(userID); err != nil {
+		log.Printf("[Trials] Error syncing primary subscription of user %s: %v", userID, err)
+	}
+	s.db.InvalidateUserCache(userID)
+	log.Printf("[Trials] Started trial %d for user %s, ends at %s", trial.ID, userID, trial.EndsAt.Format(time.RFC3339))
+	return trial, nil
+}
+
+// StartSignupTrial starts a trial for a new user if trials on signup are enabled.
+// Errors are logged rather than returned so they never block a signup.
+func (s *Service) StartSignupTrial(userID string) {
+	if !s.config.OnSignup || !s.Enabled() {
+		return
+	}
+	if _, err := s.Start(userID); err != nil {
+		log.Printf("[Trials] Error starting signup trial for user %s: %v", userID, err)
+	}
+}
+
+// ProcessTrials sends reminders for trials ending soon and expires trials that have ended
+func (s *Service) ProcessTrials() error {
+	now := s.clock.Now()
+
+	expired, err := s.db.ExpireTrials(now)
+	if err != nil {
+		return err
+	}
+	for _, trial := range expired {
+		if err := s.db.SyncUserPrimarySubscription(trial.UserID); err != nil {
+			log.Printf("[Trials] Error syncing primary subscription of user %s: %v", trial.UserID, err)
+		}
+		s.db.InvalidateUserCache(trial.UserID)
+		log.Printf("[Trials] Trial %d for user %s expired", trial.ID, trial.UserID)
+		if err := s.notify(trial.UserID, func(to string, locale string) error {
+			return s.notifier.SendTrialExpired(to, locale, s.config.UpgradeURL)
+		}); err != nil {
+			log.Printf("[Trials] Error sending trial expired email for trial %d: %v", trial.ID, err)
+		}
+	}
+
+	due, err := s.db.GetTrialsNeedingReminder(now.Add(s.config.ReminderBefore))
+	if err != nil {
+		return err
+	}
+	for _, trial := range due {
+		if err := s.notify(trial.UserID, func(to string, locale string) error {
+			return s.notifier.SendTrialEnding(to, locale, s.config.UpgradeURL, trial.EndsAt)
+		}); err != nil {
+			log.Printf("[Trials] Error sending trial ending email for trial %d: %v", trial.ID, err)
+			continue
+		}
+		if err := s.db.MarkTrialReminderSent(trial.ID, now); err != nil {
+			log.Printf("[Trials] Error marking reminder sent for trial %d: %v", trial.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// notify looks up the user's email address and language and passes them to send
+func (s *Service) notify(userID string, send func(to string, locale string) error) error {
+	user, err := s.db.GetUserByID(userID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return send(user.Email, user.Language)
+}
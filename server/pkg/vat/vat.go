@@ -0,0 +1,339 @@
+// Package vat validates EU VAT identification numbers offline. Numbers are checked against
+// each member state's format and, where the algorithm is public, its check digits. A number
+// that passes may still not be registered; only VIES can confirm that.
+package vat
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrUnsupportedCountry is returned for numbers that don't start with an EU country prefix
+	ErrUnsupportedCountry = errors.New("VAT number must start with an EU country code")
+	// ErrInvalidFormat is returned when a number doesn't match its country's format
+	ErrInvalidFormat = errors.New("VAT number has an invalid format")
+	// ErrInvalidChecksum is returned when a number's check digits don't match
+	ErrInvalidChecksum = errors.New("VAT number has an invalid check digit")
+)
+
+// Number is a validated VAT number split into its country prefix and national part
+type Number struct {
+	Prefix   string // VAT prefix, "EL" for Greece
+	National string
+}
+
+// String returns the number in its canonical form, e.g. "DE123456788"
+func (n Number) String() string {
+	return n.Prefix + n.National
+}
+
+// country describes the format and check digit algorithm of one member state
+type country struct {
+	format   *regexp.Regexp
+	checksum func(string) bool // nil when only the format is checked
+}
+
+// countries maps VAT prefixes to their rules
+var countries = map[string]country{
+	"AT": {regexp.MustCompile(`^U\d{8}$`), checkAT},
+	"BE": {regexp.MustCompile(`^[01]\d{9}$`), checkBE},
+	"BG": {regexp.MustCompile(`^\d{9,10}$`), nil},
+	"CY": {regexp.MustCompile(`^\d{8}[A-Z]$`), nil},
+	"CZ": {regexp.MustCompile(`^\d{8,10}$`), nil},
+	"DE": {regexp.MustCompile(`^[1-9]\d{8}$`), checkMod1110},
+	"DK": {regexp.MustCompile(`^\d{8}$`), checkDK},
+	"EE": {regexp.MustCompile(`^10\d{7}$`), checkEE},
+	"EL": {regexp.MustCompile(`^\d{9}$`), checkEL},
+	"ES": {regexp.MustCompile(`^[A-Z0-9]\d{7}[A-Z0-9]$`), nil},
+	"FI": {regexp.MustCompile(`^\d{8}$`), checkFI},
+	"FR": {regexp.MustCompile(`^[A-HJ-NP-Z0-9]{2}\d{9}$`), checkFR},
+	"HR": {regexp.MustCompile(`^\d{11}$`), checkMod1110},
+	"HU": {regexp.MustCompile(`^\d{8}$`), checkHU},
+	"IE": {regexp.MustCompile(`^(\d{7}[A-W][A-I]?|\d[A-Z+*]\d{5}[A-W])$`), nil},
+	"IT": {regexp.MustCompile(`^\d{11}$`), checkLuhn},
+	"LT": {regexp.MustCompile(`^(\d{9}|\d{12})$`), checkLT},
+	"LU": {regexp.MustCompile(`^\d{8}$`), checkLU},
+	"LV": {regexp.MustCompile(`^\d{11}$`), checkLV},
+	"MT": {regexp.MustCompile(`^[1-9]\d{7}$`), checkMT},
+	"NL": {regexp.MustCompile(`^\d{9}B\d{2}$`), checkNL},
+	"PL": {regexp.MustCompile(`^\d{10}$`), checkPL},
+	"PT": {regexp.MustCompile(`^\d{9}$`), checkPT},
+	"RO": {regexp.MustCompile(`^[1-9]\d{1,9}$`), checkRO},
+	"SE": {regexp.MustCompile(`^\d{10}01$`), checkSE},
+	"SI": {regexp.MustCompile(`^[1-9]\d{7}$`), checkSI},
+	"SK": {regexp.MustCompile(`^[1-9]\d{9}$`), checkSK},
+}
+
+// Prefix returns the VAT prefix of an ISO 3166 country code, which differs only for Greece
+func Prefix(countryCode string) string {
+	countryCode = strings.ToUpper(countryCode)
+	if countryCode == "GR" {
+		return "EL"
+	}
+	return countryCode
+}
+
+// IsEU reports whether an ISO 3166 country code is an EU member state
+func IsEU(countryCode string) bool {
+	_, ok := countries[Prefix(countryCode)]
+	return ok
+}
+
+// Validate normalises a VAT number and checks its format and check digits.
+// Spaces, dots and dashes are ignored and the country prefix is required.
+func Validate(number string) (Number, error) {
+	normalized := strings.ToUpper(number)
+	normalized = strings.NewReplacer(" ", "", ".", "", "-", "").Replace(normalized)
+	if len(normalized) < 3 {
+		return Number{}, ErrInvalidFormat
+	}
+
+	n := Number{Prefix: normalized[:2], National: normalized[2:]}
+	if n.Prefix == "GR" {
+		n.Prefix = "EL"
+	}
+
+	rules, ok := countries[n.Prefix]
+	if !ok {
+		return Number{}, ErrUnsupportedCountry
+	}
+	if !rules.format.MatchString(n.National) {
+		return Number{}, ErrInvalidFormat
+	}
+	if rules.checksum != nil && !rules.checksum(n.National) {
+		return Number{}, ErrInvalidChecksum
+	}
+	return n, nil
+}
+
+// digits converts a string of ASCII digits to their values
+func digits(s string) []int {
+	d := make([]int, len(s))
+	for i, r := range s {
+		d[i] = int(r - '0')
+	}
+	return d
+}
+
+// weightedSum multiplies each digit by its weight and sums the products
+func weightedSum(d []int, weights []int) int {
+	sum := 0
+	for i, w := range weights {
+		sum += d[i] * w
+	}
+	return sum
+}
+
+func checkAT(s string) bool {
+	d := digits(s[1:])
+	sum := 0
+	for i := 0; i < 7; i++ {
+		if i%2 == 0 {
+			sum += d[i]
+		} else {
+			p := d[i] * 2
+			sum += p/10 + p%10
+		}
+	}
+	return (10-(sum+4)%10)%10 == d[7]
+}
+
+func checkBE(s string) bool {
+	base, _ := strconv.Atoi(s[:8])
+	check, _ := strconv.Atoi(s[8:])
+	return 97-base%97 == check
+}
+
+// checkMod1110 implements ISO 7064 MOD 11,10, used by Germany and Croatia
+func checkMod1110(s string) bool {
+	d := digits(s)
+	product := 10
+	for _, digit := range d[:len(d)-1] {
+		sum := (digit + product) % 10
+		if sum == 0 {
+			sum = 10
+		}
+		product = (2 * sum) % 11
+	}
+	return (11-product)%10 == d[len(d)-1]
+}
+
+func checkDK(s string) bool {
+	return weightedSum(digits(s), []int{2, 7, 6, 5, 4, 3, 2, 1})%11 == 0
+}
+
+func checkEE(s string) bool {
+	d := digits(s)
+	sum := weightedSum(d, []int{3, 7, 1, 3, 7, 1, 3, 7})
+	return (10-sum%10)%10 == d[8]
+}
+
+func checkEL(s string) bool {
+	d := digits(s)
+	sum := weightedSum(d, []int{256, 128, 64, 32, 16, 8, 4, 2})
+	return sum%11%10 == d[8]
+}
+
+func checkFI(s string) bool {
+	d := digits(s)
+	r := weightedSum(d, []int{7, 9, 10, 5, 8, 4, 2}) % 11
+	switch r {
+	case 0:
+		return d[7] == 0
+	case 1:
+		return false
+	default:
+		return 11-r == d[7]
+	}
+}
+
+// checkFR validates numeric keys; the alphanumeric keys of newer numbers have no public algorithm
+func checkFR(s string) bool {
+	key, err := strconv.Atoi(s[:2])
+	if err != nil {
+		return true
+	}
+	siren, _ := strconv.Atoi(s[2:])
+	return (12+3*(siren%97))%97 == key
+}
+
+func checkHU(s string) bool {
+	d := digits(s)
+	sum := weightedSum(d, []int{9, 7, 3, 1, 9, 7, 3})
+	return (10-sum%10)%10 == d[7]
+}
+
+// checkLuhn validates the Luhn check digit, used by Italy and Sweden
+func checkLuhn(s string) bool {
+	d := digits(s)
+	sum := 0
+	for i := len(d) - 1; i >= 0; i-- {
+		digit := d[i]
+		if (len(d)-1-i)%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+func checkLT(s string) bool {
+	d := digits(s)
+	n := len(d) - 1
+	first := make([]int, n)
+	second := make([]int, n)
+	for i := 0; i < n; i++ {
+		first[i] = 1 + i%9
+		second[i] = 1 + (i+2)%9
+	}
+
+	r := weightedSum(d, first) % 11
+	if r == 10 {
+		r = weightedSum(d, second) % 11
+		if r == 10 {
+			r = 0
+		}
+	}
+	return r == d[n]
+}
+
+func checkLU(s string) bool {
+	base, _ := strconv.Atoi(s[:6])
+	check, _ := strconv.Atoi(s[6:])
+	return base%89 == check
+}
+
+// checkLV validates legal entities; numbers of individuals start with 0-3 and have no check digit
+func checkLV(s string) bool {
+	d := digits(s)
+	if d[0] <= 3 {
+		return true
+	}
+	r := 3 - weightedSum(d, []int{9, 1, 4, 8, 3, 10, 2, 5, 7, 6})%11
+	if r == -1 {
+		return false
+	}
+	if r < -1 {
+		r += 11
+	}
+	return r == d[10]
+}
+
+func checkMT(s string) bool {
+	d := digits(s)
+	check, _ := strconv.Atoi(s[6:])
+	return 37-weightedSum(d, []int{3, 4, 6, 7, 8, 9})%37 == check
+}
+
+// checkNL accepts both the classic 11-test of company numbers and the
+// ISO 7064 MOD 97-10 check used for sole proprietors since 2020
+func checkNL(s string) bool {
+	d := digits(s[:9])
+	r := weightedSum(d, []int{9, 8, 7, 6, 5, 4, 3, 2}) % 11
+	if r != 10 && r == d[8] {
+		return true
+	}
+
+	// Letters count as 10 for A through 35 for Z
+	remainder := 0
+	for _, c := range "NL" + s {
+		v := int(c - '0')
+		if c >= 'A' && c <= 'Z' {
+			v = int(c-'A') + 10
+			remainder = (remainder*100 + v) % 97
+			continue
+		}
+		remainder = (remainder*10 + v) % 97
+	}
+	return remainder == 1
+}
+
+func checkPL(s string) bool {
+	d := digits(s)
+	r := weightedSum(d, []int{6, 5, 7, 2, 3, 4, 5, 6, 7}) % 11
+	return r != 10 && r == d[9]
+}
+
+func checkPT(s string) bool {
+	d := digits(s)
+	r := 11 - weightedSum(d, []int{9, 8, 7, 6, 5, 4, 3, 2})%11
+	if r >= 10 {
+		r = 0
+	}
+	return r == d[8]
+}
+
+func checkRO(s string) bool {
+	d := digits(s)
+	weights := []int{7, 5, 3, 2, 1, 7, 5, 3, 2}
+	n := len(d) - 1
+	sum := weightedSum(d[:n], weights[len(weights)-n:])
+	return sum*10%11%10 == d[n]
+}
+
+func checkSE(s string) bool {
+	return checkLuhn(s[:10])
+}
+
+func checkSI(s string) bool {
+	d := digits(s)
+	r := 11 - weightedSum(d, []int{8, 7, 6, 5, 4, 3, 2})%11
+	if r == 11 {
+		return false
+	}
+	if r == 10 {
+		r = 0
+	}
+	return r == d[7]
+}
+
+func checkSK(s string) bool {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n%11 == 0
+}
@@ -0,0 +1,183 @@
+package vat
+
+import (
+	"errors"
+	"testing"
+)
+
+// The valid numbers are published examples of each member state's format
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		number string
+		want   error
+	}{
+		{"ATU13585627", nil},
+		{"ATU13585626", ErrInvalidChecksum},
+		{"AT13585627", ErrInvalidFormat},
+
+		{"BE0403019261", nil},
+		{"BE0403019262", ErrInvalidChecksum},
+		{"BE2403019261", ErrInvalidFormat},
+
+		{"BG175074752", nil},
+		{"BG7523169263", nil},
+		{"BG17507475", ErrInvalidFormat},
+
+		{"CY10259033P", nil},
+		{"CY102590331", ErrInvalidFormat},
+
+		{"CZ25123891", nil},
+		{"CZ7103192745", nil},
+		{"CZ2512389", ErrInvalidFormat},
+
+		{"DE136695976", nil},
+		{"DE136695975", ErrInvalidChecksum},
+		{"DE036695976", ErrInvalidFormat},
+
+		{"DK13585628", nil},
+		{"DK13585627", ErrInvalidChecksum},
+		{"DK1358562", ErrInvalidFormat},
+
+		{"EE100931558", nil},
+		{"EE100931557", ErrInvalidChecksum},
+		{"EE200931558", ErrInvalidFormat},
+
+		{"EL094259216", nil},
+		{"EL094259215", ErrInvalidChecksum},
+		{"EL09425921", ErrInvalidFormat},
+
+		{"ESA13585625", nil},
+		{"ESX2482300W", nil},
+		{"ESA1358562", ErrInvalidFormat},
+
+		{"FI20774740", nil},
+		{"FI20774741", ErrInvalidChecksum},
+		{"FI2077474", ErrInvalidFormat},
+
+		{"FR40303265045", nil},
+		{"FRK7399859412", nil}, // Alphanumeric keys can't be checked
+		{"FR41303265045", ErrInvalidChecksum},
+		{"FRI7399859412", ErrInvalidFormat},
+
+		{"HR33392005961", nil},
+		{"HR33392005962", ErrInvalidChecksum},
+		{"HR3339200596", ErrInvalidFormat},
+
+		{"HU12892312", nil},
+		{"HU12892313", ErrInvalidChecksum},
+		{"HU1289231", ErrInvalidFormat},
+
+		{"IE6433435F", nil},
+		{"IE8Z49289F", nil},
+		{"IE3628739UA", nil},
+		{"IE6433435X", ErrInvalidFormat},
+
+		{"IT00743110157", nil},
+		{"IT00743110158", ErrInvalidChecksum},
+		{"IT0074311015", ErrInvalidFormat},
+
+		{"LT119511515", nil},
+		{"LT100001919017", nil},
+		{"LT119511516", ErrInvalidChecksum},
+		{"LT1195115151", ErrInvalidFormat},
+
+		{"LU15027442", nil},
+		{"LU15027443", ErrInvalidChecksum},
+		{"LU1502744", ErrInvalidFormat},
+
+		{"LV40003521600", nil},
+		{"LV16117519997", nil}, // Individuals have no check digit
+		{"LV40003521601", ErrInvalidChecksum},
+		{"LV4000352160", ErrInvalidFormat},
+
+		{"MT11679112", nil},
+		{"MT11679113", ErrInvalidChecksum},
+		{"MT01679112", ErrInvalidFormat},
+
+		{"NL004495445B01", nil},
+		{"NL000099998B57", nil}, // Sole proprietor, MOD 97-10
+		{"NL004495446B01", ErrInvalidChecksum},
+		{"NL004495445A01", ErrInvalidFormat},
+
+		{"PL8567346215", nil},
+		{"PL8567346216", ErrInvalidChecksum},
+		{"PL856734621", ErrInvalidFormat},
+
+		{"PT501964843", nil},
+		{"PT501964844", ErrInvalidChecksum},
+		{"PT50196484", ErrInvalidFormat},
+
+		{"RO18547290", nil},
+		{"RO18547291", ErrInvalidChecksum},
+		{"RO08547290", ErrInvalidFormat},
+
+		{"SE123456789701", nil},
+		{"SE123456789801", ErrInvalidChecksum},
+		{"SE123456789702", ErrInvalidFormat},
+
+		{"SI50223054", nil},
+		{"SI50223055", ErrInvalidChecksum},
+		{"SI05022305", ErrInvalidFormat},
+
+		{"SK2021853504", nil},
+		{"SK2021853505", ErrInvalidChecksum},
+		{"SK0021853504", ErrInvalidFormat},
+
+		{"GB980780684", ErrUnsupportedCountry},
+		{"US123456789", ErrUnsupportedCountry},
+		{"DE", ErrInvalidFormat},
+		{"", ErrInvalidFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			n, err := Validate(tt.number)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Validate(%q) = %v, want %v", tt.number, err, tt.want)
+			}
+			if tt.want == nil && n.String() != tt.number {
+				t.Errorf("Validate(%q) = %q", tt.number, n)
+			}
+		})
+	}
+}
+
+func TestValidateNormalizes(t *testing.T) {
+	tests := []struct {
+		number string
+		want   Number
+	}{
+		{"de 136 695 976", Number{Prefix: "DE", National: "136695976"}},
+		{"BE 0403.019.261", Number{Prefix: "BE", National: "0403019261"}},
+		{"FR-40-303-265-045", Number{Prefix: "FR", National: "40303265045"}},
+		{"GR094259216", Number{Prefix: "EL", National: "094259216"}},
+		{"nl004495445b01", Number{Prefix: "NL", National: "004495445B01"}},
+	}
+	for _, tt := range tests {
+		n, err := Validate(tt.number)
+		if err != nil {
+			t.Errorf("Validate(%q) returned %v", tt.number, err)
+			continue
+		}
+		if n != tt.want {
+			t.Errorf("Validate(%q) = %+v, want %+v", tt.number, n, tt.want)
+		}
+	}
+}
+
+func TestIsEU(t *testing.T) {
+	tests := []struct {
+		countryCode string
+		want        bool
+	}{
+		{"DE", true},
+		{"gr", true},
+		{"GB", false},
+		{"CH", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsEU(tt.countryCode); got != tt.want {
+			t.Errorf("IsEU(%q) = %v, want %v", tt.countryCode, got, tt.want)
+		}
+	}
+}